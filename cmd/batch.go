@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// batchSync returns true if sync was given several configuration files, or directories of them
+func batchSync() bool {
+	if os.Getenv("MINIMA_CONFIG") != "" {
+		return false
+	}
+	files, err := configFiles()
+	if err != nil || len(files) > 1 {
+		return true
+	}
+	for _, file := range cfgFiles {
+		if info, err := os.Stat(file); err == nil && info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// syncConfigFiles syncs the repos of each of files with run, one after the other, in the same process so
+// that connections to upstreams shared by configurations are reused. Results are merged, with the file of
+// each repo. Configurations that cannot be read or synced are reported in the result, and the other ones
+// are synced anyway, unless ctx is done
+func syncConfigFiles(ctx context.Context, files []string, run func(ctx context.Context, configString string, options SyncOptions) (SyncResult, error), options SyncOptions) (SyncResult, error) {
+	logger := options.logger()
+	result := SyncResult{}
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		logger.Printf("Syncing configuration %s", file)
+		start := time.Now()
+		data, err := os.ReadFile(file)
+		if err != nil {
+			logger.Println(err)
+			result.Repos = append(result.Repos, RepoResult{Config: file, Repo: "configuration", Err: err})
+			continue
+		}
+		configResult, err := run(ctx, string(data), options)
+		for _, repo := range configResult.Repos {
+			repo.Config = file
+			result.Repos = append(result.Repos, repo)
+		}
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		if err != nil {
+			logger.Println(err)
+			result.Repos = append(result.Repos, RepoResult{Config: file, Repo: "configuration", Duration: time.Since(start), Err: err})
+		}
+	}
+	return result, nil
+}
+
+// printSyncReport writes the outcome of each repo of result as a table
+func printSyncReport(writer io.Writer, result SyncResult) error {
+	table := tabwriter.NewWriter(writer, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(table, "CONFIG\tREPO\tDURATION\tRESULT\n")
+	for _, repo := range result.Repos {
+		outcome := "synced"
+		if repo.Err != nil {
+			outcome = repo.Err.Error()
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", repo.Config, repo.Repo, repo.Duration.Round(time.Second), outcome)
+	}
+	fmt.Fprintf(table, "total\t%d repos\t\t%d failed\n", len(result.Repos), len(result.Failed()))
+	return table.Flush()
+}
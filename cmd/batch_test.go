@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigFiles(t *testing.T) {
+	directory := t.TempDir()
+	for _, file := range []string{"b.yaml", "a.yml", "notes.txt"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(directory, file), []byte("storage: {}"), 0644))
+	}
+	defer func(files []string) { cfgFiles = files }(cfgFiles)
+
+	cfgFiles = []string{"minima.yaml"}
+	files, err := configFiles()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"minima.yaml"}, files)
+	assert.False(t, batchSync())
+
+	cfgFiles = []string{"minima.yaml", directory}
+	files, err = configFiles()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"minima.yaml", filepath.Join(directory, "a.yml"), filepath.Join(directory, "b.yaml")}, files)
+	assert.True(t, batchSync())
+	_, err = configFile()
+	assert.ErrorContains(t, err, "a single configuration file is needed")
+
+	cfgFiles = []string{t.TempDir()}
+	_, err = configFiles()
+	assert.ErrorContains(t, err, "no .yaml configuration files")
+}
+
+func TestSyncConfigFiles(t *testing.T) {
+	directory := t.TempDir()
+	first := filepath.Join(directory, "a.yaml")
+	second := filepath.Join(directory, "b.yaml")
+	assert.NoError(t, os.WriteFile(first, []byte("first"), 0644))
+	assert.NoError(t, os.WriteFile(second, []byte("second"), 0644))
+
+	synced := []string{}
+	run := func(ctx context.Context, configString string, options SyncOptions) (SyncResult, error) {
+		synced = append(synced, configString)
+		if configString == "second" {
+			return SyncResult{}, errors.New("configuration parse error")
+		}
+		return SyncResult{Repos: []RepoResult{{Repo: "http://test/repo"}, {Repo: "http://test/other", Err: errors.New("404")}}}, nil
+	}
+	result, err := syncConfigFiles(context.Background(), []string{first, filepath.Join(directory, "missing.yaml"), second}, run, SyncOptions{Logger: log.New(io.Discard, "", 0), Quiet: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"first", "second"}, synced)
+	assert.Len(t, result.Repos, 4)
+	assert.Equal(t, first, result.Repos[0].Config)
+	assert.Len(t, result.Failed(), 3)
+
+	output := &strings.Builder{}
+	assert.NoError(t, printSyncReport(output, result))
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	assert.Len(t, lines, 6)
+	assert.Contains(t, lines[1], "http://test/repo")
+	assert.Contains(t, lines[1], "synced")
+	assert.Contains(t, lines[4], "configuration parse error")
+	assert.Contains(t, lines[5], "4 repos")
+	assert.Contains(t, lines[5], "3 failed")
+
+	// interrupted batches stop
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = syncConfigFiles(ctx, []string{first}, run, SyncOptions{Logger: log.New(io.Discard, "", 0), Quiet: true})
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/uyuni-project/minima/get"
+)
+
+var (
+	benchCmd = &cobra.Command{
+		Use:   "bench",
+		Short: "Measures latency and throughput of upstream repos",
+		Long: `Measures the latency and throughput of each repo of the configuration, and of each mirror listed in
+the mirrorlists given with --mirrorlist, to guide the choice of mirrors and concurrency settings.
+
+Latency is the time until the response to the request of repomd.xml, or Release, and throughput the
+speed of downloading up to --bytes of the largest metadata file of the repo. Nothing is stored.
+
+Example:
+  minima bench --mirrorlist "https://mirrors.example.com/mirrorlist?repo=updates" --bytes 64M`,
+		Run: func(cmd *cobra.Command, args []string) {
+			initConfig()
+			maxBytes, err := get.ParseSize(benchBytes)
+			if err != nil {
+				log.Fatal(err)
+			}
+			syncers, err := syncersFromConfig(cfgString, SyncOptions{Quiet: true})
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, mirrorlist := range benchMirrorlists {
+				mirrors, err := get.ReadMirrorlist(mirrorlist)
+				if err != nil {
+					log.Fatalf("mirrorlist %s: %v", mirrorlist, err)
+				}
+				for _, mirror := range mirrors {
+					mirrorURL, err := url.Parse(mirror)
+					if err != nil {
+						log.Fatalf("mirrorlist %s: %v", mirrorlist, err)
+					}
+					syncers = append(syncers, get.NewSyncer(*mirrorURL, nil, nil, true))
+				}
+			}
+			err = printBench(os.Stdout, syncers, maxBytes)
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	benchBytes       string
+	benchMirrorlists []string
+)
+
+// printBench benches each syncer and writes the results as a table
+func printBench(writer io.Writer, syncers []*get.Syncer, maxBytes int64) error {
+	table := tabwriter.NewWriter(writer, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(table, "REPO\tLATENCY\tTHROUGHPUT\tERROR\n")
+	for _, syncer := range syncers {
+		result, err := syncer.Bench(context.Background(), maxBytes)
+		if err != nil {
+			fmt.Fprintf(table, "%s\t-\t-\t%v\n", redactURL(&syncer.URL), err)
+			continue
+		}
+		fmt.Fprintf(table, "%s\t%v\t%s/s\t\n", redactURL(&syncer.URL), result.Latency.Round(time.Millisecond), formatSize(int64(result.Throughput())))
+	}
+	return table.Flush()
+}
+
+func init() {
+	RootCmd.AddCommand(benchCmd)
+	benchCmd.Flags().StringVar(&benchBytes, "bytes", "16M", "maximum size downloaded from each repo to measure throughput")
+	benchCmd.Flags().StringSliceVar(&benchMirrorlists, "mirrorlist", nil, "file or URL of a mirrorlist whose mirrors are also measured, can be repeated")
+}
@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/uyuni-project/minima/get"
+)
+
+func TestPrintBench(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	repoURL, err := url.Parse(server.URL + "/repo/?token")
+	assert.NoError(t, err)
+
+	output := &strings.Builder{}
+	assert.NoError(t, printBench(output, []*get.Syncer{get.NewSyncer(*repoURL, nil, nil, true)}, 1000))
+	lines := strings.Split(output.String(), "\n")
+	assert.Regexp(t, `^REPO\s+LATENCY\s+THROUGHPUT\s+ERROR$`, lines[0])
+	assert.Regexp(t, `^http://127.0.0.1:\d+/repo/\?xxxxx\s+-\s+-\s+Got unexpected status code .*, 404$`, lines[1])
+}
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/uyuni-project/minima/get"
+)
+
+var (
+	exportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "Exports the mirror to a bundle for air-gapped transfer",
+		Long: `Writes a tar bundle of the mirror in the storage path, which must be of type file, for
+transfer to a disconnected host where it is applied with minima import.
+
+The bundle contains a manifest of all files with their checksums, also written next to it with a
+.manifest extension. With --since, only files new or changed since the export of that manifest are
+included, so that only the previous bundle must have been imported before this one.
+
+Example:
+  minima export --output mirror-2.tar --since mirror-1.tar.manifest`,
+		Run: func(cmd *cobra.Command, args []string) {
+			initConfig()
+			directory, err := mirrorDirectory(cfgString)
+			if err != nil {
+				log.Fatal(err)
+			}
+			err = exportBundle(directory, bundleOutput, bundleSince)
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	importCmd = &cobra.Command{
+		Use:   "import BUNDLE",
+		Short: "Applies a bundle made with minima export to the mirror",
+		Long: `Applies a bundle made with minima export to the mirror in the storage path, which must be of
+type file. Checksums of all files in the bundle are verified, and the mirror is only replaced if all of
+them match and files not in the bundle are present from previous imports.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			initConfig()
+			directory, err := mirrorDirectory(cfgString)
+			if err != nil {
+				log.Fatal(err)
+			}
+			f, err := os.Open(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			err = get.ImportBundle(f, directory)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("Imported %s into %s\n", args[0], directory)
+		},
+	}
+	bundleOutput string
+	bundleSince  string
+)
+
+// mirrorDirectory returns the local directory of the mirror, as configured
+func mirrorDirectory(configString string) (string, error) {
+	config, err := parseConfig(configString)
+	if err != nil {
+		return "", err
+	}
+	if config.Storage.Type != "file" {
+		return "", fmt.Errorf("bundles require storage type file, not %s", config.Storage.Type)
+	}
+	return config.Storage.Path, nil
+}
+
+// exportBundle writes a bundle of directory to output, and its manifest next to it. Files are included
+// unless they are unchanged since the export of the previous manifest, if any
+func exportBundle(directory string, output string, since string) error {
+	started := time.Now()
+	previous := get.Manifest{}
+	var previousTime time.Time
+	if since != "" {
+		f, err := os.Open(since)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		// manifests are dated when their export started, see below
+		previousTime = info.ModTime()
+		previous, err = get.ReadManifest(f)
+		if err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	manifest, err := get.ExportBundle(directory, previous, previousTime, f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	manifestFile := output + ".manifest"
+	f, err = os.Create(manifestFile)
+	if err != nil {
+		return err
+	}
+	_, err = manifest.WriteTo(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	// files modified while exporting are included again by the next export
+	err = os.Chtimes(manifestFile, started, started)
+	if err != nil {
+		return err
+	}
+	log.Printf("Exported %s to %s, with manifest %s\n", directory, output, manifestFile)
+	return nil
+}
+
+func init() {
+	RootCmd.AddCommand(exportCmd)
+	RootCmd.AddCommand(importCmd)
+	exportCmd.Flags().StringVarP(&bundleOutput, "output", "o", "minima-bundle.tar", "bundle file to write")
+	exportCmd.Flags().StringVarP(&bundleSince, "since", "s", "", "manifest of the previous export, to only include new or changed files")
+}
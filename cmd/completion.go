@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/uyuni-project/minima/get"
+)
+
+// completeRepoNames completes --repository, and the last of the comma-separated patterns of --repo, with the
+// repo names of the configuration, or of the -c file, and of the SCC listings cached by earlier syncs. Nothing
+// is requested from SCC, so that completions are instant
+func completeRepoNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	// unlike initConfig, nothing is printed, as output is read as completions
+	configString := os.Getenv("MINIMA_CONFIG")
+	if configString == "" {
+		file, err := configFile()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		configString = string(data)
+	}
+	previous, last := "", toComplete
+	if i := strings.LastIndex(toComplete, ","); i >= 0 {
+		previous, last = toComplete[:i+1], toComplete[i+1:]
+	}
+	completions := repoNames(configString, last)
+	for i := range completions {
+		completions[i] = previous + completions[i]
+	}
+	return completions, cobra.ShellCompDirectiveNoFileComp
+}
+
+// repoNames returns the sorted names starting with prefix that --repository selects in configString: those
+// of scc and rmt repositories and of cached SCC listings. Uyuni channels are not listed, as that takes a request
+func repoNames(configString string, prefix string) []string {
+	config, err := parseConfig(configString)
+	if err != nil {
+		return nil
+	}
+	names := []string{}
+	for _, organization := range config.SCC.AllOrganizations() {
+		for _, repositories := range organization.Repositories {
+			names = append(names, repositories.Names...)
+		}
+		username, _, err := organization.Credentials()
+		if err != nil {
+			continue
+		}
+		repos, err := get.SCCCache{File: organization.CacheFile}.Cached(username)
+		if err != nil {
+			continue
+		}
+		for _, repo := range repos {
+			names = append(names, repo.Name)
+		}
+	}
+	for _, repositories := range config.RMT.Repositories {
+		names = append(names, repositories.Names...)
+	}
+
+	completions := []string{}
+	for _, name := range names {
+		if name != "" && strings.HasPrefix(name, prefix) {
+			completions = append(completions, name)
+		}
+	}
+	slices.Sort(completions)
+	return slices.Compact(completions)
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepoNames(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "scc.json")
+	assert.NoError(t, os.WriteFile(cacheFile, []byte(`{"Username": "customer-a", "Repos": [
+		{"Name": "SLE-Module-Basesystem15-SP5-Pool"}, {"Name": "SLE-Product-SLES15-SP5-Pool"}]}`), 0600))
+	configString := `
+storage:
+  type: file
+  path: /srv/mirror
+scc:
+  organizations:
+    - username: customer-a
+      password: pass-a
+      path_prefix: customer-a
+      cache_file: ` + cacheFile + `
+    - credentials_file: /nonexistent/customer-b.credentials
+      path_prefix: customer-b
+      repositories:
+        - names: [SLE-Product-SLES15-SP5-Pool, SLE-Product-SLES15-SP5-Updates]
+rmt:
+  url: https://rmt.example.com
+  credentials_file: /etc/zypp/credentials.d/SCCcredentials
+  repositories:
+    - names: [SLE-Module-Legacy15-SP5-Pool]
+`
+	assert.Equal(t, []string{
+		"SLE-Module-Basesystem15-SP5-Pool",
+		"SLE-Module-Legacy15-SP5-Pool",
+		"SLE-Product-SLES15-SP5-Pool",
+		"SLE-Product-SLES15-SP5-Updates",
+	}, repoNames(configString, ""))
+	assert.Equal(t, []string{"SLE-Product-SLES15-SP5-Pool", "SLE-Product-SLES15-SP5-Updates"}, repoNames(configString, "SLE-Product"))
+	assert.Empty(t, repoNames("storage: [", ""))
+}
+
+func TestCompleteRepoNames(t *testing.T) {
+	t.Setenv("MINIMA_CONFIG", `
+storage:
+  type: file
+  path: /srv/mirror
+rmt:
+  url: https://rmt.example.com
+  credentials_file: /etc/zypp/credentials.d/SCCcredentials
+  repositories:
+    - names: [SLE-Module-Legacy15-SP5-Pool, SLE-Product-SLES15-SP5-Pool, SLE-Product-SLES15-SP5-Updates]
+`)
+	for _, flag := range []string{"repository", "repo"} {
+		complete, ok := syncCmd.GetFlagCompletionFunc(flag)
+		assert.True(t, ok, flag)
+		completions, directive := complete(syncCmd, nil, "SLE-Product")
+		assert.Equal(t, []string{"SLE-Product-SLES15-SP5-Pool", "SLE-Product-SLES15-SP5-Updates"}, completions, flag)
+		assert.Equal(t, cobra.ShellCompDirectiveNoFileComp, directive, flag)
+	}
+
+	// the last of the --repo patterns is completed
+	complete, _ := syncCmd.GetFlagCompletionFunc("repo")
+	completions, _ := complete(syncCmd, nil, "SLE-Module-*,SLE-Product-SLES15-SP5-U")
+	assert.Equal(t, []string{"SLE-Module-*,SLE-Product-SLES15-SP5-Updates"}, completions)
+}
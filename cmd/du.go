@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/uyuni-project/minima/get"
+)
+
+var duCmd = &cobra.Command{
+	Use:   "du",
+	Short: "Reports the storage taken by each repo",
+	Long: `Reports the number of packages and the storage taken by each repo of the configuration, as of its
+last sync. Sizes are computed from the metadata of the repos instead of listing their files, so that
+reports are quick even for object storages and mirrors of millions of files. Files not listed in
+metadata, like signatures and extra files, are not counted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		initConfig()
+		syncers, err := syncersFromConfig(cfgString, SyncOptions{Quiet: true})
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = printUsage(os.Stdout, syncers)
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// printUsage writes the usage of each repo as a table, followed by the total
+func printUsage(writer io.Writer, syncers []*get.Syncer) error {
+	table := tabwriter.NewWriter(writer, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(table, "REPO\tPACKAGES\tSIZE\n")
+	total := get.RepoUsage{}
+	for _, syncer := range syncers {
+		usage, err := syncer.Usage()
+		if err != nil {
+			return fmt.Errorf("%s: %v", redactURL(&syncer.URL), err)
+		}
+		fmt.Fprintf(table, "%s\t%d\t%s\n", redactURL(&syncer.URL), usage.Packages, formatSize(usage.Total()))
+		total.Metadata += usage.Metadata
+		total.Packages += usage.Packages
+		total.PackagesSize += usage.PackagesSize
+	}
+	fmt.Fprintf(table, "total\t%d\t%s\n", total.Packages, formatSize(total.Total()))
+	return table.Flush()
+}
+
+// formatSize returns a size in bytes in the largest binary unit it reaches, like 1.5G
+func formatSize(size int64) string {
+	units := []string{"K", "M", "G", "T"}
+	if size < 1024 {
+		return fmt.Sprintf("%d", size)
+	}
+	value := float64(size)
+	unit := ""
+	for _, u := range units {
+		if value < 1024 {
+			break
+		}
+		value /= 1024
+		unit = u
+	}
+	return fmt.Sprintf("%.1f%s", value, unit)
+}
+
+func init() {
+	RootCmd.AddCommand(duCmd)
+}
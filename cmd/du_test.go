@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/uyuni-project/minima/get"
+)
+
+func TestPrintUsage(t *testing.T) {
+	repoURL, err := url.Parse("http://test/SLE-Product-SLES15-SP5-Pool/?token")
+	assert.NoError(t, err)
+	syncer := get.NewSyncer(*repoURL, map[string]bool{"x86_64": true}, get.NewFileStorage(t.TempDir()), true)
+
+	output := &strings.Builder{}
+	assert.NoError(t, printUsage(output, []*get.Syncer{syncer}))
+	assert.Equal(t, "REPO                                            PACKAGES  SIZE\n"+
+		"http://test/SLE-Product-SLES15-SP5-Pool/?xxxxx  0         0\n"+
+		"total                                           0         0\n", output.String())
+}
+
+func TestFormatSize(t *testing.T) {
+	assert.Equal(t, "1000", formatSize(1000))
+	assert.Equal(t, "1.5K", formatSize(1536))
+	assert.Equal(t, "50.0G", formatSize(50<<30))
+	assert.Equal(t, "2048.0T", formatSize(2<<50))
+}
@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	importReposCmd = &cobra.Command{
+		Use:   "import-repos PATH...",
+		Short: "Converts zypper and yum .repo files to minima configuration",
+		Long: `Prints http entries for minima.yaml mirroring the repos defined in .repo files, like those
+in /etc/zypp/repos.d or /etc/yum.repos.d. Directories are searched for .repo files.
+
+Repo variables like $basearch and $releasever are replaced with the values of --arch and --releasever.
+Disabled repos are printed with enabled: false. Local GPG keys (file:// URLs) are published in the
+mirrored repo as extra files, remote ones are noted in comments.
+
+Example:
+  minima import-repos --arch x86_64 --releasever 15.6 /etc/zypp/repos.d >> minima.yaml`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			err := importRepos(os.Stdout, args)
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	importArch       string
+	importReleasever string
+)
+
+// repoFileSection is a repo defined in a .repo file
+type repoFileSection struct {
+	file   string
+	alias  string
+	values map[string]string
+}
+
+// readRepoFile returns the repos defined in a .repo file, in the INI format of zypper and yum
+func readRepoFile(file string) ([]repoFileSection, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sections := []repoFileSection{}
+	key := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";"):
+			key = ""
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			sections = append(sections, repoFileSection{file, strings.Trim(trimmed, "[]"), map[string]string{}})
+			key = ""
+		case len(sections) == 0:
+			return nil, fmt.Errorf("%s: value outside of a repo section: %s", file, trimmed)
+		case key != "" && (line[0] == ' ' || line[0] == '\t'):
+			// continuation of a list, like several baseurls in yum
+			values := sections[len(sections)-1].values
+			values[key] += "\n" + trimmed
+		default:
+			name, value, ok := strings.Cut(trimmed, "=")
+			if !ok {
+				return nil, fmt.Errorf("%s: invalid line: %s", file, trimmed)
+			}
+			key = strings.ToLower(strings.TrimSpace(name))
+			sections[len(sections)-1].values[key] = strings.TrimSpace(value)
+		}
+	}
+	return sections, scanner.Err()
+}
+
+// disabled returns true if the repo is disabled in its .repo file
+func (s repoFileSection) disabled() bool {
+	enabled, ok := s.values["enabled"]
+	return ok && enabled != "1" && enabled != "yes" && enabled != "true"
+}
+
+// findRepoFiles returns .repo files in paths, which are files or directories
+func findRepoFiles(paths []string) ([]string, error) {
+	files := []string{}
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(p, "*.repo"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// expandRepoVariables replaces repo variables in value
+func expandRepoVariables(value string) string {
+	variables := []string{"${basearch}", importArch, "$basearch", importArch, "${arch}", importArch, "$arch", importArch}
+	if importReleasever != "" {
+		variables = append(variables, "${releasever}", importReleasever, "$releasever", importReleasever)
+	}
+	return strings.NewReplacer(variables...).Replace(value)
+}
+
+// yamlString returns value as a YAML scalar, quoted if needed
+func yamlString(value string) string {
+	out, err := yaml.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%q", value)
+	}
+	return strings.TrimSuffix(string(out), "\n")
+}
+
+// importRepos writes http entries for the repos defined in files found in paths
+func importRepos(writer io.Writer, paths []string) error {
+	files, err := findRepoFiles(paths)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no .repo files found in %s", strings.Join(paths, ", "))
+	}
+
+	fmt.Fprintln(writer, "http:")
+	for _, file := range files {
+		sections, err := readRepoFile(file)
+		if err != nil {
+			return err
+		}
+		for _, section := range sections {
+			lines, err := repoEntry(section)
+			if err != nil {
+				return err
+			}
+			if section.disabled() {
+				fmt.Fprintf(writer, "  # %s is disabled in %s\n", section.alias, section.file)
+			} else {
+				fmt.Fprintf(writer, "  # %s from %s\n", section.alias, section.file)
+			}
+			for _, line := range lines {
+				fmt.Fprintf(writer, "  %s\n", line)
+			}
+		}
+	}
+	return nil
+}
+
+// repoEntry returns the lines of the http entry of a repo
+func repoEntry(section repoFileSection) ([]string, error) {
+	baseURLs := strings.Fields(expandRepoVariables(section.values["baseurl"]))
+	if len(baseURLs) == 0 {
+		return []string{"# no baseurl, mirrorlists and metalinks are not supported"}, nil
+	}
+	if strings.Contains(baseURLs[0], "$") {
+		return nil, fmt.Errorf("%s: unknown variables in %s, see --arch and --releasever", section.file, baseURLs[0])
+	}
+
+	lines := []string{
+		"- url: " + yamlString(baseURLs[0]),
+		"  name: " + yamlString(section.alias),
+		"  archs: [" + importArch + "]",
+	}
+	if section.disabled() {
+		lines = append(lines, "  enabled: false")
+	}
+	if section.values["type"] == "yast2" {
+		lines = append(lines, "  # type yast2 is not supported")
+	}
+	keys := []string{}
+	comments := []string{}
+	for _, key := range strings.Fields(expandRepoVariables(section.values["gpgkey"])) {
+		u, err := url.Parse(key)
+		if err == nil && u.Scheme == "file" {
+			keys = append(keys, "    - source: "+yamlString(u.Path))
+		} else {
+			comments = append(comments, "  # gpgkey "+key+" is not mirrored")
+		}
+	}
+	if len(keys) > 0 {
+		lines = append(lines, "  extra_files:")
+		lines = append(lines, keys...)
+	}
+	return append(lines, comments...), nil
+}
+
+func init() {
+	RootCmd.AddCommand(importReposCmd)
+	importReposCmd.Flags().StringVarP(&importArch, "arch", "a", "x86_64", "arch to mirror and to replace $basearch and $arch with")
+	importReposCmd.Flags().StringVarP(&importReleasever, "releasever", "r", "", "version to replace $releasever with")
+}
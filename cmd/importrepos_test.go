@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uyuni-project/minima/get"
+	"gopkg.in/yaml.v2"
+)
+
+func TestImportRepos(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "repo-oss.repo"), []byte(`[repo-oss]
+name=Main Repository
+enabled=1
+autorefresh=1
+baseurl=http://download.opensuse.org/distribution/leap/$releasever/repo/oss/
+type=rpm-md
+keeppackages=0
+gpgkey=file:///usr/lib/rpm/gnupg/keys/gpg-pubkey-29b700a4-62b07e22.asc
+
+[repo-debug]
+name=Debug Repository
+enabled=0
+baseurl=http://download.opensuse.org/debug/distribution/leap/$releasever/repo/oss/
+`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "epel.repo"), []byte(`# EPEL
+[epel]
+name=Extra Packages for Enterprise Linux $releasever - $basearch
+baseurl=https://dl.fedoraproject.org/pub/epel/$releasever/Everything/$basearch/
+        https://mirror.example.com/epel/$releasever/Everything/$basearch/
+enabled=1
+gpgkey=https://dl.fedoraproject.org/pub/epel/RPM-GPG-KEY-EPEL-$releasever
+
+[epel-source]
+name=Extra Packages for Enterprise Linux $releasever - Source
+metalink=https://mirrors.fedoraproject.org/metalink?repo=epel-source-$releasever&arch=$basearch
+enabled=0
+`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("not a repo"), 0644))
+
+	importArch, importReleasever = "x86_64", ""
+	out := &bytes.Buffer{}
+	assert.ErrorContains(t, importRepos(out, []string{dir}), "unknown variables")
+
+	importReleasever = "15.6"
+	out.Reset()
+	assert.NoError(t, importRepos(out, []string{dir}))
+	assert.Contains(t, out.String(), "  # epel-source is disabled in "+filepath.Join(dir, "epel.repo")+"\n")
+	assert.Contains(t, out.String(), "  # gpgkey https://dl.fedoraproject.org/pub/epel/RPM-GPG-KEY-EPEL-15.6 is not mirrored\n")
+
+	config := Config{}
+	assert.NoError(t, yaml.Unmarshal(out.Bytes(), &config))
+	disabled := false
+	assert.Equal(t, []get.HTTPRepoConfig{
+		{
+			URL:   "https://dl.fedoraproject.org/pub/epel/15.6/Everything/x86_64/",
+			Name:  "epel",
+			Archs: []string{"x86_64"},
+		},
+		{
+			URL:        "http://download.opensuse.org/distribution/leap/15.6/repo/oss/",
+			Name:       "repo-oss",
+			Archs:      []string{"x86_64"},
+			ExtraFiles: []get.ExtraFile{{Source: "/usr/lib/rpm/gnupg/keys/gpg-pubkey-29b700a4-62b07e22.asc"}},
+		},
+		{
+			URL:     "http://download.opensuse.org/debug/distribution/leap/15.6/repo/oss/",
+			Name:    "repo-debug",
+			Archs:   []string{"x86_64"},
+			Enabled: &disabled,
+		},
+	}, config.HTTP)
+
+	_, err := readRepoFile(filepath.Join(dir, "README"))
+	assert.ErrorContains(t, err, "outside of a repo section")
+}
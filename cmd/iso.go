@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/uyuni-project/minima/get"
+)
+
+var (
+	isoCmd = &cobra.Command{
+		Use:   "iso [REPO_PATH...]",
+		Short: "Builds ISO images of mirrored repos for offline systems",
+		Long: `Builds data ISO images of repos in the storage path, which must be of type file, using xorriso.
+Repos are given by path relative to the storage path, all repos are included by default.
+
+Repos are distributed over as few images as fit the media size, and never split: a repo bigger than
+the media is an error. Media sizes are cd, dvd, dvd-dl, bd or a size in bytes, MiB (M) or GiB (G).
+
+Example:
+  minima iso --media dvd --output-dir /srv/iso SLE-Product-SLES15-SP5-Pool SLE-Product-SLES15-SP5-Updates`,
+		Run: func(cmd *cobra.Command, args []string) {
+			initConfig()
+			directory, err := mirrorDirectory(cfgString)
+			if err != nil {
+				log.Fatal(err)
+			}
+			err = buildISOs(directory, args)
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	isoMedia     string
+	isoOutputDir string
+	isoVolumeID  string
+)
+
+// buildISOs writes images of repos, or all repos in directory if none is given, to isoOutputDir
+func buildISOs(directory string, repos []string) error {
+	mediaSize, err := get.ParseMediaSize(isoMedia)
+	if err != nil {
+		return err
+	}
+	if len(repos) == 0 {
+		repos, err = get.FindRepos(directory)
+		if err != nil {
+			return err
+		}
+	}
+	if len(repos) == 0 {
+		return fmt.Errorf("no repos found in %s", directory)
+	}
+
+	volumes, err := get.PlanISOVolumes(directory, repos, mediaSize)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(isoOutputDir, 0755)
+	if err != nil {
+		return err
+	}
+	for i, volume := range volumes {
+		volumeID := fmt.Sprintf("%s_%d", isoVolumeID, i+1)
+		output := filepath.Join(isoOutputDir, volumeID+".iso")
+		log.Printf("Building %s (%d MiB) with %v\n", output, volume.Size/1024/1024, volume.Repos)
+		err = get.BuildISO(directory, volume, volumeID, output)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	RootCmd.AddCommand(isoCmd)
+	isoCmd.Flags().StringVarP(&isoMedia, "media", "m", "dvd", "media size of images")
+	isoCmd.Flags().StringVarP(&isoOutputDir, "output-dir", "o", ".", "directory to write images to")
+	isoCmd.Flags().StringVarP(&isoVolumeID, "volume-id", "V", "MINIMA", "volume ID of images, numbered from 1")
+}
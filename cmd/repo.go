@@ -0,0 +1,434 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	yamlv3 "gopkg.in/yaml.v3"
+
+	"github.com/uyuni-project/minima/get"
+)
+
+var (
+	repoCmd = &cobra.Command{
+		Use:   "repo",
+		Short: "Manages the repos of the configuration",
+		Long:  "Manages the repos of the http section of the configuration file.",
+	}
+	repoAddCmd = &cobra.Command{
+		Use:   "add URL",
+		Short: "Adds a repo to the configuration file",
+		Long: `Appends an entry for the repo at URL to the http section of the configuration file given with -c,
+minima.yaml by default, so that automation can manage repos without templating YAML.
+
+The configuration is validated with the new entry before it is written, and repos already in the http
+section are not added again. Comments and the other sections of the file are kept, while indentation is
+normalized to two spaces and blank lines are dropped.
+
+Example:
+  minima repo add https://download.opensuse.org/update/leap/15.6/oss/ --arch x86_64,noarch --path leap/15.6/update`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			entry := httpEntry{URL: args[0], Name: repoAddName, PathTemplate: repoAddPath}
+			if repoAddArchs != "" {
+				entry.Archs = strings.Split(repoAddArchs, ",")
+			}
+			file, err := editConfigFile(func(data []byte) ([]byte, error) {
+				return addRepo(data, entry)
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("Added %s to %s\n", entry.URL, file)
+		},
+	}
+	repoRemoveCmd = &cobra.Command{
+		Use:   "remove URL|NAME",
+		Short: "Removes a repo from the configuration file",
+		Long: `Removes the entries of the http section of the configuration file given with -c, minima.yaml by
+default, with the given URL or name.
+
+With --purge, the mirrored content of the repo is deleted from file storages too, with the temporary
+and previous versions left by syncs and its checksum cache. Other storage types are not purged, so that
+their content is deleted with their own tools. Repos stored in a storage path, or in a directory containing
+other repos of the configuration, are not purged either. Neither is anything if repos are discovered from
+the scc, rmt, uyuni or obs_projects sections, as their directories are only known at sync time.
+
+Example:
+  minima repo remove https://download.opensuse.org/update/leap/15.6/oss/ --purge`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var removed []get.HTTPRepoConfig
+			var storages []*get.FileStorage
+			file, err := editConfigFile(func(data []byte) (result []byte, err error) {
+				result, removed, err = removeRepo(data, args[0])
+				if err != nil || !repoRemovePurge {
+					return
+				}
+				// storages are checked before the configuration is changed
+				config, err := parseConfig(string(data))
+				if err != nil {
+					return
+				}
+				kept, err := parseConfig(string(result))
+				if err != nil {
+					return
+				}
+				storages, err = purgedStorages(config, removed, kept.HTTP)
+				return
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, httpRepo := range removed {
+				fmt.Printf("Removed %s from %s\n", httpRepo.URL, file)
+			}
+			for _, storage := range storages {
+				err = storage.Purge()
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+			if repoRemovePurge {
+				fmt.Printf("Purged %d repo directories\n", len(storages))
+			}
+		},
+	}
+	repoAddArchs    string
+	repoAddName     string
+	repoAddPath     string
+	repoRemovePurge bool
+)
+
+// editConfigFile replaces the configuration file given with -c with the result of edit on its data, and
+// returns its name
+func editConfigFile(edit func(data []byte) ([]byte, error)) (string, error) {
+	if os.Getenv("MINIMA_CONFIG") != "" {
+		return "", fmt.Errorf("configuration is read from $MINIMA_CONFIG, unset it to edit a configuration file")
+	}
+	file, err := configFile()
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	data, err = edit(data)
+	if err != nil {
+		return "", err
+	}
+	// written aside and renamed, so that syncs never read a partial configuration
+	temporary := file + ".tmp"
+	err = os.WriteFile(temporary, data, info.Mode().Perm())
+	if err != nil {
+		return "", err
+	}
+	return file, os.Rename(temporary, file)
+}
+
+// httpEntry is an entry of the http section written by repo add
+type httpEntry struct {
+	URL          string   `yaml:"url"`
+	Name         string   `yaml:"name,omitempty"`
+	Archs        []string `yaml:"archs,omitempty,flow"`
+	PathTemplate string   `yaml:"path_template,omitempty"`
+}
+
+// addRepo returns the configuration file data with entry appended to its http section, if the result is a
+// valid configuration
+func addRepo(data []byte, entry httpEntry) ([]byte, error) {
+	repoURL, err := url.Parse(entry.URL)
+	if err != nil || repoURL.Scheme == "" || (repoURL.Host == "" && repoURL.Scheme != "file") {
+		return nil, fmt.Errorf("invalid repo url %s", entry.URL)
+	}
+	for _, arch := range entry.Archs {
+		if arch == "" {
+			return nil, fmt.Errorf("empty arch in %s", strings.Join(entry.Archs, ","))
+		}
+	}
+	config, err := parseConfig(string(data))
+	if err != nil {
+		return nil, err
+	}
+	for _, httpRepo := range config.HTTP {
+		if httpRepo.URL == entry.URL {
+			return nil, fmt.Errorf("repo %s is already in the http section", entry.URL)
+		}
+	}
+
+	document, err := parseConfigNodes(data)
+	if err != nil {
+		return nil, err
+	}
+	var entryNode yamlv3.Node
+	err = entryNode.Encode(entry)
+	if err != nil {
+		return nil, err
+	}
+	root := document.Content[0]
+	http := mappingValue(root, "http")
+	switch {
+	case http == nil:
+		http = &yamlv3.Node{Kind: yamlv3.SequenceNode, Tag: "!!seq"}
+		root.Content = append(root.Content, &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: "http"}, http)
+	case http.Tag == "!!null":
+		// an empty http section
+		*http = yamlv3.Node{Kind: yamlv3.SequenceNode, Tag: "!!seq", HeadComment: http.HeadComment, LineComment: http.LineComment, FootComment: http.FootComment}
+	case http.Kind != yamlv3.SequenceNode:
+		return nil, fmt.Errorf("configuration parse error: http section is not a list")
+	}
+	// entries are written in block style, even if the section was written as []
+	http.Style = 0
+	http.Content = append(http.Content, &entryNode)
+
+	result, err := encodeConfigNodes(document)
+	if err != nil {
+		return nil, err
+	}
+	config, err = parseConfig(string(result))
+	if err != nil {
+		return nil, err
+	}
+	httpRepo := config.HTTP[len(config.HTTP)-1]
+	if _, err = repoPathFromConfig(config.Storage, httpRepo, repoURL, httpRepo.Archs); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// removeRepo returns the configuration file data without the entries of its http section with URL or name
+// reference, and the removed entries
+func removeRepo(data []byte, reference string) ([]byte, []get.HTTPRepoConfig, error) {
+	config, err := parseConfig(string(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	document, err := parseConfigNodes(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	http := mappingValue(document.Content[0], "http")
+	if http == nil || http.Kind != yamlv3.SequenceNode || len(http.Content) != len(config.HTTP) {
+		return nil, nil, fmt.Errorf("repo %s is not in the http section", reference)
+	}
+	kept := []*yamlv3.Node{}
+	removed := []get.HTTPRepoConfig{}
+	for i, httpRepo := range config.HTTP {
+		if httpRepo.URL == reference || (httpRepo.Name != "" && httpRepo.Name == reference) {
+			removed = append(removed, httpRepo)
+			continue
+		}
+		kept = append(kept, http.Content[i])
+	}
+	if len(removed) == 0 {
+		return nil, nil, fmt.Errorf("repo %s is not in the http section", reference)
+	}
+	http.Content = kept
+	result, err := encodeConfigNodes(document)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, removed, nil
+}
+
+// purgedStorages returns the file storages of the directories of httpRepos in each of their targets, or an
+// error if any of them is not a file storage, is a storage path, or contains the directory of another repo
+// of config, kept or merged, as those would be deleted too. Repos discovered from SCC, RMT, Uyuni or OBS
+// projects are only known at sync time, so nothing is purged if any of those is configured
+func purgedStorages(config Config, httpRepos []get.HTTPRepoConfig, kept []get.HTTPRepoConfig) ([]*get.FileStorage, error) {
+	if sources := discoverySections(config); len(sources) > 0 {
+		return nil, fmt.Errorf("--purge is not possible with repos discovered from the %s sections, which could be stored in the purged directories", strings.Join(sources, ", "))
+	}
+	keptDirectories := []repoDirectory{}
+	for _, httpRepo := range kept {
+		directories, err := httpRepoDirectories(config, httpRepo)
+		if err != nil {
+			return nil, err
+		}
+		keptDirectories = append(keptDirectories, directories...)
+	}
+	for _, mergeRepo := range config.Merge {
+		for _, target := range repoTargets(mergeRepo.Targets) {
+			directory, err := newRepoDirectory(config, target, "/"+mergeRepo.Name, mergeRepo.Name)
+			if err != nil {
+				return nil, err
+			}
+			keptDirectories = append(keptDirectories, directory)
+		}
+	}
+
+	storages := []*get.FileStorage{}
+	for _, httpRepo := range httpRepos {
+		directories, err := httpRepoDirectories(config, httpRepo)
+		if err != nil {
+			return nil, err
+		}
+		for _, directory := range directories {
+			if directory.path == "" {
+				return nil, fmt.Errorf("--purge is only possible with storage type file, not %s of target %s of %s", directory.storageType, directory.target, httpRepo.URL)
+			}
+			if directory.path == directory.root {
+				return nil, fmt.Errorf("--purge would delete %s, the storage path of target %s", directory.path, directory.target)
+			}
+			for _, other := range keptDirectories {
+				if other.path != "" && (other.path == directory.path || strings.HasPrefix(other.path, directory.path+string(filepath.Separator))) {
+					return nil, fmt.Errorf("--purge would delete %s, which contains %s of %s", directory.path, other.path, other.repo)
+				}
+			}
+			storages = append(storages, get.NewFileStorage(directory.path).(*get.FileStorage))
+		}
+	}
+	return storages, nil
+}
+
+// discoverySections returns the configured sections of config repos are discovered from
+func discoverySections(config Config) []string {
+	sections := []string{}
+	if len(config.SCC.AllOrganizations()) > 0 {
+		sections = append(sections, "scc")
+	}
+	if config.RMT.URL != "" {
+		sections = append(sections, "rmt")
+	}
+	if config.Uyuni.URL != "" {
+		sections = append(sections, "uyuni")
+	}
+	if len(config.OBSProjects.Projects) > 0 {
+		sections = append(sections, "obs_projects")
+	}
+	return sections
+}
+
+// repoDirectory is where a repo is stored in a target
+type repoDirectory struct {
+	repo        string
+	target      string
+	storageType string
+	// absolute directory of the repo and storage path of the target, empty for types other than file
+	path string
+	root string
+}
+
+// repoTargets returns targets, or the storage section if none
+func repoTargets(targets []string) []string {
+	if len(targets) == 0 {
+		return []string{defaultTarget}
+	}
+	return targets
+}
+
+// newRepoDirectory returns the directory of the repo at repoPath in target
+func newRepoDirectory(config Config, target string, repoPath string, repo string) (repoDirectory, error) {
+	storageConfig := config.Storage
+	if target != defaultTarget {
+		storageConfig = config.Targets[target]
+	}
+	directory := repoDirectory{repo: repo, target: target, storageType: storageConfig.Type}
+	if storageConfig.Type != "file" {
+		return directory, nil
+	}
+	// as file storages, see get.NewStorage
+	var err error
+	directory.root, err = filepath.Abs(storageConfig.Path)
+	if err != nil {
+		return directory, err
+	}
+	directory.path, err = filepath.Abs(filepath.Join(storageConfig.Path, filepath.FromSlash(repoPath)))
+	return directory, err
+}
+
+// httpRepoDirectories returns the directories of httpRepo in each of its targets
+func httpRepoDirectories(config Config, httpRepo get.HTTPRepoConfig) ([]repoDirectory, error) {
+	repoURL, err := url.Parse(httpRepo.URL)
+	if err != nil {
+		return nil, err
+	}
+	// each arch of split_archs repos is stored in its own directory
+	archSets := [][]string{httpRepo.Archs}
+	if httpRepo.SplitArchs {
+		archSets = nil
+		for _, arch := range httpRepo.Archs {
+			archSets = append(archSets, []string{arch})
+		}
+	}
+	directories := []repoDirectory{}
+	for _, target := range repoTargets(httpRepo.Targets) {
+		storageConfig := config.Storage
+		if target != defaultTarget {
+			storageConfig = config.Targets[target]
+		}
+		for _, archs := range archSets {
+			repoPath, err := repoPathFromConfig(storageConfig, httpRepo, repoURL, archs)
+			if err != nil {
+				return nil, err
+			}
+			directory, err := newRepoDirectory(config, target, repoPath, httpRepo.URL)
+			if err != nil {
+				return nil, err
+			}
+			directories = append(directories, directory)
+		}
+	}
+	return directories, nil
+}
+
+// parseConfigNodes returns the YAML document of configuration file data. Files are edited as nodes rather
+// than as Config, which would drop comments and unset settings
+func parseConfigNodes(data []byte) (*yamlv3.Node, error) {
+	var document yamlv3.Node
+	err := yamlv3.Unmarshal(data, &document)
+	if err != nil {
+		return nil, fmt.Errorf("configuration parse error: %v", err)
+	}
+	if len(document.Content) == 0 || document.Content[0].Kind != yamlv3.MappingNode {
+		return nil, fmt.Errorf("configuration parse error: no storage section")
+	}
+	return &document, nil
+}
+
+// mappingValue returns the value of key in a mapping node, nil if missing
+func mappingValue(mapping *yamlv3.Node, key string) *yamlv3.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// encodeConfigNodes returns the configuration file data of a YAML document, indented with two spaces
+func encodeConfigNodes(document *yamlv3.Node) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	encoder := yamlv3.NewEncoder(buffer)
+	encoder.SetIndent(2)
+	err := encoder.Encode(document)
+	if err != nil {
+		return nil, err
+	}
+	err = encoder.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func init() {
+	RootCmd.AddCommand(repoCmd)
+	repoCmd.AddCommand(repoAddCmd)
+	repoAddCmd.Flags().StringVarP(&repoAddArchs, "arch", "a", "", "flag that specifies the comma-separated archs to mirror, all by default")
+	repoAddCmd.Flags().StringVar(&repoAddName, "name", "", "flag that specifies the name of the repo, for {reponame} in path templates")
+	repoAddCmd.Flags().StringVar(&repoAddPath, "path", "", "flag that specifies the storage path of the repo, as a path template like {host}/{path}")
+	repoCmd.AddCommand(repoRemoveCmd)
+	repoRemoveCmd.Flags().BoolVar(&repoRemovePurge, "purge", false, "flag that also deletes the mirrored content and checksum cache of the repo in file storages")
+}
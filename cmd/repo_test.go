@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddRepo(t *testing.T) {
+	config := `# mirror of the build host
+storage:
+  type: file
+  # served by nginx
+  path: /srv/mirror
+
+http:
+    - url: http://test/SLE-Product-SLES15-SP5-Pool/
+      archs: [x86_64]
+`
+	data, err := addRepo([]byte(config), httpEntry{URL: "http://test/SLE-Product-SLES15-SP5-Updates/", Archs: []string{"x86_64", "noarch"}, PathTemplate: "sles/{host}"})
+	assert.NoError(t, err)
+	assert.Equal(t, `# mirror of the build host
+storage:
+  type: file
+  # served by nginx
+  path: /srv/mirror
+http:
+  - url: http://test/SLE-Product-SLES15-SP5-Pool/
+    archs: [x86_64]
+  - url: http://test/SLE-Product-SLES15-SP5-Updates/
+    archs: [x86_64, noarch]
+    path_template: sles/{host}
+`, string(data))
+
+	// the http section is added if missing or empty
+	data, err = addRepo([]byte("storage:\n  type: file\n  path: /srv/mirror\n"), httpEntry{URL: "http://test/repo/", Name: "repo"})
+	assert.NoError(t, err)
+	assert.Equal(t, "storage:\n  type: file\n  path: /srv/mirror\nhttp:\n  - url: http://test/repo/\n    name: repo\n", string(data))
+	data, err = addRepo([]byte("storage:\n  type: file\n  path: /srv/mirror\nhttp: []\n"), httpEntry{URL: "http://test/repo/"})
+	assert.NoError(t, err)
+	assert.Equal(t, "storage:\n  type: file\n  path: /srv/mirror\nhttp:\n  - url: http://test/repo/\n", string(data))
+
+	_, err = addRepo([]byte(config), httpEntry{URL: "http://test/SLE-Product-SLES15-SP5-Pool/"})
+	assert.EqualError(t, err, "repo http://test/SLE-Product-SLES15-SP5-Pool/ is already in the http section")
+	_, err = addRepo([]byte(config), httpEntry{URL: "test/repo"})
+	assert.EqualError(t, err, "invalid repo url test/repo")
+	_, err = addRepo([]byte(config), httpEntry{URL: "http://test/repo/", Archs: []string{"x86_64", ""}})
+	assert.EqualError(t, err, "empty arch in x86_64,")
+	_, err = addRepo([]byte(config), httpEntry{URL: "http://test/repo/", PathTemplate: "{unknown}"})
+	assert.Error(t, err)
+	_, err = addRepo([]byte("storage:\n  type: file\n  path: /srv/mirror\nhttp: repos\n"), httpEntry{URL: "http://test/repo/"})
+	assert.Error(t, err)
+}
+
+func TestRemoveRepo(t *testing.T) {
+	config := `storage:
+  type: file
+  path: /srv/mirror
+http:
+  # base repo
+  - url: http://test/SLE-Product-SLES15-SP5-Pool/
+    archs: [x86_64]
+  - url: http://test/SLE-Product-SLES15-SP5-Updates/
+    name: updates
+    archs: [x86_64]
+`
+	data, removed, err := removeRepo([]byte(config), "updates")
+	assert.NoError(t, err)
+	assert.Equal(t, `storage:
+  type: file
+  path: /srv/mirror
+http:
+  # base repo
+  - url: http://test/SLE-Product-SLES15-SP5-Pool/
+    archs: [x86_64]
+`, string(data))
+	assert.Len(t, removed, 1)
+	assert.Equal(t, "http://test/SLE-Product-SLES15-SP5-Updates/", removed[0].URL)
+
+	data, removed, err = removeRepo(data, "http://test/SLE-Product-SLES15-SP5-Pool/")
+	assert.NoError(t, err)
+	assert.Equal(t, "storage:\n  type: file\n  path: /srv/mirror\nhttp: []\n", string(data))
+	assert.Len(t, removed, 1)
+
+	_, _, err = removeRepo(data, "http://test/SLE-Product-SLES15-SP5-Pool/")
+	assert.EqualError(t, err, "repo http://test/SLE-Product-SLES15-SP5-Pool/ is not in the http section")
+}
+
+func TestPurgedStorages(t *testing.T) {
+	directory := t.TempDir()
+	config, err := parseConfig(`storage:
+  type: file
+  path: ` + directory + `
+targets:
+  cloud:
+    type: s3
+    bucket: mirror
+    region: us-east-1
+http:
+  - url: http://test/repo/
+    archs: [x86_64, aarch64]
+    split_archs: true
+  - url: http://test/cloud/
+    targets: [default, cloud]
+`)
+	assert.NoError(t, err)
+
+	storages, err := purgedStorages(config, config.HTTP[:1], config.HTTP[1:])
+	assert.NoError(t, err)
+	assert.Len(t, storages, 2)
+	repo := filepath.Join(directory, "repo", "x86_64")
+	assert.NoError(t, os.MkdirAll(filepath.Join(repo, "repodata"), 0755))
+	assert.NoError(t, os.WriteFile(repo+"-checksums", []byte("{}"), 0644))
+	for _, storage := range storages {
+		assert.NoError(t, storage.Purge())
+	}
+	assert.NoDirExists(t, repo)
+	assert.NoFileExists(t, repo+"-checksums")
+
+	_, err = purgedStorages(config, config.HTTP[1:], config.HTTP[:1])
+	assert.ErrorContains(t, err, "--purge is only possible with storage type file, not s3 of target cloud")
+}
+
+func TestPurgedStoragesOfOtherRepos(t *testing.T) {
+	directory := t.TempDir()
+	config, err := parseConfig(`storage:
+  type: file
+  path: ` + directory + `
+targets:
+  backup:
+    type: file
+    path: ` + directory + `/backup
+http:
+  - url: http://test/repo/
+  - url: http://test/repo/updates/
+  - url: http://test/other/
+    targets: [backup]
+  - url: http://test/
+merge:
+  - name: repo/merged
+    urls: [http://test/repo/]
+`)
+	assert.NoError(t, err)
+
+	// repos below the purged one are kept
+	_, err = purgedStorages(config, config.HTTP[:1], config.HTTP[1:])
+	assert.ErrorContains(t, err, "--purge would delete "+filepath.Join(directory, "repo")+", which contains "+filepath.Join(directory, "repo", "updates")+" of http://test/repo/updates/")
+	// so are merged repos, and repos stored in other targets
+	_, err = purgedStorages(config, config.HTTP[:1], config.HTTP[3:])
+	assert.ErrorContains(t, err, "which contains "+filepath.Join(directory, "repo", "merged")+" of repo/merged")
+	// the storage path is never purged, even without other repos
+	_, err = purgedStorages(config, config.HTTP[3:], nil)
+	assert.ErrorContains(t, err, "--purge would delete "+directory+", the storage path of target default")
+	_, err = purgedStorages(config, config.HTTP[3:], config.HTTP[2:3])
+	assert.ErrorContains(t, err, "the storage path of target default")
+
+	// repos next to each other can be purged, or repos containing others purged too
+	storages, err := purgedStorages(config, config.HTTP[1:2], config.HTTP[:1])
+	assert.NoError(t, err)
+	assert.Len(t, storages, 1)
+	config.Merge = nil
+	storages, err = purgedStorages(config, config.HTTP[:2], config.HTTP[2:3])
+	assert.NoError(t, err)
+	assert.Len(t, storages, 2)
+}
+
+func TestPurgedStoragesWithDiscovery(t *testing.T) {
+	config, err := parseConfig(`storage:
+  type: file
+  path: ` + t.TempDir() + `
+scc:
+  username: user
+  password: pass
+obs_projects:
+  projects:
+    - project: home:foo
+http:
+  - url: http://test/repo/
+`)
+	assert.NoError(t, err)
+
+	// discovered repos could be stored below the purged one
+	_, err = purgedStorages(config, config.HTTP, nil)
+	assert.EqualError(t, err, "--purge is not possible with repos discovered from the scc, obs_projects sections, which could be stored in the purged directories")
+}
@@ -4,13 +4,16 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
 
 var (
 	version   string
-	cfgFile   string
+	cfgFiles  []string
 	cfgString string
 )
 
@@ -44,7 +47,7 @@ func Execute(versionTag string) {
 
 func init() {
 	// all sub-commands will have access to this flag
-	RootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "minima.yaml", "config file")
+	RootCmd.PersistentFlags().StringArrayVarP(&cfgFiles, "config", "c", []string{"minima.yaml"}, "config file, sync accepts several and directories of .yaml files")
 	RootCmd.PersistentFlags().BoolP("quiet", "q", false, "greatly reduces the number of logs")
 	// local flags
 	RootCmd.Flags().BoolP("version", "v", false, "Print minima version")
@@ -61,12 +64,62 @@ func initConfig() {
 	}
 
 	// second, try from the commandline flag
-	if cfgFile != "" {
-		bytes, err := os.ReadFile(cfgFile)
+	files, err := configFiles()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(files) > 1 {
+		log.Fatalf("only sync accepts several configuration files, not %s", strings.Join(files, ", "))
+	}
+	if len(files) == 1 {
+		bytes, err := os.ReadFile(files[0])
 		if err != nil {
 			log.Fatal(err)
 		}
 		cfgString = string(bytes)
-		fmt.Println("Using config file:", cfgFile)
+		fmt.Println("Using config file:", files[0])
+	}
+}
+
+// configFiles returns the files given with --config, directories replaced by the .yaml and .yml files they
+// contain, in order
+func configFiles() ([]string, error) {
+	files := []string{}
+	for _, file := range cfgFiles {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil || !info.IsDir() {
+			// missing files are reported when read
+			files = append(files, file)
+			continue
+		}
+		matches := []string{}
+		for _, pattern := range []string{"*.yaml", "*.yml"} {
+			found, err := filepath.Glob(filepath.Join(file, pattern))
+			if err != nil {
+				return nil, err
+			}
+			matches = append(matches, found...)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no .yaml configuration files in %s", file)
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// configFile returns the single file given with --config
+func configFile() (string, error) {
+	files, err := configFiles()
+	if err != nil {
+		return "", err
+	}
+	if len(files) != 1 {
+		return "", fmt.Errorf("a single configuration file is needed, not %s", strings.Join(cfgFiles, ", "))
 	}
+	return files[0], nil
 }
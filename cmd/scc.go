@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/uyuni-project/minima/get"
+)
+
+var (
+	sccCmd = &cobra.Command{
+		Use:   "scc",
+		Short: "Queries SUSE Customer Center",
+		Long:  "Queries SUSE Customer Center with the credentials of the scc section of the configuration.",
+	}
+	sccProductsCmd = &cobra.Command{
+		Use:   "products",
+		Short: "Lists the products and repos available in SCC",
+		Long: `Lists the products available to the organization in SCC, with the name, URL and enabled state of
+their repos, to help writing the repositories and match_* filters of the scc section.
+
+Repos also show their distro target, like sle-15-x86_64, of which match_products matches the product
+and version (sle-15) and match_archs the arch.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			initConfig()
+			config, err := parseConfig(cfgString)
+			if err != nil {
+				log.Fatal(err)
+			}
+			organizations := config.SCC.AllOrganizations()
+			if len(organizations) == 0 {
+				log.Fatal("no SCC credentials in the scc section")
+			}
+			for _, organization := range organizations {
+				username, password, err := organization.Credentials()
+				if err != nil {
+					log.Fatal(err)
+				}
+				products, err := get.SCCProducts(sccUrl, username, password, nil)
+				if err != nil {
+					log.Fatal(err)
+				}
+				if len(organizations) > 1 {
+					fmt.Printf("Organization %s (%s):\n", username, organization.PathPrefix)
+				}
+				err = printProducts(os.Stdout, products)
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+		},
+	}
+)
+
+// printProducts writes products and their repos as a table
+func printProducts(writer io.Writer, products []get.Product) error {
+	table := tabwriter.NewWriter(writer, 0, 8, 2, ' ', 0)
+	for _, product := range products {
+		fmt.Fprintf(table, "%s\t%s/%s/%s\n", product.FriendlyName, product.Identifier, product.Version, product.Arch)
+		for _, repo := range product.Repositories {
+			enabled := "disabled"
+			if repo.Enabled {
+				enabled = "enabled"
+			}
+			fmt.Fprintf(table, "  %s\t%s\t%s\t%s\n", repo.Name, repo.DistroTarget, enabled, repo.URL)
+		}
+	}
+	return table.Flush()
+}
+
+func init() {
+	RootCmd.AddCommand(sccCmd)
+	sccCmd.AddCommand(sccProductsCmd)
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/uyuni-project/minima/get"
+)
+
+var (
+	scrubCmd = &cobra.Command{
+		Use:   "scrub",
+		Short: "Verifies stored files against their checksums to detect storage corruption",
+		Long: `Verifies a rolling subset of the files of the mirror in the storage path, which must be of type
+file, against the SHA256SUMS files of their repos, written with the sha256sums storage setting.
+Each run checks up to --max-files files, starting after the last one checked by the previous run as
+recorded in --state-file, so that running it regularly, eg. from a systemd timer or cron, covers the
+whole mirror over time.
+
+Files that are missing or do not match their checksums are logged and, with --webhook, posted as JSON
+to that URL. The command then exits with status 1.
+
+Example:
+  minima scrub --max-files 5000 --webhook https://alerts.example.com/minima`,
+		Run: func(cmd *cobra.Command, args []string) {
+			initConfig()
+			config, err := parseConfig(cfgString)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if config.Storage.Type != "file" {
+				log.Fatalf("scrubbing requires storage type file, not %s", config.Storage.Type)
+			}
+			divergences, err := scrub(config.Storage.Path, scrubStateFile, scrubMaxFiles, scrubWebhook)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if divergences > 0 {
+				os.Exit(1)
+			}
+		},
+	}
+	scrubStateFile string
+	scrubMaxFiles  int
+	scrubWebhook   string
+)
+
+// scrubAlert is the JSON body posted to webhooks
+type scrubAlert struct {
+	Directory   string           `json:"directory"`
+	Divergences []get.Divergence `json:"divergences"`
+}
+
+// scrub verifies up to maxFiles files of directory after the position in stateFile, saving the new one.
+// Divergences are posted to webhook, if set, and their number is returned
+func scrub(directory string, stateFile string, maxFiles int, webhook string) (int, error) {
+	content, err := os.ReadFile(stateFile)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	position := strings.TrimSpace(string(content))
+
+	result, err := get.Scrub(directory, position, maxFiles)
+	if err != nil {
+		return 0, err
+	}
+	for _, divergence := range result.Divergences {
+		if divergence.Actual == "" {
+			log.Printf("%s is missing\n", divergence.File)
+		} else {
+			log.Printf("%s has checksum %s, expected %s\n", divergence.File, divergence.Actual, divergence.Expected)
+		}
+	}
+	log.Printf("Verified %d files, %d diverging\n", result.Verified, len(result.Divergences))
+
+	err = os.WriteFile(stateFile, []byte(result.Position+"\n"), 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	if webhook != "" && len(result.Divergences) > 0 {
+		body, err := json.Marshal(scrubAlert{directory, result.Divergences})
+		if err != nil {
+			return 0, err
+		}
+		resp, err := http.Post(webhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return 0, fmt.Errorf("webhook %s answered %s", webhook, resp.Status)
+		}
+	}
+	return len(result.Divergences), nil
+}
+
+func init() {
+	RootCmd.AddCommand(scrubCmd)
+	scrubCmd.Flags().StringVar(&scrubStateFile, "state-file", "minima-scrub.state", "file recording where the next run starts")
+	scrubCmd.Flags().IntVar(&scrubMaxFiles, "max-files", 1000, "maximum number of files to verify")
+	scrubCmd.Flags().StringVar(&scrubWebhook, "webhook", "", "URL to post divergences to, as JSON")
+}
@@ -0,0 +1,287 @@
+package cmd
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ServeConfig maps the serve section of minima.yaml
+type ServeConfig struct {
+	// address to listen at, eg. :8080
+	Listen string
+	// credentials of clients, if set
+	Username string
+	Password string
+	// certificate and key to serve HTTPS
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// domains to get certificates for with ACME instead, eg. from Let's Encrypt
+	ACMEDomains []string `yaml:"acme_domains"`
+	// contact address for the certificate authority
+	ACMEEmail string `yaml:"acme_email"`
+	// directory keeping account keys and certificates across restarts
+	ACMECacheDir string `yaml:"acme_cache_dir"`
+	// ACME directory URL of the certificate authority, Let's Encrypt if empty
+	ACMEDirectoryURL string `yaml:"acme_directory_url"`
+	// file to append access logs to in Combined Log Format, standard error if empty or none if "off"
+	AccessLog string `yaml:"access_log"`
+}
+
+// defaultACMECacheDir is where certificates are kept if no acme_cache_dir is set
+const defaultACMECacheDir = "/var/lib/minima/acme"
+
+var (
+	serveCmd = &cobra.Command{
+		Use:   "serve",
+		Short: "Serves the mirror over HTTP",
+		Long: `Serves the mirror in the storage path, which must be of type file, over HTTP(S) as configured in
+the serve section. Range and conditional requests are supported, requests are logged in Combined Log
+Format, and syncs in progress, previous versions of repos and checksum caches are hidden, also from
+directory listings.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			initConfig()
+			config, err := parseConfig(cfgString)
+			if err != nil {
+				log.Fatal(err)
+			}
+			directory, err := mirrorDirectory(cfgString)
+			if err != nil {
+				log.Fatal(err)
+			}
+			serve := config.Serve
+			if serveListen != "" {
+				serve.Listen = serveListen
+			}
+			err = validateServe(serve)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			handler := serveHandler(directory, serve.Username, serve.Password)
+			switch serve.AccessLog {
+			case "off":
+			case "":
+				handler = logAccess(handler, os.Stderr)
+			default:
+				accessLog, err := os.OpenFile(serve.AccessLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+				if err != nil {
+					log.Fatal(err)
+				}
+				defer accessLog.Close()
+				handler = logAccess(handler, accessLog)
+			}
+
+			server := newServeServer(serve, handler)
+			log.Printf("Serving %s at %s\n", directory, server.Addr)
+			if server.TLSConfig != nil {
+				err = server.ListenAndServeTLS(serve.CertFile, serve.KeyFile)
+			} else {
+				err = server.ListenAndServe()
+			}
+			log.Fatal(err)
+		},
+	}
+	serveListen string
+)
+
+// contentTypes of repo files unknown to the mime package or the host
+var contentTypes = map[string]string{
+	".rpm":  "application/x-rpm",
+	".deb":  "application/vnd.debian.binary-package",
+	".udeb": "application/vnd.debian.binary-package",
+	".gz":   "application/gzip",
+	".xz":   "application/x-xz",
+	".bz2":  "application/x-bzip2",
+	".zst":  "application/zstd",
+	".asc":  "application/pgp-signature",
+	".gpg":  "application/pgp-signature",
+	".key":  "application/pgp-keys",
+	".xml":  "application/xml",
+}
+
+// validateServe checks the serve section for TLS settings that cannot be used together
+func validateServe(serve ServeConfig) error {
+	if (serve.CertFile == "") != (serve.KeyFile == "") {
+		return fmt.Errorf("cert_file and key_file must be set together")
+	}
+	if serve.CertFile != "" && len(serve.ACMEDomains) > 0 {
+		return fmt.Errorf("acme_domains cannot be used with cert_file")
+	}
+	if len(serve.ACMEDomains) == 0 && (serve.ACMEEmail != "" || serve.ACMECacheDir != "" || serve.ACMEDirectoryURL != "") {
+		return fmt.Errorf("ACME settings need acme_domains")
+	}
+	return nil
+}
+
+// newServeServer returns a server for handler, with TLS if serve has certificates or ACME domains.
+// ACME certificates are validated with the TLS-ALPN-01 challenge, so the server must be reachable by the
+// certificate authority on port 443, which is the default address then
+func newServeServer(serve ServeConfig, handler http.Handler) *http.Server {
+	server := &http.Server{Addr: serve.Listen, Handler: handler}
+	switch {
+	case len(serve.ACMEDomains) > 0:
+		cacheDir := serve.ACMECacheDir
+		if cacheDir == "" {
+			cacheDir = defaultACMECacheDir
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(serve.ACMEDomains...),
+			Cache:      autocert.DirCache(cacheDir),
+			Email:      serve.ACMEEmail,
+		}
+		if serve.ACMEDirectoryURL != "" {
+			manager.Client = &acme.Client{DirectoryURL: serve.ACMEDirectoryURL}
+		}
+		server.TLSConfig = manager.TLSConfig()
+		server.TLSConfig.MinVersion = tls.VersionTLS12
+		if server.Addr == "" {
+			server.Addr = ":443"
+		}
+	case serve.CertFile != "":
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if server.Addr == "" {
+		server.Addr = ":8080"
+	}
+	return server
+}
+
+// hiddenName reports whether a file or directory is internal to storage, ie. a sync in progress, the previous
+// version of a repo or a checksum cache
+func hiddenName(name string) bool {
+	return strings.HasSuffix(name, "-in-progress") || strings.HasSuffix(name, "-old") || strings.HasSuffix(name, "-checksums")
+}
+
+// hidingFileSystem is a FileSystem whose directory listings omit hidden names
+type hidingFileSystem struct {
+	http.FileSystem
+}
+
+func (h hidingFileSystem) Open(name string) (http.File, error) {
+	file, err := h.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return hidingFile{file}, nil
+}
+
+// hidingFile is a File whose Readdir omits hidden names
+type hidingFile struct {
+	http.File
+}
+
+func (f hidingFile) Readdir(count int) ([]fs.FileInfo, error) {
+	visible := []fs.FileInfo{}
+	for {
+		infos, err := f.File.Readdir(count)
+		for _, info := range infos {
+			if !hiddenName(info.Name()) {
+				visible = append(visible, info)
+			}
+		}
+		// with a count, only return no entries at the end of the directory
+		if err != nil || count <= 0 || len(visible) > 0 {
+			return visible, err
+		}
+	}
+}
+
+// serveHandler returns a handler serving directory, with basic authentication if username is set.
+// Files get an ETag from their modification time and size, so that clients refreshing metadata get
+// 304 Not Modified responses with If-None-Match as well as If-Modified-Since
+func serveHandler(directory string, username string, password string) http.Handler {
+	files := http.FileServer(hidingFileSystem{http.Dir(directory)})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username != "" {
+			user, pass, ok := r.BasicAuth()
+			if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(username)) != 1 || subtle.ConstantTimeCompare([]byte(pass), []byte(password)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="minima"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		for _, segment := range strings.Split(path.Clean(r.URL.Path), "/") {
+			if hiddenName(segment) {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		if contentType, ok := contentTypes[path.Ext(r.URL.Path)]; ok {
+			w.Header().Set("Content-Type", contentType)
+		}
+		info, err := os.Stat(filepath.Join(directory, filepath.FromSlash(path.Clean("/"+r.URL.Path))))
+		if err == nil && info.Mode().IsRegular() {
+			w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+// accessLogWriter records the status and size of a response
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// logAccess returns a handler writing a line in Combined Log Format to writer for each request to handler
+func logAccess(handler http.Handler, writer io.Writer) http.Handler {
+	var mutex sync.Mutex
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &accessLogWriter{ResponseWriter: w}
+		handler.ServeHTTP(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		user, _, ok := r.BasicAuth()
+		if !ok || user == "" {
+			user = "-"
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		fmt.Fprintf(writer, "%s - %s [%s] %q %d %d %q %q\n", host, user, start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method+" "+r.RequestURI+" "+r.Proto, recorder.status, recorder.size, r.Referer(), r.UserAgent())
+	})
+}
+
+func init() {
+	RootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVarP(&serveListen, "listen", "l", "", "address to listen at, overriding the serve section (default :8080, or :443 with ACME)")
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeHandler(t *testing.T) {
+	dir := t.TempDir()
+	for filename, content := range map[string]string{
+		"repo/repodata/repomd.xml":        "<repomd/>",
+		"repo/Packages/a.rpm":             "0123456789",
+		"repo-in-progress/Packages/b.rpm": "partial",
+		"repo-old/Packages/c.rpm":         "previous",
+		"repo-checksums":                  "{}",
+	} {
+		name := filepath.Join(dir, filepath.FromSlash(filename))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+		assert.NoError(t, os.WriteFile(name, []byte(content), 0644))
+	}
+	server := httptest.NewServer(serveHandler(dir, "user", "secret"))
+	defer server.Close()
+
+	get := func(path string, username string, headers map[string]string) *http.Response {
+		request, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		assert.NoError(t, err)
+		if username != "" {
+			request.SetBasicAuth(username, "secret")
+		}
+		for name, value := range headers {
+			request.Header.Set(name, value)
+		}
+		response, err := http.DefaultClient.Do(request)
+		assert.NoError(t, err)
+		response.Body.Close()
+		return response
+	}
+
+	response := get("/repo/Packages/a.rpm", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	assert.Contains(t, response.Header.Get("WWW-Authenticate"), "Basic")
+	assert.Equal(t, http.StatusUnauthorized, get("/repo/Packages/a.rpm", "other", nil).StatusCode)
+
+	response = get("/repo/Packages/a.rpm", "user", nil)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "application/x-rpm", response.Header.Get("Content-Type"))
+
+	response = get("/repo/Packages/a.rpm", "user", map[string]string{"Range": "bytes=2-5"})
+	assert.Equal(t, http.StatusPartialContent, response.StatusCode)
+	assert.Equal(t, "bytes 2-5/10", response.Header.Get("Content-Range"))
+
+	response = get("/repo/repodata/repomd.xml", "user", nil)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "application/xml", response.Header.Get("Content-Type"))
+
+	assert.Equal(t, http.StatusNotFound, get("/repo-in-progress/Packages/b.rpm", "user", nil).StatusCode)
+	assert.Equal(t, http.StatusNotFound, get("/repo-in-progress/", "user", nil).StatusCode)
+	assert.Equal(t, http.StatusNotFound, get("/repo-old/Packages/c.rpm", "user", nil).StatusCode)
+	assert.Equal(t, http.StatusNotFound, get("/repo-checksums", "user", nil).StatusCode)
+
+	// listings only show published repos
+	request, err := http.NewRequest(http.MethodGet, server.URL+"/", nil)
+	assert.NoError(t, err)
+	request.SetBasicAuth("user", "secret")
+	response, err = http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	listing, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	assert.NoError(t, err)
+	assert.Contains(t, string(listing), `href="repo/"`)
+	assert.NotContains(t, string(listing), "repo-")
+}
+
+func TestValidateServe(t *testing.T) {
+	tests := []struct {
+		name    string
+		serve   ServeConfig
+		wantErr bool
+	}{
+		{"HTTP", ServeConfig{Listen: ":8080"}, false},
+		{"Certificate", ServeConfig{CertFile: "server.crt", KeyFile: "server.key"}, false},
+		{"Certificate without key", ServeConfig{CertFile: "server.crt"}, true},
+		{"ACME", ServeConfig{ACMEDomains: []string{"mirror.example.com"}, ACMEEmail: "admin@example.com"}, false},
+		{"ACME with certificate", ServeConfig{CertFile: "server.crt", KeyFile: "server.key", ACMEDomains: []string{"mirror.example.com"}}, true},
+		{"ACME settings without domains", ServeConfig{ACMEEmail: "admin@example.com"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateServe(tt.serve)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestNewServeServer(t *testing.T) {
+	server := newServeServer(ServeConfig{}, http.NotFoundHandler())
+	assert.Equal(t, ":8080", server.Addr)
+	assert.Nil(t, server.TLSConfig)
+
+	server = newServeServer(ServeConfig{Listen: ":8443", CertFile: "server.crt", KeyFile: "server.key"}, http.NotFoundHandler())
+	assert.Equal(t, ":8443", server.Addr)
+	assert.Equal(t, uint16(tls.VersionTLS12), server.TLSConfig.MinVersion)
+
+	server = newServeServer(ServeConfig{ACMEDomains: []string{"mirror.example.com"}, ACMECacheDir: t.TempDir()}, http.NotFoundHandler())
+	assert.Equal(t, ":443", server.Addr)
+	assert.Contains(t, server.TLSConfig.NextProtos, "acme-tls/1")
+	assert.NotNil(t, server.TLSConfig.GetCertificate)
+}
+
+func TestServeConditional(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "repomd.xml"), []byte("<repomd/>"), 0644))
+	accessLog := &bytes.Buffer{}
+	server := httptest.NewServer(logAccess(serveHandler(dir, "", ""), accessLog))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/repomd.xml")
+	assert.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	etag := response.Header.Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	for name, value := range map[string]string{"If-None-Match": etag, "If-Modified-Since": response.Header.Get("Last-Modified")} {
+		request, err := http.NewRequest(http.MethodGet, server.URL+"/repomd.xml", nil)
+		assert.NoError(t, err)
+		request.Header.Set(name, value)
+		response, err = http.DefaultClient.Do(request)
+		assert.NoError(t, err)
+		response.Body.Close()
+		assert.Equal(t, http.StatusNotModified, response.StatusCode, name)
+	}
+
+	lines := strings.Split(strings.TrimSpace(accessLog.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.Regexp(t, `^127\.0\.0\.1 - - \[.+\] "GET /repomd.xml HTTP/1.1" 200 9 "" "Go-http-client/1.1"$`, lines[0])
+	assert.Contains(t, lines[1], `"GET /repomd.xml HTTP/1.1" 304 0`)
+
+	// a file replaced within the same second with the same size gets a new ETag
+	info, err := os.Stat(filepath.Join(dir, "repomd.xml"))
+	assert.NoError(t, err)
+	second := info.ModTime().Truncate(time.Second)
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, "repomd.xml"), second, second))
+	response, err = http.Get(server.URL + "/repomd.xml")
+	assert.NoError(t, err)
+	response.Body.Close()
+	original := response.Header.Get("ETag")
+	replaced := second.Add(500 * time.Millisecond)
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, "repomd.xml"), replaced, replaced))
+	request, err := http.NewRequest(http.MethodGet, server.URL+"/repomd.xml", nil)
+	assert.NoError(t, err)
+	request.Header.Set("If-None-Match", original)
+	response, err = http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.NotEqual(t, original, response.Header.Get("ETag"))
+}
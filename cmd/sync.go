@@ -1,13 +1,24 @@
 package cmd
 
 import (
+	"context"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
 	"net/url"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/spf13/cobra"
 
 	"github.com/uyuni-project/minima/get"
@@ -26,53 +37,439 @@ var (
 
   You can specify configuration in YAML either in a file or the MINIMA_CONFIG environment variable.
 
+  Several configurations, like one per customer, are synced one after the other in the same process when
+  -c is given several times or names a directory of .yaml files, followed by a report of all repos.
+
   An example minima.yaml is below:
 
     storage:
       type: file
       path: /srv/mirror
+      # optional size of the buffers files are written and read through, and fsync policy: file syncs every
+      # stored file to disk, commit all of them once before publishing a repo. Useful on NFS mounts
+      # buffer_size: 4M
+      # fsync: commit
+      # optional ownership and permissions of files, directories get execute permission wherever they get read
+      # permission, and SELinux label, so that the mirror can be served right away by a web server
+      # owner: nginx
+      # group: nginx
+      # mode: "0644"
+      # selinux_context: system_u:object_r:httpd_sys_content_t:s0
+      # optionally record the source URL, checksum and sync time of files in user.minima.* extended attributes,
+      # on Linux. Inspect them with getfattr -d
+      # provenance_xattrs: true
+      # optionally arrange repos like the local mirrors Uyuni and SUSE Manager sync from, with
+      # server.susemanager.fromdir or file:// URLs of custom channels: repos are stored at the path of their URL
+      # and the SCC data of the scc section is stored at its path_prefix. Requires file storage
+      # layout: uyuni
       # uncomment to save to an AWS S3 bucket instead of the filesystem
       # type: s3
+      # static credentials, omit them to use the default AWS credential chain (environment variables,
+      # instance profiles, IRSA web identity tokens, SSO...) optionally selecting a profile
       # access_key_id: ACCESS_KEY_ID
       # secret_access_key: SECRET_ACCESS_KEY
+      # profile: mirror
       # region: us-east-1
       # bucket: minima-bucket-key
+      # optional key prefix of all repos in the bucket. Repos are stored below it at their path (see
+      # path_template), so several repos can share a bucket
+      # prefix: mirror
+      # uncomment to use an S3-compatible service such as MinIO or Ceph RGW instead of AWS
+      # endpoint: https://minio.example.com:9000
+      # ca_cert_file: /etc/minima/minio-ca.pem
+      # insecure_skip_verify: false
+      # address buckets as <endpoint>/<bucket> instead of <bucket>.<endpoint>, needed by many appliances
+      # force_path_style: true
+      # optional size in MiB of the parts big files are uploaded in (5 by default), and number of parts
+      # uploaded in parallel (5 by default). Interrupted uploads of the same file version are resumed
+      # part_size_mb: 16
+      # upload_concurrency: 10
+      # optional server-side encryption of stored files, AES256 (SSE-S3) or aws:kms (SSE-KMS)
+      # server_side_encryption: aws:kms
+      # sse_kms_key_id: arn:aws:kms:us-east-1:111122223333:key/KEY_ID
+      # optional storage class of stored files, with overrides by file name (first match wins)
+      # storage_class: INTELLIGENT_TIERING
+      # storage_class_rules:
+      #   - pattern: "*.iso"
+      #     class: STANDARD_IA
+      # optional tags of stored files. Files are also tagged with minima-repo: <repo name>, and have
+      # minima-repo, minima-upstream and minima-checksum metadata
+      # tags:
+      #   owner: mirror-team
+      # optional canned ACL of stored files, eg. to make mirrors public. Leave unset for buckets that
+      # only rely on bucket policies, as they reject ACLs
+      # acl: public-read
+      # uncomment to store index.html listings of every directory, for browsers and naive clients
+      # generate_index: true
+      # uncomment for S3-compatible services not supporting object tags
+      # disable_tagging: true
+      # uncomment to save to a Backblaze B2 bucket instead, through its S3-compatible API: key ID and
+      # application key go in access_key_id and secret_access_key, the endpoint follows the region.
+      # Objects are not tagged, and storage classes, ACLs and aws:kms encryption are not supported
+      # type: b2
+      # region: us-west-004
+      # bucket: minima
+      # uncomment to save to an Azure Blob Storage container instead (prefix and endpoint also apply)
+      # type: azblob
+      # account: minimamirror
+      # container: mirror
+      # SAS token, if unset the managed identity of the host is used, optionally a user-assigned one
+      # sas_token: "?sv=2021-08-06&ss=b&srt=co&sp=rwdlc&sig=SIGNATURE"
+      # managed_identity_client_id: CLIENT_ID
+      # uncomment to save to an OpenStack Swift container instead (prefix also applies), authenticating
+      # with Keystone v3. Files bigger than 5 GiB are not supported
+      # type: swift
+      # auth_url: https://keystone.example.com:5000/v3
+      # username: minima
+      # password: INSERT_PASSWORD_HERE
+      # project: mirrors
+      # optional domains of the user and project, Default if unset
+      # user_domain: Default
+      # project_domain: Default
+      # optional region of the object-store endpoint in the catalog
+      # region: RegionOne
+      # container: mirror
+      # uncomment to save to a directory of a remote host over SFTP instead, with path on the remote host.
+      # Repos are swapped in with a rename at the end of each sync, like with file storage
+      # type: sftp
+      # host: mirror.example.com:22
+      # username: minima
+      # path: /srv/www/htdocs
+      # private key to authenticate with, if unset the keys of the SSH agent are used
+      # key_file: /etc/minima/id_ed25519
+      # optional known hosts file to check the host key against, ~/.ssh/known_hosts by default
+      # known_hosts_file: /etc/minima/known_hosts
+      # uncomment to save to a WebDAV share instead, eg. Nextcloud or Apache mod_dav, with repos swapped in
+      # at the end of each sync. ca_cert_file and insecure_skip_verify also apply
+      # type: webdav
+      # endpoint: https://cloud.example.com/remote.php/dav/files/minima/mirror
+      # username: minima
+      # password: INSERT_PASSWORD_HERE
+      # uncomment to upload to a repository of an artifact manager with HTTP PUT, eg. Artifactory generic or
+      # Nexus raw. Packages are uploaded in place, metadata at the end of each sync. username and password
+      # or token authenticate, ca_cert_file and insecure_skip_verify also apply
+      # type: http_put
+      # endpoint: https://artifactory.example.com/artifactory/mirror-generic
+      # token: INSERT_ACCESS_TOKEN_HERE
+      # uncomment to publish packages only to a hosted yum repository of artifactory or nexus instead, which
+      # generate metadata themselves (Artifactory is asked to after each sync). Their metadata folder depth
+      # must match the depth of repo paths
+      # artifact_manager: artifactory
+      # uncomment to save to path as a local staging directory, whose packages are published after every
+      # successful sync to a Pulp 3 RPM repository, publication and distribution named after the repo path.
+      # Pulp generates metadata itself. username, password, ca_cert_file and insecure_skip_verify also apply, as
+      # do the buffer_size, fsync, file attributes and provenance_xattrs settings of file storage to path
+      # type: pulp
+      # path: /srv/minima/staging
+      # endpoint: https://pulp.example.com
+      # uncomment to save to path as a local staging directory, pushed with rsync (3.2.3 or later) over SSH to
+      # each target after every successful sync. username, key_file and known_hosts_file also apply
+      # type: rsync
+      # path: /srv/minima/staging
+      # rsync_targets:
+      #   - mirror1.example.com:/srv/www/htdocs
+      #   - mirror2.example.com:/srv/www/htdocs
+      # optional template of repo paths below path, bucket or container, by default {path} (the path of the repo URL).
+      # Available variables are {host}, {path}, {reponame}, {arch} and any in the repo variables,
+      # SCC repos also define {product} and {version}
+      # path_template: "{product}/{version}/{arch}/{reponame}"
+      # uncomment to store a SHA256SUMS file in every repo, listing the checksums of all its files for
+      # verification with sha256sum -c
+      # sha256sums: true
+      # optional base URLs the storage is published at, eg. by several web servers. Every repo then gets
+      # mirrorlist and metalink.xml files listing all of them, for clients to fail over between mirrors
+      # (eg. metalink=https://mirror1.example.com/<repo path>/metalink.xml in .repo files)
+      # mirrors:
+      #   - https://mirror1.example.com/
+      #   - https://mirror2.example.com/minima/
+      # uncomment to append every file added, replaced or deleted in repos to a log in JSON lines, with its
+      # SHA-256 checksum and source URL. Targets need their own audit_log
+      # audit_log: /var/log/minima/audit.log
+
+    # optional additional storages, with the same settings as the storage section, that repos can be
+    # written to in the same sync with targets
+    # targets:
+    #   offsite:
+    #     type: s3
+    #     region: eu-central-1
+    #     bucket: minima-offsite
+
+    # optional key to sign metadata rewritten by minima (eg. with regenerate_metadata)
+    # signing:
+    #   key_file: /etc/minima/signing-key.asc
+    #   passphrase: INSERT_PASSPHRASE_HERE
+
+    # uncomment to serve the mirror with minima serve, for storage of type file
+    # serve:
+    #   listen: ":8080"
+    #   # uncomment to require basic authentication
+    #   # username: user
+    #   # password: INSERT_PASSWORD_HERE
+    #   # uncomment to serve HTTPS
+    #   # cert_file: /etc/minima/server.crt
+    #   # key_file: /etc/minima/server.key
+    #   # or get certificates from Let's Encrypt, which must reach this host on port 443
+    #   # acme_domains: [mirror.example.com]
+    #   # acme_email: admin@example.com
+    #   # acme_cache_dir: /var/lib/minima/acme
+    #   # uncomment to write access logs to a file instead of standard error, or "off"
+    #   # access_log: /var/log/minima/access.log
+
+    # uncomment to refuse repos whose metadata lists files with MD5 or SHA-1 checksums only, sha256 or sha512
+    # min_checksum: sha256
+    # uncomment to only use FIPS 140-3 approved SHA-2 hashes to verify repos. Repos with MD5 or SHA-1
+    # checksums or signatures fail to sync. Always on when minima runs with GODEBUG=fips140=on
+    # fips: true
+    # uncomment to keep downloads failing checksum or signature verification for inspection, each with a
+    # .json report of the repo, file, expected checksum and error. Every failed attempt is kept
+    # quarantine_dir: /var/lib/minima/quarantine
+    # uncomment so that of several replicas, eg. of a Kubernetes CronJob or Deployment, only the one holding
+    # a lease syncs. The lease is a Lease object (lock: kubernetes, in the namespace of the pod by default) or a
+    # file on storage shared by all replicas (lock: file). It is renewed while syncing and released afterwards
+    # leader_election:
+    #   lock: kubernetes
+    #   # name: minima
+    #   # namespace: mirrors
+    #   # file: /srv/mirror/.minima.lock
+    #   # time after which a lease that is not renewed, eg. of a crashed replica, can be taken over
+    #   # lease_duration: 1m
+    #   # uncomment to wait for the lease and then sync, rather than exiting without syncing
+    #   # standby: true
+    # uncomment so that instances sharing this configuration partition repos between them rather than each
+    # syncing all of them. Instances register in a backend, Lease objects (backend: kubernetes) or files in a
+    # shared directory (backend: file), wait settle_time for others and sync the repos hashed to them.
+    # Instances are expected to start together, eg. from the same schedule
+    # sharding:
+    #   backend: kubernetes
+    #   # name: minima
+    #   # namespace: mirrors
+    #   # directory: /srv/mirror/.minima-members
+    #   # lease_duration: 1m
+    #   # settle_time: 10s
 
     http:
       - url: http://download.opensuse.org/repositories/myrepo1/openSUSE_Leap_42.3/
         archs: [x86_64]
+        # uncomment to park the repo like a disabled zypper repo: it is not synced, but its configuration
+        # and mirrored content are kept
+        # enabled: false
+        # uncomment to label the repo, so that sync --tags security only syncs repos with that tag
+        # tags: [security, sles15]
+        # uncomment to avoid mirroring file lists and changelogs
+        # skip_filelists: true
+        # skip_other: true
+        # uncomment to rewrite metadata so that it only lists packages in the selected archs
+        # regenerate_metadata: true
+        # uncomment to publish each arch in its own subdirectory (eg. myrepo1/openSUSE_Leap_42.3/x86_64/)
+        # split_archs: true
+        # uncomment to write this repo to several storages, default being the storage section. Each
+        # target is committed separately and the outcome of each is logged
+        # targets: [default, offsite]
+        # uncomment to override the storage class for this repo
+        # storage_class: STANDARD_IA
+        # uncomment to override the storage path template for this repo
+        # path_template: "{product}/{version}/{arch}/{reponame}"
+        # name: myrepo1
+        # variables:
+        #   product: openSUSE
+        #   version: "42.3"
+        # optional local files to publish in the repo on every sync
+        # extra_files:
+        #   - source: /etc/minima/corporate.repo
+        #   - source: /etc/minima/RPM-GPG-KEY-corporate
+        #     target: keys/RPM-GPG-KEY-corporate
+        # optional shell commands run before and after syncing the repo, with MINIMA_REPO_NAME,
+        # MINIMA_REPO_PATH, MINIMA_REPO_URL and, for storage of type file, MINIMA_REPO_DIR in their
+        # environment. post_sync also gets MINIMA_CHANGED_FILES and MINIMA_SYNC_STATUS (ok or failed).
+        # The repo is not synced if pre_sync fails. Merged repos accept hooks too
+        # pre_sync: systemctl stop repo-consumer
+        # post_sync: systemctl start repo-consumer
+        # optional shell command run for each new file before the repo is committed, with the file in
+        # MINIMA_FILE, its path in the repo in MINIMA_FILE_PATH and its checksum in MINIMA_FILE_CHECKSUM.
+        # The sync fails if it rejects a file
+        # file_hook: clamscan --no-summary "$MINIMA_FILE"
+        # uncomment to accept MD5 and SHA-1 checksums despite min_checksum, for legacy vendor repos. Merged
+        # repos accept it too
+        # allow_weak_checksums: true
+        # uncomment to verify the GPG signature embedded in each RPM against these keys, so that packages
+        # are checked even if metadata was tampered with. Merged repos accept it too
+        # rpm_keys: [/etc/minima/RPM-GPG-KEY-vendor]
+        # uncomment to pin the repo to the repomd.xml with this <revision>, or to the repomd.xml or Release
+        # with this SHA-256 checksum, for reproducible frozen mirrors. Syncs fail once upstream changes
+        # revision: "1700000000"
+        # snapshot: 45f93954dcd8bc9e0e6a9b5990cb44240fe09dcf9a52e5acd47d1cad78314d56
+        # uncomment to mirror the repo as it was at a date, included, or RFC 3339 time: advisories issued
+        # later are dropped from updateinfo, with the packages only they list. Metadata is regenerated
+        # as_of: 2024-01-31
+        # uncomment to only mirror the packages of advisories issued since the last sync, with regenerated
+        # metadata, as an incremental patch channel. The repo is kept as it is until newer advisories appear
+        # patch_stream: true
+        # optional shell patterns of License tags, or of licenses in SPDX expressions, whose packages are
+        # not mirrored nor listed in the regenerated metadata. Excluded packages are logged
+        # exclude_licenses: [SUSE-NonFree, "*Proprietary*"]
+        # optional maximum size of the packages of the repo, with a K, M, G or T suffix. Syncs exceeding it
+        # fail, or with the evict quota policy first leave out the oldest builds of packages having newer
+        # ones, regenerating metadata
+        # max_size: 50G
+        # quota_policy: evict
+        # uncomment to download packages of 8 MiB or more that upstream publishes zsync control files for,
+        # like MirrorBrain servers, only in part: blocks found in the previous version of the package are
+        # copied from it, the others are requested by range
+        # zsync: true
+        # uncomment to repair stored copies of packages of 8 MiB or more that are corrupted or partial, eg.
+        # ISO images, with the piece checksums of the .meta4 metalinks or .torrent files upstream publishes next
+        # to them: only the damaged pieces are requested by range
+        # repair_pieces: true
+        # uncomment to store gzip metadata compressed with zstd, or zstd metadata with gzip (gz), eg. for older
+        # clients. repomd.xml is rewritten accordingly and metadata is downloaded again at every sync
+        # recompress_metadata: zst
+        # uncomment to store packages in subdirectories named after the first two hex digits of their checksum,
+        # like x86_64/3f/foo-1.0-1.x86_64.rpm, for repos with hundreds of thousands of packages. primary is
+        # rewritten accordingly. Packages are downloaded again at the first sync with or without it
+        # shard_packages: true
+
+      # repos requiring a TLS client certificate, like the Red Hat CDN with an entitlement certificate of
+      # subscription-manager. The key defaults to the certificate name with a -key suffix
+      # - url: https://cdn.redhat.com/content/dist/rhel9/9/x86_64/baseos/os/
+      #   archs: [x86_64]
+      #   client_cert_file: /etc/pki/entitlement/1234567890.pem
+      #   ca_cert_file: /etc/rhsm/ca/redhat-uep.pem
+
+      # repos behind an OAuth2 gateway, like some vendor content APIs. Access tokens are requested from
+      # token_url with the client credentials and scopes (client_secret can be given instead of the file), and
+      # requested again when they expire during long syncs
+      # - url: https://content.example.com/api/repos/product/x86_64/
+      #   archs: [x86_64]
+      #   oauth2:
+      #     token_url: https://login.example.com/oauth2/token
+      #     client_id: minima
+      #     client_secret_file: /etc/minima/oauth2-secret
+      #     scopes: [content.read]
+
+    # optional section to combine several repos into a single one, saved under <storage path>/<name>
+    # merge:
+    #   - name: SLES15-SP5-merged
+    #     urls:
+    #       - http://download.opensuse.org/repositories/myrepo1/15.5/
+    #       - http://download.opensuse.org/repositories/myrepo1/15.5-updates/
+    #     archs: [x86_64]
 
     # optional section to download repos from SCC
     # scc:
     #   username: UC7
     #   password: INSERT_PASSWORD_HERE
+    #   # or read them at every sync from a file with username= and password= lines
+    #   # credentials_file: /etc/zypp/credentials.d/SCCcredentials
     #   repositories:
 	#     - names:
     #       - SLE-Product-SLES15-SP5-Pool
 	#       - SLE-Product-SLES15-SP5-Updates
     #       archs: [x86_64]
+    #   # uncomment to also select repos by distro target and name, all filters accept globs
+    #   # match_products: [sle-15]
+    #   # match_archs: [x86_64]
+    #   # match_names: ["*15-SP5-*"]
+    #   # like rmt-cli products enable, free extensions such as PackageHub and modules that SCC does
+    #   # not recommend are left out of matches, uncomment to select them too
+    #   # free_extensions: true
+    #   # all_modules: true
+    #   # uncomment to cache the listing of repos, refreshed after cache_ttl or with sync --refresh
+    #   # cache_file: /var/cache/minima/scc.json
+    #   # cache_ttl: 1h
+    #   # or mirror repos of several organizations, each with the settings above and a path_prefix
+    #   # for its repos in the storage
+    #   # organizations:
+    #   #   - username: UC7
+    #   #     password: INSERT_PASSWORD_HERE
+    #   #     path_prefix: customer-a
+    #   #     match_products: [sle-15]
+
+    # optional section to mirror repos of an RMT server, with the credentials of a system registered to
+    # it. Repos are selected with repositories and match_* filters like in the scc section
+    # rmt:
+    #   url: https://rmt.example.com
+    #   credentials_file: /etc/zypp/credentials.d/SCCcredentials
+    #   match_products: [sle-15]
+    #   match_archs: [x86_64]
+
+    # optional section to mirror the repos of the software channels of an Uyuni or SUSE Manager server,
+    # following channels added to or removed from it. Repos also define {channel} for path templates
+    # uyuni:
+    #   url: https://uyuni.example.com
+    #   username: admin
+    #   password: INSERT_PASSWORD_HERE
+    #   # uncomment to select channels by label, all channels by default
+    #   # channels: ["sle-*-x86_64"]
+
+    # optional section to mirror published repositories of OBS projects, resolved with the OBS API with the
+    # credentials of the obs section unless given here. Defaults are for build.opensuse.org
+    # obs_projects:
+    #   api_url: https://api.opensuse.org
+    #   download_url: https://download.opensuse.org/repositories
+    #   projects:
+    #     - project: home:foo:tools
+    #       # uncomment to select repositories and archs, all by default
+    #       # repositories: [openSUSE_Leap_15.6]
+    #       # archs: [x86_64]
   `,
 		Run: func(cmd *cobra.Command, args []string) {
-			initConfig()
 			quiet, _ := cmd.Flags().GetBool("quiet")
+			options := syncOptionsFromFlags(quiet)
+			batch := batchSync()
+			var files []string
+			if batch {
+				var err error
+				files, err = configFiles()
+				if err != nil {
+					log.Fatal(err)
+				}
+			} else {
+				initConfig()
+			}
 
-			var errorflag bool = false
-			syncers, err := syncersFromConfig(cfgString, quiet)
-			if err != nil {
-				log.Fatal(err)
-				errorflag = true
+			if dryRun {
+				if !batch {
+					err := printDiscoveredRepos(os.Stdout, cfgString, options)
+					if err != nil {
+						log.Fatal(err)
+					}
+					return
+				}
+				for _, file := range files {
+					data, err := os.ReadFile(file)
+					if err != nil {
+						log.Fatal(err)
+					}
+					fmt.Printf("# %s\n", file)
+					err = printDiscoveredRepos(os.Stdout, string(data), options)
+					if err != nil {
+						log.Fatalf("%s: %v", file, err)
+					}
+				}
+				return
 			}
-			for _, syncer := range syncers {
-				log.Printf("Processing repo: %s", syncer.URL.String())
-				err := syncer.StoreRepo()
-				if err != nil {
-					log.Println(err)
-					errorflag = true
-				} else {
-					log.Println("...done.")
+
+			// interrupted syncs stop promptly, leaving published repos as they were
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			run := Sync
+			if tuiMode {
+				run = syncWithTUI
+			}
+			var result SyncResult
+			var err error
+			if batch {
+				result, err = syncConfigFiles(ctx, files, run, options)
+				if err == nil {
+					err = printSyncReport(os.Stdout, result)
 				}
+			} else {
+				result, err = run(ctx, cfgString, options)
+			}
+			if err != nil {
+				log.Fatal(err)
 			}
-			if errorflag {
+			if len(result.Failed()) > 0 {
 				os.Exit(1)
 			}
 		},
@@ -80,89 +477,1137 @@ var (
 	thisRepo           string
 	archs              string
 	skipLegacyPackages bool
+	packagesFrom       string
+	cvesFrom           string
+	paranoid           bool
+	refreshSCC         bool
+	dryRun             bool
+	tuiMode            bool
+	syncTags           string
+	repoGlobs          string
 )
 
+// defaultTarget is the name of the storage section in repo targets
+const defaultTarget = "default"
+
 // Config maps the configuration in minima.yaml
 type Config struct {
 	Storage get.StorageConfig
+	// additional storages repos can be written to, by name
+	Targets map[string]get.StorageConfig
+	Signing get.SigningConfig
 	SCC     get.SCC
+	RMT     get.RMT
+	Uyuni   get.Uyuni
 	OBS     updates.OBS
 	HTTP    []get.HTTPRepoConfig
+	Merge   []get.MergeRepoConfig
+	Serve   ServeConfig
+
+	// OBS projects to mirror published repositories of
+	OBSProjects get.OBSProjects `yaml:"obs_projects"`
+	// weakest checksum type accepted in repo metadata, eg. sha256
+	MinChecksum string `yaml:"min_checksum"`
+	// only use FIPS 140-3 approved algorithms to verify repos
+	FIPS bool `yaml:"fips"`
+	// local directory downloads failing verification are moved to
+	QuarantineDir string `yaml:"quarantine_dir"`
+	// lease that only one replica syncs with
+	LeaderElection get.LeaderElection `yaml:"leader_election"`
+	// partitioning of repos between instances
+	Sharding get.Sharding
+}
+
+// SyncOptions customizes Sync for programs embedding minima
+type SyncOptions struct {
+	// Logger receives progress messages, the standard logger if nil
+	Logger *log.Logger
+	// Storage, if set, replaces get.NewStorage to create the storage of each repo in each target
+	Storage func(storageConfig get.StorageConfig, repo get.StorageRepo) (get.Storage, error)
+	// Events, if set, is called with the progress of each repo
+	Events func(get.Event)
+	// Quiet omits messages for each file
+	Quiet bool
+	// Repository, if set, is the only SCC, RMT or Uyuni repo synced, in Archs for SCC and RMT (x86_64 if
+	// none), like --repository and --arch
+	Repository string
+	Archs      []string
+	// Names, if set, limits syncs to the repos whose names match any of these shell patterns, like --repo
+	Names []string
+	// Tags, if set, limits syncs to the http and merge repos tagged with any of these, like --tags
+	Tags []string
+	// SkipLegacy disables mirroring of i586 and i686 packages, like --nolegacy
+	SkipLegacy bool
+	// PackagesFrom and CVEsFrom, if set, are files listing the packages and CVEs syncs are limited to, like
+	// --packages-from and --cves-from, see get.ReadPackageList and get.ReadCVEList
+	PackagesFrom string
+	CVEsFrom     string
+	// Paranoid hashes again files left by interrupted syncs, like --paranoid
+	Paranoid bool
+	// RefreshSCC ignores cached listings of SCC repos, like --refresh
+	RefreshSCC bool
+}
+
+// syncOptionsFromFlags returns the SyncOptions of the flags of the sync command
+func syncOptionsFromFlags(quiet bool) SyncOptions {
+	return SyncOptions{
+		Quiet:        quiet,
+		Repository:   thisRepo,
+		Archs:        splitFlag(archs),
+		Names:        splitFlag(repoGlobs),
+		Tags:         splitFlag(syncTags),
+		SkipLegacy:   skipLegacyPackages,
+		PackagesFrom: packagesFrom,
+		CVEsFrom:     cvesFrom,
+		Paranoid:     paranoid,
+		RefreshSCC:   refreshSCC,
+	}
+}
+
+// splitFlag returns the comma-separated values of a flag, nil if empty
+func splitFlag(value string) []string {
+	if value == "" {
+		return nil
+	}
+	values := []string{}
+	for _, v := range strings.Split(value, ",") {
+		values = append(values, strings.TrimSpace(v))
+	}
+	return values
+}
+
+// logger returns the Logger of the options, or the standard logger
+func (o SyncOptions) logger() *log.Logger {
+	if o.Logger == nil {
+		return log.Default()
+	}
+	return o.Logger
 }
 
-func syncersFromConfig(configString string, quiet bool) ([]*get.Syncer, error) {
+// newStorage returns the storage of a repo with the Storage of the options, or get.NewStorage
+func (o SyncOptions) newStorage(storageConfig get.StorageConfig, repo get.StorageRepo) (get.Storage, error) {
+	if o.Storage != nil {
+		return o.Storage(storageConfig, repo)
+	}
+	return get.NewStorage(storageConfig, repo)
+}
+
+// RepoResult is the outcome of syncing a repo
+type RepoResult struct {
+	// configuration file of the repo, when syncing several
+	Config string
+	// URL of the repo without credentials and tokens, or name of the merged repo
+	Repo     string
+	Merged   bool
+	Duration time.Duration
+	// nil if the repo was synced
+	Err error
+	// CVEs of --cves-from not fixed by any advisory of the repo
+	UncoveredCVEs []string
+}
+
+// SyncResult lists the outcome of each repo of a Sync, in order
+type SyncResult struct {
+	Repos []RepoResult
+}
+
+// Failed returns the results of the repos that could not be synced
+func (r SyncResult) Failed() []RepoResult {
+	failed := []RepoResult{}
+	for _, repo := range r.Repos {
+		if repo.Err != nil {
+			failed = append(failed, repo)
+		}
+	}
+	return failed
+}
+
+// Sync syncs the repos of configString, YAML like minima.yaml, then writes merged repos, like the sync
+// command. It returns an error if the configuration is invalid or repos cannot be discovered, failures
+// of single repos are in the result. Once ctx is done, downloads are aborted and no further repo is synced.
+// With leader_election, repos are only synced while its lease is held, and the result is empty if another
+// replica holds it. With sharding, only the repos of the shard of the instance are synced and in the result
+func Sync(ctx context.Context, configString string, options SyncOptions) (SyncResult, error) {
 	config, err := parseConfig(configString)
 	if err != nil {
-		return nil, err
+		return SyncResult{}, err
+	}
+	if config.Sharding.Backend != "" {
+		shard, err := get.JoinShard(ctx, config.Sharding, options.logger())
+		if err != nil {
+			return SyncResult{}, err
+		}
+		defer shard.Leave(context.WithoutCancel(ctx))
+		return syncRepos(ctx, configString, options, shard)
+	}
+	if config.LeaderElection.Lock == "" {
+		return syncRepos(ctx, configString, options, nil)
+	}
+	result := SyncResult{}
+	_, err = get.RunAsLeader(ctx, config.LeaderElection, options.logger(), func(ctx context.Context) (err error) {
+		result, err = syncRepos(ctx, configString, options, nil)
+		return
+	})
+	return result, err
+}
+
+// syncRepos is Sync without leader election, of the repos of shard only if not nil
+func syncRepos(ctx context.Context, configString string, options SyncOptions, shard *get.Shard) (SyncResult, error) {
+	logger := options.logger()
+	result := SyncResult{}
+	syncers, err := syncersFromConfig(configString, options)
+	if err != nil {
+		return result, err
+	}
+	mergers, err := mergersFromConfig(configString, options)
+	if err != nil {
+		return result, err
+	}
+
+	for _, syncer := range syncers {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if !shardOwns(shard, logger, redactURL(&syncer.URL)) {
+			continue
+		}
+		logger.Printf("Processing repo: %s", redactURL(&syncer.URL))
+		start := time.Now()
+		err := syncer.StoreRepoContext(ctx)
+		if err != nil {
+			logger.Println(err)
+		} else {
+			logger.Println("...done.")
+		}
+		repoResult := RepoResult{Repo: redactURL(&syncer.URL), Duration: time.Since(start), Err: err}
+		if syncer.OnlyCVEs != nil {
+			repoResult.UncoveredCVEs = syncer.UncoveredCVEs()
+		}
+		result.Repos = append(result.Repos, repoResult)
+	}
+	if options.CVEsFrom != "" {
+		logCVEReport(logger, result)
+	}
+	for _, merger := range mergers {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if !shardOwns(shard, logger, merger.Name) {
+			continue
+		}
+		logger.Printf("Processing merged repo: %s", merger.Name)
+		start := time.Now()
+		err := merger.StoreRepoContext(ctx)
+		if err != nil {
+			logger.Println(err)
+		} else {
+			logger.Println("...done.")
+		}
+		result.Repos = append(result.Repos, RepoResult{Repo: merger.Name, Merged: true, Duration: time.Since(start), Err: err})
 	}
-	//---passing the flag value to a global variable in get package, to disables syncing of i586 and i686 rpms (usually inside x86_64)
-	get.SkipLegacy = skipLegacyPackages
 
-	if config.SCC.Username != "" {
-		if thisRepo != "" {
-			if archs == "" {
-				archs = "x86_64"
+	config, err := parseConfig(configString)
+	if err != nil {
+		return result, err
+	}
+	if config.Storage.Layout == get.LayoutUyuni {
+		for _, organization := range config.SCC.AllOrganizations() {
+			name := strings.TrimSpace("SCC data " + organization.PathPrefix)
+			if !shardOwns(shard, logger, name) {
+				continue
 			}
-			config.SCC.Repositories = []get.SCCReposConfig{
-				{
-					Names: []string{thisRepo},
-					Archs: strings.Split(archs, ","),
-				},
+			logger.Println("Storing SCC data for Uyuni...")
+			start := time.Now()
+			err := storeUyuniSCCData(config.Storage, organization, logger)
+			if err != nil {
+				logger.Println(err)
 			}
+			result.Repos = append(result.Repos, RepoResult{Repo: name, Duration: time.Since(start), Err: err})
 		}
+	}
+	return result, nil
+}
 
-		httpRepoConfigs, err := get.SCCToHTTPConfigs(sccUrl, config.SCC.Username, config.SCC.Password, config.SCC.Repositories, quiet)
+// shardOwns reports whether the repo with name is synced by the instance, always without shard
+func shardOwns(shard *get.Shard, logger *log.Logger, name string) bool {
+	if shard == nil {
+		return true
+	}
+	owner := shard.Owner(name)
+	if owner != shard.Identity() {
+		logger.Printf("Skipping repo: %s, in the shard of %s", name, owner)
+		return false
+	}
+	return true
+}
+
+// storeUyuniSCCData stores the SCC data of an organization at its path prefix, for Uyuni to sync from
+func storeUyuniSCCData(storageConfig get.StorageConfig, organization get.SCC, logger *log.Logger) error {
+	username, password, err := organization.Credentials()
+	if err != nil {
+		return err
+	}
+	directory := filepath.Join(storageConfig.Path, filepath.FromSlash(organization.PathPrefix))
+	return get.StoreUyuniSCCData(directory, sccUrl, username, password, logger)
+}
+
+// logCVEReport logs the CVEs of --cves-from that no synced repo fixes
+func logCVEReport(logger *log.Logger, result SyncResult) {
+	uncovered := map[string]int{}
+	synced := 0
+	for _, repo := range result.Repos {
+		if repo.Merged || repo.Err != nil {
+			continue
+		}
+		synced++
+		for _, cve := range repo.UncoveredCVEs {
+			uncovered[cve]++
+		}
+	}
+	if synced == 0 {
+		return
+	}
+	cves := []string{}
+	for cve, count := range uncovered {
+		if count == synced {
+			cves = append(cves, cve)
+		}
+	}
+	if len(cves) == 0 {
+		logger.Println("All CVEs are fixed by synced repos")
+		return
+	}
+	slices.Sort(cves)
+	logger.Printf("CVEs not fixed by any synced repo: %s", strings.Join(cves, ", "))
+}
+
+func syncersFromConfig(configString string, options SyncOptions) ([]*get.Syncer, error) {
+	quiet := options.Quiet
+	config, err := parseConfig(configString)
+	if err != nil {
+		return nil, err
+	}
+	var onlyPackages *get.PackageList
+	if options.PackagesFrom != "" {
+		onlyPackages, err = get.ReadPackageList(options.PackagesFrom)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var onlyCVEs map[string]bool
+	if options.CVEsFrom != "" {
+		onlyCVEs, err = get.ReadCVEList(options.CVEsFrom)
 		if err != nil {
 			return nil, err
 		}
-		config.HTTP = append(config.HTTP, httpRepoConfigs...)
+	}
+
+	config.HTTP, err = discoverHTTPRepos(config, options)
+	if err != nil {
+		return nil, err
+	}
+
+	signingKey, err := signingKeyFromConfig(config)
+	if err != nil {
+		return nil, err
 	}
 
 	syncers := []*get.Syncer{}
+	dedup := get.NewDedup()
 	for _, httpRepo := range config.HTTP {
 		repoURL, err := url.Parse(httpRepo.URL)
 		if err != nil {
 			return nil, err
 		}
 
-		archs := map[string]bool{}
-		for _, archString := range httpRepo.Archs {
-			archs[archString] = true
+		newSyncer := func(archs []string) (*get.Syncer, error) {
+			storage, err := targetsStorageFromConfig(config, httpRepo.Targets, options.Logger, func(storageConfig get.StorageConfig) (get.Storage, error) {
+				repoPath, err := repoPathFromConfig(storageConfig, httpRepo, repoURL, archs)
+				if err != nil {
+					return nil, err
+				}
+				if httpRepo.StorageClass != "" {
+					storageConfig.StorageClass = httpRepo.StorageClass
+				}
+				return options.newStorage(storageConfig, get.StorageRepo{Path: repoPath, Name: repoName(httpRepo, repoURL), Upstream: repoURL.String()})
+			})
+			if err != nil {
+				return nil, err
+			}
+			repoPath, err := repoPathFromConfig(config.Storage, httpRepo, repoURL, archs)
+			if err != nil {
+				return nil, err
+			}
+			variables := hookVariables(config.Storage, repoName(httpRepo, repoURL), repoPath, redactURL(repoURL))
+			if httpRepo.FileHook != "" {
+				storage = get.NewFileHookStorage(storage, httpRepo.FileHook, variables, options.Logger)
+			}
+			syncer := get.NewSyncer(*repoURL, archMap(archs), storage, quiet)
+			syncer.SkipFilelists = httpRepo.SkipFilelists
+			syncer.SkipOther = httpRepo.SkipOther
+			syncer.SkipLegacy = options.SkipLegacy
+			syncer.OnlyPackages = onlyPackages
+			syncer.OnlyCVEs = onlyCVEs
+			syncer.FIPS = config.FIPS
+			syncer.RegenerateMetadata = httpRepo.RegenerateMetadata
+			syncer.SigningKey = signingKey
+			syncer.ExtraFiles = httpRepo.ExtraFiles
+			syncer.RefreshURL = httpRepo.RefreshURL
+			syncer.Logger = options.Logger
+			syncer.Events = options.Events
+			syncer.Client, err = get.RepoHTTPClient(httpRepo)
+			if err != nil {
+				return nil, err
+			}
+			syncer.Hooks = httpRepo.SyncHooks
+			syncer.HookVariables = variables
+			syncer.QuarantineDir = config.QuarantineDir
+			if config.Storage.Type == "file" && len(httpRepo.Targets) == 0 && options.Storage == nil && !options.Paranoid {
+				syncer.ChecksumCache = get.NewChecksumCache(filepath.Join(config.Storage.Path, filepath.FromSlash(repoPath)))
+			}
+			if !httpRepo.AllowWeakChecksums {
+				syncer.MinChecksum = config.MinChecksum
+			}
+			syncer.Revision = httpRepo.Revision
+			syncer.Snapshot = strings.ToLower(httpRepo.Snapshot)
+			syncer.PatchStream = httpRepo.PatchStream
+			syncer.ExcludeLicenses = httpRepo.ExcludeLicenses
+			if httpRepo.MaxSize != "" {
+				syncer.MaxSize, err = get.ParseSize(httpRepo.MaxSize)
+				if err != nil {
+					return nil, err
+				}
+			}
+			syncer.QuotaPolicy = httpRepo.QuotaPolicy
+			syncer.Zsync = httpRepo.Zsync
+			syncer.RepairPieces = httpRepo.RepairPieces
+			syncer.RecompressMetadata = httpRepo.RecompressMetadata
+			syncer.ShardPackages = httpRepo.ShardPackages
+			syncer.Dedup = dedup
+			if httpRepo.AsOf != "" {
+				syncer.AsOf, err = parseAsOf(httpRepo.AsOf)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if len(httpRepo.RPMKeys) > 0 {
+				syncer.PackageKeys, err = get.ReadPackageKeys(httpRepo.RPMKeys)
+				if err != nil {
+					return nil, err
+				}
+			}
+			return syncer, nil
+		}
+
+		if !httpRepo.SplitArchs {
+			syncer, err := newSyncer(httpRepo.Archs)
+			if err != nil {
+				return nil, err
+			}
+			syncers = append(syncers, syncer)
+			continue
 		}
 
-		var storage get.Storage
-		switch config.Storage.Type {
-		case "file":
-			storage = get.NewFileStorage(filepath.Join(config.Storage.Path, filepath.FromSlash(repoURL.Path)))
-		case "s3":
-			storage, err = get.NewS3Storage(config.Storage.AccessKeyID, config.Storage.AccessKeyID, config.Storage.Region, config.Storage.Bucket+repoURL.Path)
+		// each arch is published in its own subdirectory, with metadata listing only its packages
+		for _, arch := range httpRepo.Archs {
+			syncer, err := newSyncer([]string{arch})
 			if err != nil {
 				return nil, err
 			}
+			syncer.RegenerateMetadata = true
+			syncers = append(syncers, syncer)
 		}
-		syncers = append(syncers, get.NewSyncer(*repoURL, archs, storage, quiet))
 	}
 
 	return syncers, nil
 }
 
+// discoverHTTPRepos returns the enabled repos of the http section selected by the Tags of options and,
+// without Tags, those discovered from SCC, RMT, Uyuni and OBS projects, limited to the Names of options
+func discoverHTTPRepos(config Config, options SyncOptions) ([]get.HTTPRepoConfig, error) {
+	quiet := options.Quiet
+	httpRepos := []get.HTTPRepoConfig{}
+	for _, httpRepo := range config.HTTP {
+		if !httpRepo.Disabled() && selectedByTags(options.Tags, httpRepo.Tags) {
+			httpRepos = append(httpRepos, httpRepo)
+		}
+	}
+	if len(options.Tags) > 0 {
+		// discovered repos have no tags
+		return selectedByNames(options.Names, httpRepos)
+	}
+
+	for _, organization := range config.SCC.AllOrganizations() {
+		if options.Repository != "" {
+			organization.Repositories = repositoryConfigs(options)
+			organization.SCCFilter = get.SCCFilter{}
+		}
+
+		username, password, err := organization.Credentials()
+		if err != nil {
+			return nil, err
+		}
+		httpRepoConfigs, err := get.SCCToHTTPConfigs(sccUrl, username, password, organization.Repositories, organization.SCCFilter,
+			get.SCCCache{File: organization.CacheFile, TTL: organization.CacheTTL, Refresh: options.RefreshSCC}, quiet, options.Logger)
+		if err != nil {
+			return nil, err
+		}
+		for i := range httpRepoConfigs {
+			httpRepoConfigs[i].PathPrefix = organization.PathPrefix
+		}
+		httpRepos = append(httpRepos, httpRepoConfigs...)
+	}
+
+	if config.RMT.URL != "" {
+		rmt := config.RMT
+		if options.Repository != "" {
+			rmt.Repositories = repositoryConfigs(options)
+			rmt.SCCFilter = get.SCCFilter{}
+		}
+		username, password, err := rmt.Credentials()
+		if err != nil {
+			return nil, err
+		}
+		httpRepoConfigs, err := get.RMTToHTTPConfigs(rmt.URL, username, password, rmt.Repositories, rmt.SCCFilter, quiet, options.Logger)
+		if err != nil {
+			return nil, err
+		}
+		for i := range httpRepoConfigs {
+			httpRepoConfigs[i].PathPrefix = rmt.PathPrefix
+		}
+		httpRepos = append(httpRepos, httpRepoConfigs...)
+	}
+
+	if config.Uyuni.URL != "" {
+		httpRepoConfigs, err := get.UyuniToHTTPConfigs(config.Uyuni, quiet, options.Logger)
+		if err != nil {
+			return nil, err
+		}
+		for _, httpRepoConfig := range httpRepoConfigs {
+			if options.Repository != "" && httpRepoConfig.Name != options.Repository {
+				continue
+			}
+			httpRepoConfig.PathPrefix = config.Uyuni.PathPrefix
+			httpRepos = append(httpRepos, httpRepoConfig)
+		}
+	}
+
+	if len(config.OBSProjects.Projects) > 0 {
+		obsProjects := config.OBSProjects
+		if obsProjects.Username == "" {
+			obsProjects.Username = config.OBS.Username
+			obsProjects.Password = config.OBS.Password
+		}
+		httpRepoConfigs, err := get.OBSToHTTPConfigs(obsProjects, quiet, options.Logger)
+		if err != nil {
+			return nil, err
+		}
+		httpRepos = append(httpRepos, httpRepoConfigs...)
+	}
+
+	return selectedByNames(options.Names, httpRepos)
+}
+
+// selectedByNames returns the repos among httpRepos whose names match any of globs, all of them without globs
+func selectedByNames(globs []string, httpRepos []get.HTTPRepoConfig) ([]get.HTTPRepoConfig, error) {
+	selected := []get.HTTPRepoConfig{}
+	for _, httpRepo := range httpRepos {
+		repoURL, err := url.Parse(httpRepo.URL)
+		if err != nil {
+			return nil, err
+		}
+		matched, err := selectedByName(globs, repoName(httpRepo, repoURL))
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			selected = append(selected, httpRepo)
+		}
+	}
+	return selected, nil
+}
+
+// selectedByName returns true if no globs are given, eg. with --repo, or if name matches any of them
+func selectedByName(globs []string, name string) (bool, error) {
+	if len(globs) == 0 {
+		return true, nil
+	}
+	for _, glob := range globs {
+		matched, err := path.Match(glob, name)
+		if err != nil {
+			return false, fmt.Errorf("invalid --repo pattern %s: %v", glob, err)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// selectedByTags returns true if no selected tags are given, eg. with --tags, or if tags contain any of them
+func selectedByTags(selected []string, tags []string) bool {
+	if len(selected) == 0 {
+		return true
+	}
+	for _, tag := range selected {
+		if slices.Contains(tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// repositoryConfigs returns the SCC repo selected by the Repository and Archs of options
+func repositoryConfigs(options SyncOptions) []get.SCCReposConfig {
+	archs := options.Archs
+	if len(archs) == 0 {
+		archs = []string{"x86_64"}
+	}
+	return []get.SCCReposConfig{
+		{
+			Names: []string{options.Repository},
+			Archs: archs,
+		},
+	}
+}
+
+func mergersFromConfig(configString string, options SyncOptions) ([]*get.Merger, error) {
+	config, err := parseConfig(configString)
+	if err != nil {
+		return nil, err
+	}
+
+	signingKey, err := signingKeyFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	var onlyPackages *get.PackageList
+	if options.PackagesFrom != "" {
+		onlyPackages, err = get.ReadPackageList(options.PackagesFrom)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mergers := []*get.Merger{}
+	for _, mergeRepo := range config.Merge {
+		selected, err := selectedByName(options.Names, mergeRepo.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !selected || !selectedByTags(options.Tags, mergeRepo.Tags) {
+			continue
+		}
+		repoURLs := []url.URL{}
+		for _, u := range mergeRepo.URLs {
+			repoURL, err := url.Parse(u)
+			if err != nil {
+				return nil, err
+			}
+			repoURLs = append(repoURLs, *repoURL)
+		}
+
+		storage, err := targetsStorageFromConfig(config, mergeRepo.Targets, options.Logger, func(storageConfig get.StorageConfig) (get.Storage, error) {
+			return options.newStorage(storageConfig, get.StorageRepo{Path: "/" + mergeRepo.Name, Name: mergeRepo.Name, Upstream: strings.Join(mergeRepo.URLs, ",")})
+		})
+		if err != nil {
+			return nil, err
+		}
+		upstreams := []string{}
+		for i := range repoURLs {
+			upstreams = append(upstreams, redactURL(&repoURLs[i]))
+		}
+		variables := hookVariables(config.Storage, mergeRepo.Name, "/"+mergeRepo.Name, strings.Join(upstreams, ","))
+		if mergeRepo.FileHook != "" {
+			storage = get.NewFileHookStorage(storage, mergeRepo.FileHook, variables, options.Logger)
+		}
+		merger := get.NewMerger(mergeRepo.Name, repoURLs, archMap(mergeRepo.Archs), storage, options.Quiet)
+		merger.SigningKey = signingKey
+		merger.Logger = options.Logger
+		merger.Events = options.Events
+		merger.Hooks = mergeRepo.SyncHooks
+		merger.HookVariables = variables
+		merger.QuarantineDir = config.QuarantineDir
+		merger.FIPS = config.FIPS
+		merger.SkipLegacy = options.SkipLegacy
+		merger.OnlyPackages = onlyPackages
+		if !mergeRepo.AllowWeakChecksums {
+			merger.MinChecksum = config.MinChecksum
+		}
+		if len(mergeRepo.RPMKeys) > 0 {
+			merger.PackageKeys, err = get.ReadPackageKeys(mergeRepo.RPMKeys)
+			if err != nil {
+				return nil, err
+			}
+		}
+		mergers = append(mergers, merger)
+	}
+
+	return mergers, nil
+}
+
+// repoPathFromConfig expands the path template of an HTTP repo, falling back to the storage default.
+// archs are the ones published at the path, split_archs repos get one path per arch
+func repoPathFromConfig(storageConfig get.StorageConfig, httpRepo get.HTTPRepoConfig, repoURL *url.URL, archs []string) (string, error) {
+	template := httpRepo.PathTemplate
+	if template == "" {
+		template = storageConfig.PathTemplate
+	}
+	if template == "" {
+		template = get.DefaultPathTemplate
+		if httpRepo.SplitArchs {
+			template += "/{arch}"
+		}
+	}
+	if httpRepo.SplitArchs && !strings.Contains(template, "{arch}") {
+		return "", fmt.Errorf("path template %s for %s must contain {arch} with split_archs", template, httpRepo.URL)
+	}
+
+	variables := map[string]string{
+		"host":     repoURL.Host,
+		"path":     repoURL.Path,
+		"reponame": repoName(httpRepo, repoURL),
+	}
+	if storageConfig.Layout == get.LayoutUyuni {
+		if httpRepo.PathTemplate != "" || httpRepo.SplitArchs {
+			return "", fmt.Errorf("path_template and split_archs are not possible with layout %s for %s", get.LayoutUyuni, httpRepo.URL)
+		}
+		template = get.DefaultPathTemplate
+	}
+	if len(archs) == 1 {
+		variables["arch"] = archs[0]
+	}
+	for key, value := range httpRepo.Variables {
+		variables[key] = value
+	}
+	repoPath, err := get.ExpandPathTemplate(template, variables)
+	if err != nil {
+		return "", err
+	}
+	return path.Join("/", httpRepo.PathPrefix, repoPath), nil
+}
+
+// hookVariables returns the environment variables of hooks of a repo, with its directory if the storage
+// section is of type file
+func hookVariables(storageConfig get.StorageConfig, name string, repoPath string, upstream string) map[string]string {
+	variables := map[string]string{
+		"MINIMA_REPO_NAME": name,
+		"MINIMA_REPO_PATH": repoPath,
+		"MINIMA_REPO_URL":  upstream,
+	}
+	if storageConfig.Type == "file" {
+		variables["MINIMA_REPO_DIR"] = filepath.Join(storageConfig.Path, filepath.FromSlash(repoPath))
+	}
+	return variables
+}
+
+// repoName returns the configured name of an HTTP repo, or the last segment of its URL path
+func repoName(httpRepo get.HTTPRepoConfig, repoURL *url.URL) string {
+	if httpRepo.Name != "" {
+		return httpRepo.Name
+	}
+	return path.Base(repoURL.Path)
+}
+
+// targetsStorageFromConfig returns the Storage of a repo written to targets, or to the storage section
+// if there are none. newStorage returns the Storage of the repo in one of them, in the FIPS mode of config
+// and logging to logger
+func targetsStorageFromConfig(config Config, targets []string, logger *log.Logger, newStorage func(storageConfig get.StorageConfig) (get.Storage, error)) (get.Storage, error) {
+	if len(targets) == 0 {
+		storageConfig := config.Storage
+		storageConfig.FIPS = config.FIPS
+		storageConfig.Logger = logger
+		return newStorage(storageConfig)
+	}
+
+	storageTargets := []*get.StorageTarget{}
+	for _, name := range targets {
+		storageConfig := config.Storage
+		if name != defaultTarget {
+			storageConfig = config.Targets[name]
+		}
+		storageConfig.FIPS = config.FIPS
+		storageConfig.Logger = logger
+		storage, err := newStorage(storageConfig)
+		if err != nil {
+			return nil, fmt.Errorf("target %s: %v", name, err)
+		}
+		storageTargets = append(storageTargets, &get.StorageTarget{Name: name, Storage: storage})
+	}
+	storage := get.NewMultiStorage(storageTargets)
+	storage.Logger = logger
+	return storage, nil
+}
+
+func signingKeyFromConfig(config Config) (*openpgp.Entity, error) {
+	if config.Signing.KeyFile == "" {
+		return nil, nil
+	}
+	return get.ReadSigningKey(config.Signing.KeyFile, config.Signing.Passphrase)
+}
+
+func archMap(archs []string) map[string]bool {
+	result := map[string]bool{}
+	for _, archString := range archs {
+		result[archString] = true
+	}
+	return result
+}
+
 func parseConfig(configString string) (Config, error) {
 	config := Config{}
 	if err := yaml.Unmarshal([]byte(configString), &config); err != nil {
 		return config, fmt.Errorf("configuration parse error: %v", err)
 	}
 
-	storageType := config.Storage.Type
-	if storageType != "file" && storageType != "s3" {
-		return config, fmt.Errorf("configuration parse error: unrecognised storage type")
+	if err := validateStorage(config.Storage); err != nil {
+		return config, fmt.Errorf("configuration parse error: %v", err)
+	}
+	if err := get.ValidateMinChecksum(config.MinChecksum); err != nil {
+		return config, fmt.Errorf("configuration parse error: min_checksum: %v", err)
+	}
+	if err := get.ValidateLeaderElection(config.LeaderElection); err != nil {
+		return config, fmt.Errorf("configuration parse error: leader_election: %v", err)
+	}
+	if err := get.ValidateSharding(config.Sharding); err != nil {
+		return config, fmt.Errorf("configuration parse error: sharding: %v", err)
+	}
+	if config.Sharding.Backend != "" && config.LeaderElection.Lock != "" {
+		return config, fmt.Errorf("configuration parse error: sharding is not possible with leader_election, which lets one instance sync all repos")
+	}
+	for name, target := range config.Targets {
+		if name == defaultTarget {
+			return config, fmt.Errorf("configuration parse error: target name %s is reserved for the storage section", defaultTarget)
+		}
+		if err := validateStorage(target); err != nil {
+			return config, fmt.Errorf("configuration parse error: target %s: %v", name, err)
+		}
+	}
+
+	for _, httpRepo := range config.HTTP {
+		if httpRepo.SplitArchs && len(httpRepo.Archs) == 0 {
+			return config, fmt.Errorf("configuration parse error: split_archs requires archs for %s", httpRepo.URL)
+		}
+		if err := validateTargets(config, httpRepo.Targets); err != nil {
+			return config, fmt.Errorf("configuration parse error: %v for %s", err, httpRepo.URL)
+		}
+		if storageClass := httpRepo.StorageClass; storageClass != "" && !slices.Contains(s3.StorageClass_Values(), storageClass) {
+			return config, fmt.Errorf("configuration parse error: unrecognised storage class %s", storageClass)
+		}
+		if config.FIPS && httpRepo.AllowWeakChecksums {
+			return config, fmt.Errorf("configuration parse error: allow_weak_checksums is not possible in FIPS mode for %s", httpRepo.URL)
+		}
+		if snapshot := httpRepo.Snapshot; snapshot != "" {
+			if _, err := hex.DecodeString(snapshot); err != nil || len(snapshot) != 64 {
+				return config, fmt.Errorf("configuration parse error: snapshot must be a SHA-256 checksum for %s", httpRepo.URL)
+			}
+		}
+		if httpRepo.MaxSize != "" {
+			if _, err := get.ParseSize(httpRepo.MaxSize); err != nil {
+				return config, fmt.Errorf("configuration parse error: max_size: %v for %s", err, httpRepo.URL)
+			}
+		}
+		if err := get.ValidateOAuth2(httpRepo.OAuth2); err != nil {
+			return config, fmt.Errorf("configuration parse error: oauth2: %v for %s", err, httpRepo.URL)
+		}
+		if err := get.ValidateQuotaPolicy(httpRepo.QuotaPolicy); err != nil {
+			return config, fmt.Errorf("configuration parse error: %v for %s", err, httpRepo.URL)
+		}
+		if err := get.ValidateMetadataCompression(httpRepo.RecompressMetadata); err != nil {
+			return config, fmt.Errorf("configuration parse error: %v for %s", err, httpRepo.URL)
+		}
+		if err := get.ValidateLicensePatterns(httpRepo.ExcludeLicenses); err != nil {
+			return config, fmt.Errorf("configuration parse error: exclude_licenses: %v for %s", err, httpRepo.URL)
+		}
+		if httpRepo.AsOf != "" {
+			if _, err := parseAsOf(httpRepo.AsOf); err != nil {
+				return config, fmt.Errorf("configuration parse error: %v for %s", err, httpRepo.URL)
+			}
+		}
+	}
+
+	if err := validateSCC(config.SCC); err != nil {
+		return config, fmt.Errorf("configuration parse error: %v", err)
+	}
+	if err := validateRMT(config.RMT); err != nil {
+		return config, fmt.Errorf("configuration parse error: %v", err)
+	}
+	if err := validateUyuni(config.Uyuni); err != nil {
+		return config, fmt.Errorf("configuration parse error: %v", err)
+	}
+	for _, project := range config.OBSProjects.Projects {
+		if project.Project == "" {
+			return config, fmt.Errorf("configuration parse error: obs_projects entry without project")
+		}
+	}
+
+	for _, mergeRepo := range config.Merge {
+		if mergeRepo.Name == "" || strings.Contains(mergeRepo.Name, "..") {
+			return config, fmt.Errorf("configuration parse error: invalid merged repo name '%s'", mergeRepo.Name)
+		}
+		if len(mergeRepo.URLs) == 0 {
+			return config, fmt.Errorf("configuration parse error: no urls to merge into %s", mergeRepo.Name)
+		}
+		if err := validateTargets(config, mergeRepo.Targets); err != nil {
+			return config, fmt.Errorf("configuration parse error: %v for %s", err, mergeRepo.Name)
+		}
+		if config.FIPS && mergeRepo.AllowWeakChecksums {
+			return config, fmt.Errorf("configuration parse error: allow_weak_checksums is not possible in FIPS mode for %s", mergeRepo.Name)
+		}
 	}
 	return config, nil
 }
 
+// validateStorage checks the settings of a storage section or target
+func validateStorage(storage get.StorageConfig) error {
+	storageType := storage.Type
+	if !slices.Contains(get.StorageTypes(), storageType) {
+		return fmt.Errorf("unrecognised storage type %s", storageType)
+	}
+	if storageType == "azblob" && (storage.Container == "" || storage.Account == "" && storage.Endpoint == "") {
+		return fmt.Errorf("azblob storage requires account (or endpoint) and container")
+	}
+	if storageType == "swift" && (storage.AuthURL == "" || storage.Username == "" || storage.Project == "" || storage.Container == "") {
+		return fmt.Errorf("swift storage requires auth_url, username, project and container")
+	}
+	if storageType == "sftp" && (storage.Host == "" || storage.Path == "") {
+		return fmt.Errorf("sftp storage requires host and path")
+	}
+	if storageType == "webdav" && storage.Endpoint == "" {
+		return fmt.Errorf("webdav storage requires endpoint")
+	}
+	if storageType == "http_put" && storage.Endpoint == "" {
+		return fmt.Errorf("http_put storage requires endpoint")
+	}
+	if manager := storage.ArtifactManager; manager != "" && (storageType != "http_put" || manager != "artifactory" && manager != "nexus") {
+		return fmt.Errorf("artifact_manager must be artifactory or nexus, with http_put storage")
+	}
+	if storageType == "pulp" && (storage.Endpoint == "" || storage.Path == "") {
+		return fmt.Errorf("pulp storage requires endpoint and path")
+	}
+	if storageType == "rsync" && (storage.Path == "" || len(storage.RsyncTargets) == 0) {
+		return fmt.Errorf("rsync storage requires path and rsync_targets")
+	}
+	if storageType == "b2" {
+		// B2 has a single storage class and no object tags, ACLs or KMS
+		if storage.Region == "" || storage.Bucket == "" {
+			return fmt.Errorf("b2 storage requires region and bucket")
+		}
+		if storage.StorageClass != "" || len(storage.StorageClassRules) > 0 || len(storage.Tags) > 0 || storage.ACL != "" ||
+			storage.ServerSideEncryption == s3.ServerSideEncryptionAwsKms {
+			return fmt.Errorf("b2 storage does not support storage_class, storage_class_rules, tags, acl or %s encryption", s3.ServerSideEncryptionAwsKms)
+		}
+	}
+
+	switch storage.Layout {
+	case "":
+	case get.LayoutUyuni:
+		if storageType != "file" || storage.PathTemplate != "" {
+			return fmt.Errorf("layout %s requires file storage without path_template", get.LayoutUyuni)
+		}
+	default:
+		return fmt.Errorf("unrecognised layout %s", storage.Layout)
+	}
+
+	for _, mirror := range storage.Mirrors {
+		if u, err := url.Parse(mirror); err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("invalid mirror URL %s", mirror)
+		}
+	}
+
+	if partSize := storage.PartSizeMB; partSize != 0 && partSize*1024*1024 < get.DefaultPartSize {
+		return fmt.Errorf("part_size_mb must be at least %d", get.DefaultPartSize/1024/1024)
+	}
+
+	// S3 allows 10 tags per object, one is minima-repo
+	if len(storage.Tags) > 9 {
+		return fmt.Errorf("at most 9 tags can be set")
+	}
+
+	if storage.UploadConcurrency < 0 {
+		return fmt.Errorf("upload_concurrency must be positive")
+	}
+
+	switch storage.ServerSideEncryption {
+	case "", s3.ServerSideEncryptionAes256:
+		if storage.SSEKMSKeyID != "" {
+			return fmt.Errorf("sse_kms_key_id requires server_side_encryption %s", s3.ServerSideEncryptionAwsKms)
+		}
+	case s3.ServerSideEncryptionAwsKms:
+	default:
+		return fmt.Errorf("unrecognised server_side_encryption %s", storage.ServerSideEncryption)
+	}
+
+	storageClasses := []string{storage.StorageClass}
+	for _, rule := range storage.StorageClassRules {
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return fmt.Errorf("invalid storage class pattern %s", rule.Pattern)
+		}
+		storageClasses = append(storageClasses, rule.Class)
+	}
+	for _, storageClass := range storageClasses {
+		if storageClass != "" && !slices.Contains(s3.StorageClass_Values(), storageClass) {
+			return fmt.Errorf("unrecognised storage class %s", storageClass)
+		}
+	}
+
+	if acl := storage.ACL; acl != "" && !slices.Contains(s3.ObjectCannedACL_Values(), acl) {
+		return fmt.Errorf("unrecognised acl %s", acl)
+	}
+	return nil
+}
+
+// validateSCC checks that SCC organizations have their own credentials, storage paths and caches
+func validateSCC(scc get.SCC) error {
+	if len(scc.Organizations) == 0 {
+		return nil
+	}
+	if scc.Username != "" || scc.CredentialsFile != "" || len(scc.Repositories) > 0 || scc.PathPrefix != "" || scc.CacheFile != "" {
+		return fmt.Errorf("scc settings must go in each of the organizations")
+	}
+	prefixes := map[string]bool{}
+	cacheFiles := map[string]bool{}
+	for _, organization := range scc.Organizations {
+		if organization.Username == "" && organization.CredentialsFile == "" {
+			return fmt.Errorf("scc organization without username or credentials_file")
+		}
+		if len(organization.Organizations) > 0 {
+			return fmt.Errorf("scc organizations cannot be nested")
+		}
+		prefix := path.Clean("/" + organization.PathPrefix)
+		if prefix == "/" || strings.Contains(organization.PathPrefix, "..") {
+			return fmt.Errorf("invalid path_prefix '%s' of scc organization %s", organization.PathPrefix, organization.Username)
+		}
+		if prefixes[prefix] {
+			return fmt.Errorf("path_prefix %s of scc organizations must be unique", organization.PathPrefix)
+		}
+		prefixes[prefix] = true
+		if organization.CacheFile != "" && cacheFiles[organization.CacheFile] {
+			return fmt.Errorf("cache_file %s of scc organizations must be unique", organization.CacheFile)
+		}
+		cacheFiles[organization.CacheFile] = true
+	}
+	return nil
+}
+
+// validateRMT checks that an RMT server is given with credentials of a registered system
+func validateRMT(rmt get.RMT) error {
+	if rmt.URL == "" {
+		if rmt.Username != "" || rmt.CredentialsFile != "" {
+			return fmt.Errorf("rmt requires url")
+		}
+		return nil
+	}
+	u, err := url.Parse(rmt.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid rmt url %s", rmt.URL)
+	}
+	if rmt.Username == "" && rmt.CredentialsFile == "" {
+		return fmt.Errorf("rmt requires username and password or credentials_file of a registered system")
+	}
+	return nil
+}
+
+// validateUyuni checks that an Uyuni server is given with credentials
+func validateUyuni(uyuni get.Uyuni) error {
+	if uyuni.URL == "" {
+		if uyuni.Username != "" || len(uyuni.Channels) > 0 {
+			return fmt.Errorf("uyuni requires url")
+		}
+		return nil
+	}
+	u, err := url.Parse(uyuni.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid uyuni url %s", uyuni.URL)
+	}
+	if uyuni.Username == "" || uyuni.Password == "" {
+		return fmt.Errorf("uyuni requires username and password")
+	}
+	return nil
+}
+
+// printDiscoveredRepos writes the repos that would be synced as a table, with their archs and storage
+// paths. Credentials and tokens in URLs are redacted
+func printDiscoveredRepos(writer io.Writer, configString string, options SyncOptions) error {
+	config, err := parseConfig(configString)
+	if err != nil {
+		return err
+	}
+	httpRepos, err := discoverHTTPRepos(config, options)
+	if err != nil {
+		return err
+	}
+
+	table := tabwriter.NewWriter(writer, 0, 8, 2, ' ', 0)
+	for _, httpRepo := range httpRepos {
+		repoURL, err := url.Parse(httpRepo.URL)
+		if err != nil {
+			return err
+		}
+		archSets := [][]string{httpRepo.Archs}
+		if httpRepo.SplitArchs {
+			archSets = [][]string{}
+			for _, arch := range httpRepo.Archs {
+				archSets = append(archSets, []string{arch})
+			}
+		}
+		for _, archs := range archSets {
+			repoPath, err := repoPathFromConfig(config.Storage, httpRepo, repoURL, archs)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(table, "%s\t%s\t%s\n", redactURL(repoURL), strings.Join(archs, ","), repoPath)
+		}
+	}
+	fmt.Fprintf(table, "%d repos\n", len(httpRepos))
+	return table.Flush()
+}
+
+// redactURL returns repoURL without its password and query, which hold the access tokens of SCC repos
+func redactURL(repoURL *url.URL) string {
+	redacted := *repoURL
+	if redacted.RawQuery != "" {
+		redacted.RawQuery = "xxxxx"
+	}
+	return redacted.Redacted()
+}
+
+// validateTargets checks that targets of a repo are defined
+func validateTargets(config Config, targets []string) error {
+	for _, target := range targets {
+		if _, ok := config.Targets[target]; !ok && target != defaultTarget {
+			return fmt.Errorf("undefined target %s", target)
+		}
+	}
+	return nil
+}
+
+// parseAsOf parses the as_of setting of a repo, an RFC 3339 time or a date standing for its end in UTC
+func parseAsOf(value string) (time.Time, error) {
+	if asOf, err := time.Parse(time.RFC3339, value); err == nil {
+		return asOf, nil
+	}
+	date, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("as_of %s is neither a date nor an RFC 3339 time", value)
+	}
+	return date.Add(24*time.Hour - time.Second), nil
+}
+
 func init() {
 	RootCmd.AddCommand(syncCmd)
 	// local flags
 	syncCmd.Flags().StringVarP(&thisRepo, "repository", "r", "", "flag that can specifies a single repo (example: SLES11-SP4-Updates)")
+	syncCmd.RegisterFlagCompletionFunc("repository", completeRepoNames)
 	syncCmd.Flags().StringVarP(&archs, "arch", "a", "", "flag that specifies covered archs in the given repo")
 	syncCmd.Flags().BoolVarP(&skipLegacyPackages, "nolegacy", "l", false, "flag that disables mirroring of i586 and i686 pkgs")
+	syncCmd.Flags().StringVar(&packagesFrom, "packages-from", "", "flag that limits syncs to the packages listed in a file, by name or NEVRA, one per line")
+	syncCmd.Flags().StringVar(&cvesFrom, "cves-from", "", "flag that limits syncs to the advisories fixing the CVEs listed in a file, one per line, and their packages")
+	syncCmd.Flags().BoolVar(&paranoid, "paranoid", false, "flag that hashes again files left by interrupted syncs, even if their size and modification time did not change")
+	syncCmd.Flags().BoolVar(&refreshSCC, "refresh", false, "flag that ignores the cached listing of SCC repos")
+	syncCmd.Flags().StringVar(&repoGlobs, "repo", "", "flag that limits syncs to the configured repos whose names match any of the given comma-separated shell patterns (example: 'SLE-15-SP5*')")
+	syncCmd.RegisterFlagCompletionFunc("repo", completeRepoNames)
+	syncCmd.Flags().StringVar(&syncTags, "tags", "", "flag that limits syncs to the http and merge repos tagged with any of the given comma-separated tags")
+	syncCmd.Flags().BoolVar(&tuiMode, "tui", false, "flag that shows a live table of repos with their progress, transfer rates and recent errors instead of logs")
+	syncCmd.Flags().BoolVar(&dryRun, "dry-run", false, "flag that lists the repos that would be synced, after SCC, RMT, Uyuni and OBS discovery, without syncing them")
 }
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/uyuni-project/minima/get"
+)
+
+var (
+	torrentCmd = &cobra.Command{
+		Use:   "torrent [REPO_PATH...]",
+		Short: "Creates and seeds torrents of mirrored repos for peer-to-peer distribution",
+		Long: `Creates BitTorrent files of repos in the storage path, which must be of type file, so that branch
+offices can fetch mirrors from each other instead of all downloading them from one server. Repos are
+given by path relative to the storage path, all repos are included by default.
+
+A torrent is a snapshot of the repo as it is when created: torrents are to be created again after syncs,
+and downloaded by clients to a new directory each time.
+
+With --web-seed, the URL the storage path is served at, eg. by minima serve, clients download from it
+too, so that torrents work without other seeders. With --seed, torrents are also seeded until
+interrupted, announcing to the --announce trackers.
+
+Example:
+  minima torrent --announce http://tracker.example.com:6969/announce --web-seed http://minima.example.com/ --seed SLE-Product-SLES15-SP5-Pool`,
+		Run: func(cmd *cobra.Command, args []string) {
+			initConfig()
+			directory, err := mirrorDirectory(cfgString)
+			if err != nil {
+				log.Fatal(err)
+			}
+			torrents, err := createTorrents(directory, args)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if torrentSeed {
+				ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+				defer stop()
+				err = seedTorrents(ctx, torrents)
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+		},
+	}
+	torrentAnnounce  []string
+	torrentWebSeed   string
+	torrentPieceSize string
+	torrentOutputDir string
+	torrentSeed      bool
+	torrentListen    string
+)
+
+// createTorrents writes torrents of repos, or all repos in directory if none is given, to torrentOutputDir
+func createTorrents(directory string, repos []string) ([]*get.Torrent, error) {
+	pieceLength := int64(0)
+	if torrentPieceSize != "" {
+		var err error
+		pieceLength, err = get.ParseSize(torrentPieceSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(repos) == 0 {
+		var err error
+		repos, err = get.FindRepos(directory)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(repos) == 0 {
+		return nil, fmt.Errorf("no repos found in %s", directory)
+	}
+	err := os.MkdirAll(torrentOutputDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	torrents := []*get.Torrent{}
+	for _, repo := range repos {
+		torrent, err := get.NewTorrent(filepath.Join(directory, filepath.FromSlash(repo)), pieceLength)
+		if err != nil {
+			return nil, err
+		}
+		torrent.Announce = torrentAnnounce
+		if torrentWebSeed != "" {
+			torrent.WebSeeds = []string{webSeedURL(torrentWebSeed, repo)}
+		}
+		name := strings.ReplaceAll(path.Clean(repo), "/", "_")
+		if name == "." {
+			name = torrent.Name
+		}
+		output := filepath.Join(torrentOutputDir, name+".torrent")
+		err = os.WriteFile(output, torrent.Encode(time.Now()), 0644)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Created %s (%d MiB in %d pieces)\n", output, torrent.Length()/1024/1024, len(torrent.Pieces))
+		torrents = append(torrents, torrent)
+	}
+	return torrents, nil
+}
+
+// webSeedURL returns the URL of the directory containing repo, with the storage path served at base.
+// Clients append the name of the torrent to it
+func webSeedURL(base string, repo string) string {
+	url := strings.TrimSuffix(base, "/") + "/"
+	if parent := path.Dir(path.Clean(repo)); parent != "." {
+		url += parent + "/"
+	}
+	return url
+}
+
+// seedTorrents seeds torrents on torrentListen until ctx is done
+func seedTorrents(ctx context.Context, torrents []*get.Torrent) error {
+	listener, err := net.Listen("tcp", torrentListen)
+	if err != nil {
+		return err
+	}
+	seeder := get.NewTorrentSeeder(torrents, log.Default())
+	seeder.Announce(ctx, listener.Addr().(*net.TCPAddr).Port)
+	log.Printf("Seeding %d torrents on %s\n", len(torrents), listener.Addr())
+	return seeder.Serve(ctx, listener)
+}
+
+func init() {
+	RootCmd.AddCommand(torrentCmd)
+	torrentCmd.Flags().StringArrayVar(&torrentAnnounce, "announce", nil, "flag that specifies a tracker URL, can be given several times")
+	torrentCmd.Flags().StringVar(&torrentWebSeed, "web-seed", "", "flag that specifies the URL the storage path is served at, for clients to download from too")
+	torrentCmd.Flags().StringVar(&torrentPieceSize, "piece-size", "", "flag that specifies the piece size, eg. 4M, depending on the repo size by default")
+	torrentCmd.Flags().StringVarP(&torrentOutputDir, "output-dir", "o", ".", "directory to write torrents to")
+	torrentCmd.Flags().BoolVar(&torrentSeed, "seed", false, "flag that seeds the torrents until interrupted")
+	torrentCmd.Flags().StringVar(&torrentListen, "listen", ":6881", "flag that specifies the address to accept peers on with --seed")
+}
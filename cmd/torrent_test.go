@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebSeedURL(t *testing.T) {
+	assert.Equal(t, "http://minima.example.com/", webSeedURL("http://minima.example.com", "SLE-Product-SLES15-SP5-Pool"))
+	assert.Equal(t, "http://minima.example.com/mirror/SUSE/Products/", webSeedURL("http://minima.example.com/mirror/", "SUSE/Products/SLE-Product-SLES15-SP5-Pool/"))
+}
+
+func TestCreateTorrents(t *testing.T) {
+	directory := t.TempDir()
+	repo := filepath.Join(directory, "SUSE", "Products", "SLES15", "repodata")
+	assert.NoError(t, os.MkdirAll(repo, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(repo, "repomd.xml"), []byte("<repomd/>"), 0644))
+	torrentOutputDir = t.TempDir()
+	torrentWebSeed = "http://minima.example.com/"
+	defer func() { torrentOutputDir, torrentWebSeed = ".", "" }()
+
+	torrents, err := createTorrents(directory, nil)
+	assert.NoError(t, err)
+	assert.Len(t, torrents, 1)
+	assert.Equal(t, "SLES15", torrents[0].Name)
+	assert.Equal(t, []string{"http://minima.example.com/SUSE/Products/"}, torrents[0].WebSeeds)
+	assert.FileExists(t, filepath.Join(torrentOutputDir, "SUSE_Products_SLES15.torrent"))
+
+	_, err = createTorrents(t.TempDir(), nil)
+	assert.ErrorContains(t, err, "no repos found")
+}
@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/uyuni-project/minima/get"
+)
+
+const (
+	// tuiInterval is how often the --tui view is redrawn
+	tuiInterval = 500 * time.Millisecond
+	// tuiRateWindow is the period transfer rates are averaged over
+	tuiRateWindow = 5 * time.Second
+	// tuiErrors is the number of recent errors shown
+	tuiErrors = 5
+	// tuiBarWidth is the number of characters of progress bars
+	tuiBarWidth = 20
+)
+
+// tuiView is the live table of repos of sync --tui, built from the events of syncs
+type tuiView struct {
+	mutex  sync.Mutex
+	repos  []*tuiRepo
+	byName map[string]*tuiRepo
+	errors []string
+	now    func() time.Time
+}
+
+// tuiRepo is the progress of a repo in a tuiView
+type tuiRepo struct {
+	name   string
+	status string
+	// packages and bytes to download and downloaded, once metadata is stored
+	planned     int
+	plannedSize int64
+	done        int
+	doneSize    int64
+	// files downloaded within the rate window
+	samples []tuiSample
+}
+
+// tuiSample is a downloaded file, for transfer rates
+type tuiSample struct {
+	at   time.Time
+	size int64
+}
+
+func newTUIView() *tuiView {
+	return &tuiView{byName: map[string]*tuiRepo{}, now: time.Now}
+}
+
+// event updates the view with an event of a sync, it can be used as SyncOptions.Events
+func (v *tuiView) event(event get.Event) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	repo, found := v.byName[event.Repo]
+	if !found {
+		repo = &tuiRepo{name: event.Repo}
+		v.byName[event.Repo] = repo
+		v.repos = append(v.repos, repo)
+	}
+	switch event.Type {
+	case get.RepoStarted:
+		*repo = tuiRepo{name: repo.name, status: "metadata"}
+	case get.PackagesPlanned:
+		repo.status = "packages"
+		repo.planned, repo.plannedSize = event.Count, event.Size
+		repo.done, repo.doneSize = 0, 0
+	case get.FileDownloaded:
+		if repo.status == "" {
+			// files of merged repos are reported with their upstream
+			repo.status = "merging"
+		}
+		if repo.planned > 0 {
+			repo.done++
+			repo.doneSize += event.Size
+		}
+		repo.samples = append(repo.samples, tuiSample{v.now(), event.Size})
+	case get.VerificationFailed:
+		repo.status = "retrying"
+		v.addError(event)
+	case get.RepoFinished:
+		repo.status = "done"
+		repo.samples = nil
+		if event.Err != nil {
+			repo.status = "failed"
+			v.addError(event)
+		}
+	}
+}
+
+// addError keeps the error of event among the recent ones
+func (v *tuiView) addError(event get.Event) {
+	message := strings.ReplaceAll(event.Err.Error(), "\n", " ")
+	v.errors = append(v.errors, fmt.Sprintf("%s: %s", event.Repo, message))
+	if len(v.errors) > tuiErrors {
+		v.errors = v.errors[len(v.errors)-tuiErrors:]
+	}
+}
+
+// render writes the table of repos, followed by recent errors
+func (v *tuiView) render(writer io.Writer) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	now := v.now()
+	table := tabwriter.NewWriter(writer, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(table, "REPO\tSTATUS\tPROGRESS\tRATE\n")
+	for _, repo := range v.repos {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", repo.name, repo.status, repo.progress(), repo.rate(now))
+	}
+	err := table.Flush()
+	if err != nil {
+		return err
+	}
+	if len(v.errors) > 0 {
+		fmt.Fprintf(writer, "\nRecent errors:\n")
+		for _, message := range v.errors {
+			fmt.Fprintf(writer, "  %s\n", message)
+		}
+	}
+	return nil
+}
+
+// progress returns a bar of the bytes of packages downloaded, followed by their number
+func (r *tuiRepo) progress() string {
+	if r.planned == 0 {
+		if r.status == "done" {
+			return "[" + strings.Repeat("#", tuiBarWidth) + "]"
+		}
+		return ""
+	}
+	fraction := float64(r.done) / float64(r.planned)
+	if r.plannedSize > 0 {
+		fraction = float64(r.doneSize) / float64(r.plannedSize)
+	}
+	filled := min(int(fraction*tuiBarWidth), tuiBarWidth)
+	return fmt.Sprintf("[%s%s] %d/%d", strings.Repeat("#", filled), strings.Repeat("-", tuiBarWidth-filled), r.done, r.planned)
+}
+
+// rate returns the bytes downloaded per second over the rate window, dropping older samples
+func (r *tuiRepo) rate(now time.Time) string {
+	recent := r.samples[:0]
+	total := int64(0)
+	for _, sample := range r.samples {
+		if now.Sub(sample.at) < tuiRateWindow {
+			recent = append(recent, sample)
+			total += sample.size
+		}
+	}
+	r.samples = recent
+	if len(recent) == 0 {
+		return "-"
+	}
+	return formatSize(int64(float64(total)/tuiRateWindow.Seconds())) + "/s"
+}
+
+// syncWithTUI is Sync drawing a tuiView on standard output instead of logging
+func syncWithTUI(ctx context.Context, configString string, options SyncOptions) (SyncResult, error) {
+	view := newTUIView()
+	options.Logger = log.New(io.Discard, "", 0)
+	options.Events = view.event
+	options.Quiet = true
+	done := make(chan struct{})
+	drawn := make(chan struct{})
+	go func() {
+		view.run(os.Stdout, done)
+		close(drawn)
+	}()
+	result, err := Sync(ctx, configString, options)
+	close(done)
+	<-drawn
+	return result, err
+}
+
+// run redraws the view on writer until done is closed, and a last time then
+func (v *tuiView) run(writer io.Writer, done <-chan struct{}) {
+	ticker := time.NewTicker(tuiInterval)
+	defer ticker.Stop()
+	v.draw(writer)
+	for {
+		select {
+		case <-done:
+			v.draw(writer)
+			return
+		case <-ticker.C:
+			v.draw(writer)
+		}
+	}
+}
+
+// draw clears the terminal and renders the view from its top left corner
+func (v *tuiView) draw(writer io.Writer) {
+	fmt.Fprint(writer, "\x1b[H\x1b[2J")
+	v.render(writer)
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/uyuni-project/minima/get"
+)
+
+func TestTUIView(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	view := newTUIView()
+	view.now = func() time.Time { return now }
+
+	view.event(get.Event{Type: get.RepoStarted, Repo: "http://test/a"})
+	view.event(get.Event{Type: get.RepoStarted, Repo: "http://test/b"})
+	view.event(get.Event{Type: get.PackagesPlanned, Repo: "http://test/a", Count: 4, Size: 40960})
+	view.event(get.Event{Type: get.FileDownloaded, Repo: "http://test/a", File: "x86_64/a.rpm", Size: 10240})
+	view.event(get.Event{Type: get.VerificationFailed, Repo: "http://test/b", Err: errors.New("checksum mismatch")})
+	view.event(get.Event{Type: get.RepoFinished, Repo: "http://test/b", Err: errors.New("checksum\nmismatch")})
+
+	output := &strings.Builder{}
+	assert.NoError(t, view.render(output))
+	assert.Equal(t, "REPO           STATUS    PROGRESS                    RATE\n"+
+		"http://test/a  packages  [#####---------------] 1/4  2.0K/s\n"+
+		"http://test/b  failed                                -\n"+
+		"\nRecent errors:\n"+
+		"  http://test/b: checksum mismatch\n"+
+		"  http://test/b: checksum mismatch\n", output.String())
+
+	// rates only count recent downloads
+	now = now.Add(10 * time.Second)
+	view.event(get.Event{Type: get.RepoFinished, Repo: "http://test/a"})
+	output.Reset()
+	assert.NoError(t, view.render(output))
+	assert.Contains(t, output.String(), "http://test/a  done    [#####---------------] 1/4  -\n")
+
+	for i := 0; i < 10; i++ {
+		view.event(get.Event{Type: get.VerificationFailed, Repo: "http://test/b", Err: errors.New("retry")})
+	}
+	assert.Len(t, view.errors, tuiErrors)
+}
+
+func TestTUIViewProgress(t *testing.T) {
+	assert.Equal(t, "", (&tuiRepo{status: "metadata"}).progress())
+	assert.Equal(t, "[####################]", (&tuiRepo{status: "done"}).progress())
+	assert.Equal(t, "[##########----------] 1/2", (&tuiRepo{planned: 2, done: 1}).progress())
+	assert.Equal(t, "[##------------------] 1/2", (&tuiRepo{planned: 2, plannedSize: 100, done: 1, doneSize: 10}).progress())
+}
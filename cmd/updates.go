@@ -145,7 +145,7 @@ func muFindAndSync(quiet bool) {
 			os.Exit(3)
 		}
 
-		syncers, err := syncersFromConfig(string(byteChunk), quiet)
+		syncers, err := syncersFromConfig(string(byteChunk), SyncOptions{Quiet: quiet})
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -0,0 +1,334 @@
+package get
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// repodata/<ID>-updateinfo.xml.<compression>
+
+// XMLUpdateinfo maps an <updates> tag in repodata/<ID>-updateinfo.xml.<compression>
+type XMLUpdateinfo struct {
+	Updates []XMLUpdate `xml:"update"`
+}
+
+// XMLUpdate maps an <update> tag, an advisory, in repodata/<ID>-updateinfo.xml.<compression>
+type XMLUpdate struct {
+	ID     string `xml:"id"`
+	Issued struct {
+		Date string `xml:"date,attr"`
+	} `xml:"issued"`
+	References []XMLReference     `xml:"references>reference"`
+	Packages   []XMLUpdatePackage `xml:"pkglist>collection>package"`
+}
+
+// XMLReference maps a <reference> tag of an <update> in repodata/<ID>-updateinfo.xml.<compression>
+type XMLReference struct {
+	Type string `xml:"type,attr"`
+	ID   string `xml:"id,attr"`
+}
+
+// XMLUpdatePackage maps a <package> tag of an <update> in repodata/<ID>-updateinfo.xml.<compression>
+type XMLUpdatePackage struct {
+	Name     string `xml:"name,attr"`
+	Version  string `xml:"version,attr"`
+	Release  string `xml:"release,attr"`
+	Arch     string `xml:"arch,attr"`
+	Filename string `xml:"filename"`
+}
+
+// filename returns the file name of the package, built from its NEVRA if updateinfo does not list it
+func (p *XMLUpdatePackage) filename() string {
+	if p.Filename != "" {
+		return p.Filename
+	}
+	return fmt.Sprintf("%s-%s-%s.%s.rpm", p.Name, p.Version, p.Release, p.Arch)
+}
+
+// Uncompress and read updateinfo XML
+func readUpdateinfo(reader io.Reader, compType string) (XMLUpdateinfo, error) {
+	var updateinfo XMLUpdateinfo
+
+	uncompressed, err := decompress(reader, compType)
+	if err != nil {
+		return updateinfo, err
+	}
+	defer uncompressed.Close()
+
+	err = xml.NewDecoder(uncompressed).Decode(&updateinfo)
+	return updateinfo, err
+}
+
+// issuedFormats are the date formats of <issued> tags found in the wild, besides seconds since the epoch
+var issuedFormats = []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// parseIssued parses the date of an <issued> tag
+func parseIssued(date string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(date, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	for _, format := range issuedFormats {
+		if issued, err := time.Parse(format, date); err == nil {
+			return issued, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised issued date %q", date)
+}
+
+// ReadCVEList reads CVE IDs for Syncer.OnlyCVEs from a file with one per line. Empty lines and lines starting with #
+// are ignored
+func ReadCVEList(filename string) (map[string]bool, error) {
+	entries, err := readListFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cves := map[string]bool{}
+	for _, entry := range entries {
+		cves[strings.ToUpper(entry)] = true
+	}
+	return cves, nil
+}
+
+// advisorySelection keeps track of the advisories of a repo selected for mirroring by Syncer.AsOf,
+// Syncer.PatchStream and Syncer.OnlyCVEs
+type advisorySelection struct {
+	// file names of the packages of selected and of dropped advisories
+	selected map[string]bool
+	dropped  map[string]bool
+	// exclusive selections only mirror packages of selected advisories, others all but those only
+	// listed in dropped advisories
+	exclusive bool
+	// CVEs of OnlyCVEs fixed by selected advisories
+	cves map[string]bool
+	// rewritten updateinfo entry, nil if no advisory was dropped
+	entry *repomdData
+}
+
+// selectsAdvisories reports whether the Syncer mirrors a selection of advisories
+func (r *Syncer) selectsAdvisories() bool {
+	return !r.AsOf.IsZero() || r.PatchStream || r.OnlyCVEs != nil
+}
+
+// newAdvisorySelection returns a selection without advisories, exclusive for patch streams and OnlyCVEs
+func (r *Syncer) newAdvisorySelection() *advisorySelection {
+	return &advisorySelection{
+		selected:  map[string]bool{},
+		dropped:   map[string]bool{},
+		exclusive: r.PatchStream || r.OnlyCVEs != nil,
+		cves:      map[string]bool{},
+	}
+}
+
+// fixedCVEs returns the CVEs of only referenced by an advisory
+func (u *XMLUpdate) fixedCVEs(only map[string]bool) []string {
+	cves := []string{}
+	for _, reference := range u.References {
+		cve := strings.ToUpper(reference.ID)
+		if reference.Type == "cve" && only[cve] {
+			cves = append(cves, cve)
+		}
+	}
+	return cves
+}
+
+// selectAdvisory reports whether an advisory is issued by AsOf, if set, and fixes any of OnlyCVEs, if set
+func (r *Syncer) selectAdvisory(update XMLUpdate) (bool, error) {
+	if !r.AsOf.IsZero() {
+		issued, err := parseIssued(strings.TrimSpace(update.Issued.Date))
+		if err != nil {
+			return false, fmt.Errorf("%s: %v", update.ID, err)
+		}
+		if issued.After(r.AsOf) {
+			return false, nil
+		}
+	}
+	return r.OnlyCVEs == nil || len(update.fixedCVEs(r.OnlyCVEs)) > 0, nil
+}
+
+// filterUpdateinfo downloads updateinfo and drops the advisories not selected, storing the result
+func (r *Syncer) filterUpdateinfo(ctx context.Context, entry XMLData) (*advisorySelection, error) {
+	b, err := r.downloadVerifiedAll(ctx, entry.Location.Href, entry.Checksum)
+	if err != nil {
+		return nil, err
+	}
+	compType := strings.Trim(filepath.Ext(entry.Location.Href), ".")
+	updateinfo, err := readUpdateinfo(bytes.NewReader(b), compType)
+	if err != nil {
+		return nil, err
+	}
+
+	var stream map[string]bool
+	if r.PatchStream {
+		previous, err := r.previousUpdateinfo()
+		if err != nil {
+			return nil, err
+		}
+		stream, err = patchStream(updateinfo.Updates, previous.Updates)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := r.newAdvisorySelection()
+	dropped := map[string]bool{}
+	for _, update := range updateinfo.Updates {
+		selected, err := r.selectAdvisory(update)
+		if err != nil {
+			return nil, err
+		}
+		if r.PatchStream {
+			selected = selected && stream[update.ID]
+		}
+		packages := result.selected
+		if selected {
+			for _, cve := range update.fixedCVEs(r.OnlyCVEs) {
+				result.cves[cve] = true
+			}
+		} else {
+			dropped[update.ID] = true
+			packages = result.dropped
+		}
+		for _, pack := range update.Packages {
+			packages[pack.filename()] = true
+		}
+	}
+
+	if len(dropped) == 0 && !r.recompresses(entry) {
+		return result, storeBytes(r.storage, entry.Location.Href, b)
+	}
+	if len(dropped) > 0 {
+		r.logger().Printf("Dropping %d of %d advisories\n", len(dropped), len(updateinfo.Updates))
+	}
+	uncompressed, err := decompress(bytes.NewReader(b), compType)
+	if err != nil {
+		return nil, err
+	}
+	defer uncompressed.Close()
+	data, err := storeMetadata(r.storage, path.Dir(entry.Location.Href), entry.Type, r.metadataCompression(entry), func(writer io.Writer) error {
+		return writeMetadata(writer, []io.Reader{uncompressed}, "update", func(element *metadataElement) bool {
+			return !dropped[element.ID]
+		}, -1)
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.entry = &data
+	return result, nil
+}
+
+// previousUpdateinfo returns the updateinfo mirrored by the last sync, empty if there is none
+func (r *Syncer) previousUpdateinfo() (updateinfo XMLUpdateinfo, err error) {
+	reader, err := r.storage.NewReader(repomdPath, Permanent)
+	if err == ErrFileNotFound {
+		return updateinfo, nil
+	}
+	if err != nil {
+		return
+	}
+	repomd, err := repoTypes["rpm"].DecodeMetadata(reader)
+	reader.Close()
+	if err != nil {
+		return
+	}
+	for _, entry := range repomd.Data {
+		if entry.Type != "updateinfo" {
+			continue
+		}
+		reader, err = r.storage.NewReader(entry.Location.Href, Permanent)
+		if err != nil {
+			return
+		}
+		defer reader.Close()
+		return readUpdateinfo(reader, strings.Trim(filepath.Ext(entry.Location.Href), "."))
+	}
+	return
+}
+
+// patchStream returns the IDs of the advisories of updates in a patch stream: the ones issued after the
+// newest of previous, the advisories mirrored by the last sync, or the same ones if there is no newer one.
+// All advisories are in the first patch stream
+func patchStream(updates []XMLUpdate, previous []XMLUpdate) (map[string]bool, error) {
+	stream := map[string]bool{}
+	var newest time.Time
+	for _, update := range previous {
+		issued, err := parseIssued(strings.TrimSpace(update.Issued.Date))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", update.ID, err)
+		}
+		if issued.After(newest) {
+			newest = issued
+		}
+		stream[update.ID] = true
+	}
+
+	newer := map[string]bool{}
+	for _, update := range updates {
+		issued, err := parseIssued(strings.TrimSpace(update.Issued.Date))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", update.ID, err)
+		}
+		if len(previous) == 0 || issued.After(newest) {
+			newer[update.ID] = true
+		}
+	}
+	if len(newer) == 0 {
+		return stream, nil
+	}
+	return newer, nil
+}
+
+// excludes reports whether a package is not mirrored because of the advisories listing it, or not
+func (s *advisorySelection) excludes(pack XMLPackage) bool {
+	if s == nil {
+		return false
+	}
+	filename := path.Base(pack.Location.Href)
+	return !s.selected[filename] && (s.exclusive || s.dropped[filename])
+}
+
+// dropsPackages reports whether some packages of the repo may not be mirrored
+func (s *advisorySelection) dropsPackages() bool {
+	return s != nil && (s.exclusive || len(s.dropped) > 0)
+}
+
+// affects reports whether a repomd <data> type is rewritten or dropped, which for zchunk variants of
+// updateinfo happens when advisories were dropped
+func (s *advisorySelection) affects(dataType string) bool {
+	return s != nil && s.entry != nil && strings.HasPrefix(dataType, "updateinfo")
+}
+
+// update replaces the updateinfo entry in a repomd document, dropping its variants
+func (s *advisorySelection) update(document *repomdDocument) {
+	data := document.Data[:0]
+	for _, entry := range document.Data {
+		if s.affects(entry.Type) {
+			if entry.Type != s.entry.Type {
+				continue
+			}
+			entry = *s.entry
+		}
+		data = append(data, entry)
+	}
+	document.Data = data
+}
+
+// UncoveredCVEs returns the CVEs of Syncer.OnlyCVEs that no advisory mirrored by the last sync fixes, sorted
+func (r *Syncer) UncoveredCVEs() []string {
+	uncovered := []string{}
+	for cve := range r.OnlyCVEs {
+		if r.advisories == nil || !r.advisories.cves[cve] {
+			uncovered = append(uncovered, cve)
+		}
+	}
+	sort.Strings(uncovered)
+	return uncovered
+}
@@ -0,0 +1,167 @@
+package get
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreRepoAsOf(t *testing.T) {
+	repoURL, err := url.Parse("http://localhost:8080/repo")
+	assert.NoError(t, err)
+
+	// all advisories of testdata are issued at 1436435241
+	directory := t.TempDir()
+	storage := NewFileStorage(directory)
+	syncer := NewSyncer(*repoURL, map[string]bool{"x86_64": true}, storage, true)
+	syncer.AsOf = time.Unix(1436435240, 0)
+	assert.NoError(t, syncer.StoreRepo())
+
+	repomd, err := os.ReadFile(filepath.Join(directory, "repodata", "repomd.xml"))
+	assert.NoError(t, err)
+	document, err := parseRepomd(repomd)
+	assert.NoError(t, err)
+	assert.Len(t, document.Data, 4)
+	for _, data := range document.Data {
+		reader, err := storage.NewReader(data.Location.Href, Permanent)
+		assert.NoError(t, err)
+		if data.Type == "updateinfo" {
+			updateinfo, err := readUpdateinfo(reader, "gz")
+			assert.NoError(t, err)
+			assert.Empty(t, updateinfo.Updates)
+		} else {
+			metadata, err := readMetaData(reader, "gz")
+			assert.NoError(t, err)
+			// orion-dummy-sle12 is not listed in updateinfo
+			assert.Len(t, metadata.Packages, 2, data.Type)
+		}
+		reader.Close()
+	}
+	_, err = os.Stat(filepath.Join(directory, "x86_64", "orion-dummy-sle12-1.1-4.1.x86_64.rpm"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(directory, "x86_64", "hoag-dummy-1.1-2.1.x86_64.rpm"))
+	assert.True(t, os.IsNotExist(err))
+
+	// advisories issued at the date are kept
+	syncer.AsOf = time.Unix(1436435241, 0)
+	assert.NoError(t, syncer.StoreRepo())
+	original, err := os.ReadFile(filepath.Join("testdata", "repo", "repodata", "repomd.xml"))
+	assert.NoError(t, err)
+	repomd, err = os.ReadFile(filepath.Join(directory, "repodata", "repomd.xml"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(original), string(repomd))
+	_, err = os.Stat(filepath.Join(directory, "x86_64", "hoag-dummy-1.1-2.1.x86_64.rpm"))
+	assert.NoError(t, err)
+}
+
+func TestParseIssued(t *testing.T) {
+	tests := []struct {
+		date string
+		want time.Time
+	}{
+		{"1436435241", time.Unix(1436435241, 0)},
+		{"2024-01-31 10:20:30", time.Date(2024, 1, 31, 10, 20, 30, 0, time.UTC)},
+		{"2024-01-31 10:20", time.Date(2024, 1, 31, 10, 20, 0, 0, time.UTC)},
+		{"2024-01-31", time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		issued, err := parseIssued(tt.date)
+		assert.NoError(t, err)
+		assert.True(t, tt.want.Equal(issued), tt.date)
+	}
+	_, err := parseIssued("last tuesday")
+	assert.EqualError(t, err, `unrecognised issued date "last tuesday"`)
+}
+
+func TestStoreRepoOnlyCVEs(t *testing.T) {
+	repoURL, err := url.Parse("http://localhost:8080/repo")
+	assert.NoError(t, err)
+	directory := t.TempDir()
+	storage := NewFileStorage(directory)
+	syncer := NewSyncer(*repoURL, map[string]bool{"x86_64": true}, storage, true)
+	syncer.OnlyCVEs = map[string]bool{"CVE-1999-9999": true, "CVE-2000-0001": true}
+	assert.NoError(t, syncer.StoreRepo())
+	assert.Equal(t, []string{"CVE-2000-0001"}, syncer.UncoveredCVEs())
+
+	repomd, err := os.ReadFile(filepath.Join(directory, "repodata", "repomd.xml"))
+	assert.NoError(t, err)
+	document, err := parseRepomd(repomd)
+	assert.NoError(t, err)
+	for _, data := range document.Data {
+		reader, err := storage.NewReader(data.Location.Href, Permanent)
+		assert.NoError(t, err)
+		if data.Type == "updateinfo" {
+			updateinfo, err := readUpdateinfo(reader, "gz")
+			assert.NoError(t, err)
+			assert.Len(t, updateinfo.Updates, 1)
+			assert.Equal(t, "milkyway-dummy-2345", updateinfo.Updates[0].ID)
+		} else {
+			metadata, err := readMetaData(reader, "gz")
+			assert.NoError(t, err)
+			assert.Len(t, metadata.Packages, 2, data.Type)
+		}
+		reader.Close()
+	}
+	rpms, err := filepath.Glob(filepath.Join(directory, "*", "*.rpm"))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		filepath.Join(directory, "i586", "milkyway-dummy-2.0-1.1.i586.rpm"),
+		filepath.Join(directory, "x86_64", "milkyway-dummy-2.0-1.1.x86_64.rpm"),
+	}, rpms)
+}
+
+func TestReadCVEList(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cves.txt")
+	assert.NoError(t, os.WriteFile(file, []byte("# January\ncve-2024-0001\n\nCVE-2024-0002\n"), 0644))
+	cves, err := ReadCVEList(file)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"CVE-2024-0001": true, "CVE-2024-0002": true}, cves)
+}
+
+func TestPatchStream(t *testing.T) {
+	update := func(id string, issued string) XMLUpdate {
+		u := XMLUpdate{ID: id}
+		u.Issued.Date = issued
+		return u
+	}
+	updates := []XMLUpdate{update("a", "1700000000"), update("b", "1700000100"), update("c", "2023-11-14 22:16:40")}
+
+	stream, err := patchStream(updates, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"a": true, "b": true, "c": true}, stream)
+
+	// c is issued at 1700000200
+	stream, err = patchStream(updates, []XMLUpdate{update("a", "1700000000")})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"b": true, "c": true}, stream)
+
+	// without newer advisories, the previous ones are kept
+	stream, err = patchStream(updates, []XMLUpdate{update("b", "1700000100"), update("c", "1700000200")})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"b": true, "c": true}, stream)
+
+	_, err = patchStream([]XMLUpdate{update("d", "soon")}, nil)
+	assert.EqualError(t, err, `d: unrecognised issued date "soon"`)
+}
+
+func TestStoreRepoPatchStream(t *testing.T) {
+	repoURL, err := url.Parse("http://localhost:8080/repo")
+	assert.NoError(t, err)
+	directory := t.TempDir()
+	syncer := NewSyncer(*repoURL, map[string]bool{"x86_64": true}, NewFileStorage(directory), true)
+	syncer.PatchStream = true
+
+	// all advisories are in the first stream, and kept by the next sync as no newer one is issued
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, syncer.StoreRepo())
+		_, err = os.Stat(filepath.Join(directory, "x86_64", "hoag-dummy-1.1-2.1.x86_64.rpm"))
+		assert.NoError(t, err)
+		// orion-dummy-sle12 is not listed in updateinfo
+		_, err = os.Stat(filepath.Join(directory, "x86_64", "orion-dummy-sle12-1.1-4.1.x86_64.rpm"))
+		assert.True(t, os.IsNotExist(err))
+	}
+}
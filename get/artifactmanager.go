@@ -0,0 +1,157 @@
+package get
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// publishHosted deletes packages of a hosted yum repository that were not stored again, leaving
+// staged metadata unpublished as the artifact manager generates its own. Artifactory only does so
+// when asked, Nexus after every change
+func (s *HTTPPutStorage) publishHosted() error {
+	hosted, err := s.hostedPackages()
+	if err != nil {
+		return err
+	}
+	for _, filename := range hosted {
+		if !s.stored[filename] {
+			err = s.delete(filename)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.manager != "artifactory" {
+		return nil
+	}
+	u := s.api("/api/yum/" + s.repository())
+	resp, err := s.send(http.MethodPost, u, nil, nil, http.StatusOK, http.StatusAccepted, http.StatusNoContent)
+	if err != nil {
+		return fmt.Errorf("cannot calculate yum metadata: %v", err)
+	}
+	return resp.Body.Close()
+}
+
+// repository returns the name of the target repository, the last element of the endpoint path as in
+// https://artifactory.example.com/artifactory/<name> or https://nexus.example.com/repository/<name>
+func (s *HTTPPutStorage) repository() string {
+	return path.Base(s.endpoint.Path)
+}
+
+// api returns the URL of a REST API endpoint, relative to the context path of the artifact manager
+func (s *HTTPPutStorage) api(endpoint string) url.URL {
+	u := s.endpoint
+	context := path.Dir(u.Path)
+	if s.manager == "nexus" {
+		// below /repository
+		context = path.Dir(context)
+	}
+	u.Path = path.Join(context, endpoint)
+	u.RawQuery = ""
+	return u
+}
+
+// hostedPackages returns the packages of the repo in the target repository, relative to the repo
+func (s *HTTPPutStorage) hostedPackages() ([]string, error) {
+	repoPath := strings.TrimPrefix(strings.TrimPrefix(s.base.Path, s.endpoint.Path), "/")
+
+	var paths []string
+	var err error
+	switch s.manager {
+	case "artifactory":
+		paths, err = s.artifactoryFiles(repoPath)
+	case "nexus":
+		paths, err = s.nexusAssets()
+	default:
+		return nil, fmt.Errorf("unrecognised artifact manager %s", s.manager)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	packages := []string{}
+	for _, p := range paths {
+		p = strings.TrimPrefix(p, "/")
+		if repoPath != "" {
+			if !strings.HasPrefix(p, repoPath+"/") {
+				continue
+			}
+			p = strings.TrimPrefix(p, repoPath+"/")
+		}
+		if isPackage(p) {
+			packages = append(packages, p)
+		}
+	}
+	return packages, nil
+}
+
+// artifactoryFiles returns the paths of all files below repoPath, relative to the repository
+func (s *HTTPPutStorage) artifactoryFiles(repoPath string) ([]string, error) {
+	u := s.api(path.Join("/api/storage", s.repository(), repoPath))
+	u.RawQuery = "list&deep=1&listFolders=0"
+	resp, err := s.send(http.MethodGet, u, nil, nil, http.StatusOK)
+	if err == ErrFileNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Files []struct {
+			URI string
+		}
+	}
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, file := range list.Files {
+		paths = append(paths, path.Join(repoPath, file.URI))
+	}
+	return paths, nil
+}
+
+// nexusAssets returns the paths of all assets of the repository, following continuation tokens
+func (s *HTTPPutStorage) nexusAssets() ([]string, error) {
+	paths := []string{}
+	token := ""
+	for {
+		u := s.api("/service/rest/v1/assets")
+		query := url.Values{"repository": {s.repository()}}
+		if token != "" {
+			query.Set("continuationToken", token)
+		}
+		u.RawQuery = query.Encode()
+		resp, err := s.send(http.MethodGet, u, nil, nil, http.StatusOK)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Items []struct {
+				Path string
+			}
+			ContinuationToken string
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			paths = append(paths, item.Path)
+		}
+		if page.ContinuationToken == "" {
+			return paths, nil
+		}
+		token = page.ContinuationToken
+	}
+}
@@ -0,0 +1,97 @@
+package get
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeHostedYum adds the listing and metadata APIs of Artifactory (below /artifactory) and Nexus to a
+// fakeArtifactManager. Nexus assets are listed one per page
+type fakeHostedYum struct {
+	fakeArtifactManager
+}
+
+func (f *fakeHostedYum) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/artifactory/api/storage/"):
+		folder := "/artifactory/" + strings.TrimPrefix(r.URL.Path, "/artifactory/api/storage/")
+		files := []map[string]string{}
+		for name := range f.files {
+			if strings.HasPrefix(name, folder+"/") {
+				files = append(files, map[string]string{"uri": strings.TrimPrefix(name, folder)})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"files": files})
+	case strings.HasPrefix(r.URL.Path, "/artifactory/api/yum/") && r.Method == http.MethodPost:
+		f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	case r.URL.Path == "/service/rest/v1/assets":
+		names := []string{}
+		for name := range f.files {
+			if strings.HasPrefix(name, "/repository/"+r.URL.Query().Get("repository")+"/") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		i, _ := strconv.Atoi(r.URL.Query().Get("continuationToken"))
+		page := map[string]any{"items": []map[string]string{}}
+		if i < len(names) {
+			page["items"] = []map[string]string{{"path": strings.SplitN(names[i], "/", 4)[3]}}
+			page["continuationToken"] = strconv.Itoa(i + 1)
+		}
+		json.NewEncoder(w).Encode(page)
+	default:
+		f.fakeArtifactManager.ServeHTTP(w, r)
+	}
+}
+
+func TestHTTPPutStorageHostedYum(t *testing.T) {
+	tests := []struct {
+		manager  string
+		endpoint string
+		want     []string
+	}{
+		{"artifactory", "/artifactory/rpm-local", []string{
+			"DELETE /artifactory/rpm-local/SLES/Packages/old.rpm",
+			"POST /artifactory/api/yum/rpm-local",
+		}},
+		{"nexus", "/repository/yum-hosted", []string{
+			"DELETE /repository/yum-hosted/SLES/Packages/old.rpm",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.manager, func(t *testing.T) {
+			f := &fakeHostedYum{fakeArtifactManager{files: map[string]string{
+				tt.endpoint + "/SLES/Packages/old.rpm":        "old",
+				tt.endpoint + "/SLES/Packages/recycled.rpm":   "recycled",
+				tt.endpoint + "/SLES/repodata/repomd.xml":     "generated repomd",
+				tt.endpoint + "/other/Packages/unrelated.rpm": "unrelated",
+			}}}
+			server := httptest.NewServer(f)
+			defer server.Close()
+
+			storage, err := NewHTTPPutStorage(StorageConfig{Endpoint: server.URL + tt.endpoint, Token: "TOKEN", ArtifactManager: tt.manager}, "SLES")
+			assert.NoError(t, err)
+			assert.NoError(t, storeBytes(storage, "repodata/repomd.xml", []byte("repomd")))
+			assert.NoError(t, storage.Recycle("Packages/recycled.rpm"))
+			assert.NoError(t, storeBytes(storage, "Packages/new.rpm", []byte("new")))
+
+			f.requests = nil
+			assert.NoError(t, storage.Commit())
+			assert.Equal(t, tt.want, f.requests)
+			assert.Equal(t, map[string]string{
+				tt.endpoint + "/SLES/Packages/new.rpm":        "new",
+				tt.endpoint + "/SLES/Packages/recycled.rpm":   "recycled",
+				tt.endpoint + "/SLES/repodata/repomd.xml":     "generated repomd",
+				tt.endpoint + "/other/Packages/unrelated.rpm": "unrelated",
+			}, f.files)
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package get
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/uyuni-project/minima/util"
+)
+
+// Actions recorded in audit logs
+const (
+	AuditAdd     = "add"
+	AuditReplace = "replace"
+	AuditDelete  = "delete"
+)
+
+// AuditEntry is a line of an audit log, recording a change to a file of a repo
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	Repo   string    `json:"repo"`
+	Action string    `json:"action"`
+	File   string    `json:"file"`
+	// SHA-256 checksum of the new file, empty for deletes
+	Checksum string `json:"checksum,omitempty"`
+	// Source is the URL the file was downloaded from, without tokens and credentials
+	Source string `json:"source,omitempty"`
+}
+
+// AuditStorage wraps a Storage to append an AuditEntry to a local log, in JSON lines, for every file added,
+// replaced or deleted at Commit. The files of a repo before the sync are those recorded by previous entries,
+// so the first sync after enabling it lists all files as added
+type AuditStorage struct {
+	Storage
+	logFile string
+	repo    StorageRepo
+	// checksums of files stored or recycled so far
+	checksums Manifest
+}
+
+// NewAuditStorage returns a Storage recording changes to storage, for repo, in logFile
+func NewAuditStorage(storage Storage, logFile string, repo StorageRepo) *AuditStorage {
+	return &AuditStorage{Storage: storage, logFile: logFile, repo: repo, checksums: Manifest{}}
+}
+
+// StoringMapper returns a mapper that will store read data to a temporary location specified by filename,
+// recording its checksum once it is fully stored
+func (s *AuditStorage) StoringMapper(filename string, checksum string, hash crypto.Hash) util.ReaderMapper {
+	return func(reader io.ReadCloser) (io.ReadCloser, error) {
+		stored, err := s.Storage.StoringMapper(filename, checksum, hash)(reader)
+		if err != nil {
+			return nil, err
+		}
+		return util.NewTeeReadCloser(stored, &checksumRecorder{sha256.New(), s.checksums, filename}), nil
+	}
+}
+
+// Recycle will copy a file from the permanent to the temporary location. Its checksum is computed at
+// Commit if it is not known from the log
+func (s *AuditStorage) Recycle(filename string) error {
+	err := s.Storage.Recycle(filename)
+	if err != nil {
+		return err
+	}
+	if _, ok := s.checksums[filename]; !ok {
+		s.checksums[filename] = ""
+	}
+	return nil
+}
+
+// Commit commits the wrapped Storage, then logs the changes
+func (s *AuditStorage) Commit() error {
+	previous, err := s.previous()
+	if err != nil {
+		return err
+	}
+	for filename, checksum := range s.checksums {
+		if checksum != "" {
+			continue
+		}
+		if known, ok := previous[filename]; ok {
+			s.checksums[filename] = known
+			continue
+		}
+		reader, err := s.Storage.NewReader(filename, Temporary)
+		if err != nil {
+			return err
+		}
+		s.checksums[filename], err = util.Checksum(reader, crypto.SHA256)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.Storage.Commit()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	entries := []AuditEntry{}
+	for filename, checksum := range s.checksums {
+		known, ok := previous[filename]
+		switch {
+		case !ok:
+			entries = append(entries, AuditEntry{Time: now, Repo: s.repo.Path, Action: AuditAdd, File: filename, Checksum: checksum, Source: s.source(filename)})
+		case known != checksum:
+			entries = append(entries, AuditEntry{Time: now, Repo: s.repo.Path, Action: AuditReplace, File: filename, Checksum: checksum, Source: s.source(filename)})
+		}
+	}
+	for filename := range previous {
+		if _, ok := s.checksums[filename]; ok {
+			continue
+		}
+		// some storages keep files of the previous sync, eg. when only metadata changed
+		reader, err := s.Storage.NewReader(filename, Permanent)
+		if err == nil {
+			reader.Close()
+			continue
+		}
+		entries = append(entries, AuditEntry{Time: now, Repo: s.repo.Path, Action: AuditDelete, File: filename})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].File < entries[j].File })
+	s.checksums = Manifest{}
+	return s.append(entries)
+}
+
+// previous returns the checksums of the files of the repo from the log
+func (s *AuditStorage) previous() (Manifest, error) {
+	manifest := Manifest{}
+	f, err := os.Open(s.logFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		entry := AuditEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, err
+		}
+		if entry.Repo != s.repo.Path {
+			continue
+		}
+		if entry.Action == AuditDelete {
+			delete(manifest, entry.File)
+		} else {
+			manifest[entry.File] = entry.Checksum
+		}
+	}
+	return manifest, scanner.Err()
+}
+
+// append writes entries at the end of the log
+func (s *AuditStorage) append(entries []AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	content := &strings.Builder{}
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		content.Write(line)
+		content.WriteByte('\n')
+	}
+
+	f, err := os.OpenFile(s.logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(content.String())
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// source returns the upstream URL of a file, or the upstreams of merged repos
+func (s *AuditStorage) source(filename string) string {
+	upstreams := []*url.URL{}
+	for _, upstream := range strings.Split(s.repo.Upstream, ",") {
+		u, err := url.Parse(upstream)
+		if err != nil || upstream == "" {
+			continue
+		}
+		u.RawQuery = ""
+		upstreams = append(upstreams, u)
+	}
+	if len(upstreams) == 1 {
+		upstreams[0].Path = path.Join(upstreams[0].Path, filename)
+	}
+	sources := []string{}
+	for _, u := range upstreams {
+		sources = append(sources, u.Redacted())
+	}
+	return strings.Join(sources, ",")
+}
+
+// SetModTime sets the modification time of the file stored next at filename, if the wrapped Storage keeps them
+func (s *AuditStorage) SetModTime(filename string, modTime time.Time) {
+	setModTime(s.Storage, filename, modTime)
+}
+
+// CaseInsensitive reports whether the wrapped Storage ignores case in filenames
+func (s *AuditStorage) CaseInsensitive() (bool, error) {
+	return caseInsensitive(s.Storage)
+}
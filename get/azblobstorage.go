@@ -0,0 +1,295 @@
+package get
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// azureAPIVersion is the Blob service REST API version requests are made with
+	azureAPIVersion = "2021-08-06"
+	// azureBlockSize is the size of the blocks big files are uploaded in
+	azureBlockSize = 8 * 1024 * 1024
+)
+
+// azureTokenURL is the Azure Instance Metadata Service endpoint returning managed identity tokens
+var azureTokenURL = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+// azureBlobStore is an objectStore in an Azure Blob Storage container
+type azureBlobStore struct {
+	// URL of the container, without SAS token
+	container url.URL
+	// query parameters of the SAS token, if any, otherwise managed identity tokens are used
+	sas      url.Values
+	clientID string
+	token    azureToken
+	client   *http.Client
+}
+
+// azureToken is a cached managed identity access token
+type azureToken struct {
+	sync.Mutex
+	value   string
+	expires time.Time
+}
+
+// NewAzureBlobStorage returns a new Storage backed by an Azure Blob Storage container, as specified by
+// the azblob-specific fields of config. Files are stored below repoPath, itself below the configured prefix
+func NewAzureBlobStorage(config StorageConfig, repoPath string) (Storage, error) {
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = "https://" + config.Account + ".blob.core.windows.net"
+	}
+	container, err := url.Parse(strings.TrimSuffix(endpoint, "/") + "/" + config.Container)
+	if err != nil {
+		return nil, err
+	}
+	sas, err := url.ParseQuery(strings.TrimPrefix(config.SASToken, "?"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAS token: %v", err)
+	}
+
+	store := &azureBlobStore{
+		container: *container,
+		sas:       sas,
+		clientID:  config.ManagedIdentityClientID,
+		client:    http.DefaultClient,
+	}
+	storage, err := newObjectStorage(store, path.Join(config.Prefix, repoPath))
+	if err != nil {
+		return nil, err
+	}
+	return storage, nil
+}
+
+// blobURL returns the URL of a blob, with query parameters and the SAS token if any
+func (s *azureBlobStore) blobURL(key string, query url.Values) string {
+	u := s.container
+	if key != "" {
+		u.Path += "/" + key
+	}
+	values := url.Values{}
+	for k, v := range s.sas {
+		values[k] = v
+	}
+	for k, v := range query {
+		values[k] = v
+	}
+	u.RawQuery = values.Encode()
+	return u.String()
+}
+
+// do sends a request to the Blob service, returning the response if it has the expected status code
+func (s *azureBlobStore) do(method string, url string, body io.Reader, headers map[string]string, expected int) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if len(s.sas) == 0 {
+		token, err := s.accessToken()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != expected {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, ErrFileNotFound
+		}
+		return nil, &UnexpectedStatusCodeError{method + " " + req.URL.Path, resp.StatusCode}
+	}
+	return resp, nil
+}
+
+// accessToken returns a managed identity token for the Blob service, refreshing it when about to expire
+func (s *azureBlobStore) accessToken() (string, error) {
+	s.token.Lock()
+	defer s.token.Unlock()
+	if s.token.value != "" && time.Now().Add(5*time.Minute).Before(s.token.expires) {
+		return s.token.value, nil
+	}
+
+	query := url.Values{"api-version": {"2018-02-01"}, "resource": {"https://storage.azure.com/"}}
+	if s.clientID != "" {
+		query.Set("client_id", s.clientID)
+	}
+	req, err := http.NewRequest(http.MethodGet, azureTokenURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", &UnexpectedStatusCodeError{azureTokenURL, resp.StatusCode}
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresOn   string `json:"expires_on"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return "", err
+	}
+	expiresOn, err := strconv.ParseInt(token.ExpiresOn, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid managed identity token expiry %s", token.ExpiresOn)
+	}
+	s.token.value = token.AccessToken
+	s.token.expires = time.Unix(expiresOn, 0)
+	return s.token.value, nil
+}
+
+func (s *azureBlobStore) get(key string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, s.blobURL(key, nil), nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *azureBlobStore) putBlob(key string, content []byte) error {
+	resp, err := s.do(http.MethodPut, s.blobURL(key, nil), bytes.NewReader(content), map[string]string{"x-ms-blob-type": "BlockBlob"}, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// put stores body at key, in blocks if it is bigger than one
+func (s *azureBlobStore) put(key string, body io.Reader) error {
+	buf := make([]byte, azureBlockSize)
+	n, err := io.ReadFull(body, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return s.putBlob(key, buf[:n])
+	}
+	if err != nil {
+		return err
+	}
+
+	var blockList bytes.Buffer
+	blockList.WriteString(xml.Header + "<BlockList>")
+	for i := 0; n > 0; i++ {
+		id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", i)))
+		resp, err := s.do(http.MethodPut, s.blobURL(key, url.Values{"comp": {"block"}, "blockid": {id}}), bytes.NewReader(buf[:n]), nil, http.StatusCreated)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		blockList.WriteString("<Latest>" + id + "</Latest>")
+
+		n, err = io.ReadFull(body, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+	}
+	blockList.WriteString("</BlockList>")
+
+	resp, err := s.do(http.MethodPut, s.blobURL(key, url.Values{"comp": {"blocklist"}}), &blockList, nil, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// copy copies a blob server-side, waiting for the copy to complete
+func (s *azureBlobStore) copy(source string, destination string) error {
+	headers := map[string]string{"x-ms-copy-source": s.blobURL(source, nil)}
+	if len(s.sas) == 0 {
+		token, err := s.accessToken()
+		if err != nil {
+			return err
+		}
+		headers["x-ms-copy-source-authorization"] = "Bearer " + token
+	}
+	resp, err := s.do(http.MethodPut, s.blobURL(destination, nil), nil, headers, http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	status := resp.Header.Get("x-ms-copy-status")
+	for status == "pending" {
+		time.Sleep(time.Second)
+		resp, err = s.do(http.MethodHead, s.blobURL(destination, nil), nil, nil, http.StatusOK)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		status = resp.Header.Get("x-ms-copy-status")
+	}
+	if status != "success" {
+		return fmt.Errorf("copy of %s to %s failed with status %s", source, destination, status)
+	}
+	return nil
+}
+
+// azureBlobList maps a page of the List Blobs response
+type azureBlobList struct {
+	Blobs      []string `xml:"Blobs>Blob>Name"`
+	NextMarker string
+}
+
+// list returns the names of all blobs below prefix
+func (s *azureBlobStore) list(prefix string) ([]string, error) {
+	names := []string{}
+	marker := ""
+	for {
+		query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {prefix}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		resp, err := s.do(http.MethodGet, s.blobURL("", query), nil, nil, http.StatusOK)
+		if err != nil {
+			return nil, err
+		}
+		var page azureBlobList
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, page.Blobs...)
+		if page.NextMarker == "" {
+			return names, nil
+		}
+		marker = page.NextMarker
+	}
+}
+
+func (s *azureBlobStore) delete(key string) error {
+	resp, err := s.do(http.MethodDelete, s.blobURL(key, nil), nil, nil, http.StatusAccepted)
+	if err == ErrFileNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,165 @@
+package get
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAzureBlob implements the subset of the Blob service API used by AzureBlobStorage, for container "minima"
+type fakeAzureBlob struct {
+	sync.Mutex
+	blobs  map[string][]byte
+	blocks map[string][]byte
+	// authorization of the last request
+	authorization string
+}
+
+func newFakeAzureBlob() *fakeAzureBlob {
+	return &fakeAzureBlob{blobs: map[string][]byte{}, blocks: map[string][]byte{}}
+}
+
+func (f *fakeAzureBlob) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.authorization = r.Header.Get("Authorization")
+	if r.URL.Query().Get("sig") == "" && f.authorization == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/minima/")
+	query := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+
+	switch {
+	case r.Method == http.MethodGet && query.Get("comp") == "list":
+		names := []string{}
+		for name := range f.blobs {
+			if strings.HasPrefix(name, query.Get("prefix")) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		fmt.Fprint(w, "<EnumerationResults><Blobs>")
+		for _, name := range names {
+			fmt.Fprintf(w, "<Blob><Name>%s</Name></Blob>", name)
+		}
+		fmt.Fprint(w, "</Blobs><NextMarker/></EnumerationResults>")
+	case r.Method == http.MethodGet:
+		content, ok := f.blobs[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(content)
+	case r.Method == http.MethodPut && query.Get("comp") == "block":
+		f.blocks[key+query.Get("blockid")] = body
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && query.Get("comp") == "blocklist":
+		var list struct {
+			Latest []string
+		}
+		xml.Unmarshal(body, &list)
+		var content []byte
+		for _, id := range list.Latest {
+			content = append(content, f.blocks[key+id]...)
+		}
+		f.blobs[key] = content
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && r.Header.Get("x-ms-copy-source") != "":
+		source := strings.TrimPrefix(strings.SplitN(r.Header.Get("x-ms-copy-source"), "?", 2)[0], "http://"+r.Host+"/minima/")
+		content, ok := f.blobs[source]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		f.blobs[key] = content
+		w.Header().Set("x-ms-copy-status", "success")
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPut:
+		f.blobs[key] = body
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodDelete:
+		delete(f.blobs, key)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestAzureBlobStorage(t *testing.T) {
+	f := newFakeAzureBlob()
+	f.blobs["mirror/repo/.minima-prefix"] = []byte("a/")
+	f.blobs["mirror/repo/a/recycled"] = []byte("recycled")
+	f.blobs["mirror/repo/a/old"] = []byte("old")
+	f.blobs["mirror/repo/b/stale"] = []byte("stale")
+	server := httptest.NewServer(f)
+	defer server.Close()
+
+	storage, err := NewAzureBlobStorage(StorageConfig{Endpoint: server.URL, Container: "minima", Prefix: "mirror", SASToken: "?sv=2021-08-06&sig=signature"}, "/repo/")
+	assert.NoError(t, err)
+	assert.Equal(t, "a/", storage.(*objectStorage).prefix)
+
+	big := bytes.Repeat([]byte("0123456789abcdef"), azureBlockSize*5/2/16)
+	assert.NoError(t, storeBytes(storage, "small", []byte("small")))
+	assert.NoError(t, storeBytes(storage, "big", big))
+	assert.NoError(t, storage.Recycle("recycled"))
+
+	reader, err := storage.NewReader("recycled", Permanent)
+	assert.NoError(t, err)
+	reader.Close()
+	_, err = storage.NewReader("small", Permanent)
+	assert.Equal(t, ErrFileNotFound, err)
+
+	assert.NoError(t, storage.Commit())
+	assert.Equal(t, map[string][]byte{
+		"mirror/repo/.minima-prefix": []byte("b/"),
+		"mirror/repo/b/small":        []byte("small"),
+		"mirror/repo/b/big":          big,
+		"mirror/repo/b/recycled":     []byte("recycled"),
+	}, f.blobs)
+
+	reader, err = storage.NewReader("small", Permanent)
+	assert.NoError(t, err)
+	content, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("small"), content)
+	reader.Close()
+}
+
+func TestAzureBlobStorageManagedIdentity(t *testing.T) {
+	f := newFakeAzureBlob()
+	server := httptest.NewServer(f)
+	defer server.Close()
+
+	tokens := 0
+	identityServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens++
+		assert.Equal(t, "true", r.Header.Get("Metadata"))
+		assert.Equal(t, "client", r.URL.Query().Get("client_id"))
+		fmt.Fprint(w, `{"access_token": "token", "expires_on": "4102444800"}`)
+	}))
+	defer identityServer.Close()
+	defaultTokenURL := azureTokenURL
+	azureTokenURL = identityServer.URL
+	defer func() { azureTokenURL = defaultTokenURL }()
+
+	storage, err := NewAzureBlobStorage(StorageConfig{Endpoint: server.URL, Container: "minima", ManagedIdentityClientID: "client"}, "")
+	assert.NoError(t, err)
+	assert.NoError(t, storeBytes(storage, "file", []byte("file")))
+	assert.NoError(t, storage.Commit())
+
+	assert.Equal(t, "Bearer token", f.authorization)
+	assert.Equal(t, 1, tokens)
+	assert.Equal(t, []byte("file"), f.blobs["a/file"])
+}
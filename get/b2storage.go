@@ -0,0 +1,16 @@
+package get
+
+import "fmt"
+
+// NewB2Storage returns a new Storage backed by a Backblaze B2 bucket, through its S3-compatible API.
+// Files above the part size are stored with multipart uploads, which B2 maps to its large file API.
+// Config holds a key ID and application key in the access key fields, and the bucket region, eg.
+// us-west-004, which selects the endpoint unless one is set
+func NewB2Storage(config StorageConfig, repoPath string) (*S3Storage, error) {
+	if config.Endpoint == "" {
+		config.Endpoint = fmt.Sprintf("https://s3.%s.backblazeb2.com", config.Region)
+	}
+	// B2 rejects requests with object tags
+	config.DisableTagging = true
+	return NewS3Storage(config, repoPath)
+}
@@ -0,0 +1,31 @@
+package get
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewB2Storage(t *testing.T) {
+	f := newFakeS3()
+	f.objects["SLES/15/.minima-prefix"] = []byte("a/")
+	server := httptest.NewServer(f)
+	defer server.Close()
+
+	storage, err := NewB2Storage(StorageConfig{
+		Region:          "us-west-004",
+		AccessKeyID:     "id",
+		SecretAccessKey: "key",
+		Endpoint:        server.URL,
+		Bucket:          "minima",
+		ForcePathStyle:  true,
+		Tags:            map[string]string{"owner": "mirror team"},
+	}, "SLES/15")
+	assert.NoError(t, err)
+	assert.Equal(t, "us-west-004", storage.region)
+	assert.Equal(t, "SLES/15/", storage.root)
+
+	assert.NoError(t, storeBytes(storage, "repomd.xml", []byte("repomd")))
+	assert.NotContains(t, f.headers["SLES/15/b/repomd.xml"], "X-Amz-Tagging")
+}
@@ -0,0 +1,81 @@
+package get
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// BenchResult is the measured performance of an upstream repo
+type BenchResult struct {
+	// Latency is the time until the response to the request of repomd.xml or Release
+	Latency time.Duration
+	// Bytes were downloaded from the largest metadata file in Duration
+	Bytes    int64
+	Duration time.Duration
+}
+
+// Throughput returns the download speed in bytes per second
+func (b BenchResult) Throughput() float64 {
+	if b.Duration <= 0 {
+		return 0
+	}
+	return float64(b.Bytes) / b.Duration.Seconds()
+}
+
+// Bench measures the latency of the repo, requesting its repomd.xml or Release, and its throughput,
+// downloading up to maxBytes of the largest file they list. Nothing is stored
+func (r *Syncer) Bench(ctx context.Context, maxBytes int64) (result BenchResult, err error) {
+	repoType := repoTypes["rpm"]
+	start := time.Now()
+	reader, err := r.readRelative(ctx, repoType.MetadataPath)
+	if uerr, unexpectedStatusCode := err.(*UnexpectedStatusCodeError); unexpectedStatusCode && uerr.StatusCode == http.StatusNotFound {
+		repoType = repoTypes["deb"]
+		start = time.Now()
+		reader, err = r.readRelative(ctx, repoType.MetadataPath)
+	}
+	if err != nil {
+		return
+	}
+	result.Latency = time.Since(start)
+	repomd, err := repoType.DecodeMetadata(reader)
+	reader.Close()
+	if err != nil {
+		return
+	}
+
+	largest := XMLData{}
+	for _, entry := range repomd.Data {
+		if entry.Size > largest.Size || largest.Location.Href == "" {
+			largest = entry
+		}
+	}
+	if largest.Location.Href == "" {
+		return
+	}
+	start = time.Now()
+	reader, err = r.readRelative(ctx, largest.Location.Href)
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+	result.Bytes, err = io.Copy(io.Discard, io.LimitReader(reader, maxBytes))
+	result.Duration = time.Since(start)
+	return
+}
+
+// ReadMirrorlist reads the repo URLs of a mirrorlist, a local file or an http(s) URL with one URL per
+// line, like the ones referenced by mirrorlist= in yum repo files
+func ReadMirrorlist(location string) ([]string, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		return readListFile(location)
+	}
+	reader, err := readURL(context.Background(), http.DefaultClient, location)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return readList(reader)
+}
@@ -0,0 +1,39 @@
+package get
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBench(t *testing.T) {
+	repoURL, err := url.Parse("http://localhost:8080/repo")
+	assert.NoError(t, err)
+	syncer := NewSyncer(*repoURL, nil, nil, true)
+
+	result, err := syncer.Bench(context.Background(), 1000)
+	assert.NoError(t, err)
+	assert.Positive(t, result.Latency)
+	assert.Equal(t, int64(1000), result.Bytes)
+	assert.Positive(t, result.Throughput())
+
+	repoURL.Path = "/missing"
+	syncer = NewSyncer(*repoURL, nil, nil, true)
+	_, err = syncer.Bench(context.Background(), 1000)
+	assert.IsType(t, &UnexpectedStatusCodeError{}, err)
+}
+
+func TestReadMirrorlist(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mirrorlist")
+	assert.NoError(t, os.WriteFile(file, []byte("# repo=sle\nhttp://mirror1.example.com/repo/\n\nhttp://mirror2.example.com/repo/\n"), 0644))
+	mirrors, err := ReadMirrorlist(file)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http://mirror1.example.com/repo/", "http://mirror2.example.com/repo/"}, mirrors)
+
+	_, err = ReadMirrorlist("http://localhost:8080/missing")
+	assert.IsType(t, &UnexpectedStatusCodeError{}, err)
+}
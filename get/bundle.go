@@ -0,0 +1,210 @@
+package get
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/uyuni-project/minima/util"
+)
+
+// manifestEntry is the name of the manifest in bundles, which precedes files below bundleFiles
+const (
+	manifestEntry = "MANIFEST"
+	bundleFiles   = "files/"
+)
+
+// Manifest maps paths of files, relative to a mirror or repo directory, to their SHA-256 checksums.
+// It is written like the output of sha256sum
+type Manifest map[string]string
+
+// ReadManifest reads a Manifest written with WriteTo
+func ReadManifest(reader io.Reader) (Manifest, error) {
+	manifest := Manifest{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		checksum, filename, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			return nil, fmt.Errorf("invalid manifest line: %s", scanner.Text())
+		}
+		manifest[filename] = checksum
+	}
+	return manifest, scanner.Err()
+}
+
+// WriteTo writes the manifest sorted by path
+func (m Manifest) WriteTo(writer io.Writer) (int64, error) {
+	filenames := []string{}
+	for filename := range m {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	var written int64
+	for _, filename := range filenames {
+		n, err := fmt.Fprintf(writer, "%s  %s\n", m[filename], filename)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// ExportBundle writes a tar bundle of the mirror in directory, with the Manifest of all its files and the
+// content of those that are new or changed since the previous export, if any. Checksums of files not
+// modified since then are taken from the previous manifest. Directories of syncs in progress are skipped
+func ExportBundle(directory string, previous Manifest, previousTime time.Time, writer io.Writer) (Manifest, error) {
+	manifest := Manifest{}
+	err := filepath.WalkDir(directory, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if strings.HasSuffix(name, "-in-progress") || strings.HasSuffix(name, "-old") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		relative, err := filepath.Rel(directory, name)
+		if err != nil {
+			return err
+		}
+		filename := filepath.ToSlash(relative)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if checksum, ok := previous[filename]; ok && info.ModTime().Before(previousTime) {
+			manifest[filename] = checksum
+			return nil
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		manifest[filename], err = util.Checksum(f, crypto.SHA256)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tarWriter := tar.NewWriter(writer)
+	content := &strings.Builder{}
+	manifest.WriteTo(content)
+	err = tarWriter.WriteHeader(&tar.Header{Name: manifestEntry, Mode: 0644, Size: int64(content.Len()), ModTime: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.WriteString(tarWriter, content.String())
+	if err != nil {
+		return nil, err
+	}
+
+	filenames := []string{}
+	for filename, checksum := range manifest {
+		if previous[filename] != checksum {
+			filenames = append(filenames, filename)
+		}
+	}
+	sort.Strings(filenames)
+	for _, filename := range filenames {
+		err = addToBundle(tarWriter, directory, filename)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return manifest, tarWriter.Close()
+}
+
+// addToBundle writes a file of directory to a bundle
+func addToBundle(tarWriter *tar.Writer, directory string, filename string) error {
+	f, err := os.Open(filepath.Join(directory, filepath.FromSlash(filename)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	err = tarWriter.WriteHeader(&tar.Header{Name: bundleFiles + filename, Mode: 0644, Size: info.Size(), ModTime: info.ModTime()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tarWriter, f)
+	return err
+}
+
+// ImportBundle applies a bundle to the mirror in directory, which becomes a copy of the exported one.
+// Files in the bundle must match their checksums, while others must be present from previous imports.
+// Like a sync, the new mirror is prepared next to directory and swapped in at the end
+func ImportBundle(reader io.Reader, directory string) error {
+	tarReader := tar.NewReader(reader)
+	header, err := tarReader.Next()
+	if err != nil {
+		return fmt.Errorf("cannot read bundle: %v", err)
+	}
+	if header.Name != manifestEntry {
+		return fmt.Errorf("invalid bundle: %s before %s", header.Name, manifestEntry)
+	}
+	manifest, err := ReadManifest(tarReader)
+	if err != nil {
+		return err
+	}
+	for filename := range manifest {
+		if path.IsAbs(filename) || path.Clean(filename) != filename || strings.HasPrefix(filename, "../") {
+			return fmt.Errorf("invalid bundle: path %s outside of the mirror", filename)
+		}
+	}
+
+	// leftovers of failed imports must not be published
+	err = os.RemoveAll(directory + "-in-progress")
+	if err != nil {
+		return err
+	}
+	storage := NewFileStorage(directory)
+	imported := map[string]bool{}
+	for {
+		header, err = tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read bundle: %v", err)
+		}
+		filename := strings.TrimPrefix(header.Name, bundleFiles)
+		checksum, ok := manifest[filename]
+		if !ok || !strings.HasPrefix(header.Name, bundleFiles) {
+			return fmt.Errorf("invalid bundle: unexpected entry %s", header.Name)
+		}
+		err = util.Compose(storage.StoringMapper(filename, checksum, crypto.SHA256), util.Nop)(io.NopCloser(tarReader))
+		if err != nil {
+			return fmt.Errorf("cannot import %s: %v", filename, err)
+		}
+		imported[filename] = true
+	}
+
+	for filename := range manifest {
+		if imported[filename] {
+			continue
+		}
+		err = storage.Recycle(filename)
+		if err != nil {
+			return fmt.Errorf("%s is missing, was a previous bundle not imported? %v", filename, err)
+		}
+	}
+	return storage.Commit()
+}
@@ -0,0 +1,109 @@
+package get
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeMirror replaces the files of a mirror directory
+func writeMirror(t *testing.T, directory string, files map[string]string) {
+	assert.NoError(t, os.RemoveAll(directory))
+	for filename, content := range files {
+		name := filepath.Join(directory, filepath.FromSlash(filename))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+		assert.NoError(t, os.WriteFile(name, []byte(content), 0644))
+	}
+}
+
+// readMirror returns the files of a mirror directory
+func readMirror(t *testing.T, directory string) map[string]string {
+	files := map[string]string{}
+	err := filepath.WalkDir(directory, func(name string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			content, _ := os.ReadFile(name)
+			relative, _ := filepath.Rel(directory, name)
+			files[filepath.ToSlash(relative)] = string(content)
+		}
+		return err
+	})
+	assert.NoError(t, err)
+	return files
+}
+
+// bundleEntries returns the names of the entries of a bundle
+func bundleEntries(t *testing.T, bundle []byte) []string {
+	names := []string{}
+	reader := tar.NewReader(bytes.NewReader(bundle))
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return names
+		}
+		assert.NoError(t, err)
+		names = append(names, header.Name)
+	}
+}
+
+func TestBundles(t *testing.T) {
+	dir := t.TempDir()
+	connected := filepath.Join(dir, "connected")
+	disconnected := filepath.Join(dir, "disconnected")
+
+	first := map[string]string{
+		"repo/repodata/repomd.xml": "repomd 1",
+		"repo/Packages/a.rpm":      "a",
+		"repo/Packages/b.rpm":      "b",
+	}
+	writeMirror(t, connected, first)
+	assert.NoError(t, os.MkdirAll(filepath.Join(connected, "repo-in-progress"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(connected, "repo-in-progress", "partial.rpm"), []byte("partial"), 0644))
+	bundle := &bytes.Buffer{}
+	manifest, err := ExportBundle(connected, nil, time.Time{}, bundle)
+	assert.NoError(t, err)
+	assert.Len(t, manifest, 3)
+	assert.Equal(t, []string{"MANIFEST", "files/repo/Packages/a.rpm", "files/repo/Packages/b.rpm", "files/repo/repodata/repomd.xml"}, bundleEntries(t, bundle.Bytes()))
+	assert.NoError(t, ImportBundle(bytes.NewReader(bundle.Bytes()), disconnected))
+	assert.Equal(t, first, readMirror(t, disconnected))
+
+	// manifests written and read back
+	content := &bytes.Buffer{}
+	_, err = manifest.WriteTo(content)
+	assert.NoError(t, err)
+	previous, err := ReadManifest(content)
+	assert.NoError(t, err)
+	assert.Equal(t, manifest, previous)
+
+	// files modified since the previous export are checksummed again
+	exported := time.Now()
+	second := map[string]string{
+		"repo/repodata/repomd.xml": "repomd 2",
+		"repo/Packages/a.rpm":      "a",
+		"repo/Packages/c.rpm":      "c",
+	}
+	writeMirror(t, connected, second)
+	old := exported.Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(filepath.Join(connected, "repo", "Packages", "a.rpm"), old, old))
+	bundle.Reset()
+	_, err = ExportBundle(connected, previous, exported, bundle)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"MANIFEST", "files/repo/Packages/c.rpm", "files/repo/repodata/repomd.xml"}, bundleEntries(t, bundle.Bytes()))
+	incremental := bundle.Bytes()
+
+	// incremental bundles need the previous one
+	assert.ErrorContains(t, ImportBundle(bytes.NewReader(incremental), filepath.Join(dir, "empty")), "repo/Packages/a.rpm is missing")
+	assert.NoError(t, ImportBundle(bytes.NewReader(incremental), disconnected))
+	assert.Equal(t, second, readMirror(t, disconnected))
+
+	// corrupted files are detected
+	corrupted := bytes.Replace(incremental, []byte("repomd 2"), []byte("repomd X"), 1)
+	assert.ErrorContains(t, ImportBundle(bytes.NewReader(corrupted), disconnected), "Checksum mismatch")
+	assert.Equal(t, second, readMirror(t, disconnected))
+}
@@ -0,0 +1,114 @@
+package get
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// ChecksumCache remembers the checksums of files in the temporary location of a FileStorage by their size
+// and modification time, so that syncs resuming an interrupted one do not hash again every file it
+// downloaded. It is saved next to the storage directory, with a -checksums suffix, until the next Commit
+type ChecksumCache struct {
+	// directory of the FileStorage
+	directory string
+	// entries by repo-relative path, nil until loaded
+	entries map[string]cachedChecksum
+}
+
+// cachedChecksum is the checksum of a file of a given size and modification time
+type cachedChecksum struct {
+	Size     int64  `json:"size"`
+	ModTime  int64  `json:"mtime"`
+	Type     string `json:"type"`
+	Checksum string `json:"checksum"`
+}
+
+// NewChecksumCache returns a ChecksumCache for the FileStorage of directory
+func NewChecksumCache(directory string) *ChecksumCache {
+	return &ChecksumCache{directory: directory}
+}
+
+func (c *ChecksumCache) file() string {
+	return c.directory + "-checksums"
+}
+
+// stat returns the size and modification time of a file in the temporary location
+func (c *ChecksumCache) stat(filename string) (size int64, modTime int64, err error) {
+	info, err := os.Stat(filepath.Join(c.directory+"-in-progress", filepath.FromSlash(filename)))
+	if err != nil {
+		return
+	}
+	return info.Size(), info.ModTime().UnixNano(), nil
+}
+
+// load reads the saved entries, if not done yet. A missing or unreadable cache is empty
+func (c *ChecksumCache) load() {
+	if c.entries != nil {
+		return
+	}
+	c.entries = map[string]cachedChecksum{}
+	data, err := os.ReadFile(c.file())
+	if err == nil && json.Unmarshal(data, &c.entries) != nil {
+		c.entries = map[string]cachedChecksum{}
+	}
+}
+
+// Matches reports whether the file at filename in the temporary location is unchanged since it was found
+// to have checksum
+func (c *ChecksumCache) Matches(filename string, checksum XMLChecksum) bool {
+	if c == nil {
+		return false
+	}
+	c.load()
+	entry, ok := c.entries[filename]
+	if !ok || entry.Type != checksum.Type || entry.Checksum != checksum.Checksum {
+		return false
+	}
+	size, modTime, err := c.stat(filename)
+	return err == nil && size == entry.Size && modTime == entry.ModTime
+}
+
+// Add records that the file at filename in the temporary location has checksum
+func (c *ChecksumCache) Add(filename string, checksum XMLChecksum) {
+	if c == nil {
+		return
+	}
+	c.load()
+	size, modTime, err := c.stat(filename)
+	if err != nil {
+		delete(c.entries, filename)
+		return
+	}
+	c.entries[filename] = cachedChecksum{size, modTime, checksum.Type, checksum.Checksum}
+}
+
+// Save writes the entries next to the storage directory
+func (c *ChecksumCache) Save() error {
+	if c == nil || c.entries == nil {
+		return nil
+	}
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	temporary := c.file() + ".tmp"
+	err = os.WriteFile(temporary, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporary, c.file())
+}
+
+// Clear forgets all entries, as the temporary location is empty after a Commit
+func (c *ChecksumCache) Clear() error {
+	if c == nil {
+		return nil
+	}
+	c.entries = nil
+	err := os.Remove(c.file())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,64 @@
+package get
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecksumCache(t *testing.T) {
+	directory := filepath.Join(t.TempDir(), "repo")
+	file := filepath.Join(directory+"-in-progress", "x86_64", "a.rpm")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+	assert.NoError(t, os.WriteFile(file, []byte("content"), 0644))
+	// the recorded checksum is not the one of the file, so that matches show it is not hashed again
+	checksum := XMLChecksum{Type: "sha256", Checksum: "0123"}
+
+	cache := NewChecksumCache(directory)
+	assert.False(t, cache.Matches("x86_64/a.rpm", checksum))
+	cache.Add("x86_64/a.rpm", checksum)
+	assert.NoError(t, cache.Save())
+
+	// survives across syncs
+	cache = NewChecksumCache(directory)
+	assert.True(t, cache.Matches("x86_64/a.rpm", checksum))
+	assert.False(t, cache.Matches("x86_64/a.rpm", XMLChecksum{Type: "sha256", Checksum: "4567"}))
+	assert.False(t, cache.Matches("x86_64/b.rpm", checksum))
+
+	repoURL, err := url.Parse("http://localhost:8080/repo")
+	assert.NoError(t, err)
+	syncer := NewSyncer(*repoURL, map[string]bool{"x86_64": true}, NewFileStorage(directory), true)
+	assert.Equal(t, Download, syncer.decide("x86_64/a.rpm", checksum, map[string]XMLChecksum{}))
+	syncer.ChecksumCache = cache
+	assert.Equal(t, Skip, syncer.decide("x86_64/a.rpm", checksum, map[string]XMLChecksum{}))
+
+	// changed files are hashed again
+	later := time.Now().Add(time.Hour)
+	assert.NoError(t, os.Chtimes(file, later, later))
+	assert.False(t, cache.Matches("x86_64/a.rpm", checksum))
+	cache.Add("x86_64/a.rpm", checksum)
+	assert.NoError(t, os.WriteFile(file, []byte("changed content"), 0644))
+	assert.NoError(t, os.Chtimes(file, later, later))
+	assert.False(t, cache.Matches("x86_64/a.rpm", checksum))
+
+	assert.NoError(t, cache.Clear())
+	_, err = os.Stat(directory + "-checksums")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStoreRepoChecksumCache(t *testing.T) {
+	directory := filepath.Join(t.TempDir(), "repo")
+	repoURL, err := url.Parse("http://localhost:8080/repo")
+	assert.NoError(t, err)
+	syncer := NewSyncer(*repoURL, map[string]bool{"x86_64": true}, NewFileStorage(directory), true)
+	syncer.ChecksumCache = NewChecksumCache(directory)
+	assert.NoError(t, syncer.StoreRepo())
+
+	// committed files are no longer in the temporary location
+	_, err = os.Stat(directory + "-checksums")
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,115 @@
+package get
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/uyuni-project/minima/util"
+)
+
+// checksumsFile lists the SHA-256 checksums of all files of a repo, in the format of sha256sum
+const checksumsFile = "SHA256SUMS"
+
+// ChecksumsStorage wraps a Storage to store a checksumsFile in every repo at Commit, so that the mirror
+// can be verified with `sha256sum -c SHA256SUMS` without parsing metadata
+type ChecksumsStorage struct {
+	Storage
+	// checksums of files stored or recycled so far
+	checksums Manifest
+	// checksums of the last Commit, read when first needed
+	previous Manifest
+}
+
+// NewChecksumsStorage returns a Storage adding a checksumsFile to storage
+func NewChecksumsStorage(storage Storage) *ChecksumsStorage {
+	return &ChecksumsStorage{Storage: storage, checksums: Manifest{}}
+}
+
+// StoringMapper returns a mapper that will store read data to a temporary location specified by filename,
+// recording its checksum once it is fully stored
+func (s *ChecksumsStorage) StoringMapper(filename string, checksum string, hash crypto.Hash) util.ReaderMapper {
+	return func(reader io.ReadCloser) (io.ReadCloser, error) {
+		stored, err := s.Storage.StoringMapper(filename, checksum, hash)(reader)
+		if err != nil {
+			return nil, err
+		}
+		return util.NewTeeReadCloser(stored, &checksumRecorder{sha256.New(), s.checksums, filename}), nil
+	}
+}
+
+// checksumRecorder records the checksum of written data when closed
+type checksumRecorder struct {
+	hash.Hash
+	checksums Manifest
+	filename  string
+}
+
+func (r *checksumRecorder) Close() error {
+	r.checksums[r.filename] = hex.EncodeToString(r.Sum(nil))
+	return nil
+}
+
+// Recycle will copy a file from the permanent to the temporary location. Its checksum comes from the
+// last Commit, or is computed if the repo had no checksumsFile
+func (s *ChecksumsStorage) Recycle(filename string) error {
+	err := s.Storage.Recycle(filename)
+	if err != nil {
+		return err
+	}
+
+	if s.previous == nil {
+		s.previous = Manifest{}
+		reader, err := s.Storage.NewReader(checksumsFile, Permanent)
+		if err == nil {
+			s.previous, err = ReadManifest(reader)
+			reader.Close()
+		}
+		if err != nil && err != ErrFileNotFound {
+			return err
+		}
+	}
+	if checksum, ok := s.previous[filename]; ok {
+		s.checksums[filename] = checksum
+		return nil
+	}
+
+	reader, err := s.Storage.NewReader(filename, Temporary)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	s.checksums[filename], err = util.Checksum(reader, crypto.SHA256)
+	return err
+}
+
+// Commit stores the checksumsFile, then commits the wrapped Storage
+func (s *ChecksumsStorage) Commit() error {
+	content := &strings.Builder{}
+	s.checksums.WriteTo(content)
+	err := util.Compose(s.Storage.StoringMapper(checksumsFile, "", 0), util.Nop)(io.NopCloser(strings.NewReader(content.String())))
+	if err != nil {
+		return err
+	}
+	err = s.Storage.Commit()
+	if err != nil {
+		return err
+	}
+	s.checksums = Manifest{}
+	s.previous = nil
+	return nil
+}
+
+// SetModTime sets the modification time of the file stored next at filename, if the wrapped Storage keeps them
+func (s *ChecksumsStorage) SetModTime(filename string, modTime time.Time) {
+	setModTime(s.Storage, filename, modTime)
+}
+
+// CaseInsensitive reports whether the wrapped Storage ignores case in filenames
+func (s *ChecksumsStorage) CaseInsensitive() (bool, error) {
+	return caseInsensitive(s.Storage)
+}
@@ -0,0 +1,57 @@
+package get
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecksumsStorage(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewStorage(StorageConfig{Type: "file", Path: dir, SHA256Sums: true}, StorageRepo{Path: "repo"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, storeBytes(storage, "repodata/repomd.xml", []byte("repomd")))
+	assert.NoError(t, storeBytes(storage, "Packages/a.rpm", []byte("a")))
+	assert.NoError(t, storage.Commit())
+
+	sums := "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb  Packages/a.rpm\n" +
+		"d0df476353bf7bd128a22e414a59d0baebd1fb57d11d6a197ad839227e02dad0  repodata/repomd.xml\n"
+	content, err := os.ReadFile(filepath.Join(dir, "repo", "SHA256SUMS"))
+	assert.NoError(t, err)
+	assert.Equal(t, sums, string(content))
+
+	// recycled files keep their checksum, computed again for repos without SHA256SUMS
+	assert.NoError(t, storage.Recycle("Packages/a.rpm"))
+	assert.NoError(t, storage.Recycle("repodata/repomd.xml"))
+	assert.NoError(t, storeBytes(storage, "Packages/b.rpm", []byte("b")))
+	assert.NoError(t, storage.Commit())
+	content, err = os.ReadFile(filepath.Join(dir, "repo", "SHA256SUMS"))
+	assert.NoError(t, err)
+	assert.Equal(t, "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb  Packages/a.rpm\n"+
+		"3e23e8160039594a33894f6564e1b1348bbd7a0088d42c4acb73eeaed59c009d  Packages/b.rpm\n"+
+		"d0df476353bf7bd128a22e414a59d0baebd1fb57d11d6a197ad839227e02dad0  repodata/repomd.xml\n", string(content))
+
+	assert.NoError(t, os.Remove(filepath.Join(dir, "repo", "SHA256SUMS")))
+	assert.NoError(t, storage.Recycle("Packages/a.rpm"))
+	assert.NoError(t, storage.Recycle("repodata/repomd.xml"))
+	assert.NoError(t, storage.Commit())
+	content, err = os.ReadFile(filepath.Join(dir, "repo", "SHA256SUMS"))
+	assert.NoError(t, err)
+	assert.Equal(t, sums, string(content))
+}
+
+func TestChecksumsStorageRecycleS3(t *testing.T) {
+	// the first recycle after enabling sha256sums, without SHA256SUMS
+	f := newFakeS3()
+	f.objects["a/x86_64/a.rpm"] = []byte("a")
+	s3Storage, server := newFakeS3Storage(f)
+	defer server.Close()
+	storage := NewChecksumsStorage(s3Storage)
+
+	assert.NoError(t, storage.Recycle("x86_64/a.rpm"))
+	assert.Equal(t, []byte("a"), f.objects["b/x86_64/a.rpm"])
+	assert.Equal(t, "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb", storage.checksums["x86_64/a.rpm"])
+}
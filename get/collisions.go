@@ -0,0 +1,77 @@
+package get
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// caseInsensitiveStorage is implemented by Storages that can tell whether they ignore case in filenames
+type caseInsensitiveStorage interface {
+	CaseInsensitive() (bool, error)
+}
+
+// caseInsensitive reports whether storage ignores case in filenames, false if it cannot tell
+func caseInsensitive(storage Storage) (bool, error) {
+	if storage, ok := storage.(caseInsensitiveStorage); ok {
+		return storage.CaseInsensitive()
+	}
+	return false, nil
+}
+
+// CaseInsensitive reports whether the filesystem of the temporary location ignores case in filenames, like
+// the default ones of macOS and Windows and most SMB shares. It is probed with a lowercase temporary file
+func (s *FileStorage) CaseInsensitive() (bool, error) {
+	directory := s.directory + "-in-progress"
+	err := os.MkdirAll(directory, os.ModePerm)
+	if err != nil {
+		return false, err
+	}
+	probe, err := os.CreateTemp(directory, "case-probe-")
+	if err != nil {
+		return false, err
+	}
+	probe.Close()
+	defer os.Remove(probe.Name())
+
+	_, err = os.Stat(filepath.Join(directory, strings.ToUpper(filepath.Base(probe.Name()))))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// CollisionError is returned if packages would overwrite each other in a Storage ignoring case in filenames
+type CollisionError struct {
+	Locations []string
+}
+
+func (e *CollisionError) Error() string {
+	return fmt.Sprintf("package filenames only differing in case cannot be stored on a case-insensitive filesystem: %s", strings.Join(e.Locations, ", "))
+}
+
+// checkPackageCollisions returns a CollisionError if the storage ignores case in filenames and any of
+// packages only differ in case, rather than letting the last one overwrite the others
+func (r *Syncer) checkPackageCollisions(packages []XMLPackage) error {
+	locations := map[string]string{}
+	var collisions []string
+	for _, pack := range packages {
+		location := pack.Location.Href
+		key := strings.ToLower(location)
+		previous, found := locations[key]
+		if !found {
+			locations[key] = location
+		} else if previous != location {
+			collisions = append(collisions, previous, location)
+		}
+	}
+	if len(collisions) == 0 {
+		return nil
+	}
+	insensitive, err := caseInsensitive(r.storage)
+	if err != nil || !insensitive {
+		return err
+	}
+	return &CollisionError{collisions}
+}
@@ -0,0 +1,73 @@
+package get
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// caseInsensitiveFileStorage is a FileStorage on a filesystem ignoring case
+type caseInsensitiveFileStorage struct {
+	*FileStorage
+}
+
+func (s *caseInsensitiveFileStorage) CaseInsensitive() (bool, error) {
+	return true, nil
+}
+
+func TestCheckPackageCollisions(t *testing.T) {
+	pack := func(href string) XMLPackage {
+		return XMLPackage{Location: XMLLocation{Href: href}}
+	}
+	packages := []XMLPackage{pack("x86_64/Foo-1.rpm"), pack("x86_64/bar-1.rpm"), pack("x86_64/foo-1.rpm"), pack("x86_64/bar-1.rpm")}
+
+	// the temporary directory of tests is case-sensitive
+	storage := NewFileStorage(t.TempDir()).(*FileStorage)
+	insensitive, err := storage.CaseInsensitive()
+	assert.NoError(t, err)
+	assert.False(t, insensitive)
+	syncer := &Syncer{storage: storage}
+	assert.NoError(t, syncer.checkPackageCollisions(packages))
+
+	syncer.storage = &caseInsensitiveFileStorage{storage}
+	err = syncer.checkPackageCollisions(packages)
+	assert.IsType(t, &CollisionError{}, err)
+	assert.Equal(t, []string{"x86_64/Foo-1.rpm", "x86_64/foo-1.rpm"}, err.(*CollisionError).Locations)
+	assert.NoError(t, syncer.checkPackageCollisions(packages[:2]))
+}
+
+func TestCheckPackageCollisionsWrapped(t *testing.T) {
+	RegisterStorage("test-case-insensitive", func(config StorageConfig, repo StorageRepo) (Storage, error) {
+		return &caseInsensitiveFileStorage{NewFileStorage(config.Options["directory"] + repo.Path).(*FileStorage)}, nil
+	})
+	defer func() {
+		storageFactoriesLock.Lock()
+		delete(storageFactories, "test-case-insensitive")
+		storageFactoriesLock.Unlock()
+	}()
+	packages := []XMLPackage{{Location: XMLLocation{Href: "x86_64/Foo-1.rpm"}}, {Location: XMLLocation{Href: "x86_64/foo-1.rpm"}}}
+
+	config := StorageConfig{Type: "test-case-insensitive", Options: map[string]string{"directory": t.TempDir()}, SHA256Sums: true}
+	storage, err := NewStorage(config, StorageRepo{Path: "/repo", Name: "repo"})
+	assert.NoError(t, err)
+	assert.IsType(t, &ChecksumsStorage{}, storage)
+	syncer := &Syncer{storage: NewFileHookStorage(storage, "true", nil, nil)}
+	assert.IsType(t, &CollisionError{}, syncer.checkPackageCollisions(packages))
+
+	config.AuditLog = filepath.Join(t.TempDir(), "audit.log")
+	config.Mirrors = []string{"https://mirror.example.com/"}
+	syncer.storage, err = NewStorage(config, StorageRepo{Path: "/repo", Name: "repo"})
+	assert.NoError(t, err)
+	assert.IsType(t, &MetalinkStorage{}, syncer.storage)
+	assert.IsType(t, &CollisionError{}, syncer.checkPackageCollisions(packages))
+
+	// targets are case-insensitive if any of them is
+	syncer.storage = NewMultiStorage([]*StorageTarget{
+		{Name: "local", Storage: NewFileStorage(t.TempDir())},
+		{Name: "share", Storage: storage},
+	})
+	assert.IsType(t, &CollisionError{}, syncer.checkPackageCollisions(packages))
+	syncer.storage = NewMultiStorage([]*StorageTarget{{Name: "local", Storage: NewFileStorage(t.TempDir())}})
+	assert.NoError(t, syncer.checkPackageCollisions(packages))
+}
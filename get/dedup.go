@@ -0,0 +1,72 @@
+package get
+
+import (
+	"sync"
+
+	"github.com/uyuni-project/minima/util"
+)
+
+// Dedup remembers the packages downloaded by the Syncers sharing it, by checksum, so that packages listed
+// by several repos of a run, like base and LTSS repos, are downloaded once and copied from the storage of
+// the first repo into the others
+type Dedup struct {
+	mutex sync.Mutex
+	files map[XMLChecksum]dedupFile
+}
+
+// dedupFile is a downloaded package, in the temporary or, once committed, permanent location of storage
+type dedupFile struct {
+	storage  Storage
+	location string
+}
+
+// NewDedup returns an empty Dedup
+func NewDedup() *Dedup {
+	return &Dedup{files: map[XMLChecksum]dedupFile{}}
+}
+
+// add records that storage has a package with checksum at location
+func (d *Dedup) add(checksum XMLChecksum, storage Storage, location string) {
+	if d == nil || checksum.Checksum == "" {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.files[checksum] = dedupFile{storage, location}
+}
+
+// find returns a package with checksum downloaded by another Syncer
+func (d *Dedup) find(checksum XMLChecksum, storage Storage) (dedupFile, bool) {
+	if d == nil {
+		return dedupFile{}, false
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	file, ok := d.files[checksum]
+	return file, ok && file.storage != storage
+}
+
+// copyDownloaded stores pack, at the escaped relativeURL, copying it from the storage of another repo that
+// downloaded it in this run, while applying a ReaderConsumer. stored is false if no repo did, or if the copy
+// cannot be read or does not match and pack must be downloaded
+func (r *Syncer) copyDownloaded(pack XMLPackage, relativeURL string, description string, f util.ReaderConsumer) (stored bool, err error) {
+	file, ok := r.Dedup.find(pack.Checksum, r.storage)
+	if !ok {
+		return false, nil
+	}
+	reader, err := file.storage.NewReader(file.location, Permanent)
+	if err != nil {
+		reader, err = file.storage.NewReader(file.location, Temporary)
+	}
+	if err != nil {
+		return false, nil
+	}
+	if !r.quiet {
+		r.logger().Printf("Copying %v, downloaded for another repo...", description)
+	}
+	err = r.storeApply(reader, relativeURL, pack.Checksum.Checksum, hashMap[pack.Checksum.Type], f)
+	if _, checksumError := err.(*util.ChecksumError); checksumError {
+		return false, nil
+	}
+	return err == nil, err
+}
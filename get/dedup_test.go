@@ -0,0 +1,53 @@
+package get
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingTransport counts requests of packages
+type countingTransport struct {
+	packages atomic.Int32
+}
+
+func (c *countingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if strings.HasSuffix(request.URL.Path, ".rpm") {
+		c.packages.Add(1)
+	}
+	return http.DefaultTransport.RoundTrip(request)
+}
+
+func TestStoreRepoDedup(t *testing.T) {
+	transport := &countingTransport{}
+	dedup := NewDedup()
+	repoURL, err := url.Parse("http://localhost:8080/repo")
+	assert.NoError(t, err)
+
+	directories := []string{filepath.Join(t.TempDir(), "base"), filepath.Join(t.TempDir(), "ltss")}
+	for _, directory := range directories {
+		syncer := NewSyncer(*repoURL, map[string]bool{"x86_64": true}, NewFileStorage(directory), true)
+		syncer.Client = &http.Client{Transport: transport}
+		syncer.Dedup = dedup
+		assert.NoError(t, syncer.StoreRepo())
+	}
+
+	// packages are only downloaded for the first repo, and copied into the second
+	assert.Equal(t, int32(12), transport.packages.Load())
+	packages, err := filepath.Glob(filepath.Join(directories[1], "*", "*.rpm"))
+	assert.NoError(t, err)
+	assert.Len(t, packages, 12)
+	for _, pack := range packages {
+		copied, err := os.ReadFile(pack)
+		assert.NoError(t, err)
+		original, err := os.ReadFile(filepath.Join(directories[0], filepath.Base(filepath.Dir(pack)), filepath.Base(pack)))
+		assert.NoError(t, err)
+		assert.Equal(t, original, copied)
+	}
+}
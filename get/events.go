@@ -0,0 +1,67 @@
+package get
+
+import (
+	"errors"
+	"io"
+	"net/url"
+
+	"github.com/uyuni-project/minima/util"
+)
+
+// EventType is the kind of an Event
+type EventType int
+
+const (
+	// RepoStarted is sent before a repo is synced
+	RepoStarted EventType = iota
+	// FileDownloaded is sent once a file is downloaded and stored in the temporary location
+	FileDownloaded
+	// VerificationFailed is sent when checksums or signatures do not match, before the sync is retried
+	VerificationFailed
+	// RepoFinished is sent after a repo is synced, with the error if it failed
+	RepoFinished
+	// PackagesPlanned is sent once metadata is stored, with the number and size of packages to download
+	PackagesPlanned
+)
+
+// Event reports the progress of syncs, eg. to build user interfaces on top of Syncers
+type Event struct {
+	Type EventType
+	// URL of the repo without query and password, name of merged repos. Files of merged repos are reported
+	// with the URL of the upstream they are downloaded from
+	Repo string
+	// repo-relative path, for FileDownloaded
+	File string
+	// error of VerificationFailed and failed RepoFinished events
+	Err error
+	// number of packages, for PackagesPlanned
+	Count int
+	// bytes stored, for FileDownloaded, or to download, for PackagesPlanned
+	Size int64
+}
+
+// eventRepo returns repoURL as reported in events, without tokens and credentials
+func eventRepo(repoURL url.URL) string {
+	repoURL.RawQuery = ""
+	return repoURL.Redacted()
+}
+
+// countingReader counts bytes read from the wrapped ReadCloser
+type countingReader struct {
+	io.ReadCloser
+	count int64
+}
+
+func (r *countingReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	r.count += int64(n)
+	return
+}
+
+// verificationError returns true if err signals content not matching checksums or signatures
+func verificationError(err error) bool {
+	var checksumErr *util.ChecksumError
+	var signatureErr *SignatureError
+	var packageSignatureErr *PackageSignatureError
+	return errors.As(err, &checksumErr) || errors.As(err, &signatureErr) || errors.As(err, &packageSignatureErr)
+}
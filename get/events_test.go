@@ -0,0 +1,68 @@
+package get
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncerEvents(t *testing.T) {
+	corrupt := false
+	fileServer := http.FileServer(http.Dir("testdata"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if corrupt && strings.HasSuffix(r.URL.Path, "milkyway-dummy-2.0-1.1.x86_64.rpm") {
+			w.Write([]byte("corrupt"))
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	repoURL, err := url.Parse(server.URL + "/repo?token")
+	assert.NoError(t, err)
+	syncer := NewSyncer(*repoURL, map[string]bool{"x86_64": true}, NewFileStorage(t.TempDir()), true)
+	events := []Event{}
+	syncer.Events = func(event Event) {
+		events = append(events, event)
+	}
+	assert.NoError(t, syncer.StoreRepo())
+
+	repo := server.URL + "/repo"
+	assert.Equal(t, Event{Type: RepoStarted, Repo: repo}, events[0])
+	assert.Equal(t, Event{Type: RepoFinished, Repo: repo}, events[len(events)-1])
+	assert.Contains(t, events, Event{Type: FileDownloaded, Repo: repo, File: "repodata/dadb7d32493327d1afdead2b4f191f8bcd449bcfe48fda241a0b94555c5495f6-primary.xml.gz", Size: 2654})
+	assert.Contains(t, events, Event{Type: FileDownloaded, Repo: repo, File: "x86_64/milkyway-dummy-2.0-1.1.x86_64.rpm", Size: 9727})
+	planned := 0
+	for _, event := range events {
+		if event.Type == PackagesPlanned {
+			planned++
+			assert.Equal(t, 12, event.Count)
+			assert.Positive(t, event.Size)
+		}
+	}
+	assert.Equal(t, 1, planned)
+
+	// verification failures are reported at each attempt
+	corrupt = true
+	events = []Event{}
+	syncer = NewSyncer(*repoURL, map[string]bool{"x86_64": true}, NewFileStorage(t.TempDir()), true)
+	syncer.Events = func(event Event) {
+		events = append(events, event)
+	}
+	err = syncer.StoreRepo()
+	assert.Error(t, err)
+	failures := 0
+	for _, event := range events {
+		if event.Type == VerificationFailed {
+			failures++
+			assert.Error(t, event.Err)
+		}
+	}
+	assert.Equal(t, 20, failures)
+	assert.Equal(t, RepoFinished, events[len(events)-1].Type)
+	assert.Equal(t, err, events[len(events)-1].Err)
+}
@@ -0,0 +1,120 @@
+package get
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// FileAttributes are the ownership, permissions and SELinux label of the files and directories of a
+// FileStorage, so that the mirror can be served right away by a web server running as another user
+type FileAttributes struct {
+	// Owner and Group are user and group names or numeric IDs, if set
+	Owner string
+	Group string
+	// Mode is the permissions of files, if set. Directories get execute permission wherever they get read
+	// permission, so 0644 makes them 0755
+	Mode os.FileMode
+	// SELinuxContext is the security context files and directories are labeled with, if set, like
+	// system_u:object_r:httpd_sys_content_t:s0
+	SELinuxContext string
+}
+
+// fileAttributes returns the FileAttributes of the owner, group, mode and selinux_context storage settings
+func fileAttributes(config StorageConfig) (attributes FileAttributes, err error) {
+	attributes = FileAttributes{Owner: config.Owner, Group: config.Group, SELinuxContext: config.SELinuxContext}
+	if _, _, err = attributes.ids(); err != nil {
+		return
+	}
+	if config.Mode != "" {
+		mode, err := strconv.ParseUint(config.Mode, 8, 32)
+		if err != nil || mode > 0777 {
+			return attributes, fmt.Errorf("invalid mode %s", config.Mode)
+		}
+		attributes.Mode = os.FileMode(mode)
+	}
+	return attributes, nil
+}
+
+// ids returns the numeric IDs of Owner and Group, -1 if unset
+func (a FileAttributes) ids() (uid int, gid int, err error) {
+	uid, gid = -1, -1
+	if a.Owner != "" {
+		uid, err = strconv.Atoi(a.Owner)
+		if err != nil {
+			owner, lookupErr := user.Lookup(a.Owner)
+			if lookupErr != nil {
+				return -1, -1, fmt.Errorf("unknown owner %s", a.Owner)
+			}
+			uid, err = strconv.Atoi(owner.Uid)
+			if err != nil {
+				return -1, -1, fmt.Errorf("unknown owner %s", a.Owner)
+			}
+		}
+	}
+	if a.Group != "" {
+		gid, err = strconv.Atoi(a.Group)
+		if err != nil {
+			group, lookupErr := user.LookupGroup(a.Group)
+			if lookupErr != nil {
+				return -1, -1, fmt.Errorf("unknown group %s", a.Group)
+			}
+			gid, err = strconv.Atoi(group.Gid)
+			if err != nil {
+				return -1, -1, fmt.Errorf("unknown group %s", a.Group)
+			}
+		}
+	}
+	return uid, gid, nil
+}
+
+// empty reports whether no attribute is set
+func (a FileAttributes) empty() bool {
+	return a == FileAttributes{}
+}
+
+// apply sets the attributes on dir and the directories and regular files below it
+func (a FileAttributes) apply(dir string) error {
+	if a.empty() {
+		return nil
+	}
+	uid, gid, err := a.ids()
+	if err != nil {
+		return err
+	}
+	dirMode := a.Mode | (a.Mode&0444)>>2
+	return filepath.WalkDir(dir, func(name string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		if uid != -1 || gid != -1 {
+			if err := os.Chown(name, uid, gid); err != nil {
+				return err
+			}
+		}
+		if a.Mode != 0 {
+			mode := a.Mode
+			if d.IsDir() {
+				mode = dirMode
+			}
+			if err := os.Chmod(name, mode); err != nil {
+				return err
+			}
+		}
+		if a.SELinuxContext != "" {
+			return setSELinuxContext(name, a.SELinuxContext)
+		}
+		return nil
+	})
+}
+
+// setSELinuxContext labels a file or directory with an SELinux security context
+func setSELinuxContext(name string, context string) error {
+	// NUL-terminated, like libselinux does
+	return setXattr(name, "security.selinux", append([]byte(context), 0))
+}
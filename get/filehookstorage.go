@@ -0,0 +1,111 @@
+package get
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/uyuni-project/minima/util"
+)
+
+// FileHookStorage wraps a Storage to run a command for each file stored, before the repo is committed,
+// eg. to scan packages for malware or check their licenses. The command finds a local copy of the file in
+// MINIMA_FILE, its path in the repo in MINIMA_FILE_PATH and its SHA-256 checksum in MINIMA_FILE_CHECKSUM.
+// If it fails the file is rejected, failing the sync. Recycled files are not checked again
+type FileHookStorage struct {
+	Storage
+	command string
+	// environment variables describing the repo, like MINIMA_REPO_NAME
+	variables map[string]string
+	logger    *log.Logger
+}
+
+// NewFileHookStorage returns a Storage running command for the files stored in storage
+func NewFileHookStorage(storage Storage, command string, variables map[string]string, logger *log.Logger) *FileHookStorage {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return &FileHookStorage{Storage: storage, command: command, variables: variables, logger: logger}
+}
+
+// StoringMapper returns a mapper that will store read data to a temporary location specified by filename,
+// running the command once it is fully stored
+func (s *FileHookStorage) StoringMapper(filename string, checksum string, hash crypto.Hash) util.ReaderMapper {
+	return func(reader io.ReadCloser) (io.ReadCloser, error) {
+		stored, err := s.Storage.StoringMapper(filename, checksum, hash)(reader)
+		if err != nil {
+			return nil, err
+		}
+		local, err := os.CreateTemp("", "minima-file-hook-*")
+		if err != nil {
+			stored.Close()
+			return nil, err
+		}
+		runner := &fileHookRunner{s, filename, local, sha256.New()}
+		return &fileHookReader{util.NewTeeReadCloser(stored, runner), runner}, nil
+	}
+}
+
+// fileHookReader removes the local copy of the file when closed, even if storing failed
+type fileHookReader struct {
+	*util.TeeReadCloser
+	runner *fileHookRunner
+}
+
+func (r *fileHookReader) Close() error {
+	err := r.TeeReadCloser.Close()
+	r.runner.local.Close()
+	os.Remove(r.runner.local.Name())
+	return err
+}
+
+// fileHookRunner keeps a local copy of written data and runs the hook on it when closed
+type fileHookRunner struct {
+	storage  *FileHookStorage
+	filename string
+	local    *os.File
+	hash     hash.Hash
+}
+
+func (r *fileHookRunner) Write(p []byte) (int, error) {
+	r.hash.Write(p)
+	return r.local.Write(p)
+}
+
+func (r *fileHookRunner) Close() error {
+	err := r.local.Close()
+	if err != nil {
+		return err
+	}
+
+	variables := map[string]string{
+		"MINIMA_FILE":          r.local.Name(),
+		"MINIMA_FILE_PATH":     r.filename,
+		"MINIMA_FILE_CHECKSUM": hex.EncodeToString(r.hash.Sum(nil)),
+	}
+	for name, value := range r.storage.variables {
+		variables[name] = value
+	}
+	err = runHook(context.Background(), "file_hook", r.storage.command, variables, r.storage.logger)
+	if err != nil {
+		return fmt.Errorf("%s was rejected: %v", r.filename, err)
+	}
+	return nil
+}
+
+// SetModTime sets the modification time of the file stored next at filename, if the wrapped Storage keeps them
+func (s *FileHookStorage) SetModTime(filename string, modTime time.Time) {
+	setModTime(s.Storage, filename, modTime)
+}
+
+// CaseInsensitive reports whether the wrapped Storage ignores case in filenames
+func (s *FileHookStorage) CaseInsensitive() (bool, error) {
+	return caseInsensitive(s.Storage)
+}
@@ -0,0 +1,35 @@
+package get
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uyuni-project/minima/util"
+)
+
+func TestFileHookStorage(t *testing.T) {
+	directory := t.TempDir()
+	output := filepath.Join(t.TempDir(), "output")
+	command := `test "$(cat "$MINIMA_FILE")" != "malware" && echo "$MINIMA_REPO_NAME $MINIMA_FILE_PATH $MINIMA_FILE_CHECKSUM" >> ` + output
+	storage := NewFileHookStorage(NewFileStorage(directory), command, map[string]string{"MINIMA_REPO_NAME": "repo"}, nil)
+
+	store := func(filename string, content string) error {
+		return util.Compose(storage.StoringMapper(filename, "", 0), util.Nop)(io.NopCloser(strings.NewReader(content)))
+	}
+	assert.NoError(t, store("x86_64/clean-1.0-1.x86_64.rpm", "clean"))
+	content, err := os.ReadFile(output)
+	assert.NoError(t, err)
+	assert.Equal(t, "repo x86_64/clean-1.0-1.x86_64.rpm 3b066804f6d1d077173cfe4d06002e6a61e6f21c2b2e648417962115f1afcd8e\n", string(content))
+
+	err = store("x86_64/infected-1.0-1.x86_64.rpm", "malware")
+	assert.ErrorContains(t, err, "x86_64/infected-1.0-1.x86_64.rpm was rejected")
+
+	// local copies are removed
+	copies, err := filepath.Glob(filepath.Join(os.TempDir(), "minima-file-hook-*"))
+	assert.NoError(t, err)
+	assert.Empty(t, copies)
+}
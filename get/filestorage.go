@@ -1,24 +1,91 @@
 package get
 
 import (
+	"bufio"
 	"crypto"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
-	"path"
 	"path/filepath"
+	"runtime"
+	"time"
 
 	"github.com/uyuni-project/minima/util"
 )
 
+// fsync policies of FileStorageOptions
+const (
+	// FsyncFile syncs every stored file to disk when it is closed
+	FsyncFile = "file"
+	// FsyncCommit syncs all stored files to disk at Commit, before they are published
+	FsyncCommit = "commit"
+)
+
 // FileStorage allows to store data in a local directory
 type FileStorage struct {
 	directory string
+	options   FileStorageOptions
+	// noReflinks is set once the filesystem was found not to support reflinks
+	noReflinks bool
+	// upstream modification times of the files stored next, see SetModTime
+	modTimes map[string]time.Time
+
+	// Upstream is recorded in the provenance extended attributes of stored files, if enabled
+	Upstream string
+}
+
+// errReflinkUnsupported is returned by reflink if the filesystem cannot clone files
+var errReflinkUnsupported = errors.New("reflinks are not supported")
+
+// FileStorageOptions tune the I/O of a FileStorage, eg. for network filesystems
+type FileStorageOptions struct {
+	// BufferSize is the size of the buffers stored files are written and read through, if set
+	BufferSize int
+	// Fsync is FsyncFile or FsyncCommit, if set. Either way, directories are synced at Commit
+	Fsync string
+	// Attributes are set on stored files and directories at Commit, before they are published
+	Attributes FileAttributes
+	// ProvenanceXattrs records the source URL, checksum and time of stored files in extended attributes
+	ProvenanceXattrs bool
 }
 
 // NewFileStorage returns a new Storage given a local directory
 func NewFileStorage(directory string) Storage {
-	return &FileStorage{directory}
+	return &FileStorage{directory: directory}
+}
+
+// NewFileStorageWithOptions returns a new Storage given a local directory, with tuned I/O
+func NewFileStorageWithOptions(directory string, options FileStorageOptions) Storage {
+	return &FileStorage{directory: directory, options: options}
+}
+
+// fileStorageOptions returns the FileStorageOptions of the buffer_size, fsync and file attributes storage
+// settings
+func fileStorageOptions(config StorageConfig) (options FileStorageOptions, err error) {
+	options.Attributes, err = fileAttributes(config)
+	if err != nil {
+		return
+	}
+	if config.BufferSize != "" {
+		size, err := ParseSize(config.BufferSize)
+		if err != nil {
+			return options, fmt.Errorf("invalid buffer_size %s", config.BufferSize)
+		}
+		options.BufferSize = int(size)
+	}
+	switch config.Fsync {
+	case "", FsyncFile, FsyncCommit:
+		options.Fsync = config.Fsync
+	default:
+		return options, fmt.Errorf("unsupported fsync policy %s", config.Fsync)
+	}
+	if config.ProvenanceXattrs && runtime.GOOS != "linux" {
+		return options, errors.New("provenance_xattrs is only supported on Linux")
+	}
+	options.ProvenanceXattrs = config.ProvenanceXattrs
+	return options, nil
 }
 
 // NewReader returns a Reader for a file in a location, returns ErrFileNotFound
@@ -30,7 +97,7 @@ func (s *FileStorage) NewReader(filename string, location Location) (reader io.R
 	} else {
 		prefix = "-in-progress"
 	}
-	fullPath := path.Join(s.directory+prefix, filename)
+	fullPath := filepath.Join(s.directory+prefix, filepath.FromSlash(filename))
 	stat, err := os.Stat(fullPath)
 	if os.IsNotExist(err) || stat == nil {
 		err = ErrFileNotFound
@@ -42,15 +109,76 @@ func (s *FileStorage) NewReader(filename string, location Location) (reader io.R
 		log.Fatal(err)
 	}
 
+	if s.options.BufferSize > 0 {
+		return &bufferedFileReader{bufio.NewReaderSize(f, s.options.BufferSize), f}, nil
+	}
 	return f, err
 }
 
+// bufferedFileReader reads a file through a buffer
+type bufferedFileReader struct {
+	*bufio.Reader
+	io.Closer
+}
+
+// fileWriter writes a stored file, through a buffer if set, syncing it and setting extended attributes
+// when closed if set
+type fileWriter struct {
+	file   *os.File
+	buffer *bufio.Writer
+	sync   bool
+	xattrs map[string]string
+	// modTime is set once the file is closed, if not zero
+	modTime time.Time
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	if w.buffer != nil {
+		return w.buffer.Write(p)
+	}
+	return w.file.Write(p)
+}
+
+func (w *fileWriter) Close() error {
+	var err error
+	if w.buffer != nil {
+		err = w.buffer.Flush()
+	}
+	if err == nil && w.xattrs != nil {
+		err = setProvenance(w.file.Name(), w.xattrs)
+	}
+	if err == nil && w.sync {
+		err = w.file.Sync()
+	}
+	closeErr := w.file.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr == nil && !w.modTime.IsZero() {
+		return os.Chtimes(w.file.Name(), w.modTime, w.modTime)
+	}
+	return closeErr
+}
+
+// SetModTime sets the modification time of the file stored next at filename, eg. to the upstream
+// Last-Modified time, so that rsync and HTTP cache validators of downstream consumers see it
+func (s *FileStorage) SetModTime(filename string, modTime time.Time) {
+	if modTime.IsZero() {
+		delete(s.modTimes, filename)
+		return
+	}
+	if s.modTimes == nil {
+		s.modTimes = map[string]time.Time{}
+	}
+	s.modTimes[filename] = modTime
+}
+
 // StoringMapper returns a mapper that will store read data to a temporary location specified by filename
 func (s *FileStorage) StoringMapper(filename string, checksum string, hash crypto.Hash) util.ReaderMapper {
 	return func(reader io.ReadCloser) (result io.ReadCloser, err error) {
-		fullPath := path.Join(s.directory+"-in-progress", filename)
+		fullPath := filepath.Join(s.directory+"-in-progress", filepath.FromSlash(filename))
 		// attempt to create any missing directories in the full path
-		err = os.MkdirAll(path.Dir(fullPath), os.ModePerm)
+		err = os.MkdirAll(filepath.Dir(fullPath), os.ModePerm)
 		if err != nil {
 			return
 		}
@@ -60,42 +188,130 @@ func (s *FileStorage) StoringMapper(filename string, checksum string, hash crypt
 			return
 		}
 
-		result = util.NewTeeReadCloser(reader, util.NewChecksummingWriter(file, checksum, hash))
+		writer := &fileWriter{file: file, sync: s.options.Fsync == FsyncFile, modTime: s.modTimes[filename]}
+		delete(s.modTimes, filename)
+		if s.options.ProvenanceXattrs {
+			writer.xattrs = s.provenance(filename, checksum, hash)
+		}
+		if s.options.BufferSize > 0 {
+			writer.buffer = bufio.NewWriterSize(file, s.options.BufferSize)
+		}
+		result = util.NewTeeReadCloser(reader, util.NewChecksummingWriter(writer, checksum, hash))
 		return
 	}
 }
 
-// Recycle will copy a file from the permanent to the temporary location
+// Recycle will copy a file from the permanent to the temporary location. Files are cloned where the
+// filesystem supports reflinks, so that they do not share changes to their attributes or contents with
+// the permanent location, and hard linked otherwise
 func (s *FileStorage) Recycle(filename string) (err error) {
-	newPath := path.Join(s.directory+"-in-progress", filename)
-	err = os.MkdirAll(path.Dir(newPath), os.ModePerm)
+	newPath := filepath.Join(s.directory+"-in-progress", filepath.FromSlash(filename))
+	err = os.MkdirAll(filepath.Dir(newPath), os.ModePerm)
 	if err != nil {
 		return
 	}
 
-	err = os.Link(path.Join(s.directory, filename), newPath)
+	oldPath := filepath.Join(s.directory, filepath.FromSlash(filename))
+	if !s.noReflinks {
+		err = reflink(oldPath, newPath)
+		if err == nil {
+			return s.recycleMetadata(oldPath, newPath)
+		}
+		if os.IsExist(err) {
+			return nil
+		}
+		// anything else is reported by linking
+		s.noReflinks = err == errReflinkUnsupported
+	}
+	err = os.Link(oldPath, newPath)
 	if err != nil && os.IsExist(err) {
 		// ignore, we are fine already
 		return nil
 	}
+	if linkErr, ok := err.(*os.LinkError); ok && !os.IsNotExist(linkErr.Err) {
+		// filesystems without hard links, like FAT or some SMB shares
+		err = copyFile(oldPath, newPath)
+		if err != nil {
+			return err
+		}
+		return s.recycleMetadata(oldPath, newPath)
+	}
 	return
 }
 
+// recycleMetadata copies the modification time and, if enabled, the provenance extended attributes of a
+// recycled file, which clones and copies do not keep
+func (s *FileStorage) recycleMetadata(oldPath string, newPath string) error {
+	info, err := os.Stat(oldPath)
+	if err != nil {
+		return err
+	}
+	err = os.Chtimes(newPath, info.ModTime(), info.ModTime())
+	if err != nil || !s.options.ProvenanceXattrs {
+		return err
+	}
+	return copyProvenance(oldPath, newPath)
+}
+
+// copyFile copies the file at source to target
+func copyFile(source string, target string) error {
+	from, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+	to, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(to, from)
+	if err != nil {
+		to.Close()
+		return err
+	}
+	return to.Close()
+}
+
+// renameRetries is the number of times renames are retried on Windows, where files opened by other
+// processes, like virus scanners and indexers, make them fail for a while
+const renameRetries = 5
+
+// rename renames a file or directory, retrying on Windows
+func rename(from string, to string) (err error) {
+	for attempt := 1; ; attempt++ {
+		err = os.Rename(from, to)
+		if err == nil || os.IsNotExist(err) || runtime.GOOS != "windows" || attempt == renameRetries {
+			return
+		}
+		time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+	}
+}
+
 // Commit will take care of moving downloaded metadata and packages in the target
 // path, plus cleanup old or temporary files
 func (s *FileStorage) Commit() error {
 	oldDir := s.directory + "-old"
 	tmpDir := s.directory + "-in-progress"
+	if err := s.options.Attributes.apply(tmpDir); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if s.options.Fsync != "" {
+		if err := syncTree(tmpDir, s.options.Fsync == FsyncCommit); err != nil {
+			return err
+		}
+		defer syncDir(filepath.Dir(s.directory))
+	}
 
 	// If in-progress contains actual packages, it is a candidate for being swapped with the target repo.
 	// Otherwise, it's a situation where we only have metadata in x-in-progress.
 	if hasPackages(tmpDir) {
 		os.RemoveAll(oldDir)
 
-		if err := os.Rename(s.directory, oldDir); err != nil && !os.IsNotExist(err) {
+		// directories cannot be replaced by renames on Windows, so the old one is moved away first
+		if err := rename(s.directory, oldDir); err != nil && !os.IsNotExist(err) {
 			return err
 		}
-		if err := os.Rename(tmpDir, s.directory); err != nil {
+		if err := rename(tmpDir, s.directory); err != nil {
 			return err
 		}
 
@@ -109,6 +325,40 @@ func (s *FileStorage) Commit() error {
 	return os.RemoveAll(tmpDir)
 }
 
+// Purge removes the repo from the storage, with its temporary location, previous version and checksum
+// cache, eg. once it is not mirrored anymore
+func (s *FileStorage) Purge() error {
+	for _, dir := range []string{s.directory, s.directory + "-in-progress", s.directory + "-old", NewChecksumCache(s.directory).file()} {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncTree syncs the directories below dir to disk and, if files is set, their files
+func syncTree(dir string, files bool) error {
+	return filepath.WalkDir(dir, func(name string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || (files && d.Type().IsRegular()) {
+			return syncDir(name)
+		}
+		return nil
+	})
+}
+
+// syncDir syncs a directory or file to disk
+func syncDir(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
 func hasPackages(dir string) bool {
 	found := false
 	// We check for common package extensions used in Linux distros
@@ -146,7 +396,7 @@ func mergeDirs(source, target string) error {
 		// cleanup previous entries in the target to prevent errors
 		_ = os.RemoveAll(to)
 
-		err = os.Rename(from, to)
+		err = rename(from, to)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,160 @@
+package get
+
+import (
+	"bytes"
+	"crypto"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/uyuni-project/minima/util"
+)
+
+func TestFileStorageOptions(t *testing.T) {
+	directory := t.TempDir()
+	storage, err := NewStorage(StorageConfig{Type: "file", Path: directory, BufferSize: "1M", Fsync: FsyncCommit}, StorageRepo{Path: "repo"})
+	assert.NoError(t, err)
+	assert.Equal(t, FileStorageOptions{BufferSize: 1 << 20, Fsync: FsyncCommit}, storage.(*FileStorage).options)
+
+	content := bytes.Repeat([]byte("minima"), 1<<18)
+	for _, fsync := range []string{FsyncFile, FsyncCommit} {
+		storage.(*FileStorage).options.Fsync = fsync
+		err = util.Compose(storage.StoringMapper("x86_64/a.rpm", "", 0), util.Nop)(io.NopCloser(bytes.NewReader(content)))
+		assert.NoError(t, err)
+		assert.NoError(t, storage.Commit())
+
+		stored, err := os.ReadFile(filepath.Join(directory, "repo", "x86_64", "a.rpm"))
+		assert.NoError(t, err)
+		assert.True(t, bytes.Equal(content, stored))
+		reader, err := storage.NewReader("x86_64/a.rpm", Permanent)
+		assert.NoError(t, err)
+		read, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.NoError(t, reader.Close())
+		assert.True(t, bytes.Equal(content, read))
+	}
+
+	_, err = NewStorage(StorageConfig{Type: "file", Path: directory, BufferSize: "large"}, StorageRepo{Path: "repo"})
+	assert.EqualError(t, err, "invalid buffer_size large")
+	_, err = NewStorage(StorageConfig{Type: "file", Path: directory, Fsync: "always"}, StorageRepo{Path: "repo"})
+	assert.EqualError(t, err, "unsupported fsync policy always")
+}
+
+func TestCopyFile(t *testing.T) {
+	directory := t.TempDir()
+	source := filepath.Join(directory, "source")
+	target := filepath.Join(directory, "target")
+	assert.Nil(t, os.WriteFile(source, []byte("content"), 0644))
+
+	assert.Nil(t, copyFile(source, target))
+	data, err := os.ReadFile(target)
+	assert.Nil(t, err)
+	assert.Equal(t, "content", string(data))
+
+	assert.NotNil(t, copyFile(filepath.Join(directory, "missing"), target))
+}
+
+func TestFileStorageAttributes(t *testing.T) {
+	directory := t.TempDir()
+	// owned by the current user, as changing owners needs root
+	config := StorageConfig{Type: "file", Path: directory, Owner: strconv.Itoa(os.Getuid()), Group: strconv.Itoa(os.Getgid()), Mode: "0640"}
+	storage, err := NewStorage(config, StorageRepo{Path: "repo"})
+	assert.NoError(t, err)
+	assert.Equal(t, FileAttributes{Owner: config.Owner, Group: config.Group, Mode: 0640}, storage.(*FileStorage).options.Attributes)
+
+	err = util.Compose(storage.StoringMapper("x86_64/a.rpm", "", 0), util.Nop)(io.NopCloser(bytes.NewReader([]byte("a"))))
+	assert.NoError(t, err)
+	assert.NoError(t, storage.Commit())
+
+	info, err := os.Stat(filepath.Join(directory, "repo", "x86_64", "a.rpm"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+	info, err = os.Stat(filepath.Join(directory, "repo", "x86_64"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0750), info.Mode().Perm())
+
+	_, err = NewStorage(StorageConfig{Type: "file", Path: directory, Mode: "u+rw"}, StorageRepo{Path: "repo"})
+	assert.EqualError(t, err, "invalid mode u+rw")
+	_, err = NewStorage(StorageConfig{Type: "file", Path: directory, Owner: "no-such-minima-user"}, StorageRepo{Path: "repo"})
+	assert.EqualError(t, err, "unknown owner no-such-minima-user")
+	_, err = NewStorage(StorageConfig{Type: "file", Path: directory, Group: "no-such-minima-group"}, StorageRepo{Path: "repo"})
+	assert.EqualError(t, err, "unknown group no-such-minima-group")
+}
+
+func TestFileStorageRecycle(t *testing.T) {
+	directory := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(directory, "x86_64"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(directory, "x86_64", "a.rpm"), []byte("a"), 0644))
+
+	storage := NewFileStorage(directory).(*FileStorage)
+	assert.NoError(t, storage.Recycle("x86_64/a.rpm"))
+	assert.NoError(t, storage.Recycle("x86_64/a.rpm"))
+	recycled, err := os.ReadFile(filepath.Join(directory+"-in-progress", "x86_64", "a.rpm"))
+	assert.NoError(t, err)
+	assert.Equal(t, "a", string(recycled))
+
+	// cloned where reflinks are supported, hard linked otherwise
+	old, err := os.Stat(filepath.Join(directory, "x86_64", "a.rpm"))
+	assert.NoError(t, err)
+	recycledInfo, err := os.Stat(filepath.Join(directory+"-in-progress", "x86_64", "a.rpm"))
+	assert.NoError(t, err)
+	assert.Equal(t, storage.noReflinks, os.SameFile(old, recycledInfo))
+
+	assert.Error(t, storage.Recycle("x86_64/missing.rpm"))
+}
+
+func TestFileStorageProvenanceXattrs(t *testing.T) {
+	directory := t.TempDir()
+	if err := setXattr(directory, xattrSynced, []byte("probe")); err != nil {
+		t.Skip("extended attributes are not supported:", err)
+	}
+	storage, err := NewStorage(StorageConfig{Type: "file", Path: directory, ProvenanceXattrs: true}, StorageRepo{Path: "repo", Upstream: "http://example.com/repo/"})
+	assert.NoError(t, err)
+
+	checksum := "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb"
+	err = util.Compose(storage.StoringMapper("x86_64/a.rpm", checksum, crypto.SHA256), util.Nop)(io.NopCloser(bytes.NewReader([]byte("a"))))
+	assert.NoError(t, err)
+	assert.NoError(t, storage.Commit())
+
+	name := filepath.Join(directory, "repo", "x86_64", "a.rpm")
+	source, err := getXattr(name, xattrSource)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example.com/repo/x86_64/a.rpm", string(source))
+	stored, err := getXattr(name, xattrChecksum)
+	assert.NoError(t, err)
+	assert.Equal(t, "SHA-256:"+checksum, string(stored))
+	synced, err := getXattr(name, xattrSynced)
+	assert.NoError(t, err)
+	_, err = time.Parse(time.RFC3339, string(synced))
+	assert.NoError(t, err)
+
+	// recycled files keep their provenance
+	target := filepath.Join(directory, "copy.rpm")
+	assert.NoError(t, copyFile(name, target))
+	assert.NoError(t, copyProvenance(name, target))
+	copied, err := getXattr(target, xattrSynced)
+	assert.NoError(t, err)
+	assert.Equal(t, synced, copied)
+}
+
+func TestFileStoragePurge(t *testing.T) {
+	parent := t.TempDir()
+	directory := filepath.Join(parent, "repo")
+	for _, dir := range []string{directory, directory + "-in-progress", directory + "-old", filepath.Join(parent, "other")} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, "x86_64"), 0755))
+	}
+	assert.NoError(t, os.WriteFile(directory+"-checksums", []byte("{}"), 0644))
+
+	assert.NoError(t, NewFileStorage(directory).(*FileStorage).Purge())
+	entries, err := os.ReadDir(parent)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "other", entries[0].Name())
+	// purging again is fine
+	assert.NoError(t, NewFileStorage(directory).(*FileStorage).Purge())
+}
@@ -0,0 +1,58 @@
+package get
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/fips140"
+	"fmt"
+	"io"
+
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// fipsChecksums are the checksum types of hashMap approved in FIPS mode
+var fipsChecksums = map[string]bool{
+	"sha256": true,
+	"sha512": true,
+}
+
+// fipsHashes are the signature hashes approved in FIPS mode
+var fipsHashes = map[crypto.Hash]bool{
+	crypto.SHA256: true,
+	crypto.SHA384: true,
+	crypto.SHA512: true,
+}
+
+// fipsMode reports whether FIPS mode is enabled, as set or by the Go FIPS 140-3 module, eg. with
+// GODEBUG=fips140=on
+func fipsMode(enabled bool) bool {
+	return enabled || fips140.Enabled()
+}
+
+// checkFIPSSignature returns an error if an armored detached signature uses a hash not approved in FIPS
+// mode, before it is verified with it
+func checkFIPSSignature(signaturePath string, signature []byte) error {
+	block, err := armor.Decode(bytes.NewReader(signature))
+	if err != nil {
+		return &SignatureError{signaturePath + " file does not contain a valid signature"}
+	}
+	return checkFIPSSignaturePacket(signaturePath, block.Body)
+}
+
+// checkFIPSSignaturePacket is checkFIPSSignature for a binary signature
+func checkFIPSSignaturePacket(signaturePath string, signature io.Reader) error {
+	reader := packet.NewReader(signature)
+	for {
+		p, err := reader.Next()
+		if err != nil {
+			return &SignatureError{signaturePath + " file does not contain a valid signature"}
+		}
+		if signature, ok := p.(*packet.Signature); ok {
+			if !fipsHashes[signature.Hash] {
+				return &ChecksumPolicyError{fmt.Sprintf("%s is made with %s, which is not FIPS approved", signaturePath, signature.Hash)}
+			}
+			return nil
+		}
+	}
+}
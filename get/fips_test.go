@@ -0,0 +1,62 @@
+package get
+
+import (
+	"bytes"
+	"crypto"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFIPSChecksums(t *testing.T) {
+	syncer := &Syncer{FIPS: true}
+	assert.NoError(t, syncer.checkChecksum("a.rpm", XMLChecksum{Type: "sha256"}))
+	assert.NoError(t, syncer.checkChecksum("a.rpm", XMLChecksum{Type: "sha512"}))
+	assert.EqualError(t, syncer.checkChecksum("a.rpm", XMLChecksum{Type: "sha1"}), "a.rpm only has a sha1 checksum, which is not FIPS approved")
+	assert.EqualError(t, syncer.checkChecksum("a.rpm", XMLChecksum{}), "a.rpm has no checksum, which FIPS mode requires")
+
+	assert.NotContains(t, metalinkHashes(true), "md5")
+	assert.NotContains(t, metalinkHashes(true), "sha1")
+
+	// testdata only has SHA-256 checksums
+	repoURL, err := url.Parse("http://localhost:8080/repo")
+	assert.NoError(t, err)
+	syncer = NewSyncer(*repoURL, map[string]bool{"x86_64": true}, NewFileStorage(t.TempDir()), true)
+	syncer.FIPS = true
+	assert.NoError(t, syncer.StoreRepo())
+}
+
+func TestCheckFIPSSignature(t *testing.T) {
+	entity, err := openpgp.NewEntity("minima", "test", "minima@example.com", nil)
+	assert.NoError(t, err)
+	sign := func(hashID byte) []byte {
+		signature := &bytes.Buffer{}
+		err := openpgp.DetachSign(signature, entity, strings.NewReader("repomd"), &packet.Config{DefaultHash: crypto.SHA256})
+		assert.NoError(t, err)
+		// go-crypto refuses to sign with SHA-1, so the hash algorithm is replaced. It follows the packet
+		// header (tag and two length bytes) and the version, type and public key algorithm
+		raw := signature.Bytes()
+		if hashID != 0 {
+			raw[3+3] = hashID
+		}
+		armored := &bytes.Buffer{}
+		writer, err := armor.Encode(armored, openpgp.SignatureType, nil)
+		assert.NoError(t, err)
+		writer.Write(raw)
+		writer.Close()
+		return armored.Bytes()
+	}
+
+	assert.NoError(t, checkFIPSSignature("repodata/repomd.xml.asc", sign(0)))
+	err = checkFIPSSignature("repodata/repomd.xml.asc", sign(2))
+	assert.EqualError(t, err, "repodata/repomd.xml.asc is made with SHA-1, which is not FIPS approved")
+	assert.IsType(t, &ChecksumPolicyError{}, err)
+
+	_, ok := checkFIPSSignature("repodata/repomd.xml.asc", []byte("garbage")).(*SignatureError)
+	assert.True(t, ok)
+}
@@ -0,0 +1,77 @@
+package get
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+)
+
+// hookShell runs hook commands
+var hookShell = "/bin/sh"
+
+// SyncHooks are shell commands run before and after a repo is synced, eg. to quiesce consumers, post-process
+// the repo or invalidate CDN caches. They get MINIMA_* environment variables describing the repo
+type SyncHooks struct {
+	// run before downloading, the repo is not synced if it fails
+	PreSync string `yaml:"pre_sync"`
+	// run after the repo is committed, or failed with MINIMA_SYNC_STATUS=failed
+	PostSync string `yaml:"post_sync"`
+	// run for each new file before the repo is committed, see FileHookStorage
+	FileHook string `yaml:"file_hook"`
+}
+
+// storeWithHooks calls store between the hooks. variables, like MINIMA_REPO_PATH, are passed to both hooks,
+// the post-sync one also gets the number of changed files and the outcome of store
+func storeWithHooks(ctx context.Context, hooks SyncHooks, variables map[string]string, logger *log.Logger, store func() (int, error)) error {
+	if hooks.PreSync != "" {
+		logger.Println("Running pre_sync hook...")
+		err := runHook(ctx, "pre_sync", hooks.PreSync, variables, logger)
+		if err != nil {
+			return err
+		}
+	}
+
+	changed, err := store()
+	if hooks.PostSync == "" {
+		return err
+	}
+	postVariables := map[string]string{"MINIMA_CHANGED_FILES": strconv.Itoa(changed), "MINIMA_SYNC_STATUS": "ok"}
+	if err != nil {
+		postVariables["MINIMA_SYNC_STATUS"] = "failed"
+	}
+	for name, value := range variables {
+		postVariables[name] = value
+	}
+	logger.Println("Running post_sync hook...")
+	hookErr := runHook(ctx, "post_sync", hooks.PostSync, postVariables, logger)
+	if err != nil {
+		return err
+	}
+	return hookErr
+}
+
+// runHook runs command with the shell, with variables added to the environment. Its output is logged
+func runHook(ctx context.Context, name string, command string, variables map[string]string, logger *log.Logger) error {
+	cmd := exec.CommandContext(ctx, hookShell, "-c", command)
+	cmd.Env = os.Environ()
+	names := []string{}
+	for variable := range variables {
+		names = append(names, variable)
+	}
+	sort.Strings(names)
+	for _, variable := range names {
+		cmd.Env = append(cmd.Env, variable+"="+variables[variable])
+	}
+	out, err := cmd.CombinedOutput()
+	if len(out) > 0 {
+		logger.Printf("%s", out)
+	}
+	if err != nil {
+		return fmt.Errorf("%s hook failed: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package get
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreWithHooks(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "output")
+	hooks := SyncHooks{
+		PreSync:  `echo "pre $MINIMA_REPO_NAME" >> ` + output,
+		PostSync: `echo "post $MINIMA_REPO_NAME $MINIMA_CHANGED_FILES $MINIMA_SYNC_STATUS" >> ` + output,
+	}
+	variables := map[string]string{"MINIMA_REPO_NAME": "SLES15-SP5-Pool"}
+	logs := &strings.Builder{}
+	logger := log.New(logs, "", 0)
+
+	stored := 0
+	err := storeWithHooks(context.Background(), hooks, variables, logger, func() (int, error) {
+		stored++
+		return 3, nil
+	})
+	assert.NoError(t, err)
+	err = storeWithHooks(context.Background(), hooks, variables, logger, func() (int, error) {
+		stored++
+		return 1, errors.New("sync failed")
+	})
+	assert.EqualError(t, err, "sync failed")
+	assert.Equal(t, 2, stored)
+
+	content, err := os.ReadFile(output)
+	assert.NoError(t, err)
+	assert.Equal(t, "pre SLES15-SP5-Pool\npost SLES15-SP5-Pool 3 ok\npre SLES15-SP5-Pool\npost SLES15-SP5-Pool 1 failed\n", string(content))
+
+	// repos are not synced if the pre-sync hook fails, whose output is logged
+	err = storeWithHooks(context.Background(), SyncHooks{PreSync: "echo consumers busy; exit 1"}, variables, logger, func() (int, error) {
+		stored++
+		return 0, nil
+	})
+	assert.ErrorContains(t, err, "pre_sync hook failed")
+	assert.Equal(t, 2, stored)
+	assert.Contains(t, logs.String(), "consumers busy")
+
+	// failures of the post-sync hook fail the repo
+	err = storeWithHooks(context.Background(), SyncHooks{PostSync: "exit 2"}, variables, logger, func() (int, error) {
+		return 0, nil
+	})
+	assert.ErrorContains(t, err, "post_sync hook failed")
+}
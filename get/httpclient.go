@@ -1,9 +1,14 @@
 package get
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
 )
 
 // UnexpectedStatusCodeError signals a successful request that resulted in an unexpected status code
@@ -18,17 +23,73 @@ func (e UnexpectedStatusCodeError) Error() string {
 
 // ReadURL returns a Reader for bytes from an http URL
 func ReadURL(url string) (r io.ReadCloser, err error) {
-	response, err := http.Get(url)
+	return readURL(context.Background(), http.DefaultClient, url)
+}
+
+// readURL returns a Reader for bytes from an http URL, downloaded with client until ctx is done
+func readURL(ctx context.Context, client *http.Client, url string) (r io.ReadCloser, err error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return
+	}
+	response, err := client.Do(request)
 	if err != nil {
 		return
 	}
 
 	if response.StatusCode != 200 {
+		response.Body.Close()
 		err = &UnexpectedStatusCodeError{url, response.StatusCode}
 		return
 	}
 
-	r = response.Body
+	r = newModifiedBody(response)
 
 	return
 }
+
+// RepoHTTPClient returns a client for an HTTP repo requiring a TLS client certificate, like an entitlement
+// certificate of the Red Hat CDN, or OAuth2 access tokens, or nil if it has none. The key defaults to the
+// file named like the certificate with a -key suffix, as written by subscription-manager (eg. 123.pem and
+// 123-key.pem)
+func RepoHTTPClient(config HTTPRepoConfig) (*http.Client, error) {
+	if config.ClientCertFile == "" && config.CACertFile == "" && config.OAuth2 == nil {
+		return nil, nil
+	}
+	client, err := tlsHTTPClient(config.CACertFile, false)
+	if err != nil {
+		return nil, err
+	}
+	if config.ClientCertFile != "" {
+		err = loadClientCertificate(client, config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.OAuth2 != nil {
+		repoURL, err := url.Parse(config.URL)
+		if err != nil {
+			return nil, err
+		}
+		client.Transport, err = newOAuth2Transport(client.Transport, *config.OAuth2, repoURL.Host)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// loadClientCertificate makes client present the TLS client certificate of config
+func loadClientCertificate(client *http.Client, config HTTPRepoConfig) error {
+	keyFile := config.ClientKeyFile
+	if keyFile == "" {
+		extension := filepath.Ext(config.ClientCertFile)
+		keyFile = strings.TrimSuffix(config.ClientCertFile, extension) + "-key" + extension
+	}
+	certificate, err := tls.LoadX509KeyPair(config.ClientCertFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load client certificate for %s: %v", config.URL, err)
+	}
+	client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{certificate}
+	return nil
+}
@@ -1,10 +1,27 @@
 package get
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/big"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestReadURL(t *testing.T) {
@@ -39,3 +56,76 @@ func TestReadURL(t *testing.T) {
 		t.Error("404 error expected, got ", uerr.StatusCode)
 	}
 }
+
+// writeTestCertificate writes a PEM certificate for name signed by parent, or self-signed if nil, and its key
+func writeTestCertificate(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, certFile string, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  parent == nil,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	certificate, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return certificate, key
+}
+
+func TestRepoHTTPClient(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "redhat-uep.pem")
+	ca, caKey := writeTestCertificate(t, "Entitlement Master CA", nil, nil, caFile, filepath.Join(dir, "ca-key.pem"))
+	writeTestCertificate(t, "cdn.example.com", ca, caKey, filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem"))
+	// entitlement certificates and keys are written by subscription-manager as <serial>.pem and <serial>-key.pem
+	entitlement := filepath.Join(dir, "1234.pem")
+	writeTestCertificate(t, "entitlement", ca, caKey, entitlement, filepath.Join(dir, "1234-key.pem"))
+
+	serverCertificate, err := tls.LoadX509KeyPair(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem"))
+	assert.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "entitled")
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{serverCertificate}, ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	client, err := RepoHTTPClient(HTTPRepoConfig{URL: server.URL})
+	assert.NoError(t, err)
+	assert.Nil(t, client)
+
+	client, err = RepoHTTPClient(HTTPRepoConfig{URL: server.URL, ClientCertFile: entitlement, CACertFile: caFile})
+	assert.NoError(t, err)
+	reader, err := readURL(context.Background(), client, server.URL+"/content/dist/rhel9/repodata/repomd.xml")
+	assert.NoError(t, err)
+	content, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	reader.Close()
+	assert.Equal(t, "entitled", string(content))
+
+	// without the entitlement, the server refuses the connection
+	client, err = RepoHTTPClient(HTTPRepoConfig{URL: server.URL, CACertFile: caFile})
+	assert.NoError(t, err)
+	_, err = readURL(context.Background(), client, server.URL+"/content/dist/rhel9/repodata/repomd.xml")
+	assert.Error(t, err)
+
+	_, err = RepoHTTPClient(HTTPRepoConfig{URL: server.URL, ClientCertFile: filepath.Join(dir, "missing.pem")})
+	assert.ErrorContains(t, err, "cannot load client certificate")
+}
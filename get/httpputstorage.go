@@ -0,0 +1,312 @@
+package get
+
+import (
+	"bufio"
+	"crypto"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/uyuni-project/minima/util"
+)
+
+// filesManifest lists the files of the last committed sync in an HTTPPutStorage, relative to the repo
+const filesManifest = ".minima-files"
+
+// HTTPPutStorage allows to store data in a server accepting uploads with HTTP PUT and removals with
+// DELETE, eg. Artifactory generic or Nexus raw repositories. As such servers cannot move files,
+// packages are uploaded in place while metadata is staged locally and only uploaded at Commit, with
+// repomd.xml or Release last. Files missing from the new sync are then deleted, as listed by the
+// manifest of the previous one. Hosted yum repositories of artifact managers only get packages, see
+// publishHosted
+type HTTPPutStorage struct {
+	// URLs of the target repository and of the repo in it
+	endpoint url.URL
+	base     url.URL
+	// artifact manager generating metadata of a hosted yum repository, if any, see publishHosted
+	manager  string
+	username string
+	password string
+	token    string
+	client   *http.Client
+
+	// metadata files staged in a local directory until Commit
+	stagingDir string
+	staging    Storage
+	// files stored or recycled so far, and those of them that are staged
+	stored map[string]bool
+	staged map[string]bool
+}
+
+// NewHTTPPutStorage returns a new Storage in repoPath below the URL at the configured endpoint
+func NewHTTPPutStorage(config StorageConfig, repoPath string) (Storage, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(config.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	base := *endpoint
+	base.Path = path.Join("/", base.Path, repoPath)
+	client, err := tlsHTTPClient(config.CACertFile, config.InsecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+	staging, err := os.MkdirTemp("", "minima-staging")
+	if err != nil {
+		return nil, err
+	}
+	return &HTTPPutStorage{
+		endpoint:   *endpoint,
+		base:       base,
+		manager:    config.ArtifactManager,
+		username:   config.Username,
+		password:   config.Password,
+		token:      config.Token,
+		client:     client,
+		stagingDir: staging,
+		staging:    NewFileStorage(filepath.Join(staging, "repo")),
+		stored:     map[string]bool{},
+		staged:     map[string]bool{},
+	}, nil
+}
+
+// do sends a request for a file of the repo, returning the response if it has one of the expected
+// status codes
+func (s *HTTPPutStorage) do(method string, filename string, body io.Reader, headers map[string]string, expected ...int) (*http.Response, error) {
+	u := s.base
+	u.Path = path.Join(u.Path, filename)
+	return s.send(method, u, body, headers, expected...)
+}
+
+// send sends a request to the server, see do
+func (s *HTTPPutStorage) send(method string, u url.URL, body io.Reader, headers map[string]string, expected ...int) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	} else if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrFileNotFound
+	}
+	return nil, &UnexpectedStatusCodeError{method + " " + u.Path, resp.StatusCode}
+}
+
+// isPackage returns whether filename is uploaded in place rather than staged
+func isPackage(filename string) bool {
+	_, ok := packageExtensions[path.Ext(filename)]
+	return ok
+}
+
+// NewReader returns a Reader for a file in a location, returns ErrFileNotFound
+// if the requested path was not found at all
+func (s *HTTPPutStorage) NewReader(filename string, location Location) (io.ReadCloser, error) {
+	if location == Temporary {
+		if s.staged[filename] {
+			return s.staging.NewReader(filename, Temporary)
+		}
+		if !s.stored[filename] {
+			return nil, ErrFileNotFound
+		}
+	}
+	resp, err := s.do(http.MethodGet, filename, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// StoringMapper returns a mapper that will upload read data of packages, and stage it for other files
+func (s *HTTPPutStorage) StoringMapper(filename string, checksum string, hash crypto.Hash) util.ReaderMapper {
+	return func(reader io.ReadCloser) (result io.ReadCloser, err error) {
+		if !isPackage(filename) {
+			s.stored[filename] = true
+			s.staged[filename] = true
+			return s.staging.StoringMapper(filename, checksum, hash)(reader)
+		}
+
+		// Artifactory rejects uploads not matching checksum headers, others ignore them
+		headers := map[string]string{}
+		switch hash {
+		case crypto.SHA1:
+			headers["X-Checksum-Sha1"] = checksum
+		case crypto.SHA256:
+			headers["X-Checksum-Sha256"] = checksum
+		}
+		pipeReader, pipeWriter := io.Pipe()
+
+		errs := make(chan error)
+		go func() {
+			err := s.put(filename, pipeReader, headers)
+			// unblock writes if the upload stopped early
+			pipeReader.CloseWithError(err)
+			errs <- err
+		}()
+
+		writer := util.NewChecksummingWriter(&waitingCloser{pipeWriter, errs, filename}, checksum, hash)
+		result = util.NewTeeReadCloser(reader, &uploadCloser{writer, s, filename})
+		return
+	}
+}
+
+// put uploads body to filename, replacing any existing file
+func (s *HTTPPutStorage) put(filename string, body io.Reader, headers map[string]string) error {
+	resp, err := s.do(http.MethodPut, filename, body, headers, http.StatusCreated, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// uploadCloser records an uploaded package as stored, or deletes it if it fails its checksum as it
+// is already published
+type uploadCloser struct {
+	io.WriteCloser
+	storage  *HTTPPutStorage
+	filename string
+}
+
+func (c *uploadCloser) Close() error {
+	err := c.WriteCloser.Close()
+	if _, ok := err.(*util.ChecksumError); ok {
+		c.storage.delete(c.filename)
+	}
+	if err == nil {
+		c.storage.stored[c.filename] = true
+	}
+	return err
+}
+
+// Recycle keeps a file from the last sync. Packages are already in place, other files are staged again
+// so that they are uploaded before repomd.xml or Release
+func (s *HTTPPutStorage) Recycle(filename string) error {
+	if isPackage(filename) {
+		s.stored[filename] = true
+		return nil
+	}
+	reader, err := s.NewReader(filename, Permanent)
+	if err != nil {
+		return err
+	}
+	return util.Compose(s.StoringMapper(filename, "", 0), util.Nop)(reader)
+}
+
+// Commit publishes the sync, then forgets about stored files
+func (s *HTTPPutStorage) Commit() error {
+	var err error
+	if s.manager == "" {
+		err = s.publishStaged()
+	} else {
+		err = s.publishHosted()
+	}
+	if err != nil {
+		return err
+	}
+
+	s.stored = map[string]bool{}
+	s.staged = map[string]bool{}
+	return os.RemoveAll(s.stagingDir)
+}
+
+// publishStaged uploads staged files and deletes those of the previous sync that were not stored again
+func (s *HTTPPutStorage) publishStaged() error {
+	staged := []string{}
+	for filename := range s.staged {
+		staged = append(staged, filename)
+	}
+	// files naming or signing repodata are uploaded after it
+	last := func(filename string) bool {
+		return strings.HasPrefix(filename, repomdPath) || strings.HasPrefix(filename, releasePath)
+	}
+	sort.Slice(staged, func(i, j int) bool {
+		if last(staged[i]) != last(staged[j]) {
+			return last(staged[j])
+		}
+		return staged[i] < staged[j]
+	})
+	for _, filename := range staged {
+		reader, err := s.staging.NewReader(filename, Temporary)
+		if err != nil {
+			return err
+		}
+		err = s.put(filename, reader, nil)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	previous, err := s.manifest()
+	if err != nil {
+		return err
+	}
+	for _, filename := range previous {
+		if !s.stored[filename] {
+			err = s.delete(filename)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	filenames := []string{}
+	for filename := range s.stored {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	return s.put(filesManifest, strings.NewReader(strings.Join(filenames, "\n")+"\n"), nil)
+}
+
+// manifest returns the files of the last committed sync
+func (s *HTTPPutStorage) manifest() ([]string, error) {
+	resp, err := s.do(http.MethodGet, filesManifest, nil, nil, http.StatusOK)
+	if err == ErrFileNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	filenames := []string{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if filename := scanner.Text(); filename != "" {
+			filenames = append(filenames, filename)
+		}
+	}
+	return filenames, scanner.Err()
+}
+
+// delete removes a file, if it exists
+func (s *HTTPPutStorage) delete(filename string) error {
+	resp, err := s.do(http.MethodDelete, filename, nil, nil, http.StatusNoContent, http.StatusOK, http.StatusAccepted)
+	if err == ErrFileNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,119 @@
+package get
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uyuni-project/minima/util"
+)
+
+// fakeArtifactManager implements a raw repository in memory, accepting token TOKEN and checking
+// SHA-256 checksum headers like Artifactory
+type fakeArtifactManager struct {
+	files map[string]string
+	// methods and paths of modifying requests, in order
+	requests []string
+}
+
+func (f *fakeArtifactManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer TOKEN" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	content, exists := f.files[r.URL.Path]
+	if r.Method != http.MethodGet {
+		f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, content)
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		if checksum := r.Header.Get("X-Checksum-Sha256"); checksum != "" && checksum != sha256Hex(body) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.files[r.URL.Path] = string(body)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.files, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestHTTPPutStorage(t *testing.T) {
+	f := &fakeArtifactManager{files: map[string]string{
+		"/raw/repo/.minima-files":         "Packages/old.rpm\nPackages/recycled.rpm\nrepodata/repomd.xml\n",
+		"/raw/repo/Packages/old.rpm":      "old",
+		"/raw/repo/Packages/recycled.rpm": "recycled",
+		"/raw/repo/repodata/repomd.xml":   "old repomd",
+	}}
+	server := httptest.NewServer(f)
+	defer server.Close()
+
+	storage, err := NewHTTPPutStorage(StorageConfig{Endpoint: server.URL + "/raw/", Token: "TOKEN"}, "repo")
+	assert.NoError(t, err)
+
+	assert.NoError(t, storeBytes(storage, "repodata/repomd.xml", []byte("repomd")))
+	assert.NoError(t, storeBytes(storage, "repodata/primary.xml.gz", []byte("primary")))
+	assert.NoError(t, storage.Recycle("Packages/recycled.rpm"))
+	assert.NoError(t, storeBytes(storage, "Packages/new.rpm", []byte("new")))
+	err = util.Compose(storage.StoringMapper("Packages/corrupt.rpm", sha256Hex([]byte("expected")), crypto.SHA256), util.Nop)(
+		io.NopCloser(strings.NewReader("corrupt")))
+	assert.Error(t, err)
+
+	// metadata is only published at Commit
+	reader, err := storage.NewReader("repodata/repomd.xml", Permanent)
+	assert.NoError(t, err)
+	content, _ := io.ReadAll(reader)
+	reader.Close()
+	assert.Equal(t, "old repomd", string(content))
+	reader, err = storage.NewReader("repodata/repomd.xml", Temporary)
+	assert.NoError(t, err)
+	content, _ = io.ReadAll(reader)
+	reader.Close()
+	assert.Equal(t, "repomd", string(content))
+
+	f.requests = nil
+	assert.NoError(t, storage.Commit())
+	assert.Equal(t, []string{
+		"PUT /raw/repo/repodata/primary.xml.gz",
+		"PUT /raw/repo/repodata/repomd.xml",
+		"DELETE /raw/repo/Packages/old.rpm",
+		"PUT /raw/repo/.minima-files",
+	}, f.requests)
+	assert.Equal(t, map[string]string{
+		"/raw/repo/.minima-files":           "Packages/new.rpm\nPackages/recycled.rpm\nrepodata/primary.xml.gz\nrepodata/repomd.xml\n",
+		"/raw/repo/Packages/new.rpm":        "new",
+		"/raw/repo/Packages/recycled.rpm":   "recycled",
+		"/raw/repo/repodata/primary.xml.gz": "primary",
+		"/raw/repo/repodata/repomd.xml":     "repomd",
+	}, f.files)
+
+	unauthorized, err := NewHTTPPutStorage(StorageConfig{Endpoint: server.URL + "/raw", Username: "minima", Password: "secret"}, "other")
+	assert.NoError(t, err)
+	assert.Error(t, storeBytes(unauthorized, "Packages/file.rpm", []byte("file")))
+}
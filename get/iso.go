@@ -0,0 +1,152 @@
+package get
+
+import (
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// isoCommand is the xorriso executable ISO images are built with
+var isoCommand = "xorriso"
+
+// isoSector is the block size of ISO 9660 images, files and directory records take whole sectors
+const isoSector = 2048
+
+// MediaSizes are the capacities in bytes of common media, as accepted by ParseMediaSize
+var MediaSizes = map[string]int64{
+	"cd":     737280000,
+	"dvd":    4700372992,
+	"dvd-dl": 8543666176,
+	"bd":     25025314816,
+}
+
+// ParseMediaSize returns the capacity of a medium named in MediaSizes, or given as accepted by ParseSize
+func ParseMediaSize(media string) (int64, error) {
+	if size, ok := MediaSizes[media]; ok {
+		return size, nil
+	}
+	size, err := ParseSize(media)
+	if err != nil {
+		return 0, fmt.Errorf("invalid media size %s", media)
+	}
+	return size, nil
+}
+
+// ISOVolume is a set of whole repos fitting on one medium
+type ISOVolume struct {
+	// paths of the repos, relative to the mirror directory
+	Repos []string
+	// estimated size of the image in bytes
+	Size int64
+}
+
+// FindRepos returns the paths of repos below directory, relative to it, recognised by their metadata
+func FindRepos(directory string) ([]string, error) {
+	repos := []string{}
+	err := filepath.WalkDir(directory, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && (strings.HasSuffix(name, "-in-progress") || strings.HasSuffix(name, "-old")) {
+			return filepath.SkipDir
+		}
+		relative, err := filepath.Rel(directory, name)
+		if err != nil {
+			return err
+		}
+		relative = filepath.ToSlash(relative)
+		for _, metadata := range []string{repomdPath, releasePath} {
+			if relative == metadata {
+				repos = append(repos, ".")
+			} else if strings.HasSuffix(relative, "/"+metadata) {
+				repos = append(repos, strings.TrimSuffix(relative, "/"+metadata))
+			}
+		}
+		return nil
+	})
+	sort.Strings(repos)
+	return repos, err
+}
+
+// isoSize returns an estimate of the space taken by a repo in an ISO image
+func isoSize(directory string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(directory, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// a sector for the directory record, upper bound for all but very long names
+		size += isoSector
+		if !d.IsDir() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += (info.Size() + isoSector - 1) / isoSector * isoSector
+		}
+		return nil
+	})
+	return size, err
+}
+
+// PlanISOVolumes distributes repos of the mirror in directory over as few media of mediaSize as
+// possible, largest repos first. Repos are never split, as they would not be usable from a single medium
+func PlanISOVolumes(directory string, repos []string, mediaSize int64) ([]ISOVolume, error) {
+	// system area, volume descriptors and path tables
+	const overhead = 1024 * 1024
+
+	sizes := map[string]int64{}
+	for _, repo := range repos {
+		size, err := isoSize(filepath.Join(directory, filepath.FromSlash(repo)))
+		if err != nil {
+			return nil, err
+		}
+		if size+overhead > mediaSize {
+			return nil, fmt.Errorf("repo %s (%d MiB) does not fit on media of %d MiB", repo, size/1024/1024, mediaSize/1024/1024)
+		}
+		sizes[repo] = size
+	}
+	sorted := append([]string{}, repos...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sizes[sorted[i]] > sizes[sorted[j]]
+	})
+
+	volumes := []ISOVolume{}
+	for _, repo := range sorted {
+		placed := false
+		for i := range volumes {
+			if volumes[i].Size+sizes[repo] <= mediaSize {
+				volumes[i].Repos = append(volumes[i].Repos, repo)
+				volumes[i].Size += sizes[repo]
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			volumes = append(volumes, ISOVolume{[]string{repo}, overhead + sizes[repo]})
+		}
+	}
+	for _, volume := range volumes {
+		sort.Strings(volume.Repos)
+	}
+	return volumes, nil
+}
+
+// BuildISO writes a data ISO image of the repos of volume to output, with Rock Ridge and Joliet
+// extensions. Repos keep their paths relative to directory
+func BuildISO(directory string, volume ISOVolume, volumeID string, output string) error {
+	args := []string{"-as", "mkisofs", "-r", "-J", "-joliet-long", "-V", volumeID, "-o", output, "-graft-points"}
+	for _, repo := range volume.Repos {
+		args = append(args, path.Join("/", repo)+"="+filepath.Join(directory, filepath.FromSlash(repo)))
+	}
+	cmd := exec.Command(isoCommand, args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("building %s failed: %v\n%s", output, err, out)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package get
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMediaSize(t *testing.T) {
+	tests := []struct {
+		media   string
+		want    int64
+		wantErr bool
+	}{
+		{"dvd", 4700372992, false},
+		{"700M", 700 * 1024 * 1024, false},
+		{"2G", 2 * 1024 * 1024 * 1024, false},
+		{"123456", 123456, false},
+		{"floppy", 0, true},
+		{"-1M", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.media, func(t *testing.T) {
+			got, err := ParseMediaSize(tt.media)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestPlanISOVolumes(t *testing.T) {
+	dir := t.TempDir()
+	for repo, size := range map[string]int{"big": 600, "medium": 300, "small": 100, "tiny/x86_64": 10} {
+		writeMirror(t, filepath.Join(dir, filepath.FromSlash(repo)), map[string]string{
+			"repodata/repomd.xml": "repomd",
+			"Packages/a.rpm":      strings.Repeat("a", size*1024),
+		})
+	}
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "small-in-progress", "repodata"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "small-in-progress", "repodata", "repomd.xml"), []byte("partial"), 0644))
+
+	repos, err := FindRepos(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"big", "medium", "small", "tiny/x86_64"}, repos)
+
+	volumes, err := PlanISOVolumes(dir, repos, 1800*1024)
+	assert.NoError(t, err)
+	assert.Len(t, volumes, 2)
+	assert.Equal(t, []string{"big", "small", "tiny/x86_64"}, volumes[0].Repos)
+	assert.Equal(t, []string{"medium"}, volumes[1].Repos)
+	for _, volume := range volumes {
+		assert.LessOrEqual(t, volume.Size, int64(1800*1024))
+	}
+
+	_, err = PlanISOVolumes(dir, repos, 1024*1024+500*1024)
+	assert.EqualError(t, err, "repo big (0 MiB) does not fit on media of 1 MiB")
+}
+
+func TestBuildISO(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "log")
+	script := filepath.Join(dir, "xorriso")
+	assert.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" > "+log+"\n"), 0755))
+	defaultCommand := isoCommand
+	isoCommand = script
+	defer func() { isoCommand = defaultCommand }()
+
+	err := BuildISO("/srv/mirror", ISOVolume{Repos: []string{"SLES/15", "."}}, "MINIMA_1", "/srv/iso/MINIMA_1.iso")
+	assert.NoError(t, err)
+	args, err := os.ReadFile(log)
+	assert.NoError(t, err)
+	assert.Equal(t, "-as mkisofs -r -J -joliet-long -V MINIMA_1 -o /srv/iso/MINIMA_1.iso -graft-points /SLES/15=/srv/mirror/SLES/15 /=/srv/mirror\n", string(args))
+}
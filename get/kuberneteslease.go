@@ -0,0 +1,189 @@
+package get
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// serviceAccountDir has the credentials and namespace Kubernetes mounts in pods
+var serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubernetesMicroTime is the format of the times of Lease objects
+const kubernetesMicroTime = "2006-01-02T15:04:05.000000Z07:00"
+
+// kubernetesLease is a leaderLock in a coordination.k8s.io/v1 Lease object, updated with the API of the
+// cluster minima runs in. Updates fail if the object changed since it was read, so that only one replica
+// takes a free lease
+type kubernetesLease struct {
+	client *http.Client
+	// URL of the API server
+	apiURL string
+	// service account token, read for each request as it is rotated
+	tokenFile string
+	namespace string
+	name      string
+	identity  string
+	duration  time.Duration
+	// labels of the Lease object when it is created
+	labels map[string]string
+}
+
+// kubernetesLeaseObject is a Lease object of the Kubernetes API
+type kubernetesLeaseObject struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string            `json:"name"`
+		Namespace       string            `json:"namespace"`
+		ResourceVersion string            `json:"resourceVersion,omitempty"`
+		Labels          map[string]string `json:"labels,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		HolderIdentity       string `json:"holderIdentity,omitempty"`
+		LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+		AcquireTime          string `json:"acquireTime,omitempty"`
+		RenewTime            string `json:"renewTime,omitempty"`
+		LeaseTransitions     int    `json:"leaseTransitions,omitempty"`
+	} `json:"spec"`
+}
+
+// expired reports whether the Lease is free or can be taken at now
+func (o *kubernetesLeaseObject) expired(now time.Time) bool {
+	renewTime, err := time.Parse(time.RFC3339Nano, o.Spec.RenewTime)
+	return o.Spec.HolderIdentity == "" || err != nil || now.Sub(renewTime) > time.Duration(o.Spec.LeaseDurationSeconds)*time.Second
+}
+
+// kubernetesLeaseList is a list of Lease objects of the Kubernetes API
+type kubernetesLeaseList struct {
+	Items []kubernetesLeaseObject `json:"items"`
+}
+
+// newKubernetesLease returns the Lease of election in the cluster of the pod minima runs in
+func newKubernetesLease(election LeaderElection) (*kubernetesLease, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("kubernetes lock requires running in a pod, KUBERNETES_SERVICE_HOST is not set")
+	}
+	client, err := tlsHTTPClient(filepath.Join(serviceAccountDir, "ca.crt"), false)
+	if err != nil {
+		return nil, err
+	}
+	namespace := election.Namespace
+	if namespace == "" {
+		data, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+		if err != nil {
+			return nil, fmt.Errorf("cannot find the namespace of the pod, set namespace: %v", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+	return &kubernetesLease{
+		client:    client,
+		apiURL:    "https://" + net.JoinHostPort(host, port),
+		tokenFile: filepath.Join(serviceAccountDir, "token"),
+		namespace: namespace,
+		name:      election.Name,
+		identity:  election.Identity,
+		duration:  election.LeaseDuration,
+	}, nil
+}
+
+func (l *kubernetesLease) acquire(ctx context.Context) (bool, error) {
+	lease := kubernetesLeaseObject{}
+	status, err := l.do(ctx, http.MethodGet, "/"+l.name, nil, &lease)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if status == http.StatusNotFound {
+		lease.APIVersion, lease.Kind = "coordination.k8s.io/v1", "Lease"
+		lease.Metadata.Name, lease.Metadata.Namespace, lease.Metadata.Labels = l.name, l.namespace, l.labels
+		l.hold(&lease, now)
+		status, err = l.do(ctx, http.MethodPost, "", lease, nil)
+		// conflicts if another replica created it first
+		return status/100 == 2, err
+	}
+
+	if lease.Spec.HolderIdentity != l.identity {
+		if !lease.expired(now) {
+			return false, nil
+		}
+		lease.Spec.LeaseTransitions++
+		l.hold(&lease, now)
+	}
+	lease.Spec.RenewTime = now.UTC().Format(kubernetesMicroTime)
+	// updates with the resourceVersion read fail if another replica renewed or took the lease meanwhile
+	status, err = l.do(ctx, http.MethodPut, "/"+l.name, lease, nil)
+	return status/100 == 2, err
+}
+
+// hold sets the Lease to be held by the identity of l since now
+func (l *kubernetesLease) hold(lease *kubernetesLeaseObject, now time.Time) {
+	lease.Spec.HolderIdentity = l.identity
+	lease.Spec.LeaseDurationSeconds = int((l.duration + time.Second - 1) / time.Second)
+	lease.Spec.AcquireTime = now.UTC().Format(kubernetesMicroTime)
+	lease.Spec.RenewTime = lease.Spec.AcquireTime
+}
+
+func (l *kubernetesLease) release(ctx context.Context) error {
+	lease := kubernetesLeaseObject{}
+	status, err := l.do(ctx, http.MethodGet, "/"+l.name, nil, &lease)
+	if err != nil || status == http.StatusNotFound || lease.Spec.HolderIdentity != l.identity {
+		return err
+	}
+	lease.Spec.HolderIdentity = ""
+	_, err = l.do(ctx, http.MethodPut, "/"+l.name, lease, nil)
+	return err
+}
+
+// do requests the collection of Leases in the namespace of l followed by suffix, eg. /name for the Lease
+// object with name, and decodes the response into result. Statuses "not found" and "conflict" are
+// returned without errors
+func (l *kubernetesLease) do(ctx context.Context, method string, suffix string, body any, result any) (int, error) {
+	url := fmt.Sprintf("%s/apis/coordination.k8s.io/v1/namespaces/%s/leases%s", l.apiURL, l.namespace, suffix)
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("Content-Type", "application/json")
+	if l.tokenFile != "" {
+		token, err := os.ReadFile(l.tokenFile)
+		if err != nil {
+			return 0, err
+		}
+		request.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	response, err := l.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	switch {
+	case response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusConflict:
+		return response.StatusCode, nil
+	case response.StatusCode/100 != 2:
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return response.StatusCode, fmt.Errorf("lease %s/%s: %s %s", l.namespace, l.name, response.Status, bytes.TrimSpace(message))
+	case result != nil:
+		return response.StatusCode, json.NewDecoder(response.Body).Decode(result)
+	}
+	return response.StatusCode, nil
+}
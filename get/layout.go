@@ -0,0 +1,43 @@
+package get
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// DefaultPathTemplate stores repos at the path of their URL
+const DefaultPathTemplate = "{path}"
+
+var placeholderRegexp = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// ExpandPathTemplate returns the storage path of a repo replacing {name} placeholders in template with
+// the corresponding values in variables. The result is always a clean, absolute slash-separated path
+func ExpandPathTemplate(template string, variables map[string]string) (string, error) {
+	var missing []string
+	result := placeholderRegexp.ReplaceAllStringFunc(template, func(placeholder string) string {
+		value, ok := variables[strings.Trim(placeholder, "{}")]
+		if !ok {
+			missing = append(missing, placeholder)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("unknown variables %s in path template %s", strings.Join(missing, ", "), template)
+	}
+	return path.Clean("/" + result), nil
+}
+
+// distroTargetVariables returns product and version path template variables from an SCC distro target
+// such as sle-15-x86_64
+func distroTargetVariables(distroTarget string) map[string]string {
+	parts := strings.Split(distroTarget, "-")
+	if len(parts) < 3 {
+		return nil
+	}
+	return map[string]string{
+		"product": strings.Join(parts[:len(parts)-2], "-"),
+		"version": parts[len(parts)-2],
+	}
+}
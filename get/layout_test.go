@@ -0,0 +1,44 @@
+package get
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandPathTemplate(t *testing.T) {
+	variables := map[string]string{
+		"path":     "/repositories/myrepo/",
+		"reponame": "SLE-Product-SLES15-SP5-Pool",
+		"product":  "sle",
+		"version":  "15",
+		"arch":     "x86_64",
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{"Default", DefaultPathTemplate, "/repositories/myrepo", false},
+		{"All variables", "{product}/{version}/{arch}/{reponame}", "/sle/15/x86_64/SLE-Product-SLES15-SP5-Pool", false},
+		{"Constant prefix", "mirror/{path}", "/mirror/repositories/myrepo", false},
+		{"Escaping root", "../../{reponame}", "/SLE-Product-SLES15-SP5-Pool", false},
+		{"Unknown variable", "{product}/{sp}", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExpandPathTemplate(tt.template, variables)
+			assert.EqualValues(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDistroTargetVariables(t *testing.T) {
+	assert.Equal(t, map[string]string{"product": "sle", "version": "15"}, distroTargetVariables("sle-15-x86_64"))
+	assert.Equal(t, map[string]string{"product": "open-enterprise-server", "version": "2018"}, distroTargetVariables("open-enterprise-server-2018-x86_64"))
+	assert.Nil(t, distroTargetVariables(""))
+}
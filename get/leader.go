@@ -0,0 +1,313 @@
+package get
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Locks holding the lease of a LeaderElection
+const (
+	// LockKubernetes is a coordination.k8s.io/v1 Lease object in the namespace of the pod
+	LockKubernetes = "kubernetes"
+	// LockFile is a file on storage shared by all replicas, eg. a ReadWriteMany volume
+	LockFile = "file"
+)
+
+// defaultLeaseDuration is the LeaseDuration of elections without one
+const defaultLeaseDuration = time.Minute
+
+// LeaderElection configures a lease, so that only one of several replicas of minima syncs while the
+// others stand by
+type LeaderElection struct {
+	// lock holding the lease, LockKubernetes or LockFile
+	Lock string
+	// name of the Lease object, minima by default
+	Name string
+	// namespace of the Lease object, the one of the pod by default
+	Namespace string
+	// path of the lock file
+	File string
+	// holder of the lease, the hostname and process ID by default
+	Identity string
+	// time after which a lease that is not renewed can be taken by another replica
+	LeaseDuration time.Duration `yaml:"lease_duration"`
+	// wait for the lease and then sync, rather than not syncing if another replica holds it
+	Standby bool
+}
+
+// ValidateLeaderElection checks the settings of a leader_election section
+func ValidateLeaderElection(election LeaderElection) error {
+	switch election.Lock {
+	case "", LockKubernetes:
+	case LockFile:
+		if election.File == "" {
+			return fmt.Errorf("file lock requires file")
+		}
+	default:
+		return fmt.Errorf("unsupported lock %s", election.Lock)
+	}
+	if election.LeaseDuration < 0 || election.LeaseDuration > 0 && election.LeaseDuration < time.Second {
+		return fmt.Errorf("lease_duration must be at least 1s")
+	}
+	return nil
+}
+
+// withDefaults returns the election with the defaults of unset settings
+func (e LeaderElection) withDefaults() (LeaderElection, error) {
+	if e.Name == "" {
+		e.Name = "minima"
+	}
+	if e.LeaseDuration == 0 {
+		e.LeaseDuration = defaultLeaseDuration
+	}
+	if e.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return e, err
+		}
+		e.Identity = fmt.Sprintf("%s_%d", hostname, os.Getpid())
+	}
+	return e, nil
+}
+
+// leaderLock holds the lease of an election
+type leaderLock interface {
+	// acquire takes the lease if it is free or expired, or renews it if held already, and reports whether
+	// it is held
+	acquire(ctx context.Context) (bool, error)
+	// release frees the lease if it is held
+	release(ctx context.Context) error
+}
+
+// newLeaderLock returns the lock of an election with defaults
+func newLeaderLock(election LeaderElection) (leaderLock, error) {
+	if election.Lock == LockFile {
+		return &fileLease{path: election.File, identity: election.Identity, duration: election.LeaseDuration}, nil
+	}
+	return newKubernetesLease(election)
+}
+
+// RunAsLeader calls run once the lease of election is held, after waiting for it with Standby, and reports
+// whether it was called. The lease is renewed until run returns and then released. The context of run is
+// canceled if the lease is lost, after which another replica may sync
+func RunAsLeader(ctx context.Context, election LeaderElection, logger *log.Logger, run func(ctx context.Context) error) (bool, error) {
+	election, err := election.withDefaults()
+	if err != nil {
+		return false, err
+	}
+	lock, err := newLeaderLock(election)
+	if err != nil {
+		return false, err
+	}
+	// the lease is renewed well before it expires, and checked as often while standing by
+	period := election.LeaseDuration / 3
+
+	for waiting := false; ; waiting = true {
+		held, err := lock.acquire(ctx)
+		if err != nil {
+			return false, fmt.Errorf("cannot acquire lease %s: %v", election.Name, err)
+		}
+		if held {
+			break
+		}
+		if !election.Standby {
+			logger.Printf("Lease %s is held by another replica, not syncing", election.Name)
+			return false, nil
+		}
+		if !waiting {
+			logger.Printf("Lease %s is held by another replica, standing by", election.Name)
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(period):
+		}
+	}
+	logger.Printf("Acquired lease %s as %s", election.Name, election.Identity)
+
+	leaderCtx, cancel := context.WithCancelCause(ctx)
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		renewed := time.Now()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			held, err := lock.acquire(leaderCtx)
+			switch {
+			case err == nil && held:
+				renewed = time.Now()
+			case err == nil:
+				cancel(fmt.Errorf("lease %s was taken by another replica", election.Name))
+				return
+			case time.Since(renewed) >= election.LeaseDuration:
+				// other replicas may take the expired lease from now on
+				cancel(fmt.Errorf("lease %s expired, renewal failed: %v", election.Name, err))
+				return
+			default:
+				logger.Printf("Cannot renew lease %s, retrying: %v", election.Name, err)
+			}
+		}
+	}()
+
+	err = run(leaderCtx)
+	close(stop)
+	<-stopped
+	lost := context.Cause(leaderCtx)
+	cancel(nil)
+	if lost != nil && ctx.Err() == nil {
+		return true, lost
+	}
+	releaseErr := lock.release(context.WithoutCancel(ctx))
+	if err != nil {
+		return true, err
+	}
+	if releaseErr != nil {
+		return true, fmt.Errorf("cannot release lease %s: %v", election.Name, releaseErr)
+	}
+	return true, nil
+}
+
+// fileLease is a leaderLock in a file holding a leaseRecord, replaced to renew it. It only relies on
+// exclusive creation and atomic renames, which network file systems provide
+type fileLease struct {
+	path     string
+	identity string
+	duration time.Duration
+}
+
+// leaseRecord is the content of the file of a fileLease
+type leaseRecord struct {
+	Holder string `json:"holder"`
+	// time of the last renewal, after which the lease is held for its duration
+	RenewTime            time.Time `json:"renew_time"`
+	LeaseDurationSeconds float64   `json:"lease_duration_seconds"`
+}
+
+// expired reports whether the lease of record can be taken at now
+func (r leaseRecord) expired(now time.Time) bool {
+	return now.Sub(r.RenewTime).Seconds() > r.LeaseDurationSeconds
+}
+
+func (l *fileLease) acquire(ctx context.Context) (bool, error) {
+	data, err := os.ReadFile(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l.create()
+	}
+	if err != nil {
+		return false, err
+	}
+	record := leaseRecord{}
+	err = json.Unmarshal(data, &record)
+	if err != nil {
+		return false, fmt.Errorf("invalid lock file %s: %v", l.path, err)
+	}
+	if record.Holder == l.identity {
+		return true, l.renew()
+	}
+	if !record.expired(time.Now()) {
+		return false, nil
+	}
+
+	// the expired file is claimed by renaming it, which succeeds for one replica only. A lease created
+	// meanwhile by another replica is put back
+	claimed := fmt.Sprintf("%s.%d.expired", l.path, time.Now().UnixNano())
+	err = os.Rename(l.path, claimed)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	claimedData, err := os.ReadFile(claimed)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(data, claimedData) {
+		return false, os.Rename(claimed, l.path)
+	}
+	err = os.Remove(claimed)
+	if err != nil {
+		return false, err
+	}
+	return l.create()
+}
+
+// create writes the file of a new lease, unless another replica created one first
+func (l *fileLease) create() (bool, error) {
+	data, err := l.record()
+	if err != nil {
+		return false, err
+	}
+	err = os.MkdirAll(filepath.Dir(l.path), 0755)
+	if err != nil {
+		return false, err
+	}
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	_, err = file.Write(data)
+	if err != nil {
+		file.Close()
+		return false, err
+	}
+	return true, file.Close()
+}
+
+// renew replaces the file of the lease with one renewed now
+func (l *fileLease) renew() error {
+	data, err := l.record()
+	if err != nil {
+		return err
+	}
+	temporary, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = temporary.Write(data)
+	if closeErr := temporary.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temporary.Name())
+		return err
+	}
+	return os.Rename(temporary.Name(), l.path)
+}
+
+// record returns the content of the file of the lease renewed now
+func (l *fileLease) record() ([]byte, error) {
+	return json.Marshal(leaseRecord{Holder: l.identity, RenewTime: time.Now().UTC(), LeaseDurationSeconds: l.duration.Seconds()})
+}
+
+func (l *fileLease) release(ctx context.Context) error {
+	data, err := os.ReadFile(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	record := leaseRecord{}
+	if json.Unmarshal(data, &record) != nil || record.Holder != l.identity {
+		return nil
+	}
+	return os.Remove(l.path)
+}
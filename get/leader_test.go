@@ -0,0 +1,258 @@
+package get
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateLeaderElection(t *testing.T) {
+	assert.NoError(t, ValidateLeaderElection(LeaderElection{}))
+	assert.NoError(t, ValidateLeaderElection(LeaderElection{Lock: LockKubernetes, LeaseDuration: time.Minute}))
+	assert.ErrorContains(t, ValidateLeaderElection(LeaderElection{Lock: "etcd"}), "unsupported lock etcd")
+	assert.ErrorContains(t, ValidateLeaderElection(LeaderElection{Lock: LockFile}), "file lock requires file")
+	assert.ErrorContains(t, ValidateLeaderElection(LeaderElection{Lock: LockKubernetes, LeaseDuration: time.Millisecond}), "lease_duration")
+}
+
+func TestFileLease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locks", "minima.lock")
+	first := &fileLease{path: path, identity: "first", duration: time.Minute}
+	second := &fileLease{path: path, identity: "second", duration: time.Minute}
+	ctx := context.Background()
+
+	held, err := first.acquire(ctx)
+	assert.NoError(t, err)
+	assert.True(t, held)
+	held, err = second.acquire(ctx)
+	assert.NoError(t, err)
+	assert.False(t, held)
+	// renewals
+	held, err = first.acquire(ctx)
+	assert.NoError(t, err)
+	assert.True(t, held)
+
+	// releases only free leases held
+	assert.NoError(t, second.release(ctx))
+	assert.FileExists(t, path)
+	assert.NoError(t, first.release(ctx))
+	assert.NoFileExists(t, path)
+	held, err = second.acquire(ctx)
+	assert.NoError(t, err)
+	assert.True(t, held)
+
+	// expired leases are taken over
+	data, err := json.Marshal(leaseRecord{Holder: "second", RenewTime: time.Now().Add(-2 * time.Minute), LeaseDurationSeconds: 60})
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(path, data, 0644))
+	held, err = first.acquire(ctx)
+	assert.NoError(t, err)
+	assert.True(t, held)
+	held, err = second.acquire(ctx)
+	assert.NoError(t, err)
+	assert.False(t, held)
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestRunAsLeader(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	path := filepath.Join(t.TempDir(), "minima.lock")
+	election := LeaderElection{Lock: LockFile, File: path, Identity: "first", LeaseDuration: 300 * time.Millisecond}
+	other := election
+	other.Identity = "second"
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	results := make(chan error, 1)
+	go func() {
+		led, err := RunAsLeader(context.Background(), election, logger, func(ctx context.Context) error {
+			close(started)
+			<-finish
+			return nil
+		})
+		assert.True(t, led)
+		results <- err
+	}()
+	<-started
+
+	// replicas do not sync while the lease is held, even after its duration as it is renewed
+	time.Sleep(election.LeaseDuration * 2)
+	led, err := RunAsLeader(context.Background(), other, logger, func(ctx context.Context) error {
+		t.Error("synced without lease")
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.False(t, led)
+
+	// or sync once it is released with standby
+	other.Standby = true
+	synced := false
+	go func() {
+		time.Sleep(election.LeaseDuration)
+		close(finish)
+	}()
+	led, err = RunAsLeader(context.Background(), other, logger, func(ctx context.Context) error {
+		synced = true
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, led)
+	assert.True(t, synced)
+	assert.NoError(t, <-results)
+	assert.NoFileExists(t, path)
+}
+
+func TestRunAsLeaderLost(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	path := filepath.Join(t.TempDir(), "minima.lock")
+	election := LeaderElection{Lock: LockFile, File: path, Identity: "first", LeaseDuration: 300 * time.Millisecond}
+
+	led, err := RunAsLeader(context.Background(), election, logger, func(ctx context.Context) error {
+		// another replica takes the lease
+		taken := &fileLease{path: path, identity: "second", duration: time.Minute}
+		assert.NoError(t, taken.renew())
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.True(t, led)
+	assert.ErrorContains(t, err, "lease minima was taken by another replica")
+	// and keeps it
+	assert.FileExists(t, path)
+}
+
+// fakeLeaseAPI serves the Lease objects of a namespace of the coordination.k8s.io/v1 API, checking
+// resourceVersions
+type fakeLeaseAPI struct {
+	mutex   sync.Mutex
+	leases  map[string]*kubernetesLeaseObject
+	version int
+}
+
+func (f *fakeLeaseAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	collection := "/apis/coordination.k8s.io/v1/namespaces/mirrors/leases"
+	if r.URL.Path == collection && r.Method == http.MethodGet {
+		list := kubernetesLeaseList{Items: []kubernetesLeaseObject{}}
+		label, value, _ := strings.Cut(r.URL.Query().Get("labelSelector"), "=")
+		for _, lease := range f.leases {
+			if lease.Metadata.Labels[label] == value {
+				list.Items = append(list.Items, *lease)
+			}
+		}
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, collection+"/")
+	existing := f.leases[name]
+	lease := &kubernetesLeaseObject{}
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		if err := json.NewDecoder(r.Body).Decode(lease); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	switch {
+	case r.Method == http.MethodGet && existing != nil:
+		json.NewEncoder(w).Encode(existing)
+		return
+	case r.Method == http.MethodDelete && existing != nil:
+		delete(f.leases, name)
+		return
+	case r.Method == http.MethodPost && r.URL.Path == collection:
+		if f.leases[lease.Metadata.Name] != nil {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+	case r.Method == http.MethodPut && existing != nil:
+		if lease.Metadata.ResourceVersion != existing.Metadata.ResourceVersion {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	f.version++
+	lease.Metadata.ResourceVersion = strconv.Itoa(f.version)
+	f.leases[lease.Metadata.Name] = lease
+	json.NewEncoder(w).Encode(lease)
+}
+
+// newFakeLeaseAPI returns a server of a fakeLeaseAPI and a service account token file for it
+func newFakeLeaseAPI(t *testing.T) (*fakeLeaseAPI, *httptest.Server, string) {
+	api := &fakeLeaseAPI{leases: map[string]*kubernetesLeaseObject{}}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("token\n"), 0600))
+	return api, server, tokenFile
+}
+
+func TestKubernetesLease(t *testing.T) {
+	api, server, tokenFile := newFakeLeaseAPI(t)
+	newLease := func(identity string) *kubernetesLease {
+		return &kubernetesLease{client: server.Client(), apiURL: server.URL, tokenFile: tokenFile, namespace: "mirrors", name: "minima", identity: identity, duration: time.Minute}
+	}
+	first, second := newLease("first"), newLease("second")
+	ctx := context.Background()
+
+	held, err := first.acquire(ctx)
+	assert.NoError(t, err)
+	assert.True(t, held)
+	assert.Equal(t, "first", api.leases["minima"].Spec.HolderIdentity)
+	assert.Equal(t, 60, api.leases["minima"].Spec.LeaseDurationSeconds)
+	held, err = second.acquire(ctx)
+	assert.NoError(t, err)
+	assert.False(t, held)
+	held, err = first.acquire(ctx)
+	assert.NoError(t, err)
+	assert.True(t, held)
+	assert.Equal(t, "2", api.leases["minima"].Metadata.ResourceVersion)
+
+	// released leases are free
+	assert.NoError(t, second.release(ctx))
+	assert.Equal(t, "first", api.leases["minima"].Spec.HolderIdentity)
+	assert.NoError(t, first.release(ctx))
+	assert.Equal(t, "", api.leases["minima"].Spec.HolderIdentity)
+	held, err = second.acquire(ctx)
+	assert.NoError(t, err)
+	assert.True(t, held)
+	assert.Equal(t, 1, api.leases["minima"].Spec.LeaseTransitions)
+
+	// as are expired ones
+	api.leases["minima"].Spec.RenewTime = time.Now().Add(-2 * time.Minute).UTC().Format(kubernetesMicroTime)
+	held, err = first.acquire(ctx)
+	assert.NoError(t, err)
+	assert.True(t, held)
+	assert.Equal(t, "first", api.leases["minima"].Spec.HolderIdentity)
+	assert.Equal(t, 2, api.leases["minima"].Spec.LeaseTransitions)
+
+	// other errors are reported
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("expired"), 0600))
+	_, err = first.acquire(ctx)
+	assert.ErrorContains(t, err, "lease mirrors/minima: 401 Unauthorized")
+}
+
+func TestNewKubernetesLease(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	_, err := newKubernetesLease(LeaderElection{Name: "minima"})
+	assert.ErrorContains(t, err, "requires running in a pod")
+}
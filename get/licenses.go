@@ -0,0 +1,60 @@
+package get
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// licenseOperators are the keywords of SPDX license expressions, which are not licenses themselves
+var licenseOperators = map[string]bool{"AND": true, "OR": true, "WITH": true}
+
+// ValidateLicensePatterns checks that patterns are valid for ExcludeLicenses
+func ValidateLicensePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// excludedLicense returns the license of a package if it matches any of ExcludeLicenses, or an empty string.
+// Patterns are matched against the whole License tag and each license of SPDX expressions, so that
+// SUSE-NonFree excludes "GPL-2.0-only AND SUSE-NonFree" too
+func (r *Syncer) excludedLicense(pack XMLPackage) string {
+	license := strings.TrimSpace(pack.License)
+	if len(r.ExcludeLicenses) == 0 || license == "" {
+		return ""
+	}
+	candidates := []string{license}
+	for _, field := range strings.FieldsFunc(license, func(c rune) bool { return c == ' ' || c == '(' || c == ')' }) {
+		if !licenseOperators[strings.ToUpper(field)] {
+			candidates = append(candidates, field)
+		}
+	}
+	for _, pattern := range r.ExcludeLicenses {
+		for _, candidate := range candidates {
+			if matched, _ := path.Match(pattern, candidate); matched {
+				return license
+			}
+		}
+	}
+	return ""
+}
+
+// reportExcludedLicenses logs the packages excluded by ExcludeLicenses in the last attempt
+func (r *Syncer) reportExcludedLicenses() {
+	if len(r.licenseExcluded) == 0 {
+		return
+	}
+	locations := []string{}
+	for location := range r.licenseExcluded {
+		locations = append(locations, location)
+	}
+	sort.Strings(locations)
+	r.logger().Printf("Excluded %d packages by license:\n", len(locations))
+	for _, location := range locations {
+		r.logger().Printf("  %s (%s)\n", location, r.licenseExcluded[location])
+	}
+}
@@ -0,0 +1,46 @@
+package get
+
+import (
+	"bytes"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExcludedLicense(t *testing.T) {
+	syncer := &Syncer{ExcludeLicenses: []string{"SUSE-NonFree", "*Proprietary*"}}
+	assert.Equal(t, "SUSE-NonFree", syncer.excludedLicense(XMLPackage{License: "SUSE-NonFree"}))
+	assert.Equal(t, "GPL-2.0-only AND (MIT OR SUSE-NonFree)", syncer.excludedLicense(XMLPackage{License: "GPL-2.0-only AND (MIT OR SUSE-NonFree)"}))
+	assert.Equal(t, "Vendor Proprietary License", syncer.excludedLicense(XMLPackage{License: "Vendor Proprietary License"}))
+	assert.Equal(t, "", syncer.excludedLicense(XMLPackage{License: "GPL-2.0-only"}))
+	assert.Equal(t, "", syncer.excludedLicense(XMLPackage{}))
+
+	assert.NoError(t, ValidateLicensePatterns([]string{"SUSE-*"}))
+	assert.Error(t, ValidateLicensePatterns([]string{"[SUSE"}))
+}
+
+func TestStoreRepoExcludeLicenses(t *testing.T) {
+	repoURL, err := url.Parse("http://localhost:8080/repo")
+	assert.NoError(t, err)
+	directory := t.TempDir()
+	output := &bytes.Buffer{}
+	syncer := NewSyncer(*repoURL, map[string]bool{"x86_64": true}, NewFileStorage(directory), true)
+	syncer.Logger = log.New(output, "", 0)
+	// all packages of testdata are GPL-2.0
+	syncer.ExcludeLicenses = []string{"GPL-*"}
+	assert.NoError(t, syncer.StoreRepo())
+
+	rpms, err := filepath.Glob(filepath.Join(directory, "*", "*.rpm"))
+	assert.NoError(t, err)
+	assert.Empty(t, rpms)
+	assert.Contains(t, output.String(), "Excluded 12 packages by license:\n  i586/hoag-dummy-1.1-2.1.i586.rpm (GPL-2.0)\n")
+
+	syncer.ExcludeLicenses = []string{"SUSE-NonFree"}
+	assert.NoError(t, syncer.StoreRepo())
+	_, err = os.Stat(filepath.Join(directory, "x86_64", "hoag-dummy-1.1-2.1.x86_64.rpm"))
+	assert.NoError(t, err)
+}
@@ -8,8 +8,11 @@ import (
 	"testing"
 )
 
-// TestMain starts an HTTP server on localhost:8080 for test use
+// TestMain starts an HTTP server on localhost:8080 serving testdata for test use
 func TestMain(m *testing.M) {
+	// Respond to http://localhost:8080/repo serving the content of the testdata/repo directory
+	http.Handle("/", http.FileServer(http.Dir("testdata")))
+
 	errs := make(chan error)
 	go func() {
 		listener, err := net.Listen("tcp", ":8080")
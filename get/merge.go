@@ -0,0 +1,317 @@
+package get
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// MergeRepoConfig defines the configuration of a repo combining several HTTP repos
+type MergeRepoConfig struct {
+	Name  string
+	URLs  []string
+	Archs []string
+	// labels to select the repo with, eg. in sync --tags
+	Tags []string
+	// names of the targets to write the repo to, default for the storage section
+	Targets   []string
+	SyncHooks `yaml:",inline"`
+	// accept MD5 and SHA-1 checksums despite min_checksum, for legacy vendor repos
+	AllowWeakChecksums bool `yaml:"allow_weak_checksums"`
+	// armored GPG public keys each RPM must be signed with
+	RPMKeys []string `yaml:"rpm_keys"`
+}
+
+// mergedTypes lists the repomd <data> types that are combined in merged repos, with the name of the
+// root tag children they list
+var mergedTypes = map[string]string{
+	"primary":       "package",
+	"filelists":     "package",
+	"filelists_ext": "package",
+	"other":         "package",
+	"susedata":      "package",
+	"updateinfo":    "update",
+}
+
+// Merger syncs several HTTP repos into a single Storage, writing unified metadata
+type Merger struct {
+	// Name of the merged repo
+	Name      string
+	upstreams []*Syncer
+	storage   Storage
+	quiet     bool
+	// SigningKey, if set, is used to sign the merged metadata
+	SigningKey *openpgp.Entity
+	// Logger receives progress messages, also of upstreams, the standard logger if nil
+	Logger *log.Logger
+	// Hooks run around StoreRepo with HookVariables in their environment
+	Hooks         SyncHooks
+	HookVariables map[string]string
+	// Events, if set, is called with the progress of StoreRepo
+	Events func(Event)
+	// MinChecksum, if set, is the weakest checksum type accepted in metadata of upstreams
+	MinChecksum string
+	// FIPS, SkipLegacy and OnlyPackages apply to upstreams, see Syncer
+	FIPS         bool
+	SkipLegacy   bool
+	OnlyPackages *PackageList
+	// PackageKeys, if set, must have signed each RPM of upstreams
+	PackageKeys openpgp.EntityList
+	// QuarantineDir, if set, receives downloads of upstreams failing verification
+	QuarantineDir string
+}
+
+// NewMerger creates a new Merger
+func NewMerger(name string, urls []url.URL, archs map[string]bool, storage Storage, quiet bool) *Merger {
+	upstreams := []*Syncer{}
+	for _, u := range urls {
+		upstreams = append(upstreams, NewSyncer(u, archs, storage, quiet))
+	}
+	return &Merger{Name: name, upstreams: upstreams, storage: storage, quiet: quiet}
+}
+
+// mergedPackage is a package selected for the merged repo, with the upstream to download it from
+type mergedPackage struct {
+	upstream *Syncer
+	pack     XMLPackage
+}
+
+// metadataFile is an upstream metadata file kept in memory until merged metadata is written
+type metadataFile struct {
+	content  []byte
+	compType string
+}
+
+// StoreRepo stores the merged repo in a Storage, automatically retrying in case of recoverable errors
+func (m *Merger) StoreRepo() error {
+	return m.StoreRepoContext(context.Background())
+}
+
+// StoreRepoContext is StoreRepo, stopping downloads and retries once ctx is done
+func (m *Merger) StoreRepoContext(ctx context.Context) error {
+	for _, upstream := range m.upstreams {
+		upstream.Logger = m.Logger
+		upstream.Events = m.Events
+		upstream.MinChecksum = m.MinChecksum
+		upstream.FIPS = m.FIPS
+		upstream.SkipLegacy = m.SkipLegacy
+		upstream.OnlyPackages = m.OnlyPackages
+		upstream.PackageKeys = m.PackageKeys
+		upstream.QuarantineDir = m.QuarantineDir
+	}
+	send := func(event Event) {
+		if m.Events != nil {
+			m.Events(event)
+		}
+	}
+
+	send(Event{Type: RepoStarted, Repo: m.Name})
+	err := storeWithHooks(ctx, m.Hooks, m.HookVariables, m.logger(), func() (int, error) {
+		checksumMap := m.upstreams[0].readChecksumMap()
+		err := retryTemporaryErrors(ctx, m.logger(), func() error {
+			err := m.storeRepo(ctx, checksumMap)
+			if verificationError(err) {
+				send(Event{Type: VerificationFailed, Repo: m.Name, Err: err})
+			}
+			return err
+		})
+		changed := 0
+		for _, upstream := range m.upstreams {
+			changed += upstream.changed
+		}
+		return changed, err
+	})
+	send(Event{Type: RepoFinished, Repo: m.Name, Err: err})
+	return err
+}
+
+// logger returns the Logger of the merger, or the standard logger
+func (m *Merger) logger() *log.Logger {
+	if m.Logger == nil {
+		return log.Default()
+	}
+	return m.Logger
+}
+
+func (m *Merger) storeRepo(ctx context.Context, checksumMap map[string]XMLChecksum) (err error) {
+	for _, upstream := range m.upstreams {
+		upstream.changed = 0
+	}
+	repoType := repoTypes["rpm"]
+	sources := map[string][]metadataFile{}
+	// types in order of first appearance, for a stable repomd.xml
+	types := []string{}
+	pkgids := map[string]bool{}
+	locations := map[string]XMLChecksum{}
+	var packagesToDownload, packagesToRecycle []mergedPackage
+
+	for _, upstream := range m.upstreams {
+		m.logger().Printf("Reading metadata from %s", upstream.URL.String())
+		b, err := upstream.downloadAll(ctx, repomdPath)
+		if err != nil {
+			return err
+		}
+		_, err = upstream.checkRepomdSignature(ctx, bytes.NewReader(b), repoType)
+		if err != nil {
+			return err
+		}
+		repomd, err := repoType.DecodeMetadata(bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range repomd.Data {
+			if _, ok := mergedTypes[entry.Type]; !ok {
+				if !m.quiet {
+					m.logger().Printf("Skipping %s, %s metadata cannot be merged", entry.Location.Href, entry.Type)
+				}
+				continue
+			}
+
+			content, err := upstream.downloadVerifiedAll(ctx, entry.Location.Href, entry.Checksum)
+			if err != nil {
+				return err
+			}
+			compType := strings.Trim(filepath.Ext(entry.Location.Href), ".")
+			if _, ok := sources[entry.Type]; !ok {
+				types = append(types, entry.Type)
+			}
+			sources[entry.Type] = append(sources[entry.Type], metadataFile{content, compType})
+
+			if entry.Type != repoType.PackagesType {
+				continue
+			}
+			primary, err := repoType.DecodePackages(bytes.NewReader(content), compType)
+			if err != nil {
+				return err
+			}
+			for _, pack := range primary.Packages {
+				if !upstream.wanted(pack, repoType) {
+					continue
+				}
+				err = upstream.checkChecksum(pack.Location.Href, pack.Checksum)
+				if err != nil {
+					return err
+				}
+				// the first upstream offering a file wins
+				if previous, ok := locations[pack.Location.Href]; ok {
+					if previous != pack.Checksum {
+						m.logger().Printf("Skipping %s from %s, a different file with the same name was already merged", pack.Location.Href, upstream.URL.String())
+					}
+					continue
+				}
+				locations[pack.Location.Href] = pack.Checksum
+				pkgids[pack.Checksum.Checksum] = true
+
+				switch upstream.decide(pack.Location.Href, pack.Checksum, checksumMap) {
+				case Download:
+					packagesToDownload = append(packagesToDownload, mergedPackage{upstream, pack})
+				case Recycle:
+					packagesToRecycle = append(packagesToRecycle, mergedPackage{upstream, pack})
+				}
+			}
+		}
+	}
+
+	if _, ok := sources[repoType.PackagesType]; !ok {
+		return fmt.Errorf("no primary metadata found in repos merged into %s", m.Name)
+	}
+
+	downloadCount := len(packagesToDownload)
+	m.logger().Printf("Downloading %v packages...\n", downloadCount)
+	for i, merged := range packagesToDownload {
+		err = merged.upstream.downloadPackage(ctx, merged.pack, i, downloadCount)
+		if err != nil {
+			return
+		}
+	}
+
+	recycleCount := len(packagesToRecycle)
+	m.logger().Printf("Recycling %v packages...\n", recycleCount)
+	for _, merged := range packagesToRecycle {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		err = m.storage.Recycle(merged.pack.Location.Href)
+		if err != nil {
+			return
+		}
+	}
+
+	m.logger().Println("Writing merged metadata...")
+	document := repomdDocument{Revision: strconv.FormatInt(time.Now().Unix(), 10)}
+	for _, dataType := range types {
+		data, err := m.writeMergedMetadata(dataType, sources[dataType], pkgids)
+		if err != nil {
+			return err
+		}
+		document.Data = append(document.Data, data)
+	}
+
+	b, err := document.marshal()
+	if err != nil {
+		return
+	}
+	err = storeBytes(m.storage, repomdPath, b)
+	if err != nil {
+		return
+	}
+	if m.SigningKey != nil {
+		signatureFiles, err := signMetadata(m.SigningKey, b, repoType)
+		if err != nil {
+			return err
+		}
+		for filename, content := range signatureFiles {
+			err = storeBytes(m.storage, filename, content)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	m.logger().Println("Committing changes...")
+	return m.storage.Commit()
+}
+
+// writeMergedMetadata combines upstream metadata files of one type into a stored one, returning its repomd entry
+func (m *Merger) writeMergedMetadata(dataType string, files []metadataFile, pkgids map[string]bool) (repomdData, error) {
+	sources := []io.Reader{}
+	for _, file := range files {
+		uncompressed, err := decompress(bytes.NewReader(file.content), file.compType)
+		if err != nil {
+			return repomdData{}, err
+		}
+		defer uncompressed.Close()
+		sources = append(sources, uncompressed)
+	}
+
+	element := mergedTypes[dataType]
+	keep := keepPackages(pkgids)
+	count := len(pkgids)
+	if element != "package" {
+		// advisories listed in more than one upstream are kept once
+		ids := map[string]bool{}
+		keep = func(e *metadataElement) bool {
+			if ids[e.ID] {
+				return false
+			}
+			ids[e.ID] = true
+			return true
+		}
+		count = -1
+	}
+
+	return storeMetadata(m.storage, path.Dir(repomdPath), dataType, files[0].compType, func(writer io.Writer) error {
+		return writeMetadata(writer, sources, element, keep, count)
+	})
+}
@@ -0,0 +1,82 @@
+package get
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uyuni-project/minima/util"
+)
+
+func TestMergerStoreRepo(t *testing.T) {
+	directory := filepath.Join(os.TempDir(), "merger_test")
+	err := os.RemoveAll(directory)
+	if err != nil {
+		t.Error(err)
+	}
+
+	urls := []url.URL{}
+	for _, u := range []string{"http://localhost:8080/repo", "http://localhost:8080/zstrepo"} {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls = append(urls, *parsed)
+	}
+	storage := NewFileStorage(directory)
+	merger := NewMerger("merged", urls, map[string]bool{"x86_64": true}, storage, true)
+
+	// first sync
+	err = merger.StoreRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repomd, err := os.ReadFile(filepath.Join(directory, "repodata", "repomd.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	document, err := parseRepomd(repomd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	types := []string{}
+	for _, data := range document.Data {
+		types = append(types, data.Type)
+
+		reader, err := storage.NewReader(data.Location.Href, Permanent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checksum, err := util.Checksum(reader, hashMap[data.Checksum.Type])
+		reader.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, data.Checksum.Checksum, checksum, data.Type)
+
+		if data.Type == "primary" {
+			reader, err := storage.NewReader(data.Location.Href, Permanent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			primary, err := readMetaData(reader, "gz")
+			reader.Close()
+			assert.NoError(t, err)
+			// packages in both repos are merged once
+			assert.Len(t, primary.Packages, 12)
+			for _, pack := range primary.Packages {
+				_, err := os.Stat(filepath.Join(directory, pack.Location.Href))
+				assert.NoError(t, err)
+			}
+		}
+	}
+	assert.Equal(t, []string{"filelists", "updateinfo", "other", "primary"}, types)
+
+	// second sync
+	err = merger.StoreRepo()
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,243 @@
+package get
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// metadataElement maps a child of the root tag in primary, filelists, other, susedata or updateinfo XML,
+// keeping its content verbatim
+type metadataElement struct {
+	Attrs    []xml.Attr  `xml:",any,attr"`
+	Checksum XMLChecksum `xml:"checksum"`
+	ID       string      `xml:"id"`
+	Content  []byte      `xml:",innerxml"`
+}
+
+// pkgid returns the package identifier of a <package> tag, which is an attribute in all files but primary
+func (e *metadataElement) pkgid() string {
+	for _, attr := range e.Attrs {
+		if attr.Name.Local == "pkgid" {
+			return attr.Value
+		}
+	}
+	return e.Checksum.Checksum
+}
+
+// keepPackages returns a function keeping, once each, the <package> tags with the given pkgids
+func keepPackages(pkgids map[string]bool) func(*metadataElement) bool {
+	written := map[string]bool{}
+	return func(element *metadataElement) bool {
+		pkgid := element.pkgid()
+		if !pkgids[pkgid] || written[pkgid] {
+			return false
+		}
+		written[pkgid] = true
+		return true
+	}
+}
+
+// writeMetadata writes an uncompressed XML metadata file combining the root tag children named element
+// from all the uncompressed sources, if keep returns true for them. If count is not negative, it is written as
+// the new number of packages in the file
+func writeMetadata(writer io.Writer, sources []io.Reader, element string, keep func(*metadataElement) bool, count int) error {
+	// the root tag is written first, declaring all namespaces declared in any source
+	decoders := []*xml.Decoder{}
+	var root *xml.StartElement
+	for _, source := range sources {
+		decoder := xml.NewDecoder(source)
+		start, err := nextStartElement(decoder)
+		if err == io.EOF {
+			return errors.New("no root element in metadata file")
+		}
+		if err != nil {
+			return err
+		}
+		decoders = append(decoders, decoder)
+
+		if root == nil {
+			root = &start
+			continue
+		}
+		for _, attr := range start.Attr {
+			if !hasAttr(*root, attr.Name) {
+				root.Attr = append(root.Attr, attr)
+			}
+		}
+	}
+	if root == nil {
+		return errors.New("no metadata files to write")
+	}
+
+	err := writeStartElement(writer, xml.Header, *root, func(attr xml.Attr) xml.Attr {
+		if attr.Name.Local == "packages" && count >= 0 {
+			attr.Value = strconv.Itoa(count)
+		}
+		return attr
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, decoder := range decoders {
+		for {
+			start, err := nextStartElement(decoder)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			if start.Name.Local != element {
+				err = decoder.Skip()
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			var child metadataElement
+			err = decoder.DecodeElement(&child, &start)
+			if err != nil {
+				return err
+			}
+			if !keep(&child) {
+				continue
+			}
+			err = writeStartElement(writer, "\n", start, nil)
+			if err != nil {
+				return err
+			}
+			_, err = writer.Write(child.Content)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(writer, "</"+element+">")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = io.WriteString(writer, "\n</"+root.Name.Local+">\n")
+	return err
+}
+
+// nextStartElement returns the next start tag read by decoder, or io.EOF
+func nextStartElement(decoder *xml.Decoder) (xml.StartElement, error) {
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start, nil
+		}
+	}
+}
+
+func hasAttr(start xml.StartElement, name xml.Name) bool {
+	for _, attr := range start.Attr {
+		if attr.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// writeStartElement writes a start tag preceded by prefix, restoring namespace declarations resolved by the
+// decoder and optionally mapping attributes
+func writeStartElement(writer io.Writer, prefix string, start xml.StartElement, mapAttr func(xml.Attr) xml.Attr) error {
+	var buf bytes.Buffer
+	buf.WriteString(prefix)
+	buf.WriteString("<" + start.Name.Local)
+	for _, attr := range start.Attr {
+		if mapAttr != nil {
+			attr = mapAttr(attr)
+		}
+		buf.WriteByte(' ')
+		if attr.Name.Space == "xmlns" {
+			buf.WriteString("xmlns:")
+		}
+		buf.WriteString(attr.Name.Local + `="`)
+		xml.EscapeText(&buf, []byte(attr.Value))
+		buf.WriteByte('"')
+	}
+	buf.WriteByte('>')
+	_, err := writer.Write(buf.Bytes())
+	return err
+}
+
+// storeMetadata stores the uncompressed output of write as a metadata file of dataType compressed with
+// compType in the dir directory of storage, named after its checksum. It returns the file's repomd entry
+func storeMetadata(storage Storage, dir string, dataType string, compType string, write func(io.Writer) error) (data repomdData, err error) {
+	var compressed bytes.Buffer
+	compressor, err := compress(&compressed, compType)
+	if err != nil {
+		return
+	}
+	openHash := sha256.New()
+	open := &countingWriter{writer: io.MultiWriter(compressor, openHash)}
+	err = write(open)
+	if err != nil {
+		return
+	}
+	err = compressor.Close()
+	if err != nil {
+		return
+	}
+
+	sum := sha256.Sum256(compressed.Bytes())
+	checksum := hex.EncodeToString(sum[:])
+	location := path.Join(dir, checksum+"-"+dataType+".xml."+compType)
+	err = storeBytes(storage, location, compressed.Bytes())
+	if err != nil {
+		return
+	}
+
+	data = repomdData{
+		Type:         dataType,
+		Checksum:     repomdChecksum{Type: "sha256", Checksum: checksum},
+		OpenChecksum: &repomdChecksum{Type: "sha256", Checksum: hex.EncodeToString(openHash.Sum(nil))},
+		Location:     XMLLocation{Href: location},
+		Timestamp:    time.Now().Unix(),
+		Size:         int64(compressed.Len()),
+		OpenSize:     open.count,
+	}
+	return
+}
+
+// compress returns a Writer compressing data with compType to writer
+func compress(writer io.Writer, compType string) (io.WriteCloser, error) {
+	switch compType {
+	case "gz":
+		return gzip.NewWriter(writer), nil
+	case "zst":
+		return zstd.NewWriter(writer)
+	default:
+		return nil, errors.New("unsupported compression type")
+	}
+}
+
+// countingWriter counts bytes written to the wrapped Writer
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (w *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = w.writer.Write(p)
+	w.count += int64(n)
+	return
+}
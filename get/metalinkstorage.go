@@ -0,0 +1,181 @@
+package get
+
+import (
+	"crypto"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/uyuni-project/minima/util"
+)
+
+// metalinkFile and mirrorlistFile let clients fail over between the mirrors a repo is published at
+const (
+	metalinkFile   = "metalink.xml"
+	mirrorlistFile = "mirrorlist"
+)
+
+// MetalinkStorage wraps a Storage to store a metalinkFile and a mirrorlistFile in every repo at Commit,
+// listing the URLs of the repo on all mirrors. The metalink, for rpm repos only, also has the size and
+// checksums of repodata/repomd.xml so that clients detect outdated mirrors
+type MetalinkStorage struct {
+	Storage
+	// URLs of the repo on each mirror, by decreasing preference
+	urls []string
+	// hashes of repomd.xml, once stored or recycled
+	repomdSize   int64
+	repomdHashes map[string]string
+	// FIPS leaves hashes not approved in FIPS mode out of metalinks
+	FIPS bool
+}
+
+// NewMetalinkStorage returns a Storage adding metalink and mirrorlist files to storage, for the repo at
+// repoPath below each of the mirrors base URLs
+func NewMetalinkStorage(storage Storage, mirrors []string, repoPath string) (*MetalinkStorage, error) {
+	urls := []string{}
+	for _, mirror := range mirrors {
+		u, err := url.Parse(mirror)
+		if err != nil {
+			return nil, err
+		}
+		u.Path = path.Join("/", u.Path, repoPath) + "/"
+		urls = append(urls, u.String())
+	}
+	return &MetalinkStorage{Storage: storage, urls: urls}, nil
+}
+
+// metalinkHashes returns the hashes listed in metalinks, by name. MD5 and SHA-1 are left out in FIPS mode
+// with fips
+func metalinkHashes(fips bool) map[string]hash.Hash {
+	if fipsMode(fips) {
+		return map[string]hash.Hash{"sha256": sha256.New(), "sha512": sha512.New()}
+	}
+	return map[string]hash.Hash{"md5": md5.New(), "sha1": sha1.New(), "sha256": sha256.New(), "sha512": sha512.New()}
+}
+
+// repomdRecorder records the size and hashes of repomd.xml when closed
+type repomdRecorder struct {
+	storage *MetalinkStorage
+	hashes  map[string]hash.Hash
+	size    int64
+}
+
+func (r *repomdRecorder) Write(p []byte) (int, error) {
+	for _, h := range r.hashes {
+		h.Write(p)
+	}
+	r.size += int64(len(p))
+	return len(p), nil
+}
+
+func (r *repomdRecorder) Close() error {
+	r.storage.repomdSize = r.size
+	r.storage.repomdHashes = map[string]string{}
+	for name, h := range r.hashes {
+		r.storage.repomdHashes[name] = hex.EncodeToString(h.Sum(nil))
+	}
+	return nil
+}
+
+// StoringMapper returns a mapper that will store read data to a temporary location specified by filename,
+// recording the hashes of repomd.xml
+func (s *MetalinkStorage) StoringMapper(filename string, checksum string, hash crypto.Hash) util.ReaderMapper {
+	mapper := s.Storage.StoringMapper(filename, checksum, hash)
+	if filename != repomdPath {
+		return mapper
+	}
+	return func(reader io.ReadCloser) (io.ReadCloser, error) {
+		stored, err := mapper(reader)
+		if err != nil {
+			return nil, err
+		}
+		return util.NewTeeReadCloser(stored, &repomdRecorder{s, metalinkHashes(s.FIPS), 0}), nil
+	}
+}
+
+// Recycle will copy a file from the permanent to the temporary location, hashing repomd.xml
+func (s *MetalinkStorage) Recycle(filename string) error {
+	err := s.Storage.Recycle(filename)
+	if err != nil || filename != repomdPath {
+		return err
+	}
+	reader, err := s.Storage.NewReader(filename, Temporary)
+	if err != nil {
+		return err
+	}
+	recorder := &repomdRecorder{s, metalinkHashes(s.FIPS), 0}
+	_, err = io.Copy(recorder, reader)
+	reader.Close()
+	if err != nil {
+		return err
+	}
+	return recorder.Close()
+}
+
+// Commit stores the metalinkFile and mirrorlistFile, then commits the wrapped Storage
+func (s *MetalinkStorage) Commit() error {
+	err := storeBytes(s.Storage, mirrorlistFile, []byte(strings.Join(s.urls, "\n")+"\n"))
+	if err != nil {
+		return err
+	}
+	if s.repomdHashes != nil {
+		err = storeBytes(s.Storage, metalinkFile, s.metalink(time.Now()))
+		if err != nil {
+			return err
+		}
+	}
+	s.repomdHashes = nil
+	return s.Storage.Commit()
+}
+
+// metalink returns a metalink for repomd.xml in the format of MirrorManager, as expected by dnf and zypper
+func (s *MetalinkStorage) metalink(now time.Time) []byte {
+	escape := func(text string) string {
+		escaped := &strings.Builder{}
+		xml.EscapeText(escaped, []byte(text))
+		return escaped.String()
+	}
+
+	metalink := &strings.Builder{}
+	fmt.Fprintf(metalink, `<?xml version="1.0" encoding="utf-8"?>
+<metalink version="3.0" xmlns="http://www.metalinker.org/" type="dynamic" pubdate="%s" generator="minima" xmlns:mm0="http://fedorahosted.org/mirrormanager">
+  <files>
+    <file name="repomd.xml">
+      <mm0:timestamp>%d</mm0:timestamp>
+      <size>%d</size>
+      <verification>
+`, now.UTC().Format(time.RFC1123), now.Unix(), s.repomdSize)
+	for _, name := range []string{"md5", "sha1", "sha256", "sha512"} {
+		if _, ok := s.repomdHashes[name]; !ok {
+			continue
+		}
+		fmt.Fprintf(metalink, "        <hash type=\"%s\">%s</hash>\n", name, s.repomdHashes[name])
+	}
+	fmt.Fprintf(metalink, "      </verification>\n      <resources maxconnections=\"1\">\n")
+	for i, repoURL := range s.urls {
+		protocol := strings.SplitN(repoURL, ":", 2)[0]
+		fmt.Fprintf(metalink, "        <url protocol=\"%s\" type=\"%s\" preference=\"%d\">%s</url>\n", protocol, protocol, 100-i, escape(repoURL+repomdPath))
+	}
+	fmt.Fprintf(metalink, "      </resources>\n    </file>\n  </files>\n</metalink>\n")
+	return []byte(metalink.String())
+}
+
+// SetModTime sets the modification time of the file stored next at filename, if the wrapped Storage keeps them
+func (s *MetalinkStorage) SetModTime(filename string, modTime time.Time) {
+	setModTime(s.Storage, filename, modTime)
+}
+
+// CaseInsensitive reports whether the wrapped Storage ignores case in filenames
+func (s *MetalinkStorage) CaseInsensitive() (bool, error) {
+	return caseInsensitive(s.Storage)
+}
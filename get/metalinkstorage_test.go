@@ -0,0 +1,48 @@
+package get
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetalinkStorage(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewStorage(StorageConfig{
+		Type:       "file",
+		Path:       dir,
+		SHA256Sums: true,
+		Mirrors:    []string{"https://mirror1.example.com", "http://mirror2.example.com/minima/"},
+	}, StorageRepo{Path: "SLES/15"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, storeBytes(storage, "repodata/repomd.xml", []byte("repomd")))
+	assert.NoError(t, storeBytes(storage, "Packages/a.rpm", []byte("a")))
+	assert.NoError(t, storage.Commit())
+
+	content, err := os.ReadFile(filepath.Join(dir, "SLES", "15", "mirrorlist"))
+	assert.NoError(t, err)
+	assert.Equal(t, "https://mirror1.example.com/SLES/15/\nhttp://mirror2.example.com/minima/SLES/15/\n", string(content))
+	content, err = os.ReadFile(filepath.Join(dir, "SLES", "15", "SHA256SUMS"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "  metalink.xml\n")
+	assert.Contains(t, string(content), "  mirrorlist\n")
+
+	content, err = os.ReadFile(filepath.Join(dir, "SLES", "15", "metalink.xml"))
+	assert.NoError(t, err)
+	metalink := string(content)
+	assert.Contains(t, metalink, "<size>6</size>")
+	assert.Contains(t, metalink, `<hash type="sha256">d0df476353bf7bd128a22e414a59d0baebd1fb57d11d6a197ad839227e02dad0</hash>`)
+	assert.Contains(t, metalink, `<url protocol="https" type="https" preference="100">https://mirror1.example.com/SLES/15/repodata/repomd.xml</url>`)
+	assert.Contains(t, metalink, `<url protocol="http" type="http" preference="99">http://mirror2.example.com/minima/SLES/15/repodata/repomd.xml</url>`)
+
+	// recycled repomd.xml files are hashed again
+	assert.NoError(t, storage.Recycle("repodata/repomd.xml"))
+	assert.NoError(t, storage.Recycle("Packages/a.rpm"))
+	assert.NoError(t, storage.Commit())
+	content, err = os.ReadFile(filepath.Join(dir, "SLES", "15", "metalink.xml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `<hash type="md5">`)
+}
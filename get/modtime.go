@@ -0,0 +1,43 @@
+package get
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// modTimeStorage is implemented by Storages keeping the upstream modification times of stored files
+type modTimeStorage interface {
+	// SetModTime sets the modification time of the file stored next at filename, a zero one unsets it
+	SetModTime(filename string, modTime time.Time)
+}
+
+// setModTime sets the modification time of the file stored next at filename, if storage keeps them
+func setModTime(storage Storage, filename string, modTime time.Time) {
+	if storage, ok := storage.(modTimeStorage); ok {
+		storage.SetModTime(filename, modTime)
+	}
+}
+
+// modifiedBody is the body of a response with a Last-Modified header
+type modifiedBody struct {
+	io.ReadCloser
+	modTime time.Time
+}
+
+// newModifiedBody returns the body of response, with its Last-Modified time if valid
+func newModifiedBody(response *http.Response) io.ReadCloser {
+	modTime, err := http.ParseTime(response.Header.Get("Last-Modified"))
+	if err != nil {
+		return response.Body
+	}
+	return &modifiedBody{response.Body, modTime}
+}
+
+// lastModified returns the Last-Modified time of a body returned by readURL, zero if unknown
+func lastModified(body io.Reader) time.Time {
+	if body, ok := body.(*modifiedBody); ok {
+		return body.modTime
+	}
+	return time.Time{}
+}
@@ -0,0 +1,36 @@
+package get
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreRepoModTimes(t *testing.T) {
+	directory := filepath.Join(t.TempDir(), "repo")
+	repoURL, err := url.Parse("http://localhost:8080/repo")
+	assert.NoError(t, err)
+	syncer := NewSyncer(*repoURL, map[string]bool{"x86_64": true}, NewFileStorage(directory), true)
+	assert.NoError(t, syncer.StoreRepo())
+
+	// the test server sends the modification times of testdata as Last-Modified, with a resolution of seconds
+	for _, file := range []string{"repodata/repomd.xml", "noarch/andromeda-dummy-2.0-1.1.noarch.rpm"} {
+		upstream, err := os.Stat(filepath.Join("testdata", "repo", filepath.FromSlash(file)))
+		assert.NoError(t, err)
+		stored, err := os.Stat(filepath.Join(directory, filepath.FromSlash(file)))
+		assert.NoError(t, err)
+		assert.Equal(t, upstream.ModTime().Truncate(time.Second), stored.ModTime(), file)
+	}
+}
+
+func TestS3StorageModTimeMetadata(t *testing.T) {
+	storage := &S3Storage{}
+	storage.SetModTime("x86_64/a.rpm", time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	metadata := storage.objectMetadata("", 0, storage.modTimes["x86_64/a.rpm"])
+	assert.Equal(t, "Wed, 01 May 2024 12:00:00 GMT", *metadata["minima-last-modified"])
+	assert.NotContains(t, storage.objectMetadata("", 0, time.Time{}), "minima-last-modified")
+}
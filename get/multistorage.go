@@ -0,0 +1,112 @@
+package get
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/uyuni-project/minima/util"
+)
+
+// StorageTarget is one of the storages a repo is written to
+type StorageTarget struct {
+	Name    string
+	Storage Storage
+	// Err is the result of the last Commit to this target
+	Err error
+}
+
+// MultiStorage writes a repo to several storages in one pass. Files are read from the first target,
+// which decides what is downloaded or recycled
+type MultiStorage struct {
+	Targets []*StorageTarget
+	// Logger gets the result of each Commit, the standard logger if nil
+	Logger *log.Logger
+}
+
+// NewMultiStorage returns a Storage writing to all targets
+func NewMultiStorage(targets []*StorageTarget) *MultiStorage {
+	return &MultiStorage{Targets: targets}
+}
+
+// NewReader returns a Reader for a file in a location of the first target
+func (s *MultiStorage) NewReader(filename string, location Location) (io.ReadCloser, error) {
+	return s.Targets[0].Storage.NewReader(filename, location)
+}
+
+// StoringMapper returns a mapper that will store read data to the temporary location of all targets
+func (s *MultiStorage) StoringMapper(filename string, checksum string, hash crypto.Hash) util.ReaderMapper {
+	return func(reader io.ReadCloser) (result io.ReadCloser, err error) {
+		result = reader
+		for _, target := range s.Targets {
+			mapped, err := target.Storage.StoringMapper(filename, checksum, hash)(result)
+			if err != nil {
+				result.Close()
+				return nil, err
+			}
+			result = mapped
+		}
+		return
+	}
+}
+
+// Recycle will copy a file from the permanent to the temporary location of all targets. Targets
+// missing the file, eg. added after the first sync, get it from the first target instead
+func (s *MultiStorage) Recycle(filename string) error {
+	first := s.Targets[0].Storage
+	err := first.Recycle(filename)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range s.Targets[1:] {
+		if target.Storage.Recycle(filename) == nil {
+			continue
+		}
+		reader, err := first.NewReader(filename, Temporary)
+		if err != nil {
+			return err
+		}
+		err = util.Compose(target.Storage.StoringMapper(filename, "", 0), util.Nop)(reader)
+		if err != nil {
+			return fmt.Errorf("cannot copy %s to target %s: %v", filename, target.Name, err)
+		}
+	}
+	return nil
+}
+
+// Commit commits all targets, even if some fail, logging and recording the result of each
+func (s *MultiStorage) Commit() error {
+	errs := []error{}
+	for _, target := range s.Targets {
+		target.Err = target.Storage.Commit()
+		if target.Err != nil {
+			loggerOrDefault(s.Logger).Printf("Commit to target %s failed: %v\n", target.Name, target.Err)
+			errs = append(errs, fmt.Errorf("target %s: %v", target.Name, target.Err))
+		} else {
+			loggerOrDefault(s.Logger).Printf("Committed to target %s\n", target.Name)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SetModTime sets the modification time of the file stored next at filename in the targets keeping them
+func (s *MultiStorage) SetModTime(filename string, modTime time.Time) {
+	for _, target := range s.Targets {
+		setModTime(target.Storage, filename, modTime)
+	}
+}
+
+// CaseInsensitive reports whether any of the targets ignores case in filenames
+func (s *MultiStorage) CaseInsensitive() (bool, error) {
+	for _, target := range s.Targets {
+		insensitive, err := caseInsensitive(target.Storage)
+		if err != nil || insensitive {
+			return insensitive, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,62 @@
+package get
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingStorage is a Storage failing to commit
+type failingStorage struct {
+	Storage
+}
+
+func (s *failingStorage) Commit() error {
+	return errors.New("disk full")
+}
+
+func TestMultiStorage(t *testing.T) {
+	dir := t.TempDir()
+	local := filepath.Join(dir, "local", "repo")
+	assert.NoError(t, os.MkdirAll(filepath.Join(local, "Packages"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(local, "Packages", "recycled.rpm"), []byte("recycled"), 0644))
+
+	targets := []*StorageTarget{
+		{Name: "local", Storage: NewFileStorage(local)},
+		// added after the first sync, so it misses recycled files
+		{Name: "backup", Storage: NewFileStorage(filepath.Join(dir, "backup", "repo"))},
+		{Name: "broken", Storage: &failingStorage{NewFileStorage(filepath.Join(dir, "broken", "repo"))}},
+	}
+	storage := NewMultiStorage(targets)
+	output := &bytes.Buffer{}
+	storage.Logger = log.New(output, "", 0)
+
+	assert.NoError(t, storeBytes(storage, "Packages/new.rpm", []byte("new")))
+	assert.NoError(t, storage.Recycle("Packages/recycled.rpm"))
+	reader, err := storage.NewReader("Packages/recycled.rpm", Permanent)
+	assert.NoError(t, err)
+	reader.Close()
+
+	err = storage.Commit()
+	assert.EqualError(t, err, "target broken: disk full")
+	assert.NoError(t, targets[0].Err)
+	assert.NoError(t, targets[1].Err)
+	assert.EqualError(t, targets[2].Err, "disk full")
+	assert.Equal(t, "Committed to target local\nCommitted to target backup\nCommit to target broken failed: disk full\n", output.String())
+
+	for _, target := range []string{"local", "backup"} {
+		for filename, expected := range map[string]string{"new.rpm": "new", "recycled.rpm": "recycled"} {
+			f, err := os.Open(filepath.Join(dir, target, "repo", "Packages", filename))
+			assert.NoError(t, err)
+			content, _ := io.ReadAll(f)
+			f.Close()
+			assert.Equal(t, expected, string(content))
+		}
+	}
+}
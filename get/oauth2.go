@@ -0,0 +1,167 @@
+package get
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OAuth2Config defines the client credentials an HTTP repo fronted by an OAuth 2.0 gateway is accessed
+// with: access tokens are requested from the token endpoint with the client credentials grant (RFC 6749)
+type OAuth2Config struct {
+	TokenURL     string `yaml:"token_url"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// file containing the client secret, eg. a mounted Kubernetes secret, instead of ClientSecret
+	ClientSecretFile string `yaml:"client_secret_file"`
+	Scopes           []string
+}
+
+// ValidateOAuth2 checks that config, if set, has a token endpoint and client credentials
+func ValidateOAuth2(config *OAuth2Config) error {
+	if config == nil {
+		return nil
+	}
+	tokenURL, err := url.Parse(config.TokenURL)
+	if err != nil || (tokenURL.Scheme != "https" && tokenURL.Scheme != "http") || tokenURL.Host == "" {
+		return fmt.Errorf("token_url must be an http or https URL")
+	}
+	if config.ClientID == "" {
+		return fmt.Errorf("client_id is required")
+	}
+	if (config.ClientSecret == "") == (config.ClientSecretFile == "") {
+		return fmt.Errorf("one of client_secret or client_secret_file is required")
+	}
+	return nil
+}
+
+// oauth2ExpiryDelta is how long before they expire access tokens are refreshed, at most
+const oauth2ExpiryDelta = time.Minute
+
+// oauth2Transport adds access tokens to the requests of a repo, getting a new one when the current one
+// is about to expire or is refused, so that syncs outlasting tokens go on
+type oauth2Transport struct {
+	base   http.RoundTripper
+	config OAuth2Config
+	secret string
+	// host of the repo: tokens are not sent to others, eg. CDNs requests are redirected to
+	host string
+
+	sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// newOAuth2Transport returns a transport adding tokens got with config to requests for host, sent with base
+func newOAuth2Transport(base http.RoundTripper, config OAuth2Config, host string) (*oauth2Transport, error) {
+	secret := config.ClientSecret
+	if config.ClientSecretFile != "" {
+		content, err := os.ReadFile(config.ClientSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read OAuth2 client secret: %v", err)
+		}
+		secret = strings.TrimSpace(string(content))
+	}
+	return &oauth2Transport{base: base, config: config, secret: secret, host: host}, nil
+}
+
+func (t *oauth2Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host {
+		return t.base.RoundTrip(req)
+	}
+	token, err := t.accessToken(req.Context(), "")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(withBearer(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || (req.Body != nil && req.GetBody == nil) {
+		return resp, err
+	}
+
+	// the token was revoked or expired early, it is refreshed once
+	resp.Body.Close()
+	token, err = t.accessToken(req.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+	retry := withBearer(req, token)
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t.base.RoundTrip(retry)
+}
+
+// withBearer returns a copy of req authenticated with token, as RoundTrippers must not modify requests
+func withBearer(req *http.Request, token string) *http.Request {
+	clone := req.Clone(req.Context())
+	clone.Header.Set("Authorization", "Bearer "+token)
+	return clone
+}
+
+// accessToken returns the current access token, or a new one if it is about to expire or is refused
+func (t *oauth2Transport) accessToken(ctx context.Context, refused string) (string, error) {
+	t.Lock()
+	defer t.Unlock()
+	// tokens refused are only requested again if no other request did meanwhile
+	if t.token != "" && t.token != refused && (t.expires.IsZero() || time.Now().Before(t.expires)) {
+		return t.token, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(t.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(t.config.Scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// client credentials are form-encoded in basic authentication, see RFC 6749 section 2.3.1
+	req.SetBasicAuth(url.QueryEscape(t.config.ClientID), url.QueryEscape(t.secret))
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		// some servers send a string
+		ExpiresIn        json.Number `json:"expires_in"`
+		Error            string
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token)
+	if resp.StatusCode != http.StatusOK {
+		if err == nil && token.Error != "" {
+			return "", fmt.Errorf("cannot get OAuth2 token from %s: %s %s", t.config.TokenURL, token.Error, token.ErrorDescription)
+		}
+		return "", &UnexpectedStatusCodeError{t.config.TokenURL, resp.StatusCode}
+	}
+	if err != nil || token.AccessToken == "" {
+		return "", fmt.Errorf("invalid OAuth2 token response from %s", t.config.TokenURL)
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return "", fmt.Errorf("unsupported OAuth2 token type %s from %s", token.TokenType, t.config.TokenURL)
+	}
+
+	t.token = token.AccessToken
+	t.expires = time.Time{}
+	if seconds, err := token.ExpiresIn.Int64(); err == nil && seconds > 0 {
+		lifetime := time.Duration(seconds) * time.Second
+		t.expires = time.Now().Add(lifetime - min(oauth2ExpiryDelta, lifetime/2))
+	}
+	return t.token, nil
+}
@@ -0,0 +1,111 @@
+package get
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateOAuth2(t *testing.T) {
+	assert.NoError(t, ValidateOAuth2(nil))
+	assert.NoError(t, ValidateOAuth2(&OAuth2Config{TokenURL: "https://login.example.com/token", ClientID: "minima", ClientSecret: "secret"}))
+	assert.NoError(t, ValidateOAuth2(&OAuth2Config{TokenURL: "https://login.example.com/token", ClientID: "minima", ClientSecretFile: "/etc/minima/secret"}))
+	assert.ErrorContains(t, ValidateOAuth2(&OAuth2Config{TokenURL: "login.example.com", ClientID: "minima", ClientSecret: "secret"}), "token_url")
+	assert.ErrorContains(t, ValidateOAuth2(&OAuth2Config{TokenURL: "https://login.example.com/token", ClientSecret: "secret"}), "client_id")
+	assert.ErrorContains(t, ValidateOAuth2(&OAuth2Config{TokenURL: "https://login.example.com/token", ClientID: "minima"}), "client_secret")
+	assert.ErrorContains(t, ValidateOAuth2(&OAuth2Config{TokenURL: "https://login.example.com/token", ClientID: "minima", ClientSecret: "secret", ClientSecretFile: "/etc/minima/secret"}), "client_secret")
+}
+
+func TestRepoHTTPClientOAuth2(t *testing.T) {
+	// the gateway issues numbered tokens, and only accepts the last one
+	issued := 0
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// credentials are form-encoded
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "minima+client" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": "invalid_client", "error_description": "unknown client"}`)
+			return
+		}
+		assert.Equal(t, "client_credentials", r.PostFormValue("grant_type"))
+		assert.Equal(t, "content.read offline", r.PostFormValue("scope"))
+		issued++
+		fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "Bearer", "expires_in": "3600"}`, issued)
+	}))
+	defer tokenServer.Close()
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// tokens are not sent to other hosts
+		assert.Empty(t, r.Header.Get("Authorization"))
+		fmt.Fprint(w, "package")
+	}))
+	defer cdn.Close()
+	repo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != fmt.Sprintf("Bearer token-%d", issued) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/repo/x86_64/a.rpm" {
+			http.Redirect(w, r, cdn.URL+"/a.rpm", http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, "repomd")
+	}))
+	defer repo.Close()
+
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	assert.NoError(t, os.WriteFile(secretFile, []byte("s3cret\n"), 0600))
+	config := HTTPRepoConfig{URL: repo.URL + "/repo/", OAuth2: &OAuth2Config{TokenURL: tokenServer.URL, ClientID: "minima client", ClientSecretFile: secretFile, Scopes: []string{"content.read", "offline"}}}
+	client, err := RepoHTTPClient(config)
+	assert.NoError(t, err)
+	read := func(path string) (string, error) {
+		reader, err := readURL(context.Background(), client, repo.URL+path)
+		if err != nil {
+			return "", err
+		}
+		defer reader.Close()
+		content, err := io.ReadAll(reader)
+		return string(content), err
+	}
+
+	content, err := read("/repo/repodata/repomd.xml")
+	assert.NoError(t, err)
+	assert.Equal(t, "repomd", content)
+	content, err = read("/repo/x86_64/a.rpm")
+	assert.NoError(t, err)
+	assert.Equal(t, "package", content)
+	// the token is reused while valid
+	assert.Equal(t, 1, issued)
+
+	// tokens about to expire are renewed
+	transport := client.Transport.(*oauth2Transport)
+	transport.expires = time.Now().Add(-time.Second)
+	_, err = read("/repo/repodata/repomd.xml")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, issued)
+	assert.True(t, transport.expires.After(time.Now().Add(58*time.Minute)))
+
+	// tokens refused are renewed once
+	issued++
+	_, err = read("/repo/repodata/repomd.xml")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, issued)
+
+	config.OAuth2.ClientSecretFile = ""
+	config.OAuth2.ClientSecret = "wrong"
+	client, err = RepoHTTPClient(config)
+	assert.NoError(t, err)
+	_, err = read("/repo/repodata/repomd.xml")
+	assert.ErrorContains(t, err, "invalid_client unknown client")
+
+	config.OAuth2.ClientSecretFile = filepath.Join(t.TempDir(), "missing")
+	_, err = RepoHTTPClient(config)
+	assert.ErrorContains(t, err, "cannot read OAuth2 client secret")
+}
@@ -0,0 +1,155 @@
+package get
+
+import (
+	"bytes"
+	"crypto"
+	"io"
+	"strings"
+
+	"github.com/uyuni-project/minima/util"
+)
+
+// objectStore is a flat store of files by key, as offered by object storage services
+type objectStore interface {
+	// get returns the content of key, or ErrFileNotFound
+	get(key string) (io.ReadCloser, error)
+	// put stores body at key
+	put(key string, body io.Reader) error
+	// copy copies source to destination within the store
+	copy(source string, destination string) error
+	// list returns all keys starting with prefix
+	list(prefix string) ([]string, error)
+	// delete removes key, if it exists
+	delete(key string) error
+}
+
+// objectStorage implements Storage in an objectStore. Files are kept below a/ or b/ prefixes,
+// alternating at every Commit, with the current one recorded in a prefixMarker object
+type objectStorage struct {
+	store objectStore
+	// key prefix of the repo in the store, empty or ending with a slash
+	root string
+	// a/ or b/, empty before the first Commit
+	prefix string
+	// files stored in the temporary location so far
+	stored map[string]bool
+}
+
+// newObjectStorage returns a Storage for files below repoPath in store
+func newObjectStorage(store objectStore, repoPath string) (*objectStorage, error) {
+	root := strings.Trim(repoPath, "/")
+	if root != "" {
+		root += "/"
+	}
+	storage := &objectStorage{store: store, root: root}
+
+	reader, err := store.get(root + prefixMarker)
+	if err == ErrFileNotFound {
+		return storage, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	prefix, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	storage.prefix = string(prefix)
+	return storage, nil
+}
+
+func (s *objectStorage) newPrefix() string {
+	if s.prefix == "a/" {
+		return "b/"
+	}
+	return "a/"
+}
+
+// NewReader returns a Reader for a file in a location, returns ErrFileNotFound
+// if the requested path was not found at all
+func (s *objectStorage) NewReader(filename string, location Location) (io.ReadCloser, error) {
+	prefix := s.newPrefix()
+	if location == Permanent {
+		if s.prefix == "" {
+			return nil, ErrFileNotFound
+		}
+		prefix = s.prefix
+	}
+	return s.store.get(s.root + prefix + filename)
+}
+
+// StoringMapper returns a mapper that will store read data to a temporary location specified by filename
+func (s *objectStorage) StoringMapper(filename string, checksum string, hash crypto.Hash) util.ReaderMapper {
+	return func(reader io.ReadCloser) (result io.ReadCloser, err error) {
+		s.markStored(filename)
+		pipeReader, pipeWriter := io.Pipe()
+
+		errs := make(chan error)
+		go func() {
+			// verified while uploading, so that corrupt files fail before being stored
+			err := s.store.put(s.root+s.newPrefix()+filename, util.NewChecksummingReader(pipeReader, checksum, hash))
+			// unblock writes if the upload stopped early
+			pipeReader.CloseWithError(err)
+			errs <- err
+		}()
+
+		result = util.NewTeeReadCloser(reader, &waitingCloser{pipeWriter, errs, filename})
+		return
+	}
+}
+
+// Recycle will copy a file from the permanent to the temporary location
+func (s *objectStorage) Recycle(filename string) error {
+	s.markStored(filename)
+	return s.store.copy(s.root+s.prefix+filename, s.root+s.newPrefix()+filename)
+}
+
+// markStored records that filename is part of the sync in progress
+func (s *objectStorage) markStored(filename string) {
+	if s.stored == nil {
+		s.stored = map[string]bool{}
+	}
+	s.stored[filename] = true
+}
+
+// Commit moves any temporary file accumulated so far to the permanent location
+func (s *objectStorage) Commit() error {
+	newPrefix := s.newPrefix()
+
+	// files left in the temporary location by interrupted syncs must not be published
+	temporary, err := s.store.list(s.root + newPrefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range temporary {
+		if !s.stored[strings.TrimPrefix(key, s.root+newPrefix)] {
+			err = s.store.delete(key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = s.store.put(s.root+prefixMarker, bytes.NewReader([]byte(newPrefix)))
+	if err != nil {
+		return err
+	}
+
+	if s.prefix != "" {
+		permanent, err := s.store.list(s.root + s.prefix)
+		if err != nil {
+			return err
+		}
+		for _, key := range permanent {
+			err = s.store.delete(key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	s.prefix = newPrefix
+	s.stored = nil
+	return nil
+}
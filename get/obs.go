@@ -0,0 +1,135 @@
+package get
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// DefaultOBSAPIURL and DefaultOBSDownloadURL are the API and published repos of the openSUSE Build Service
+const (
+	DefaultOBSAPIURL      = "https://api.opensuse.org"
+	DefaultOBSDownloadURL = "https://download.opensuse.org/repositories"
+)
+
+// OBSProjects defines the configuration to mirror repos of Open Build Service projects, whose published
+// URLs are resolved with the OBS API
+type OBSProjects struct {
+	APIURL      string `yaml:"api_url"`
+	DownloadURL string `yaml:"download_url"`
+	// credentials for the API, those of the obs section if empty
+	Username string
+	Password string
+	Projects []OBSProjectConfig
+}
+
+// OBSProjectConfig selects repositories of an OBS project, like home:foo:tools
+type OBSProjectConfig struct {
+	Project string
+	// names of the repositories, like openSUSE_Leap_15.6, all repositories of the project if empty
+	Repositories []string
+	// all archs built in each repository if empty
+	Archs []string
+}
+
+// obsProjectMeta is the metadata of an OBS project, as returned by /source/<project>/_meta
+type obsProjectMeta struct {
+	Repositories []struct {
+		Name  string   `xml:"name,attr"`
+		Archs []string `xml:"arch"`
+	} `xml:"repository"`
+}
+
+// OBSToHTTPConfigs returns HTTPS repos configurations for the published repositories of the configured
+// projects, for the archs built in each of them. Progress is logged to logger, the standard logger if nil
+func OBSToHTTPConfigs(config OBSProjects, quiet bool, logger *log.Logger) ([]HTTPRepoConfig, error) {
+	apiURL := strings.TrimSuffix(config.APIURL, "/")
+	if apiURL == "" {
+		apiURL = DefaultOBSAPIURL
+	}
+	downloadURL := strings.TrimSuffix(config.DownloadURL, "/")
+	if downloadURL == "" {
+		downloadURL = DefaultOBSDownloadURL
+	}
+	request := func(path string, v interface{}) (int, error) {
+		req, err := http.NewRequest(http.MethodGet, apiURL+path, nil)
+		if err != nil {
+			return 0, err
+		}
+		req.SetBasicAuth(config.Username, config.Password)
+		req.Header.Set("Accept", "application/xml")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return resp.StatusCode, &UnexpectedStatusCodeError{apiURL + path, resp.StatusCode}
+		}
+		if v == nil {
+			return resp.StatusCode, nil
+		}
+		return resp.StatusCode, xml.NewDecoder(resp.Body).Decode(v)
+	}
+
+	logger = loggerOrDefault(logger)
+	logger.Println("Checking OBS projects ...")
+	httpConfigs := []HTTPRepoConfig{}
+	for _, project := range config.Projects {
+		var meta obsProjectMeta
+		_, err := request("/source/"+url.PathEscape(project.Project)+"/_meta", &meta)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read OBS project %s: %v", project.Project, err)
+		}
+
+		found := map[string]bool{}
+		for _, repository := range meta.Repositories {
+			if len(project.Repositories) > 0 && !slices.Contains(project.Repositories, repository.Name) {
+				continue
+			}
+			found[repository.Name] = true
+
+			archs := []string{}
+			for _, arch := range repository.Archs {
+				if len(project.Archs) == 0 || slices.Contains(project.Archs, arch) {
+					archs = append(archs, arch)
+				}
+			}
+			if len(archs) == 0 {
+				continue
+			}
+			// repositories with publishing disabled have no URL
+			status, err := request("/published/"+url.PathEscape(project.Project)+"/"+url.PathEscape(repository.Name), nil)
+			if status == http.StatusNotFound {
+				if !quiet {
+					logger.Printf("  %s/%s: not published, skipping\n", project.Project, repository.Name)
+				}
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			repoURL := downloadURL + "/" + strings.ReplaceAll(project.Project, ":", ":/") + "/" + repository.Name + "/"
+			if !quiet {
+				logger.Printf("  %s/%s: %s\n", project.Project, repository.Name, repoURL)
+			}
+			httpConfigs = append(httpConfigs, HTTPRepoConfig{
+				URL:       repoURL,
+				Name:      repository.Name,
+				Archs:     archs,
+				Variables: map[string]string{"project": project.Project, "repository": repository.Name},
+			})
+		}
+		for _, name := range project.Repositories {
+			if !found[name] {
+				return nil, fmt.Errorf("OBS project %s has no repository %s", project.Project, name)
+			}
+		}
+	}
+	return httpConfigs, nil
+}
@@ -0,0 +1,87 @@
+package get
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOBSToHTTPConfigs(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/source/home:foo:tools/_meta", func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "foo" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `<project name="home:foo:tools">
+  <title>Tools</title>
+  <repository name="openSUSE_Leap_15.6">
+    <path project="openSUSE:Leap:15.6" repository="standard"/>
+    <arch>x86_64</arch>
+    <arch>aarch64</arch>
+  </repository>
+  <repository name="openSUSE_Tumbleweed">
+    <path project="openSUSE:Factory" repository="snapshot"/>
+    <arch>x86_64</arch>
+  </repository>
+  <repository name="unpublished">
+    <arch>x86_64</arch>
+  </repository>
+</project>`)
+	})
+	mux.HandleFunc("/published/home:foo:tools/openSUSE_Leap_15.6", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<directory><entry name="repodata"/></directory>`)
+	})
+	mux.HandleFunc("/published/home:foo:tools/openSUSE_Tumbleweed", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<directory><entry name="repodata"/></directory>`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := OBSProjects{
+		APIURL:   server.URL,
+		Username: "foo",
+		Password: "secret",
+		Projects: []OBSProjectConfig{{Project: "home:foo:tools"}},
+	}
+	output := &bytes.Buffer{}
+	configs, err := OBSToHTTPConfigs(config, false, log.New(output, "", 0))
+	assert.NoError(t, err)
+	assert.Contains(t, output.String(), "Checking OBS projects ...\n")
+	assert.Contains(t, output.String(), "  home:foo:tools/unpublished: not published, skipping\n")
+	assert.Equal(t, []HTTPRepoConfig{
+		{
+			URL:       "https://download.opensuse.org/repositories/home:/foo:/tools/openSUSE_Leap_15.6/",
+			Name:      "openSUSE_Leap_15.6",
+			Archs:     []string{"x86_64", "aarch64"},
+			Variables: map[string]string{"project": "home:foo:tools", "repository": "openSUSE_Leap_15.6"},
+		},
+		{
+			URL:       "https://download.opensuse.org/repositories/home:/foo:/tools/openSUSE_Tumbleweed/",
+			Name:      "openSUSE_Tumbleweed",
+			Archs:     []string{"x86_64"},
+			Variables: map[string]string{"project": "home:foo:tools", "repository": "openSUSE_Tumbleweed"},
+		},
+	}, configs)
+
+	config.DownloadURL = "http://mirror.example.com/obs/"
+	config.Projects = []OBSProjectConfig{{Project: "home:foo:tools", Repositories: []string{"openSUSE_Leap_15.6"}, Archs: []string{"aarch64"}}}
+	configs, err = OBSToHTTPConfigs(config, true, nil)
+	assert.NoError(t, err)
+	assert.Len(t, configs, 1)
+	assert.Equal(t, "http://mirror.example.com/obs/home:/foo:/tools/openSUSE_Leap_15.6/", configs[0].URL)
+	assert.Equal(t, []string{"aarch64"}, configs[0].Archs)
+
+	config.Projects = []OBSProjectConfig{{Project: "home:foo:tools", Repositories: []string{"openSUSE_Leap_15.5"}}}
+	_, err = OBSToHTTPConfigs(config, true, nil)
+	assert.ErrorContains(t, err, "has no repository openSUSE_Leap_15.5")
+
+	config.Password = "wrong"
+	_, err = OBSToHTTPConfigs(config, true, nil)
+	assert.ErrorContains(t, err, "cannot read OBS project home:foo:tools")
+}
@@ -0,0 +1,81 @@
+package get
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// PackageList is a set of packages given by name or NEVRA, like bash or bash-0:5.1-150300.12.1.x86_64
+type PackageList struct {
+	entries map[string]bool
+}
+
+// NewPackageList returns a PackageList of the given names or NEVRAs. The epoch, arch and .rpm or .deb
+// extension are optional in NEVRAs, Debian packages also match their file names
+func NewPackageList(entries []string) *PackageList {
+	list := &PackageList{entries: map[string]bool{}}
+	for _, entry := range entries {
+		entry = strings.TrimSuffix(strings.TrimSuffix(entry, ".rpm"), ".deb")
+		list.entries[entry] = true
+	}
+	return list
+}
+
+// ReadPackageList reads a PackageList from a file with one entry per line. Empty lines and lines starting
+// with # are ignored
+func ReadPackageList(filename string) (*PackageList, error) {
+	entries, err := readListFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewPackageList(entries), nil
+}
+
+// readListFile returns the lines of a file, except empty ones and comments starting with #
+func readListFile(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readList(f)
+}
+
+// readList reads the entries of a list with one per line, ignoring empty lines and lines starting with #
+func readList(reader io.Reader) ([]string, error) {
+	entries := []string{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	return entries, scanner.Err()
+}
+
+// Contains reports whether a package is in the list
+func (l *PackageList) Contains(pack XMLPackage) bool {
+	base := path.Base(pack.Location.Href)
+	candidates := []string{pack.Name, strings.TrimSuffix(strings.TrimSuffix(base, ".rpm"), ".deb")}
+	if version := pack.Version; version.Version != "" && version.Release != "" {
+		nvr := pack.Name + "-" + version.Version + "-" + version.Release
+		candidates = append(candidates, nvr, nvr+"."+pack.Arch)
+		epoch := version.Epoch
+		if epoch == "" {
+			epoch = "0"
+		}
+		envr := pack.Name + "-" + epoch + ":" + version.Version + "-" + version.Release
+		candidates = append(candidates, envr, envr+"."+pack.Arch)
+	}
+	for _, candidate := range candidates {
+		if candidate != "" && l.entries[candidate] {
+			return true
+		}
+	}
+	return false
+}
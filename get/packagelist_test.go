@@ -0,0 +1,62 @@
+package get
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPackageList(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "packages.txt")
+	assert.NoError(t, os.WriteFile(file, []byte("# fixes\nhoag-dummy\n\nmilkyway-dummy-2.0-1.1.x86_64\norion-dummy-0:1.1-1.1\nbash_5.1-6_amd64.deb\n"), 0644))
+	list, err := ReadPackageList(file)
+	assert.NoError(t, err)
+
+	version := XMLVersion{Epoch: "0", Version: "2.0", Release: "1.1"}
+	assert.True(t, list.Contains(XMLPackage{Name: "hoag-dummy", Arch: "x86_64"}))
+	assert.True(t, list.Contains(XMLPackage{Name: "milkyway-dummy", Arch: "x86_64", Version: version}))
+	assert.False(t, list.Contains(XMLPackage{Name: "milkyway-dummy", Arch: "i586", Version: version}))
+	assert.True(t, list.Contains(XMLPackage{Name: "orion-dummy", Arch: "i586", Version: XMLVersion{Version: "1.1", Release: "1.1"}}))
+	assert.False(t, list.Contains(XMLPackage{Name: "orion-dummy", Arch: "i586", Version: version}))
+	assert.True(t, list.Contains(XMLPackage{Name: "bash", Arch: "amd64", Location: XMLLocation{Href: "pool/main/b/bash/bash_5.1-6_amd64.deb"}}))
+	assert.False(t, list.Contains(XMLPackage{Name: "fish", Arch: "amd64", Location: XMLLocation{Href: "pool/main/f/fish/fish_3.6-1_amd64.deb"}}))
+
+	_, err = ReadPackageList(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStoreRepoOnlyPackages(t *testing.T) {
+	repoURL, err := url.Parse("http://localhost:8080/repo")
+	assert.NoError(t, err)
+	directory := t.TempDir()
+	storage := NewFileStorage(directory)
+	syncer := NewSyncer(*repoURL, map[string]bool{"x86_64": true}, storage, true)
+	syncer.OnlyPackages = NewPackageList([]string{"hoag-dummy", "milkyway-dummy-2.0-1.1.x86_64"})
+	assert.NoError(t, syncer.StoreRepo())
+
+	repomd, err := os.ReadFile(filepath.Join(directory, "repodata", "repomd.xml"))
+	assert.NoError(t, err)
+	document, err := parseRepomd(repomd)
+	assert.NoError(t, err)
+	for _, data := range document.Data {
+		if data.Type != "primary" {
+			continue
+		}
+		reader, err := storage.NewReader(data.Location.Href, Permanent)
+		assert.NoError(t, err)
+		metadata, err := readMetaData(reader, "gz")
+		reader.Close()
+		assert.NoError(t, err)
+		locations := []string{}
+		for _, pack := range metadata.Packages {
+			locations = append(locations, pack.Location.Href)
+		}
+		assert.ElementsMatch(t, []string{"x86_64/hoag-dummy-1.1-2.1.x86_64.rpm", "i586/hoag-dummy-1.1-2.1.i586.rpm", "x86_64/milkyway-dummy-2.0-1.1.x86_64.rpm"}, locations)
+	}
+	rpms, err := filepath.Glob(filepath.Join(directory, "*", "*.rpm"))
+	assert.NoError(t, err)
+	assert.Len(t, rpms, 3)
+}
@@ -0,0 +1,255 @@
+package get
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/uyuni-project/minima/util"
+)
+
+// pieceRepairMinSize is the size from which stored copies of packages are repaired, if enabled
+const pieceRepairMinSize = 8 << 20
+
+// pieceHashes are the checksums of consecutive pieces of a file, published by servers like MirrorBrain
+// in metalinks (RFC 5854) and torrents next to large files, eg. ISO images, with .meta4 and .torrent
+// extensions. They locate the damaged parts of a file, so that only those are downloaded again
+type pieceHashes struct {
+	length  int64
+	newHash func() hash.Hash
+	hashes  [][]byte
+}
+
+// metalinkPieceHashes are the hashes of metalink pieces by IANA name, by decreasing strength. SHA-1 is
+// left out in FIPS mode
+func metalinkPieceHashes(fips bool) []string {
+	if fipsMode(fips) {
+		return []string{"sha-512", "sha-256"}
+	}
+	return []string{"sha-512", "sha-256", "sha-1"}
+}
+
+// pieceHashConstructors creates the hashes of metalinkPieceHashes
+var pieceHashConstructors = map[string]func() hash.Hash{"sha-512": sha512.New, "sha-256": sha256.New, "sha-1": sha1.New}
+
+// XMLMetalink is a metalink, listing the pieces of files
+type XMLMetalink struct {
+	Files []struct {
+		Name   string `xml:"name,attr"`
+		Size   int64  `xml:"size"`
+		Pieces []struct {
+			Length int64    `xml:"length,attr"`
+			Type   string   `xml:"type,attr"`
+			Hashes []string `xml:"hash"`
+		} `xml:"pieces"`
+	} `xml:"file"`
+}
+
+// parseMetalinkPieces returns the strongest piece hashes of the file name of size bytes in a metalink,
+// approved in FIPS mode with fips
+func parseMetalinkPieces(reader io.Reader, name string, size int64, fips bool) (*pieceHashes, error) {
+	metalink := XMLMetalink{}
+	err := xml.NewDecoder(reader).Decode(&metalink)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metalink: %v", err)
+	}
+	for _, file := range metalink.Files {
+		if file.Name != name || file.Size != size {
+			continue
+		}
+		for _, hashType := range metalinkPieceHashes(fips) {
+			for _, pieces := range file.Pieces {
+				if pieces.Type != hashType {
+					continue
+				}
+				hashes := [][]byte{}
+				for _, piece := range pieces.Hashes {
+					sum, err := hex.DecodeString(strings.TrimSpace(piece))
+					if err != nil {
+						return nil, fmt.Errorf("invalid metalink piece hash %s", piece)
+					}
+					hashes = append(hashes, sum)
+				}
+				return newPieceHashes(pieces.Length, pieceHashConstructors[hashType], hashes, size)
+			}
+		}
+	}
+	return nil, fmt.Errorf("metalink has no piece hashes of %s", name)
+}
+
+// parseTorrentPieces returns the piece hashes of a torrent of the single file of size bytes, refused in FIPS
+// mode with fips
+func parseTorrentPieces(data []byte, size int64, fips bool) (*pieceHashes, error) {
+	if fipsMode(fips) {
+		return nil, fmt.Errorf("torrent pieces are hashed with SHA-1, which is not possible in FIPS mode")
+	}
+	value, _, err := bdecode(data)
+	metainfo, ok := value.(map[string]any)
+	if err != nil || !ok {
+		return nil, fmt.Errorf("invalid torrent")
+	}
+	info, _ := metainfo["info"].(map[string]any)
+	length, _ := info["length"].(int64)
+	if length != size {
+		return nil, fmt.Errorf("torrent is not of a single file of %d bytes", size)
+	}
+	pieceLength, _ := info["piece length"].(int64)
+	pieces, _ := info["pieces"].(string)
+	if len(pieces)%sha1.Size != 0 {
+		return nil, fmt.Errorf("invalid torrent pieces")
+	}
+	hashes := [][]byte{}
+	for i := 0; i < len(pieces); i += sha1.Size {
+		hashes = append(hashes, []byte(pieces[i:i+sha1.Size]))
+	}
+	return newPieceHashes(pieceLength, sha1.New, hashes, size)
+}
+
+// newPieceHashes returns pieceHashes if they are the right number for a file of size bytes
+func newPieceHashes(length int64, newHash func() hash.Hash, hashes [][]byte, size int64) (*pieceHashes, error) {
+	if length <= 0 || length > 1<<30 || int64(len(hashes)) != (size+length-1)/length {
+		return nil, fmt.Errorf("%d pieces of %d bytes do not match a file of %d bytes", len(hashes), length, size)
+	}
+	return &pieceHashes{length: length, newHash: newHash, hashes: hashes}, nil
+}
+
+// match reads seed, and returns the offsets in seed of the pieces of the file matching their hashes, by
+// piece. Pieces past the end of seed do not match
+func (p *pieceHashes) match(seed io.Reader, size int64) (map[int]int64, error) {
+	found := map[int]int64{}
+	piece := make([]byte, p.length)
+	for i, expected := range p.hashes {
+		offset := int64(i) * p.length
+		n, err := io.ReadFull(seed, piece[:min(p.length, size-offset)])
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		h := p.newHash()
+		h.Write(piece[:n])
+		if bytes.Equal(h.Sum(nil), expected) {
+			found[i] = offset
+		}
+	}
+	return found, nil
+}
+
+// pieceHashesOf returns the piece hashes upstream publishes for the file at relativeURL, nil if none
+func (r *Syncer) pieceHashesOf(ctx context.Context, pack XMLPackage, relativeURL string) (*pieceHashes, error) {
+	for _, extension := range []string{".meta4", ".torrent"} {
+		reader, err := r.readRelative(ctx, relativeURL+extension)
+		if uerr, unexpectedStatusCode := err.(*UnexpectedStatusCodeError); unexpectedStatusCode && (uerr.StatusCode == http.StatusNotFound || uerr.StatusCode == http.StatusForbidden) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var pieces *pieceHashes
+		if extension == ".meta4" {
+			pieces, err = parseMetalinkPieces(reader, path.Base(pack.Location.Href), pack.Size.Package, r.FIPS)
+		} else {
+			var data []byte
+			data, err = io.ReadAll(io.LimitReader(reader, 16<<20))
+			if err == nil {
+				pieces, err = parseTorrentPieces(data, pack.Size.Package, r.FIPS)
+			}
+		}
+		reader.Close()
+		if err == nil {
+			return pieces, nil
+		}
+		r.logger().Printf("Ignoring %s of %s: %v\n", extension, pack.Location.Href, err)
+	}
+	return nil, nil
+}
+
+// repairSeed returns where a copy of pack is stored at its location, that must be downloaded again as it
+// is corrupted or partial
+func (r *Syncer) repairSeed(pack XMLPackage) (Location, bool) {
+	for _, location := range []Location{Temporary, Permanent} {
+		reader, err := r.storage.NewReader(pack.Location.Href, location)
+		if err == nil {
+			reader.Close()
+			return location, true
+		}
+	}
+	return Permanent, false
+}
+
+// piecesBody returns a Reader of pack built from the pieces of the copy of pack at seed matching the piece
+// hashes published upstream, and of the others, downloaded with range requests. ok is false if upstream
+// publishes no piece hashes or none match
+func (r *Syncer) piecesBody(ctx context.Context, pack XMLPackage, relativeURL string, seed Location) (body io.ReadCloser, ok bool, err error) {
+	pieces, err := r.pieceHashesOf(ctx, pack, relativeURL)
+	if err != nil || pieces == nil {
+		return nil, false, err
+	}
+
+	// the copy is read into a local file, as the repaired file replaces it in storage
+	seedReader, err := r.storage.NewReader(pack.Location.Href, seed)
+	if err != nil {
+		return nil, false, err
+	}
+	local, err := os.CreateTemp("", "minima-pieces-*")
+	if err != nil {
+		seedReader.Close()
+		return nil, false, err
+	}
+	found, err := pieces.match(io.TeeReader(seedReader, local), pack.Size.Package)
+	seedReader.Close()
+	if err != nil || len(found) == 0 {
+		local.Close()
+		os.Remove(local.Name())
+		return nil, false, err
+	}
+	r.logger().Printf("Reusing %d of %d pieces of %s\n", len(found), len(pieces.hashes), path.Base(pack.Location.Href))
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	fileURL := r.repoFileURL(relativeURL)
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		pipeWriter.CloseWithError(assembleBlocks(pipeWriter, int(pieces.length), pack.Size.Package, found, local, func(start int64, end int64) (io.ReadCloser, error) {
+			return readURLRange(ctx, client, fileURL, start, end)
+		}))
+	}()
+	return &assembledReader{pipeReader, local}, true, nil
+}
+
+// piecesStoreApply stores pack repaired from the copy at seed, while applying a ReaderConsumer. stored is
+// false if the copy cannot be repaired, or if the result does not match the checksum of pack and must be
+// downloaded fully
+func (r *Syncer) piecesStoreApply(ctx context.Context, pack XMLPackage, relativeURL string, storageURL string, seed Location, description string, f util.ReaderConsumer) (stored bool, err error) {
+	body, ok, err := r.piecesBody(ctx, pack, relativeURL, seed)
+	if err != nil || !ok {
+		return false, err
+	}
+	if !r.quiet {
+		r.logger().Printf("Repairing %v...", description)
+	}
+	err = r.storeApply(body, storageURL, pack.Checksum.Checksum, hashMap[pack.Checksum.Type], f)
+	if _, checksumError := err.(*util.ChecksumError); checksumError {
+		r.logger().Printf("Repair of %s did not match its checksum, downloading it fully\n", path.Base(pack.Location.Href))
+		return false, nil
+	}
+	if _, rangeError := err.(*UnexpectedStatusCodeError); rangeError {
+		r.logger().Printf("Repair of %s failed, downloading it fully: %v\n", path.Base(pack.Location.Href), err)
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,158 @@
+package get
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/uyuni-project/minima/util"
+)
+
+// makeMetalink returns a metalink of the file name with content, with SHA-1 and SHA-256 pieces of
+// pieceLength bytes
+func makeMetalink(name string, content []byte, pieceLength int) []byte {
+	metalink := &bytes.Buffer{}
+	fmt.Fprintf(metalink, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<metalink xmlns=\"urn:ietf:params:xml:ns:metalink\">\n<file name=\"%s\">\n<size>%d</size>\n", name, len(content))
+	for _, hashType := range []string{"sha-1", "sha-256"} {
+		fmt.Fprintf(metalink, "<pieces length=\"%d\" type=\"%s\">\n", pieceLength, hashType)
+		for start := 0; start < len(content); start += pieceLength {
+			piece := content[start:min(start+pieceLength, len(content))]
+			sum := fmt.Sprintf("%x", sha1.Sum(piece))
+			if hashType == "sha-256" {
+				sum = fmt.Sprintf("%x", sha256.Sum256(piece))
+			}
+			fmt.Fprintf(metalink, "<hash>%s</hash>\n", sum)
+		}
+		fmt.Fprintf(metalink, "</pieces>\n")
+	}
+	fmt.Fprintf(metalink, "<url>http://download.example.com/%s</url>\n</file>\n</metalink>\n", name)
+	return metalink.Bytes()
+}
+
+// makeSingleFileTorrent returns a torrent of content, in pieces of pieceLength bytes
+func makeSingleFileTorrent(content []byte, pieceLength int) []byte {
+	pieces := []byte{}
+	for start := 0; start < len(content); start += pieceLength {
+		sum := sha1.Sum(content[start:min(start+pieceLength, len(content))])
+		pieces = append(pieces, sum[:]...)
+	}
+	return bencode(map[string]any{"info": map[string]any{"name": "test.iso", "length": len(content), "piece length": pieceLength, "pieces": pieces}})
+}
+
+func TestParseMetalinkPieces(t *testing.T) {
+	content := bytes.Repeat([]byte("minima"), 1000)
+	pieces, err := parseMetalinkPieces(bytes.NewReader(makeMetalink("test.iso", content, 1024)), "test.iso", int64(len(content)), false)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1024), pieces.length)
+	assert.Len(t, pieces.hashes, 6)
+	// the strongest hash is used
+	assert.Len(t, pieces.hashes[0], sha256.Size)
+
+	_, err = parseMetalinkPieces(bytes.NewReader(makeMetalink("test.iso", content, 1024)), "other.iso", int64(len(content)), false)
+	assert.ErrorContains(t, err, "metalink has no piece hashes of other.iso")
+	_, err = parseMetalinkPieces(bytes.NewReader(makeMetalink("test.iso", content, 1024)), "test.iso", 10000, false)
+	assert.Error(t, err)
+	_, err = parseMetalinkPieces(strings.NewReader("<metalink><file name=\"test.iso\"><size>3000</size><pieces length=\"1024\" type=\"sha-256\"><hash>00</hash></pieces></file></metalink>"), "test.iso", 3000, false)
+	assert.ErrorContains(t, err, "1 pieces of 1024 bytes do not match a file of 3000 bytes")
+}
+
+func TestParseTorrentPieces(t *testing.T) {
+	content := bytes.Repeat([]byte("minima"), 1000)
+	pieces, err := parseTorrentPieces(makeSingleFileTorrent(content, 4096), int64(len(content)), false)
+	assert.NoError(t, err)
+	assert.Len(t, pieces.hashes, 2)
+	assert.Equal(t, sha1.Sum(content[4096:]), [sha1.Size]byte(pieces.hashes[1]))
+
+	_, err = parseTorrentPieces(makeSingleFileTorrent(content, 4096), 100, false)
+	assert.ErrorContains(t, err, "not of a single file of 100 bytes")
+	_, err = parseTorrentPieces([]byte("not a torrent"), 100, false)
+	assert.ErrorContains(t, err, "invalid torrent")
+}
+
+func TestPiecesStoreApply(t *testing.T) {
+	random := rand.New(rand.NewSource(3))
+	content := make([]byte, 100000)
+	random.Read(content)
+	// the stored copy has a damaged piece and misses the last ones
+	damaged := append([]byte{}, content[:90000]...)
+	damaged[40000] ^= 0xff
+
+	for _, extension := range []string{".meta4", ".torrent"} {
+		ranges := []string{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/repo/x86_64/test.iso":
+				ranges = append(ranges, r.Header.Get("Range"))
+				http.ServeContent(w, r, "test.iso", time.Time{}, bytes.NewReader(content))
+			case "/repo/x86_64/test.iso.meta4":
+				if extension != ".meta4" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.Write(makeMetalink("test.iso", content, 16384))
+			case "/repo/x86_64/test.iso.torrent":
+				w.Write(makeSingleFileTorrent(content, 16384))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		directory := filepath.Join(t.TempDir(), "repo")
+		assert.NoError(t, os.MkdirAll(filepath.Join(directory, "x86_64"), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(directory, "x86_64", "test.iso"), damaged, 0644))
+		repoURL, err := url.Parse(server.URL + "/repo")
+		assert.NoError(t, err)
+		syncer := NewSyncer(*repoURL, map[string]bool{"x86_64": true}, NewFileStorage(directory), true)
+
+		sum := sha256.Sum256(content)
+		pack := XMLPackage{
+			Location: XMLLocation{Href: "x86_64/test.iso"},
+			Checksum: XMLChecksum{Type: "sha256", Checksum: hex.EncodeToString(sum[:])},
+			Size:     XMLSize{Package: int64(len(content))},
+		}
+		seed, ok := syncer.repairSeed(pack)
+		assert.True(t, ok)
+		assert.Equal(t, Permanent, seed)
+		stored, err := syncer.piecesStoreApply(context.Background(), pack, pack.Location.Href, pack.Location.Href, seed, "test.iso", util.Nop)
+		assert.NoError(t, err, extension)
+		assert.True(t, stored, extension)
+		// the damaged piece, and the ones missing, including the piece only partially stored
+		assert.Equal(t, []string{"bytes=32768-49151", "bytes=81920-99999"}, ranges, extension)
+		storedContent, err := os.ReadFile(filepath.Join(directory+"-in-progress", "x86_64", "test.iso"))
+		assert.NoError(t, err)
+		assert.True(t, bytes.Equal(content, storedContent), extension)
+	}
+}
+
+func TestPiecesStoreApplyWithoutPieces(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	directory := filepath.Join(t.TempDir(), "repo")
+	repoURL, err := url.Parse(server.URL + "/repo")
+	assert.NoError(t, err)
+	syncer := NewSyncer(*repoURL, map[string]bool{"x86_64": true}, NewFileStorage(directory), true)
+	pack := XMLPackage{Location: XMLLocation{Href: "x86_64/test.iso"}, Size: XMLSize{Package: 100000}}
+
+	_, ok := syncer.repairSeed(pack)
+	assert.False(t, ok)
+	stored, err := syncer.piecesStoreApply(context.Background(), pack, pack.Location.Href, pack.Location.Href, Permanent, "test.iso", util.Nop)
+	assert.NoError(t, err)
+	assert.False(t, stored)
+}
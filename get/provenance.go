@@ -0,0 +1,62 @@
+package get
+
+import (
+	"crypto"
+	"strings"
+	"time"
+)
+
+// extended attributes recording the provenance of files stored by a FileStorage with ProvenanceXattrs
+const (
+	// xattrSource is the URL a file was downloaded from, or the upstream URLs of merged repos
+	xattrSource = "user.minima.source"
+	// xattrChecksum is the checksum a file was verified against, like SHA-256:<hex>
+	xattrChecksum = "user.minima.checksum"
+	// xattrSynced is the RFC 3339 time a file was stored at
+	xattrSynced = "user.minima.synced"
+)
+
+var provenanceXattrs = []string{xattrSource, xattrChecksum, xattrSynced}
+
+// provenance returns the provenance extended attributes of a file stored now with checksum
+func (s *FileStorage) provenance(filename string, checksum string, hash crypto.Hash) map[string]string {
+	attributes := map[string]string{xattrSynced: time.Now().UTC().Format(time.RFC3339)}
+	if s.Upstream != "" {
+		attributes[xattrSource] = s.Upstream
+		if !strings.Contains(s.Upstream, ",") {
+			attributes[xattrSource] = strings.TrimSuffix(s.Upstream, "/") + "/" + filename
+		}
+	}
+	if checksum != "" {
+		attributes[xattrChecksum] = hash.String() + ":" + checksum
+	}
+	return attributes
+}
+
+// setProvenance sets the provenance extended attributes of a file
+func setProvenance(name string, attributes map[string]string) error {
+	for _, attribute := range provenanceXattrs {
+		if value, ok := attributes[attribute]; ok {
+			if err := setXattr(name, attribute, []byte(value)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copyProvenance copies the provenance extended attributes of source to target, which are not kept by
+// clones and copies
+func copyProvenance(source string, target string) error {
+	attributes := map[string]string{}
+	for _, attribute := range provenanceXattrs {
+		value, err := getXattr(source, attribute)
+		if err != nil {
+			return err
+		}
+		if value != nil {
+			attributes[attribute] = string(value)
+		}
+	}
+	return setProvenance(target, attributes)
+}
@@ -0,0 +1,371 @@
+package get
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/uyuni-project/minima/util"
+)
+
+// pulpPollInterval is the delay between checks of running Pulp tasks
+var pulpPollInterval = time.Second
+
+// PulpStorage stores data in a local directory like FileStorage, then publishes its packages to an RPM
+// repository of a Pulp 3 server at every Commit. The repository, its publication and its distribution
+// are created as needed, and named after the repo path. Pulp generates the published metadata itself
+type PulpStorage struct {
+	FileStorage
+	// URL of the Pulp server, with the API below /pulp/api/v3/
+	endpoint url.URL
+	username string
+	password string
+	client   *http.Client
+	// name of the repository and base path of the distribution
+	name string
+	// where publishing is logged, the standard logger if nil
+	logger *log.Logger
+}
+
+// NewPulpStorage returns a new Storage staging repoPath below the configured local path, and
+// publishing it to the Pulp server at the configured endpoint. The staging directory is written like a file
+// storage, with its buffer_size, fsync and file attributes settings
+func NewPulpStorage(config StorageConfig, repoPath string) (Storage, error) {
+	options, err := fileStorageOptions(config)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(config.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	client, err := tlsHTTPClient(config.CACertFile, config.InsecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.Trim(repoPath, "/")
+	if name == "" {
+		name = "minima"
+	}
+	return &PulpStorage{
+		FileStorage: FileStorage{directory: filepath.Join(config.Path, filepath.FromSlash(repoPath)), options: options},
+		endpoint:    *endpoint,
+		username:    config.Username,
+		password:    config.Password,
+		client:      client,
+		name:        name,
+		logger:      config.Logger,
+	}, nil
+}
+
+// pulpTask is the response of API calls running asynchronously
+type pulpTask struct {
+	Task string
+}
+
+// pulpRepository is an RPM repository in Pulp
+type pulpRepository struct {
+	Href          string `json:"pulp_href"`
+	LatestVersion string `json:"latest_version_href"`
+}
+
+// pulpResource is any Pulp object, with its link to a publication if it is a distribution
+type pulpResource struct {
+	Href        string `json:"pulp_href"`
+	SHA256      string
+	Publication string
+}
+
+// do sends a request to an API href, with a JSON body if any, and decodes the response into result if
+// not nil
+func (s *PulpStorage) do(method string, href string, body any, result any, expected ...int) error {
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(content)
+	}
+	return s.send(method, href, reader, "application/json", result, expected...)
+}
+
+// send sends a request with a body of contentType to an API href, see do
+func (s *PulpStorage) send(method string, href string, body io.Reader, contentType string, result any, expected ...int) error {
+	u, err := s.endpoint.Parse(href)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			if result == nil {
+				return nil
+			}
+			return json.NewDecoder(resp.Body).Decode(result)
+		}
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%v: %s", &UnexpectedStatusCodeError{method + " " + u.Path, resp.StatusCode}, message)
+}
+
+// list returns all results of a paginated API href
+func (s *PulpStorage) list(href string) ([]pulpResource, error) {
+	results := []pulpResource{}
+	for href != "" {
+		var page struct {
+			Next    string
+			Results []pulpResource
+		}
+		err := s.do(http.MethodGet, href, nil, &page, http.StatusOK)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, page.Results...)
+		href = page.Next
+	}
+	return results, nil
+}
+
+// wait waits for a task to complete, and returns the hrefs of the resources it created
+func (s *PulpStorage) wait(task pulpTask) ([]string, error) {
+	for {
+		var status struct {
+			State            string
+			CreatedResources []string `json:"created_resources"`
+			Error            struct {
+				Description string
+			}
+		}
+		err := s.do(http.MethodGet, task.Task, nil, &status, http.StatusOK)
+		if err != nil {
+			return nil, err
+		}
+		switch status.State {
+		case "completed":
+			return status.CreatedResources, nil
+		case "failed", "canceled":
+			return nil, fmt.Errorf("Pulp task %s %s: %s", task.Task, status.State, status.Error.Description)
+		}
+		time.Sleep(pulpPollInterval)
+	}
+}
+
+// Commit moves temporary files to the local directory, then publishes its packages
+func (s *PulpStorage) Commit() error {
+	err := s.FileStorage.Commit()
+	if err != nil {
+		return err
+	}
+
+	loggerOrDefault(s.logger).Printf("Publishing to Pulp repository %s...\n", s.name)
+	repository, err := s.repository()
+	if err != nil {
+		return err
+	}
+	packages, err := s.packages(repository)
+	if err != nil {
+		return err
+	}
+
+	// the content of the repository is replaced, Pulp only creates a version if it changed
+	var task pulpTask
+	modify := map[string][]string{"add_content_units": packages, "remove_content_units": {"*"}}
+	err = s.do(http.MethodPost, repository.Href+"modify/", modify, &task, http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+	_, err = s.wait(task)
+	if err != nil {
+		return err
+	}
+	err = s.do(http.MethodGet, repository.Href, nil, &repository, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	publication, err := s.publication(repository.LatestVersion)
+	if err != nil {
+		return err
+	}
+	return s.distribute(publication)
+}
+
+// repository returns the repository named after the repo, creating it if needed
+func (s *PulpStorage) repository() (repository pulpRepository, err error) {
+	href := "/pulp/api/v3/repositories/rpm/rpm/?" + url.Values{"name": {s.name}}.Encode()
+	var page struct {
+		Results []pulpRepository
+	}
+	err = s.do(http.MethodGet, href, nil, &page, http.StatusOK)
+	if err != nil {
+		return
+	}
+	if len(page.Results) > 0 {
+		return page.Results[0], nil
+	}
+	err = s.do(http.MethodPost, "/pulp/api/v3/repositories/rpm/rpm/", map[string]string{"name": s.name}, &repository, http.StatusCreated)
+	return
+}
+
+// packages returns the hrefs of the packages in the local directory, uploading those Pulp does not know
+func (s *PulpStorage) packages(repository pulpRepository) ([]string, error) {
+	known := map[string]string{}
+	if repository.LatestVersion != "" {
+		query := url.Values{"repository_version": {repository.LatestVersion}, "fields": {"pulp_href,sha256"}, "limit": {"1000"}}
+		current, err := s.list("/pulp/api/v3/content/rpm/packages/?" + query.Encode())
+		if err != nil {
+			return nil, err
+		}
+		for _, pack := range current {
+			known[pack.SHA256] = pack.Href
+		}
+	}
+
+	hrefs := []string{}
+	err := filepath.WalkDir(s.directory, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !isPackage(name) {
+			return err
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		checksum, err := util.Checksum(f, crypto.SHA256)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		href, ok := known[checksum]
+		if !ok {
+			href, err = s.upload(name, checksum)
+			if err != nil {
+				return err
+			}
+		}
+		hrefs = append(hrefs, href)
+		return nil
+	})
+	return hrefs, err
+}
+
+// upload returns the href of a package with checksum, uploading file unless Pulp already has it from
+// another repository
+func (s *PulpStorage) upload(file string, checksum string) (string, error) {
+	existing, err := s.list("/pulp/api/v3/content/rpm/packages/?" + url.Values{"sha256": {checksum}, "fields": {"pulp_href"}}.Encode())
+	if err != nil {
+		return "", err
+	}
+	if len(existing) > 0 {
+		return existing[0].Href, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	pipeReader, pipeWriter := io.Pipe()
+	writer := multipart.NewWriter(pipeWriter)
+	go func() {
+		part, err := writer.CreateFormFile("file", path.Base(filepath.ToSlash(file)))
+		if err == nil {
+			_, err = io.Copy(part, f)
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		pipeWriter.CloseWithError(err)
+	}()
+
+	var task pulpTask
+	err = s.send(http.MethodPost, "/pulp/api/v3/content/rpm/packages/", pipeReader, writer.FormDataContentType(), &task, http.StatusAccepted)
+	// unblock the writer if the upload stopped early
+	pipeReader.Close()
+	if err != nil {
+		return "", fmt.Errorf("cannot upload %s: %v", file, err)
+	}
+	created, err := s.wait(task)
+	if err != nil {
+		return "", fmt.Errorf("cannot upload %s: %v", file, err)
+	}
+	if len(created) == 0 {
+		return "", fmt.Errorf("cannot upload %s: no package created", file)
+	}
+	return created[0], nil
+}
+
+// publication returns a publication of a repository version, creating it if needed
+func (s *PulpStorage) publication(version string) (string, error) {
+	existing, err := s.list("/pulp/api/v3/publications/rpm/rpm/?" + url.Values{"repository_version": {version}}.Encode())
+	if err != nil {
+		return "", err
+	}
+	if len(existing) > 0 {
+		return existing[0].Href, nil
+	}
+
+	var task pulpTask
+	err = s.do(http.MethodPost, "/pulp/api/v3/publications/rpm/rpm/", map[string]string{"repository_version": version}, &task, http.StatusAccepted)
+	if err != nil {
+		return "", err
+	}
+	created, err := s.wait(task)
+	if err != nil {
+		return "", err
+	}
+	if len(created) == 0 {
+		return "", fmt.Errorf("no publication created for %s", version)
+	}
+	return created[0], nil
+}
+
+// distribute serves a publication at the distribution named after the repo, creating it if needed
+func (s *PulpStorage) distribute(publication string) error {
+	existing, err := s.list("/pulp/api/v3/distributions/rpm/rpm/?" + url.Values{"name": {s.name}}.Encode())
+	if err != nil {
+		return err
+	}
+
+	var task pulpTask
+	switch {
+	case len(existing) == 0:
+		distribution := map[string]string{"name": s.name, "base_path": s.name, "publication": publication}
+		err = s.do(http.MethodPost, "/pulp/api/v3/distributions/rpm/rpm/", distribution, &task, http.StatusAccepted)
+	case existing[0].Publication != publication:
+		err = s.do(http.MethodPatch, existing[0].Href, map[string]string{"publication": publication}, &task, http.StatusAccepted)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = s.wait(task)
+	return err
+}
@@ -0,0 +1,166 @@
+package get
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePulp implements the subset of the Pulp 3 API used by PulpStorage for a single repository, with
+// tasks completing immediately
+type fakePulp struct {
+	repository   string
+	versions     [][]string
+	packages     map[string]string
+	publications map[string]string
+	distribution map[string]string
+	tasks        [][]string
+	// names of uploaded files
+	uploads []string
+}
+
+const fakePulpRepository = "/pulp/api/v3/repositories/rpm/rpm/1/"
+
+func (f *fakePulp) task(w http.ResponseWriter, created ...string) {
+	f.tasks = append(f.tasks, created)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"task": fmt.Sprintf("/pulp/api/v3/tasks/%d/", len(f.tasks)-1)})
+}
+
+func (f *fakePulp) version() string {
+	return fmt.Sprintf("%sversions/%d/", fakePulpRepository, len(f.versions)-1)
+}
+
+func (f *fakePulp) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	results := []map[string]string{}
+	switch r.Method + " " + r.URL.Path {
+	case "GET /pulp/api/v3/repositories/rpm/rpm/":
+		if f.repository == query.Get("name") {
+			results = append(results, map[string]string{"pulp_href": fakePulpRepository, "latest_version_href": f.version()})
+		}
+	case "POST /pulp/api/v3/repositories/rpm/rpm/":
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		f.repository = body["name"]
+		f.versions = [][]string{{}}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"pulp_href": fakePulpRepository, "latest_version_href": f.version()})
+		return
+	case "GET " + fakePulpRepository:
+		json.NewEncoder(w).Encode(map[string]string{"pulp_href": fakePulpRepository, "latest_version_href": f.version()})
+		return
+	case "POST " + fakePulpRepository + "modify/":
+		var body map[string][]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if strings.Join(body["add_content_units"], ",") != strings.Join(f.versions[len(f.versions)-1], ",") {
+			f.versions = append(f.versions, body["add_content_units"])
+		}
+		f.task(w)
+		return
+	case "GET /pulp/api/v3/content/rpm/packages/":
+		for checksum, href := range f.packages {
+			version := query.Get("repository_version")
+			if checksum == query.Get("sha256") || version == f.version() && strings.Contains(strings.Join(f.versions[len(f.versions)-1], ","), href) {
+				results = append(results, map[string]string{"pulp_href": href, "sha256": checksum})
+			}
+		}
+	case "POST /pulp/api/v3/content/rpm/packages/":
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		content, _ := io.ReadAll(file)
+		sum := sha256.Sum256(content)
+		href := fmt.Sprintf("/pulp/api/v3/content/rpm/packages/%d/", len(f.packages))
+		f.packages[hex.EncodeToString(sum[:])] = href
+		f.uploads = append(f.uploads, header.Filename)
+		f.task(w, href)
+		return
+	case "GET /pulp/api/v3/publications/rpm/rpm/":
+		if href, ok := f.publications[query.Get("repository_version")]; ok {
+			results = append(results, map[string]string{"pulp_href": href})
+		}
+	case "POST /pulp/api/v3/publications/rpm/rpm/":
+		href := fmt.Sprintf("/pulp/api/v3/publications/rpm/rpm/%d/", len(f.publications))
+		f.publications[f.version()] = href
+		f.task(w, href)
+		return
+	case "GET /pulp/api/v3/distributions/rpm/rpm/":
+		if f.distribution != nil && f.distribution["name"] == query.Get("name") {
+			results = append(results, map[string]string{"pulp_href": "/pulp/api/v3/distributions/rpm/rpm/1/", "publication": f.distribution["publication"]})
+		}
+	case "POST /pulp/api/v3/distributions/rpm/rpm/", "PATCH /pulp/api/v3/distributions/rpm/rpm/1/":
+		if f.distribution == nil {
+			f.distribution = map[string]string{}
+		}
+		json.NewDecoder(r.Body).Decode(&f.distribution)
+		f.task(w)
+		return
+	default:
+		var i int
+		if _, err := fmt.Sscanf(r.URL.Path, "/pulp/api/v3/tasks/%d/", &i); err == nil && r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(map[string]any{"state": "completed", "created_resources": f.tasks[i]})
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"count": len(results), "next": nil, "results": results})
+}
+
+func TestPulpStorage(t *testing.T) {
+	shared := sha256.Sum256([]byte("shared"))
+	f := &fakePulp{
+		// shared.rpm is already known from another repository
+		packages:     map[string]string{hex.EncodeToString(shared[:]): "/pulp/api/v3/content/rpm/packages/shared/"},
+		publications: map[string]string{},
+	}
+	server := httptest.NewServer(f)
+	defer server.Close()
+
+	storage, err := NewPulpStorage(StorageConfig{Endpoint: server.URL, Path: t.TempDir()}, "SLES/15")
+	assert.NoError(t, err)
+	assert.NoError(t, storeBytes(storage, "repodata/repomd.xml", []byte("repomd")))
+	assert.NoError(t, storeBytes(storage, "Packages/shared.rpm", []byte("shared")))
+	assert.NoError(t, storeBytes(storage, "Packages/old.rpm", []byte("old")))
+	assert.NoError(t, storage.Commit())
+
+	assert.Equal(t, "SLES/15", f.repository)
+	assert.Equal(t, []string{"old.rpm"}, f.uploads)
+	assert.Len(t, f.versions[1], 2)
+	assert.Equal(t, map[string]string{"name": "SLES/15", "base_path": "SLES/15", "publication": "/pulp/api/v3/publications/rpm/rpm/0/"}, f.distribution)
+
+	// packages of the current version are not uploaded again, removed ones are dropped from the repository
+	assert.NoError(t, storage.Recycle("Packages/shared.rpm"))
+	assert.NoError(t, storeBytes(storage, "Packages/new.rpm", []byte("new")))
+	assert.NoError(t, storage.Commit())
+	assert.Equal(t, []string{"old.rpm", "new.rpm"}, f.uploads)
+	assert.ElementsMatch(t, []string{"/pulp/api/v3/content/rpm/packages/2/", "/pulp/api/v3/content/rpm/packages/shared/"}, f.versions[2])
+	assert.Equal(t, "/pulp/api/v3/publications/rpm/rpm/1/", f.distribution["publication"])
+
+	// unchanged repositories are not published again
+	assert.NoError(t, storage.Recycle("Packages/shared.rpm"))
+	assert.NoError(t, storage.Recycle("Packages/new.rpm"))
+	assert.NoError(t, storage.Commit())
+	assert.Len(t, f.versions, 3)
+	assert.Len(t, f.publications, 2)
+}
+
+func TestPulpStorageFileOptions(t *testing.T) {
+	storage, err := NewPulpStorage(StorageConfig{Endpoint: "https://pulp.example.com", Path: t.TempDir(), BufferSize: "4M", Fsync: FsyncCommit}, "SLES/15")
+	assert.NoError(t, err)
+	assert.Equal(t, FileStorageOptions{BufferSize: 4 * 1024 * 1024, Fsync: FsyncCommit}, storage.(*PulpStorage).options)
+
+	_, err = NewPulpStorage(StorageConfig{Endpoint: "https://pulp.example.com", Path: t.TempDir(), Fsync: "always"}, "SLES/15")
+	assert.EqualError(t, err, "unsupported fsync policy always")
+}
@@ -0,0 +1,121 @@
+package get
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/uyuni-project/minima/util"
+)
+
+// QuarantineReport describes a file that failed verification, saved next to its copy in the quarantine
+// directory with a .json extension
+type QuarantineReport struct {
+	// Repo is the URL of the repo, without tokens and credentials
+	Repo string `json:"repo"`
+	// File is the path of the file in the repo
+	File string `json:"file"`
+	// Checksum is the expected checksum, if any
+	Checksum string `json:"checksum,omitempty"`
+	// Error is the reason the file was refused
+	Error string    `json:"error"`
+	Time  time.Time `json:"time"`
+}
+
+// quarantineCopy keeps a local copy of a download in the quarantine directory until it is verified
+type quarantineCopy struct {
+	syncer *Syncer
+	local  *os.File
+	report QuarantineReport
+}
+
+// quarantining returns a reader of body also writing a copy of it to QuarantineDir, if set. The copy is
+// moved into quarantine or removed by finish. body is closed on errors
+func (r *Syncer) quarantining(body io.ReadCloser, file string, checksum string) (io.ReadCloser, *quarantineCopy, error) {
+	if r.QuarantineDir == "" {
+		return body, nil, nil
+	}
+	err := os.MkdirAll(r.QuarantineDir, 0700)
+	if err != nil {
+		body.Close()
+		return nil, nil, err
+	}
+	local, err := os.CreateTemp(r.QuarantineDir, ".download-*")
+	if err != nil {
+		body.Close()
+		return nil, nil, err
+	}
+	report := QuarantineReport{Repo: eventRepo(r.URL), File: file, Checksum: checksum}
+	return util.NewTeeReadCloser(body, local), &quarantineCopy{r, local, report}, nil
+}
+
+// finish quarantines the copy if err signals a verification failure, and removes it otherwise
+func (c *quarantineCopy) finish(err error) {
+	if c == nil {
+		return
+	}
+	c.local.Close()
+	if verificationError(err) {
+		c.syncer.quarantine(c.local.Name(), c.report, err)
+	}
+	os.Remove(c.local.Name())
+}
+
+// quarantiningReader finishes its quarantineCopy when closed, with the error of verification on Close
+type quarantiningReader struct {
+	io.ReadCloser
+	copy *quarantineCopy
+}
+
+func (r *quarantiningReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.copy.finish(err)
+	return err
+}
+
+// quarantineBytes moves content, verified in memory, into quarantine if QuarantineDir is set
+func (r *Syncer) quarantineBytes(file string, content []byte, err error) {
+	if r.QuarantineDir == "" {
+		return
+	}
+	body, quarantined, qerr := r.quarantining(io.NopCloser(bytes.NewReader(content)), file, "")
+	if qerr != nil {
+		r.logger().Printf("Cannot quarantine %s: %v\n", file, qerr)
+		return
+	}
+	io.Copy(io.Discard, body)
+	body.Close()
+	quarantined.finish(err)
+}
+
+// quarantine moves the local copy of a file failing verification with err into QuarantineDir, named after
+// the time and its name, like 20240102T150405Z-1234-perseus-dummy-1.1-1.1.x86_64.rpm, and writes the
+// report next to it
+func (r *Syncer) quarantine(local string, report QuarantineReport, err error) {
+	report.Error = err.Error()
+	report.Time = time.Now().UTC()
+	quarantined, err := func() (string, error) {
+		named, err := os.CreateTemp(r.QuarantineDir, report.Time.Format("20060102T150405Z")+"-*-"+path.Base(report.File))
+		if err != nil {
+			return "", err
+		}
+		named.Close()
+		err = os.Rename(local, named.Name())
+		if err != nil {
+			return "", err
+		}
+		content, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return named.Name(), os.WriteFile(named.Name()+".json", append(content, '\n'), 0600)
+	}()
+	if err != nil {
+		r.logger().Printf("Cannot quarantine %s: %v\n", report.File, err)
+		return
+	}
+	r.logger().Printf("%s failed verification, quarantined as %s\n", report.File, quarantined)
+}
@@ -0,0 +1,56 @@
+package get
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuarantine(t *testing.T) {
+	corrupted := false
+	fileServer := http.FileServer(http.Dir("testdata"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first download is tampered with
+		if !corrupted && strings.HasSuffix(r.URL.Path, "milkyway-dummy-2.0-1.1.x86_64.rpm") {
+			corrupted = true
+			w.Write([]byte("corrupt"))
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	quarantineDir := t.TempDir()
+	repoURL, err := url.Parse(server.URL + "/repo?token")
+	assert.NoError(t, err)
+	syncer := NewSyncer(*repoURL, map[string]bool{"x86_64": true}, NewFileStorage(t.TempDir()), true)
+	syncer.QuarantineDir = quarantineDir
+	assert.NoError(t, syncer.StoreRepo())
+
+	// only the failed download is kept, with its report
+	entries, err := os.ReadDir(quarantineDir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	quarantined := filepath.Join(quarantineDir, entries[0].Name())
+	assert.True(t, strings.HasSuffix(quarantined, "-milkyway-dummy-2.0-1.1.x86_64.rpm"))
+	content, err := os.ReadFile(quarantined)
+	assert.NoError(t, err)
+	assert.Equal(t, "corrupt", string(content))
+
+	content, err = os.ReadFile(quarantined + ".json")
+	assert.NoError(t, err)
+	report := QuarantineReport{}
+	assert.NoError(t, json.Unmarshal(content, &report))
+	assert.Equal(t, server.URL+"/repo", report.Repo)
+	assert.Equal(t, "x86_64/milkyway-dummy-2.0-1.1.x86_64.rpm", report.File)
+	assert.NotEmpty(t, report.Checksum)
+	assert.Contains(t, report.Error, "Checksum mismatch")
+	assert.False(t, report.Time.IsZero())
+}
@@ -0,0 +1,111 @@
+package get
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Policies for repos exceeding MaxSize
+const (
+	// QuotaFail fails the sync
+	QuotaFail = "fail"
+	// QuotaEvict does not mirror the oldest packages having newer versions, then fails if that is not enough
+	QuotaEvict = "evict"
+)
+
+// sizeSuffixes are the multipliers of sizes accepted by ParseSize
+var sizeSuffixes = map[string]int64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+
+// ParseSize parses a positive size in bytes, with an optional K, M, G or T suffix for KiB, MiB, GiB or TiB
+func ParseSize(size string) (int64, error) {
+	multiplier := int64(1)
+	number := size
+	if size != "" {
+		if m, ok := sizeSuffixes[strings.ToUpper(size[len(size)-1:])]; ok {
+			multiplier = m
+			number = size[:len(size)-1]
+		}
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %s", size)
+	}
+	return n * multiplier, nil
+}
+
+// ValidateQuotaPolicy checks that policy is empty, for QuotaFail, or one of the policies
+func ValidateQuotaPolicy(policy string) error {
+	if policy != "" && policy != QuotaFail && policy != QuotaEvict {
+		return fmt.Errorf("unsupported quota policy %s", policy)
+	}
+	return nil
+}
+
+// QuotaError is returned if the packages of a repo exceed its MaxSize
+type QuotaError struct {
+	reason string
+}
+
+func (e *QuotaError) Error() string {
+	return e.reason
+}
+
+// evicting reports whether packages may be evicted, which requires regenerating metadata
+func (r *Syncer) evicting() bool {
+	return r.MaxSize > 0 && r.QuotaPolicy == QuotaEvict
+}
+
+// applyQuota checks that the size of packages does not exceed MaxSize, evicting packages with the
+// QuotaEvict policy. Versions of a package are ordered by build time, the newest one is never evicted
+func (r *Syncer) applyQuota(packages []XMLPackage) ([]XMLPackage, error) {
+	if r.MaxSize <= 0 {
+		return packages, nil
+	}
+	total := int64(0)
+	for _, pack := range packages {
+		total += pack.Size.Package
+	}
+	if total <= r.MaxSize {
+		return packages, nil
+	}
+	if !r.evicting() {
+		return nil, &QuotaError{fmt.Sprintf("packages take %d bytes, more than max_size %d", total, r.MaxSize)}
+	}
+
+	latest := map[string]int64{}
+	for _, pack := range packages {
+		key := pack.Name + "." + pack.Arch
+		latest[key] = max(latest[key], pack.Time.Build)
+	}
+	candidates := []int{}
+	for i, pack := range packages {
+		if pack.Time.Build > 0 && pack.Time.Build < latest[pack.Name+"."+pack.Arch] {
+			candidates = append(candidates, i)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return packages[candidates[i]].Time.Build < packages[candidates[j]].Time.Build
+	})
+
+	evicted := map[int]bool{}
+	for _, i := range candidates {
+		if total <= r.MaxSize {
+			break
+		}
+		evicted[i] = true
+		total -= packages[i].Size.Package
+		r.logger().Printf("Evicting %s to stay under max_size\n", packages[i].Location.Href)
+	}
+	if total > r.MaxSize {
+		return nil, &QuotaError{fmt.Sprintf("packages take %d bytes after evicting %d old versions, more than max_size %d", total, len(evicted), r.MaxSize)}
+	}
+	kept := []XMLPackage{}
+	for i, pack := range packages {
+		if !evicted[i] {
+			kept = append(kept, pack)
+		}
+	}
+	return kept, nil
+}
@@ -0,0 +1,68 @@
+package get
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSize(t *testing.T) {
+	for size, want := range map[string]int64{"1024": 1024, "4k": 4096, "50M": 50 << 20, "2G": 2 << 30, "1T": 1 << 40} {
+		got, err := ParseSize(size)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got, size)
+	}
+	for _, size := range []string{"", "G", "-1G", "1.5G", "1P"} {
+		_, err := ParseSize(size)
+		assert.EqualError(t, err, "invalid size "+size)
+	}
+}
+
+func TestApplyQuota(t *testing.T) {
+	pack := func(name string, build int64, size int64) XMLPackage {
+		return XMLPackage{Name: name, Arch: "x86_64", Location: XMLLocation{Href: fmt.Sprintf("%s-%d.rpm", name, build)}, Time: XMLTime{Build: build}, Size: XMLSize{Package: size}}
+	}
+	packages := []XMLPackage{pack("a", 1, 100), pack("a", 3, 100), pack("a", 2, 100), pack("b", 1, 100)}
+
+	syncer := &Syncer{MaxSize: 400}
+	kept, err := syncer.applyQuota(packages)
+	assert.NoError(t, err)
+	assert.Equal(t, packages, kept)
+
+	syncer.MaxSize = 300
+	_, err = syncer.applyQuota(packages)
+	assert.EqualError(t, err, "packages take 400 bytes, more than max_size 300")
+	assert.IsType(t, &QuotaError{}, err)
+
+	// the oldest version of a is evicted first, the latest versions never are
+	syncer.QuotaPolicy = QuotaEvict
+	kept, err = syncer.applyQuota(packages)
+	assert.NoError(t, err)
+	assert.Equal(t, []XMLPackage{packages[1], packages[2], packages[3]}, kept)
+
+	syncer.MaxSize = 200
+	kept, err = syncer.applyQuota(packages)
+	assert.NoError(t, err)
+	assert.Equal(t, []XMLPackage{packages[1], packages[3]}, kept)
+
+	syncer.MaxSize = 100
+	_, err = syncer.applyQuota(packages)
+	assert.EqualError(t, err, "packages take 200 bytes after evicting 2 old versions, more than max_size 100")
+}
+
+func TestStoreRepoMaxSize(t *testing.T) {
+	repoURL, err := url.Parse("http://localhost:8080/repo")
+	assert.NoError(t, err)
+	syncer := NewSyncer(*repoURL, map[string]bool{"x86_64": true}, NewFileStorage(t.TempDir()), true)
+	syncer.MaxSize = 200 << 10
+	assert.NoError(t, syncer.StoreRepo())
+
+	// without older versions to evict, the sync fails without falling back to a Debian repo
+	syncer.MaxSize = 100 << 10
+	syncer.QuotaPolicy = QuotaEvict
+	err = syncer.StoreRepo()
+	assert.IsType(t, &QuotaError{}, err)
+	assert.ErrorContains(t, err, "after evicting 0 old versions, more than max_size 102400")
+}
@@ -0,0 +1,65 @@
+package get
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// ValidateMetadataCompression checks that compType is supported by RecompressMetadata
+func ValidateMetadataCompression(compType string) error {
+	switch compType {
+	case "", "gz", "zst":
+		return nil
+	}
+	return fmt.Errorf("unsupported recompress_metadata %s", compType)
+}
+
+// metadataCompression returns the compression a metadata file is stored with: RecompressMetadata, if set and
+// the file is compressed with gzip or zstd, unless its type names the compression, like group_gz
+func (r *Syncer) metadataCompression(entry XMLData) string {
+	compType := strings.Trim(path.Ext(entry.Location.Href), ".")
+	if r.RecompressMetadata == "" || compType != "gz" && compType != "zst" || strings.HasSuffix(entry.Type, "_"+compType) {
+		return compType
+	}
+	return r.RecompressMetadata
+}
+
+// recompresses reports whether a metadata file is stored with a different compression than upstream's
+func (r *Syncer) recompresses(entry XMLData) bool {
+	return r.metadataCompression(entry) != strings.Trim(path.Ext(entry.Location.Href), ".")
+}
+
+// recompress downloads a metadata file and stores it with RecompressMetadata, returning its repomd entry
+func (r *Syncer) recompress(ctx context.Context, entry XMLData) (repomdData, error) {
+	reader, err := r.downloadVerified(ctx, entry.Location.Href, entry.Checksum)
+	if err != nil {
+		return repomdData{}, err
+	}
+	uncompressed, err := decompress(reader, strings.Trim(path.Ext(entry.Location.Href), "."))
+	if err != nil {
+		reader.Close()
+		return repomdData{}, err
+	}
+	return storeMetadata(r.storage, path.Dir(entry.Location.Href), entry.Type, r.metadataCompression(entry), func(writer io.Writer) error {
+		_, err := io.Copy(writer, uncompressed)
+		uncompressed.Close()
+		// checks the checksum before the file is stored
+		closeErr := reader.Close()
+		if err != nil {
+			return err
+		}
+		return closeErr
+	})
+}
+
+// replaceData replaces the <data> entries of a repomd document with the ones of the same type in entries
+func (d *repomdDocument) replaceData(entries map[string]repomdData) {
+	for i, data := range d.Data {
+		if entry, ok := entries[data.Type]; ok {
+			d.Data[i] = entry
+		}
+	}
+}
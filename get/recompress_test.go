@@ -0,0 +1,42 @@
+package get
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreRepoRecompressMetadata(t *testing.T) {
+	directory := filepath.Join(t.TempDir(), "repo")
+	repoURL, err := url.Parse("http://localhost:8080/repo")
+	assert.NoError(t, err)
+	syncer := NewSyncer(*repoURL, map[string]bool{"x86_64": true}, NewFileStorage(directory), true)
+	syncer.RecompressMetadata = "zst"
+	assert.NoError(t, syncer.StoreRepo())
+	// packages are recycled by the checksums of recompressed primary
+	assert.NoError(t, syncer.StoreRepo())
+
+	b, err := os.ReadFile(filepath.Join(directory, "repodata", "repomd.xml"))
+	assert.NoError(t, err)
+	document, err := parseRepomd(b)
+	assert.NoError(t, err)
+	assert.Len(t, document.Data, 4)
+	for _, data := range document.Data {
+		assert.True(t, strings.HasSuffix(data.Location.Href, "-"+data.Type+".xml.zst"), data.Location.Href)
+		content, err := os.ReadFile(filepath.Join(directory, filepath.FromSlash(data.Location.Href)))
+		assert.NoError(t, err)
+		sum := sha256.Sum256(content)
+		assert.Equal(t, hex.EncodeToString(sum[:]), data.Checksum.Checksum)
+	}
+	_, err = os.Stat(filepath.Join(directory, "x86_64", "orion-dummy-1.1-1.1.x86_64.rpm"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, ValidateMetadataCompression("gz"))
+	assert.EqualError(t, ValidateMetadataCompression("xz"), "unsupported recompress_metadata xz")
+}
@@ -0,0 +1,34 @@
+package get
+
+import (
+	"os"
+	"syscall"
+)
+
+// ficlone is the ioctl of Linux cloning a file, see ioctl_ficlone(2)
+const ficlone = 0x40049409
+
+// reflink creates target as a copy-on-write clone of source, sharing its extents on btrfs, XFS and
+// other filesystems supporting it. It returns errReflinkUnsupported if the filesystem does not
+func reflink(source string, target string) error {
+	from, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+	to, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, to.Fd(), ficlone, from.Fd())
+	closeErr := to.Close()
+	if errno != 0 {
+		os.Remove(target)
+		switch errno {
+		case syscall.EOPNOTSUPP, syscall.EXDEV, syscall.EINVAL, syscall.ENOTTY, syscall.ENOSYS:
+			return errReflinkUnsupported
+		}
+		return &os.PathError{Op: "ficlone", Path: target, Err: errno}
+	}
+	return closeErr
+}
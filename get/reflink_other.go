@@ -0,0 +1,8 @@
+//go:build !linux
+
+package get
+
+// reflink creates target as a copy-on-write clone of source. Only supported on Linux
+func reflink(source string, target string) error {
+	return errReflinkUnsupported
+}
@@ -0,0 +1,132 @@
+package get
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// regeneratedTypes lists the repomd <data> types, besides primary, listing <package pkgid="..."> entries
+// that are rewritten when regenerating metadata
+var regeneratedTypes = map[string]bool{
+	"filelists":     true,
+	"filelists_ext": true,
+	"other":         true,
+	"susedata":      true,
+}
+
+// regeneration keeps track of metadata files rewritten to only list the packages selected for mirroring
+type regeneration struct {
+	// pkgids of the selected packages
+	pkgids map[string]bool
+	// count of the selected packages
+	count int
+	// rewritten repomd entries by type
+	entries map[string]repomdData
+	// locations of sharded packages by pkgid, see ShardPackages
+	locations map[string]string
+}
+
+// regeneratePrimary downloads and filters primary metadata, returning the list of packages to download and
+// recycle. If filtering excluded any package, primary is rewritten and a regeneration is returned to take care
+// of the remaining metadata files, otherwise primary is stored as-is and the returned regeneration is nil
+func (r *Syncer) regeneratePrimary(ctx context.Context, entry XMLData, checksumMap map[string]XMLChecksum, repoType RepoType) (regenerated *regeneration, packagesToDownload []XMLPackage, packagesToRecycle []XMLPackage, err error) {
+	b, err := r.downloadVerifiedAll(ctx, entry.Location.Href, entry.Checksum)
+	if err != nil {
+		return
+	}
+
+	compType := strings.Trim(filepath.Ext(entry.Location.Href), ".")
+	primary, err := repoType.DecodePackages(bytes.NewReader(b), compType)
+	if err != nil {
+		return
+	}
+
+	packagesToDownload, packagesToRecycle, selected, err := r.selectPackages(primary, checksumMap, repoType)
+	if err != nil {
+		return
+	}
+	if len(selected) == len(primary.Packages) && !r.recompresses(entry) && !r.shards(repoType) {
+		err = storeBytes(r.storage, entry.Location.Href, b)
+		return
+	}
+
+	regenerated = &regeneration{
+		pkgids:    map[string]bool{},
+		count:     len(selected),
+		entries:   map[string]repomdData{},
+		locations: map[string]string{},
+	}
+	for _, pack := range selected {
+		regenerated.pkgids[pack.Checksum.Checksum] = true
+		if r.shards(repoType) {
+			regenerated.locations[pack.Checksum.Checksum] = shardedLocation(pack)
+		}
+	}
+	err = regenerated.write(r, entry, bytes.NewReader(b))
+	return
+}
+
+// affects reports whether a repomd <data> type is rewritten or dropped when regenerating metadata
+func (g *regeneration) affects(dataType string) bool {
+	base := strings.TrimSuffix(strings.TrimSuffix(dataType, "_db"), "_zck")
+	return base == repoTypes["rpm"].PackagesType || regeneratedTypes[base]
+}
+
+// regenerate rewrites a metadata file other than primary. sqlite and zchunk variants cannot be
+// regenerated and are dropped
+func (g *regeneration) regenerate(ctx context.Context, r *Syncer, entry XMLData) error {
+	if !regeneratedTypes[entry.Type] {
+		return nil
+	}
+
+	reader, err := r.downloadVerified(ctx, entry.Location.Href, entry.Checksum)
+	if err != nil {
+		return err
+	}
+	err = g.write(r, entry, reader)
+	if err != nil {
+		reader.Close()
+		return err
+	}
+	return reader.Close()
+}
+
+// write filters a compressed metadata file, stores the result next to the original location and
+// records its repomd entry
+func (g *regeneration) write(r *Syncer, entry XMLData, reader io.Reader) error {
+	compType := strings.Trim(filepath.Ext(entry.Location.Href), ".")
+	uncompressed, err := decompress(reader, compType)
+	if err != nil {
+		return err
+	}
+	defer uncompressed.Close()
+
+	data, err := storeMetadata(r.storage, path.Dir(entry.Location.Href), entry.Type, r.metadataCompression(entry), func(writer io.Writer) error {
+		return writeMetadata(writer, []io.Reader{uncompressed}, "package", relocate(g.locations, keepPackages(g.pkgids)), g.count)
+	})
+	if err != nil {
+		return err
+	}
+	g.entries[entry.Type] = data
+	return nil
+}
+
+// update replaces regenerated entries in a repomd document, dropping the ones that could not be regenerated
+func (g *regeneration) update(document *repomdDocument) {
+	data := document.Data[:0]
+	for _, entry := range document.Data {
+		if g.affects(entry.Type) {
+			regenerated, ok := g.entries[entry.Type]
+			if !ok {
+				continue
+			}
+			entry = regenerated
+		}
+		data = append(data, entry)
+	}
+	document.Data = data
+}
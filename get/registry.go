@@ -0,0 +1,135 @@
+package get
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// StorageRepo describes the repo a Storage is created for
+type StorageRepo struct {
+	// Path of the repo in the storage, see ExpandPathTemplate
+	Path string
+	// Name of the repo
+	Name string
+	// Upstream URL of the repo, comma separated for merged repos
+	Upstream string
+}
+
+// StorageFactory returns the Storage of a repo, as specified by config
+type StorageFactory func(config StorageConfig, repo StorageRepo) (Storage, error)
+
+var (
+	storageFactoriesLock sync.RWMutex
+	storageFactories     = map[string]StorageFactory{}
+)
+
+// RegisterStorage makes a storage type available in the configuration. It allows programs embedding
+// minima to add backends, and panics if name is already registered
+func RegisterStorage(name string, factory StorageFactory) {
+	storageFactoriesLock.Lock()
+	defer storageFactoriesLock.Unlock()
+	if _, ok := storageFactories[name]; ok {
+		panic("storage type " + name + " registered twice")
+	}
+	storageFactories[name] = factory
+}
+
+// StorageTypes returns the names of the registered storage types, sorted
+func StorageTypes() []string {
+	storageFactoriesLock.RLock()
+	defer storageFactoriesLock.RUnlock()
+	names := []string{}
+	for name := range storageFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewStorage returns the Storage of a repo for the type in config, adding SHA256SUMS, metalink and
+// mirrorlist files and an audit log if configured
+func NewStorage(config StorageConfig, repo StorageRepo) (Storage, error) {
+	storageFactoriesLock.RLock()
+	factory, ok := storageFactories[config.Type]
+	storageFactoriesLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unrecognised storage type %s", config.Type)
+	}
+	storage, err := factory(config, repo)
+	if err != nil {
+		return nil, err
+	}
+	// innermost, so that generated files are audited too
+	if config.AuditLog != "" {
+		storage = NewAuditStorage(storage, config.AuditLog, repo)
+	}
+	if config.SHA256Sums {
+		storage = NewChecksumsStorage(storage)
+	}
+	// outermost, so that SHA256SUMS lists metalinks
+	if len(config.Mirrors) > 0 {
+		metalinkStorage, err := NewMetalinkStorage(storage, config.Mirrors, repo.Path)
+		if err != nil {
+			return nil, err
+		}
+		metalinkStorage.FIPS = config.FIPS
+		return metalinkStorage, nil
+	}
+	return storage, nil
+}
+
+func init() {
+	RegisterStorage("file", func(config StorageConfig, repo StorageRepo) (Storage, error) {
+		options, err := fileStorageOptions(config)
+		if err != nil {
+			return nil, err
+		}
+		// absolute, so that paths longer than MAX_PATH work on Windows
+		directory, err := filepath.Abs(filepath.Join(config.Path, filepath.FromSlash(repo.Path)))
+		if err != nil {
+			return nil, err
+		}
+		return &FileStorage{directory: directory, options: options, Upstream: repo.Upstream}, nil
+	})
+	RegisterStorage("s3", func(config StorageConfig, repo StorageRepo) (Storage, error) {
+		storage, err := NewS3Storage(config, repo.Path)
+		if err != nil {
+			return nil, err
+		}
+		storage.Repo = repo.Name
+		storage.Upstream = repo.Upstream
+		return storage, nil
+	})
+	RegisterStorage("b2", func(config StorageConfig, repo StorageRepo) (Storage, error) {
+		storage, err := NewB2Storage(config, repo.Path)
+		if err != nil {
+			return nil, err
+		}
+		storage.Repo = repo.Name
+		storage.Upstream = repo.Upstream
+		return storage, nil
+	})
+	RegisterStorage("azblob", func(config StorageConfig, repo StorageRepo) (Storage, error) {
+		return NewAzureBlobStorage(config, repo.Path)
+	})
+	RegisterStorage("swift", func(config StorageConfig, repo StorageRepo) (Storage, error) {
+		return NewSwiftStorage(config, repo.Path)
+	})
+	RegisterStorage("sftp", func(config StorageConfig, repo StorageRepo) (Storage, error) {
+		return NewSFTPStorage(config, repo.Path)
+	})
+	RegisterStorage("webdav", func(config StorageConfig, repo StorageRepo) (Storage, error) {
+		return NewWebDAVStorage(config, repo.Path)
+	})
+	RegisterStorage("http_put", func(config StorageConfig, repo StorageRepo) (Storage, error) {
+		return NewHTTPPutStorage(config, repo.Path)
+	})
+	RegisterStorage("pulp", func(config StorageConfig, repo StorageRepo) (Storage, error) {
+		return NewPulpStorage(config, repo.Path)
+	})
+	RegisterStorage("rsync", func(config StorageConfig, repo StorageRepo) (Storage, error) {
+		return NewRsyncStorage(config, repo.Path), nil
+	})
+}
@@ -0,0 +1,36 @@
+package get
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterStorage(t *testing.T) {
+	assert.Subset(t, StorageTypes(), []string{"azblob", "b2", "file", "http_put", "pulp", "rsync", "s3", "sftp", "swift", "webdav"})
+
+	var got StorageRepo
+	RegisterStorage("test-registry", func(config StorageConfig, repo StorageRepo) (Storage, error) {
+		got = repo
+		return NewFileStorage(config.Options["directory"] + repo.Path), nil
+	})
+	defer func() {
+		storageFactoriesLock.Lock()
+		delete(storageFactories, "test-registry")
+		storageFactoriesLock.Unlock()
+	}()
+	assert.Contains(t, StorageTypes(), "test-registry")
+
+	repo := StorageRepo{Path: "/repo", Name: "repo", Upstream: "http://test/repo/"}
+	storage, err := NewStorage(StorageConfig{Type: "test-registry", Options: map[string]string{"directory": "/srv"}}, repo)
+	assert.NoError(t, err)
+	assert.Equal(t, &FileStorage{directory: "/srv/repo"}, storage)
+	assert.Equal(t, repo, got)
+
+	assert.Panics(t, func() {
+		RegisterStorage("test-registry", nil)
+	})
+
+	_, err = NewStorage(StorageConfig{Type: "memory"}, repo)
+	assert.EqualError(t, err, "unrecognised storage type memory")
+}
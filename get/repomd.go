@@ -0,0 +1,103 @@
+package get
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+)
+
+const (
+	repoNamespace = "http://linux.duke.edu/metadata/repo"
+	rpmNamespace  = "http://linux.duke.edu/metadata/rpm"
+)
+
+// repomdDocument maps the whole of repodata/repomd.xml, as needed to write it back
+type repomdDocument struct {
+	Revision string       `xml:"revision,omitempty"`
+	Tags     *repomdTags  `xml:"tags"`
+	Data     []repomdData `xml:"data"`
+}
+
+// repomdTags keeps the <tags> tag in repodata/repomd.xml verbatim
+type repomdTags struct {
+	Content string `xml:",innerxml"`
+}
+
+// repomdData maps all known children of a <data> tag in repodata/repomd.xml
+type repomdData struct {
+	Type            string          `xml:"type,attr"`
+	Checksum        repomdChecksum  `xml:"checksum"`
+	OpenChecksum    *repomdChecksum `xml:"open-checksum"`
+	HeaderChecksum  *repomdChecksum `xml:"header-checksum"`
+	Location        XMLLocation     `xml:"location"`
+	Timestamp       int64           `xml:"timestamp,omitempty"`
+	Size            int64           `xml:"size,omitempty"`
+	OpenSize        int64           `xml:"open-size,omitempty"`
+	HeaderSize      int64           `xml:"header-size,omitempty"`
+	DatabaseVersion int             `xml:"database_version,omitempty"`
+}
+
+// repomdChecksum maps a checksum tag in repodata/repomd.xml
+type repomdChecksum struct {
+	Type     string `xml:"type,attr"`
+	Checksum string `xml:",chardata"`
+}
+
+func parseRepomd(b []byte) (document repomdDocument, err error) {
+	err = xml.Unmarshal(b, &document)
+	return
+}
+
+// removeData drops all <data> entries for which the given function returns true,
+// reporting whether any entry was removed
+func (d *repomdDocument) removeData(remove func(dataType string) bool) (removed bool) {
+	kept := d.Data[:0]
+	for _, data := range d.Data {
+		if remove(data.Type) {
+			removed = true
+			continue
+		}
+		kept = append(kept, data)
+	}
+	d.Data = kept
+	return
+}
+
+func (d *repomdDocument) marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<repomd xmlns="` + repoNamespace + `" xmlns:rpm="` + rpmNamespace + `">` + "\n")
+
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent(" ", " ")
+	if d.Revision != "" {
+		if err := encoder.EncodeElement(d.Revision, xml.StartElement{Name: xml.Name{Local: "revision"}}); err != nil {
+			return nil, err
+		}
+	}
+	if d.Tags != nil {
+		if err := encoder.EncodeElement(d.Tags, xml.StartElement{Name: xml.Name{Local: "tags"}}); err != nil {
+			return nil, err
+		}
+	}
+	for _, data := range d.Data {
+		if err := encoder.EncodeElement(data, xml.StartElement{Name: xml.Name{Local: "data"}}); err != nil {
+			return nil, err
+		}
+	}
+	if err := encoder.Flush(); err != nil {
+		return nil, err
+	}
+
+	buf.WriteString("\n</repomd>\n")
+	return buf.Bytes(), nil
+}
+
+// skippedMetadata returns a function matching the repomd <data> types the Syncer was told not to mirror,
+// including their sqlite and zchunk variants
+func (r *Syncer) skippedMetadata() func(dataType string) bool {
+	return func(dataType string) bool {
+		return (r.SkipFilelists && strings.HasPrefix(dataType, "filelists")) ||
+			(r.SkipOther && strings.HasPrefix(dataType, "other"))
+	}
+}
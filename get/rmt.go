@@ -0,0 +1,85 @@
+package get
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"strings"
+)
+
+// RMT defines the configuration to discover repos mirrored by an RMT server, with the credentials of a
+// system registered to it. Repos are selected like in the SCC section
+type RMT struct {
+	// base URL of the server, eg. https://rmt.example.com
+	URL      string
+	Username string
+	Password string
+	// file with username= and password= lines instead, eg. /etc/zypp/credentials.d/SCCcredentials of
+	// the registered system
+	CredentialsFile string `yaml:"credentials_file"`
+	Repositories    []SCCReposConfig
+	SCCFilter       `yaml:",inline"`
+	// path prepended to the storage paths of the repos
+	PathPrefix string `yaml:"path_prefix"`
+}
+
+// Credentials returns the username and password of the registered system, from CredentialsFile if set
+func (r RMT) Credentials() (string, string, error) {
+	return SCC{Username: r.Username, Password: r.Password, CredentialsFile: r.CredentialsFile}.Credentials()
+}
+
+// rmtActivation represents the JSON entry for a product activated on a system, as returned by the
+// SCC-compatible API of RMT
+type rmtActivation struct {
+	Service struct {
+		Product Product
+	}
+}
+
+// RMTToHTTPConfigs returns HTTPS repos configurations for the repos of the products activated on the system
+// of username in the RMT server at baseURL, listed in sccConfigs or selected by filter. Progress is logged
+// to logger, the standard logger if nil
+func RMTToHTTPConfigs(baseURL string, username string, password string, sccConfigs []SCCReposConfig, filter SCCFilter, quiet bool, logger *log.Logger) ([]HTTPRepoConfig, error) {
+	err := filter.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	logger = loggerOrDefault(logger)
+	logger.Println("Checking available RMT repositories ...")
+	repos, products, err := rmtRepos(strings.TrimSuffix(baseURL, "/"), username, password, logger)
+	if err != nil {
+		return nil, err
+	}
+	return selectHTTPConfigs(repos, sccConfigs, filter, filter.excluded(products), quiet, logger), nil
+}
+
+// rmtRepos returns the repos of all products activated on the system of username, once each, and the
+// products themselves
+func rmtRepos(baseURL string, username string, password string, logger *log.Logger) ([]Repo, []Product, error) {
+	token := base64.URLEncoding.EncodeToString([]byte(username + ":" + password))
+	var activations []rmtActivation
+	err := downloadAllPages(baseURL+"/connect/systems/activations", token, logger, func(page []byte) error {
+		var pageActivations []rmtActivation
+		err := json.Unmarshal(page, &pageActivations)
+		activations = append(activations, pageActivations...)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	repos := []Repo{}
+	products := []Product{}
+	seen := map[string]bool{}
+	for _, activation := range activations {
+		products = append(products, activation.Service.Product)
+		for _, repo := range activation.Service.Product.Repositories {
+			if !seen[repo.URL] {
+				seen[repo.URL] = true
+				repos = append(repos, repo)
+			}
+		}
+	}
+	return repos, products, nil
+}
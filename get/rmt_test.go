@@ -0,0 +1,47 @@
+package get
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRMTToHTTPConfigs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		if r.URL.Path != "/connect/systems/activations" || user != "SCC_system" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `[
+			{"service": {"product": {"identifier": "SLES", "version": "15.5", "arch": "x86_64", "repositories": [
+				{"url": "https://rmt.example.com/repo/SUSE/Products/SLE-Product-SLES/15-SP5/x86_64/product/", "name": "SLE-Product-SLES15-SP5-Pool", "description": "SLE-Product-SLES15-SP5-Pool for sle-15-x86_64", "distro_target": "sle-15-x86_64", "enabled": true},
+				{"url": "https://rmt.example.com/repo/SUSE/Updates/SLE-Product-SLES/15-SP5/x86_64/update/", "name": "SLE-Product-SLES15-SP5-Updates", "description": "SLE-Product-SLES15-SP5-Updates for sle-15-x86_64", "distro_target": "sle-15-x86_64", "enabled": true}
+			]}}},
+			{"service": {"product": {"identifier": "sle-module-basesystem", "version": "15.5", "arch": "x86_64", "repositories": [
+				{"url": "https://rmt.example.com/repo/SUSE/Products/SLE-Product-SLES/15-SP5/x86_64/product/", "name": "SLE-Product-SLES15-SP5-Pool", "description": "SLE-Product-SLES15-SP5-Pool for sle-15-x86_64", "distro_target": "sle-15-x86_64", "enabled": true},
+				{"url": "https://rmt.example.com/repo/SUSE/Products/SLE-Module-Basesystem/15-SP5/x86_64/product/", "name": "SLE-Module-Basesystem15-SP5-Pool", "description": "SLE-Module-Basesystem15-SP5-Pool for sle-15-x86_64", "distro_target": "sle-15-x86_64", "enabled": true}
+			]}}}
+		]`)
+	}))
+	defer server.Close()
+
+	configs, err := RMTToHTTPConfigs(server.URL+"/", "SCC_system", "secret", nil, SCCFilter{MatchArchs: []string{"x86_64"}, MatchNames: []string{"*-Pool"}}, true, nil)
+	assert.NoError(t, err)
+	assert.Len(t, configs, 2)
+	assert.Equal(t, "https://rmt.example.com/repo/SUSE/Products/SLE-Product-SLES/15-SP5/x86_64/product/", configs[0].URL)
+	assert.Equal(t, "https://rmt.example.com/repo/SUSE/Products/SLE-Module-Basesystem/15-SP5/x86_64/product/", configs[1].URL)
+	assert.Equal(t, []string{"x86_64"}, configs[1].Archs)
+
+	configs, err = RMTToHTTPConfigs(server.URL, "SCC_system", "secret", []SCCReposConfig{{Names: []string{"SLE-Product-SLES15-SP5-Updates"}, Archs: []string{"x86_64"}}}, SCCFilter{}, true, nil)
+	assert.NoError(t, err)
+	assert.Len(t, configs, 1)
+	assert.Equal(t, "SLE-Product-SLES15-SP5-Updates", configs[0].Name)
+
+	_, err = RMTToHTTPConfigs(server.URL, "SCC_system", "wrong", nil, SCCFilter{}, true, nil)
+	var credentialsErr *SCCCredentialsError
+	assert.ErrorAs(t, err, &credentialsErr)
+}
@@ -0,0 +1,216 @@
+package get
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// rpmLeadSize is the size of the obsolete lead preceding the headers of an RPM
+const rpmLeadSize = 96
+
+// rpmMaxHeaderSize bounds the headers read in memory
+const rpmMaxHeaderSize = 64 << 20
+
+// RPM tags of signatures and payload digests, see rpmtag.h
+const (
+	rpmSigTagDSA            = 267
+	rpmSigTagRSA            = 268
+	rpmSigTagPGP            = 1002
+	rpmSigTagGPG            = 1005
+	rpmTagPayloadDigest     = 5092
+	rpmTagPayloadDigestAlgo = 5093
+)
+
+// rpmDigestAlgos maps the OpenPGP hash algorithm IDs of RPMTAG_PAYLOADDIGESTALGO to hashes
+var rpmDigestAlgos = map[uint32]crypto.Hash{
+	2:  crypto.SHA1,
+	8:  crypto.SHA256,
+	9:  crypto.SHA384,
+	10: crypto.SHA512,
+}
+
+var rpmHeaderMagic = []byte{0x8e, 0xad, 0xe8, 0x01}
+
+// PackageSignatureError is returned if an RPM is not signed with any of the keys of a repo
+type PackageSignatureError struct {
+	reason string
+}
+
+func (e *PackageSignatureError) Error() string {
+	return fmt.Sprintf("Package signature error: %s", e.reason)
+}
+
+// rpmHeader is a header structure of an RPM, with its raw bytes as signed
+type rpmHeader struct {
+	raw   []byte
+	index map[uint32]rpmHeaderEntry
+	// offset of the data store in raw
+	dataOffset int
+}
+
+// rpmHeaderEntry is an entry of the index of a header, pointing to its data
+type rpmHeaderEntry struct {
+	dataType uint32
+	offset   uint32
+	count    uint32
+}
+
+// readRPMHeader reads a header structure, its index and data
+func readRPMHeader(reader io.Reader) (*rpmHeader, error) {
+	intro := make([]byte, 16)
+	if _, err := io.ReadFull(reader, intro); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(intro[:4], rpmHeaderMagic) {
+		return nil, errors.New("bad header magic")
+	}
+	count := binary.BigEndian.Uint32(intro[8:12])
+	size := binary.BigEndian.Uint32(intro[12:16])
+	if uint64(count)*16+uint64(size) > rpmMaxHeaderSize {
+		return nil, errors.New("header too big")
+	}
+
+	raw := make([]byte, 16+int(count)*16+int(size))
+	copy(raw, intro)
+	if _, err := io.ReadFull(reader, raw[16:]); err != nil {
+		return nil, err
+	}
+	header := &rpmHeader{raw: raw, index: map[uint32]rpmHeaderEntry{}, dataOffset: 16 + int(count)*16}
+	for i := 0; i < int(count); i++ {
+		entry := raw[16+i*16 : 32+i*16]
+		header.index[binary.BigEndian.Uint32(entry[0:4])] = rpmHeaderEntry{
+			dataType: binary.BigEndian.Uint32(entry[4:8]),
+			offset:   binary.BigEndian.Uint32(entry[8:12]),
+			count:    binary.BigEndian.Uint32(entry[12:16]),
+		}
+	}
+	return header, nil
+}
+
+// data returns the data store of the header
+func (h *rpmHeader) data() []byte {
+	return h.raw[h.dataOffset:]
+}
+
+// binary returns the value of a binary entry, or nil if tag is missing
+func (h *rpmHeader) binary(tag uint32) []byte {
+	entry, ok := h.index[tag]
+	data := h.data()
+	if !ok || entry.dataType != 7 || uint64(entry.offset)+uint64(entry.count) > uint64(len(data)) {
+		return nil
+	}
+	return data[entry.offset : entry.offset+entry.count]
+}
+
+// int32 returns the first value of an int32 entry
+func (h *rpmHeader) int32(tag uint32) (uint32, bool) {
+	entry, ok := h.index[tag]
+	data := h.data()
+	if !ok || entry.dataType != 4 || entry.count == 0 || uint64(entry.offset)+4 > uint64(len(data)) {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(data[entry.offset:]), true
+}
+
+// string returns the first value of a string or string array entry
+func (h *rpmHeader) string(tag uint32) (string, bool) {
+	entry, ok := h.index[tag]
+	data := h.data()
+	if !ok || (entry.dataType != 6 && entry.dataType != 8) || entry.count == 0 || int(entry.offset) >= len(data) {
+		return "", false
+	}
+	value, _, found := strings.Cut(string(data[entry.offset:]), "\x00")
+	return value, found
+}
+
+// verifyRPMSignature reads an RPM and checks that it is signed with one of keys. Header-only signatures
+// of rpm 4 are preferred, the payload then being verified with the digest in the signed header. Packages
+// only signed over header and payload, like those built before rpm 4.14, are verified with that signature.
+// With fips, signatures and digests must use FIPS approved hashes
+func verifyRPMSignature(reader io.Reader, filename string, keys openpgp.EntityList, fips bool) error {
+	invalid := func(err error) error {
+		return &PackageSignatureError{fmt.Sprintf("%s is not a valid RPM: %v", filename, err)}
+	}
+	if _, err := io.CopyN(io.Discard, reader, rpmLeadSize); err != nil {
+		return invalid(err)
+	}
+	signatures, err := readRPMHeader(reader)
+	if err != nil {
+		return invalid(err)
+	}
+	// the signature header is padded to a multiple of 8 bytes
+	if _, err := io.CopyN(io.Discard, reader, int64((8-len(signatures.raw)%8)%8)); err != nil {
+		return invalid(err)
+	}
+	header, err := readRPMHeader(reader)
+	if err != nil {
+		return invalid(err)
+	}
+
+	check := func(signature []byte, signed io.Reader) error {
+		if fipsMode(fips) {
+			err := checkFIPSSignaturePacket(filename, bytes.NewReader(signature))
+			if err != nil {
+				return err
+			}
+		}
+		_, err := openpgp.CheckDetachedSignature(keys, signed, bytes.NewReader(signature), nil)
+		if err != nil {
+			return &PackageSignatureError{fmt.Sprintf("%s signature check failed: %v", filename, err)}
+		}
+		return nil
+	}
+
+	signature := signatures.binary(rpmSigTagRSA)
+	if signature == nil {
+		signature = signatures.binary(rpmSigTagDSA)
+	}
+	if signature != nil {
+		if err := check(signature, bytes.NewReader(header.raw)); err != nil {
+			return err
+		}
+		return verifyRPMPayload(reader, filename, header, fips)
+	}
+
+	signature = signatures.binary(rpmSigTagPGP)
+	if signature == nil {
+		signature = signatures.binary(rpmSigTagGPG)
+	}
+	if signature == nil {
+		return &PackageSignatureError{filename + " is not signed"}
+	}
+	return check(signature, io.MultiReader(bytes.NewReader(header.raw), reader))
+}
+
+// verifyRPMPayload checks the payload of an RPM against the digest in its header
+func verifyRPMPayload(reader io.Reader, filename string, header *rpmHeader, fips bool) error {
+	expected, ok := header.string(rpmTagPayloadDigest)
+	if !ok {
+		return &PackageSignatureError{filename + " only has a header signature and no payload digest"}
+	}
+	algo, ok := header.int32(rpmTagPayloadDigestAlgo)
+	hash := rpmDigestAlgos[algo]
+	if !ok || hash == 0 {
+		return &PackageSignatureError{fmt.Sprintf("%s has an unsupported payload digest algorithm %d", filename, algo)}
+	}
+	if fipsMode(fips) && hash == crypto.SHA1 {
+		return &ChecksumPolicyError{fmt.Sprintf("%s has a SHA-1 payload digest, which is not FIPS approved", filename)}
+	}
+
+	h := hash.New()
+	if _, err := io.Copy(h, reader); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != expected {
+		return &PackageSignatureError{fmt.Sprintf("%s payload does not match the signed header", filename)}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package get
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/stretchr/testify/assert"
+	"github.com/uyuni-project/minima/util"
+)
+
+// testRPMEntry is an entry of a header built by buildRPMHeader
+type testRPMEntry struct {
+	tag      uint32
+	dataType uint32
+	count    uint32
+	data     []byte
+}
+
+// buildRPMHeader returns a header structure with entries
+func buildRPMHeader(entries []testRPMEntry) []byte {
+	index := &bytes.Buffer{}
+	data := &bytes.Buffer{}
+	for _, entry := range entries {
+		binary.Write(index, binary.BigEndian, []uint32{entry.tag, entry.dataType, uint32(data.Len()), entry.count})
+		data.Write(entry.data)
+	}
+	header := append([]byte{}, rpmHeaderMagic...)
+	header = binary.BigEndian.AppendUint32(header, 0)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(entries)))
+	header = binary.BigEndian.AppendUint32(header, uint32(data.Len()))
+	return append(append(header, index.Bytes()...), data.Bytes()...)
+}
+
+// buildRPM returns an RPM with payload, signed by key over the header or, if legacy, over header and payload
+func buildRPM(t *testing.T, key *openpgp.Entity, payload []byte, legacy bool) []byte {
+	digest := sha256.Sum256(payload)
+	header := buildRPMHeader([]testRPMEntry{
+		{rpmTagPayloadDigest, 8, 1, []byte(hex.EncodeToString(digest[:]) + "\x00")},
+		{rpmTagPayloadDigestAlgo, 4, 1, binary.BigEndian.AppendUint32(nil, 8)},
+	})
+
+	signatureEntries := []testRPMEntry{}
+	if key != nil {
+		signature := &bytes.Buffer{}
+		signed := header
+		tag := uint32(rpmSigTagRSA)
+		if legacy {
+			signed = append(append([]byte{}, header...), payload...)
+			tag = rpmSigTagPGP
+		}
+		assert.NoError(t, openpgp.DetachSign(signature, key, bytes.NewReader(signed), nil))
+		signatureEntries = append(signatureEntries, testRPMEntry{tag, 7, uint32(signature.Len()), signature.Bytes()})
+	}
+	signatures := buildRPMHeader(signatureEntries)
+	for len(signatures)%8 != 0 {
+		signatures = append(signatures, 0)
+	}
+
+	rpm := make([]byte, rpmLeadSize)
+	rpm = append(rpm, signatures...)
+	rpm = append(rpm, header...)
+	return append(rpm, payload...)
+}
+
+func TestVerifyRPMSignature(t *testing.T) {
+	key, err := openpgp.NewEntity("minima", "test", "minima@example.com", nil)
+	assert.NoError(t, err)
+	other, err := openpgp.NewEntity("other", "test", "other@example.com", nil)
+	assert.NoError(t, err)
+	keys := openpgp.EntityList{key}
+	payload := []byte("compressed cpio archive")
+
+	for _, legacy := range []bool{false, true} {
+		rpm := buildRPM(t, key, payload, legacy)
+		assert.NoError(t, verifyRPMSignature(bytes.NewReader(rpm), "a.rpm", keys, false))
+
+		tampered := append([]byte{}, rpm...)
+		tampered[len(tampered)-1] = 'X'
+		err = verifyRPMSignature(bytes.NewReader(tampered), "a.rpm", keys, false)
+		assert.IsType(t, &PackageSignatureError{}, err)
+
+		err = verifyRPMSignature(bytes.NewReader(buildRPM(t, other, payload, legacy)), "a.rpm", keys, false)
+		assert.ErrorContains(t, err, "a.rpm signature check failed")
+	}
+
+	rpm := buildRPM(t, key, payload, false)
+	rpm[len(rpm)-1] = 'X'
+	assert.EqualError(t, verifyRPMSignature(bytes.NewReader(rpm), "a.rpm", keys, false), "Package signature error: a.rpm payload does not match the signed header")
+
+	assert.EqualError(t, verifyRPMSignature(bytes.NewReader(buildRPM(t, nil, payload, false)), "a.rpm", keys, false), "Package signature error: a.rpm is not signed")
+	assert.ErrorContains(t, verifyRPMSignature(bytes.NewReader([]byte("not an rpm")), "a.rpm", keys, false), "a.rpm is not a valid RPM")
+}
+
+func TestStoreRepoPackageKeys(t *testing.T) {
+	key, err := openpgp.NewEntity("minima", "test", "minima@example.com", nil)
+	assert.NoError(t, err)
+
+	// testdata packages are not signed with key
+	directory := t.TempDir()
+	repoURL, err := url.Parse("http://localhost:8080/repo")
+	assert.NoError(t, err)
+	syncer := NewSyncer(*repoURL, map[string]bool{"x86_64": true}, NewFileStorage(directory), true)
+	syncer.PackageKeys = openpgp.EntityList{key}
+	err = syncer.StoreRepo()
+	assert.IsType(t, &PackageSignatureError{}, err)
+	assert.ErrorContains(t, err, "signature check failed")
+
+	// nothing was committed, and the rejected package is downloaded again next time
+	_, err = os.Stat(filepath.Join(directory, "repodata", "repomd.xml"))
+	assert.True(t, os.IsNotExist(err))
+	defer os.RemoveAll(directory + "-in-progress")
+	rejected, err := filepath.Glob(filepath.Join(directory+"-in-progress", "*", "*.rpm"))
+	assert.NoError(t, err)
+	assert.Len(t, rejected, 1)
+	f, err := os.Open(rejected[0])
+	assert.NoError(t, err)
+	checksum, err := util.Checksum(f, crypto.SHA256)
+	f.Close()
+	assert.NoError(t, err)
+	location, err := filepath.Rel(directory+"-in-progress", rejected[0])
+	assert.NoError(t, err)
+	assert.Equal(t, Download, syncer.decide(filepath.ToSlash(location), XMLChecksum{Type: "sha256", Checksum: checksum}, map[string]XMLChecksum{}))
+}
@@ -0,0 +1,83 @@
+package get
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// rsyncCommand is the rsync executable pushes are made with
+var rsyncCommand = "rsync"
+
+// RsyncStorage stores data in a local directory like FileStorage, then pushes it with rsync over SSH
+// to remote hosts at every Commit, once the sync completed and checksums were verified
+type RsyncStorage struct {
+	FileStorage
+	// rsync destinations, eg. mirror.example.com:/srv/www/htdocs/repo
+	targets []string
+	// remote shell command, with SSH options
+	shell string
+	// where pushes are logged, the standard logger if nil
+	logger *log.Logger
+}
+
+// NewRsyncStorage returns a new Storage staging repoPath below the configured local path, and pushing
+// it to the same repoPath below each of the configured rsync targets
+func NewRsyncStorage(config StorageConfig, repoPath string) Storage {
+	targets := []string{}
+	for _, target := range config.RsyncTargets {
+		targets = append(targets, path.Join(target, repoPath))
+	}
+
+	shell := []string{"ssh", "-o", "BatchMode=yes"}
+	if config.KeyFile != "" {
+		shell = append(shell, "-i", config.KeyFile)
+	}
+	if config.KnownHostsFile != "" {
+		shell = append(shell, "-o", "UserKnownHostsFile="+config.KnownHostsFile)
+	}
+	if config.Username != "" {
+		shell = append(shell, "-l", config.Username)
+	}
+
+	return &RsyncStorage{
+		FileStorage: FileStorage{directory: filepath.Join(config.Path, filepath.FromSlash(repoPath))},
+		targets:     targets,
+		shell:       strings.Join(shell, " "),
+		logger:      config.Logger,
+	}
+}
+
+// Commit moves temporary files to the local directory, then pushes it to all targets. Pushes to all
+// targets are attempted even if some fail
+func (s *RsyncStorage) Commit() error {
+	err := s.FileStorage.Commit()
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+	for _, target := range s.targets {
+		loggerOrDefault(s.logger).Printf("Pushing to %s...\n", target)
+		err = s.push(target)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// push mirrors the local directory to target. Updated files are renamed in place and removed files deleted
+// once all transfers completed, so that clients of the remote host do not see incomplete repos
+func (s *RsyncStorage) push(target string) error {
+	cmd := exec.Command(rsyncCommand, "--archive", "--mkpath", "--delete-after", "--delay-updates", "--rsh", s.shell, s.directory+"/", target+"/")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("push to %s failed: %v\n%s", target, err, output)
+	}
+	return nil
+}
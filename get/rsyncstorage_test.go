@@ -0,0 +1,56 @@
+package get
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRsync replaces rsync with a script logging its arguments, failing to push to host broken
+func fakeRsync(t *testing.T) (log string) {
+	dir := t.TempDir()
+	log = filepath.Join(dir, "log")
+	script := filepath.Join(dir, "rsync")
+	err := os.WriteFile(script, []byte(`#!/bin/sh
+echo "$@" >> `+log+`
+case "$8" in broken:*) echo "connection refused" >&2; exit 255;; esac
+`), 0755)
+	assert.NoError(t, err)
+
+	defaultCommand := rsyncCommand
+	rsyncCommand = script
+	t.Cleanup(func() { rsyncCommand = defaultCommand })
+	return
+}
+
+func TestRsyncStorage(t *testing.T) {
+	log := fakeRsync(t)
+	local := t.TempDir()
+
+	storage := NewRsyncStorage(StorageConfig{
+		Path:         local,
+		RsyncTargets: []string{"broken:/srv/mirror", "mirror.example.com:/srv/mirror/"},
+		Username:     "minima",
+		KeyFile:      "/etc/minima/id_ed25519",
+	}, "repo/x86_64")
+	assert.NoError(t, storeBytes(storage, "repodata/repomd.xml", []byte("repomd")))
+
+	err := storage.Commit()
+	assert.ErrorContains(t, err, "push to broken:/srv/mirror/repo/x86_64 failed")
+	assert.ErrorContains(t, err, "connection refused")
+	content, err := os.ReadFile(filepath.Join(local, "repo", "x86_64", "repodata", "repomd.xml"))
+	assert.NoError(t, err)
+	assert.Equal(t, "repomd", string(content))
+
+	shell := "ssh -o BatchMode=yes -i /etc/minima/id_ed25519 -l minima"
+	options := "--archive --mkpath --delete-after --delay-updates --rsh " + shell + " " + filepath.Join(local, "repo", "x86_64") + "/ "
+	pushes, err := os.ReadFile(log)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		options + "broken:/srv/mirror/repo/x86_64/",
+		options + "mirror.example.com:/srv/mirror/repo/x86_64/",
+	}, strings.Split(strings.TrimSpace(string(pushes)), "\n"))
+}
@@ -0,0 +1,109 @@
+package get
+
+import (
+	"bytes"
+	"html/template"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const indexFile = "index.html"
+
+var indexTemplate = template.Must(template.New(indexFile).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Index of /{{.Path}}</title></head>
+<body>
+<h1>Index of /{{.Path}}</h1>
+<ul>
+{{- if .Path}}
+<li><a href="../index.html">../</a></li>
+{{- end}}
+{{- range .Dirs}}
+<li><a href="{{.}}/index.html">{{.}}/</a></li>
+{{- end}}
+{{- range .Files}}
+<li><a href="{{.Name}}">{{.Name}}</a> ({{.Size}} bytes)</li>
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+// indexListing is the content of an index.html file
+type indexListing struct {
+	// Path of the listed directory relative to the repo, empty or ending with a slash
+	Path  string
+	Dirs  []string
+	Files []indexEntry
+}
+
+type indexEntry struct {
+	Name string
+	Size int64
+}
+
+// writeIndexes stores an index.html file listing each directory below prefix, so that S3 mirrors can
+// be browsed. Links point to index.html files explicitly, as S3 does not serve them by default
+func (s *S3Storage) writeIndexes(prefix string) error {
+	listings := map[string]*indexListing{"": {}}
+	var listing func(dir string) *indexListing
+	listing = func(dir string) *indexListing {
+		if l, ok := listings[dir]; ok {
+			return l
+		}
+		l := &indexListing{Path: dir}
+		listings[dir] = l
+		parentDir, name := path.Split(strings.TrimSuffix(dir, "/"))
+		parent := listing(parentDir)
+		parent.Dirs = append(parent.Dirs, name)
+		return l
+	}
+
+	err := s.svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.root + prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			dir, name := path.Split(strings.TrimPrefix(aws.StringValue(object.Key), s.root+prefix))
+			if name == indexFile {
+				continue
+			}
+			l := listing(dir)
+			l.Files = append(l.Files, indexEntry{name, aws.Int64Value(object.Size)})
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for dir, l := range listings {
+		sort.Strings(l.Dirs)
+		sort.Slice(l.Files, func(i, j int) bool { return l.Files[i].Name < l.Files[j].Name })
+
+		var buf bytes.Buffer
+		err = indexTemplate.Execute(&buf, l)
+		if err != nil {
+			return err
+		}
+		_, err = s.svc.PutObject(&s3.PutObjectInput{
+			Bucket:               aws.String(s.bucket),
+			Key:                  aws.String(s.root + prefix + dir + indexFile),
+			Body:                 bytes.NewReader(buf.Bytes()),
+			ContentType:          aws.String("text/html; charset=utf-8"),
+			ServerSideEncryption: optionalString(s.encryption),
+			SSEKMSKeyId:          optionalString(s.kmsKeyID),
+			StorageClass:         s.storageClassOf(indexFile),
+			Tagging:              s.objectTagging(),
+			ACL:                  optionalString(s.acl),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package get
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// objects returns the objects below prefix in the repo by filename. The prefix is listed once per
+// sync, so that deciding what to copy takes a request per thousand files instead of one per file
+func (s *S3Storage) objects(prefix string) (map[string]*s3.Object, error) {
+	if objects, ok := s.listings[prefix]; ok {
+		return objects, nil
+	}
+
+	objects := map[string]*s3.Object{}
+	err := s.svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.root + prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			objects[strings.TrimPrefix(aws.StringValue(object.Key), s.root+prefix)] = object
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if s.listings == nil {
+		s.listings = map[string]map[string]*s3.Object{}
+	}
+	s.listings[prefix] = objects
+	return objects, nil
+}
+
+// sameObject returns true if two listed objects have the same content
+func sameObject(a *s3.Object, b *s3.Object) bool {
+	return aws.Int64Value(a.Size) == aws.Int64Value(b.Size) && aws.StringValue(a.ETag) == aws.StringValue(b.ETag)
+}
+
+// deleteObjects deletes files below prefix in the repo, in batches
+func (s *S3Storage) deleteObjects(prefix string, filenames []string) error {
+	if len(filenames) == 0 {
+		return nil
+	}
+
+	toDelete := []s3manager.BatchDeleteObject{}
+	for _, filename := range filenames {
+		toDelete = append(toDelete, s3manager.BatchDeleteObject{Object: &s3.DeleteObjectInput{
+			Key:    aws.String(s.root + prefix + filename),
+			Bucket: aws.String(s.bucket),
+		}})
+	}
+
+	batcher := s3manager.NewBatchDeleteWithClient(s.svc)
+	return batcher.Delete(aws.BackgroundContext(), &s3manager.DeleteObjectsIterator{Objects: toDelete})
+}
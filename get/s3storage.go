@@ -2,16 +2,24 @@ package get
 
 import (
 	"crypto"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
 	"io"
 	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/uyuni-project/minima/util"
 )
 
@@ -19,36 +27,155 @@ import (
 type S3Storage struct {
 	region string
 	bucket string
+	// key prefix of the repo in the bucket, empty or ending with a slash
+	root string
+	// a/ or b/, alternating at every Commit, empty before the first one
 	prefix string
 	svc    *s3.S3
+	// size in bytes of the parts big files are uploaded in, DefaultPartSize if 0
+	partSize int64
+	// number of parts uploaded in parallel, DefaultUploadConcurrency if 0
+	concurrency int
+	// server-side encryption algorithm for stored files (AES256 or aws:kms), and KMS key to use
+	encryption string
+	kmsKeyID   string
+	// storage class of stored files, unless overridden by a matching rule
+	storageClass      string
+	storageClassRules []StorageClassRule
+	// tags of stored files besides the repo one
+	tags map[string]string
+	// services not supporting object tagging get no tags at all
+	disableTagging bool
+	// canned ACL of stored files, none for buckets enforcing ownership
+	acl string
+	// whether MD5 is avoided in FIPS mode, see StorageConfig.FIPS
+	fips bool
+	// whether index.html listings are stored at every Commit
+	generateIndex bool
+	// where progress is logged, the standard logger if nil
+	logger *log.Logger
+	// objects by prefix, listed once per sync, and files stored in the temporary location so far
+	listings map[string]map[string]*s3.Object
+	stored   map[string]bool
+
+	// Repo and Upstream are recorded in stored files metadata, for lifecycle rules and audits
+	Repo     string
+	Upstream string
+	// upstream modification times of the files stored next, see SetModTime
+	modTimes map[string]time.Time
 }
 
-// NewS3Storage returns a new Storage backed by an S3 bucket
-func NewS3Storage(accessKeyID string, secretAccessKey string, region string, bucket string) (storage Storage, err error) {
-	creds := credentials.NewStaticCredentials(accessKeyID, secretAccessKey, "")
-	config := aws.NewConfig().WithRegion(region).WithCredentials(creds)
-	svc := s3.New(session.New(), config)
-
-	err = configureBucket(region, bucket, svc)
+// NewS3Storage returns a new Storage backed by an S3 bucket, connecting as specified by the
+// s3-specific fields of config. Files are stored below repoPath, itself below the configured prefix
+func NewS3Storage(config StorageConfig, repoPath string) (storage *S3Storage, err error) {
+	client, err := tlsHTTPClient(config.CACertFile, config.InsecureSkipVerify)
 	if err != nil {
 		return
 	}
+	awsConfig := aws.NewConfig().WithHTTPClient(client).WithS3ForcePathStyle(config.ForcePathStyle)
+	if config.Region != "" {
+		awsConfig = awsConfig.WithRegion(config.Region)
+	}
+	if config.Endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(config.Endpoint)
+	}
+	// without static keys, the default AWS credential chain is used: environment variables, shared
+	// config and credentials files (including SSO), web identity tokens and EC2 or ECS roles
+	if config.AccessKeyID != "" {
+		awsConfig = awsConfig.WithCredentials(credentials.NewStaticCredentials(config.AccessKeyID, config.SecretAccessKey, ""))
+	}
 
-	prefix, err := getCurrentPrefix(region, bucket, svc)
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *awsConfig,
+		Profile:           config.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
 	if err != nil {
 		return
 	}
+	region := aws.StringValue(sess.Config.Region)
+	if region == "" && config.Endpoint != "" {
+		// most S3-compatible services ignore the region, but requests need one to be signed
+		region = "us-east-1"
+	}
+	svc := s3.New(sess, aws.NewConfig().WithRegion(region))
 
-	err = configureWebsite(region, bucket, prefix, svc)
+	bucket := config.Bucket
+	err = configureBucket(region, bucket, svc, loggerOrDefault(config.Logger))
 	if err != nil {
 		return
 	}
 
-	storage = &S3Storage{region, bucket, prefix, svc}
+	root := strings.Trim(path.Join(config.Prefix, repoPath), "/")
+	var prefix string
+	if root == "" {
+		// the bucket holds a single repo, its website routes to the current prefix
+		prefix, err = getCurrentPrefix(region, bucket, svc)
+		if err != nil {
+			return
+		}
+
+		err = configureWebsite(region, bucket, prefix, svc)
+		if err != nil {
+			return
+		}
+	} else {
+		// repos sharing the bucket record their current prefix next to their content
+		root += "/"
+		prefix, err = getPrefixMarker(bucket, root+prefixMarker, svc)
+		if err != nil {
+			return
+		}
+	}
+
+	storage = &S3Storage{
+		region:      region,
+		bucket:      bucket,
+		root:        root,
+		prefix:      prefix,
+		svc:         svc,
+		partSize:    config.PartSizeMB * 1024 * 1024,
+		concurrency: config.UploadConcurrency,
+		encryption:  config.ServerSideEncryption,
+		kmsKeyID:    config.SSEKMSKeyID,
+
+		storageClass:      config.StorageClass,
+		storageClassRules: config.StorageClassRules,
+		tags:              config.Tags,
+		disableTagging:    config.DisableTagging,
+		acl:               config.ACL,
+		generateIndex:     config.GenerateIndex,
+		fips:              config.FIPS,
+		logger:            config.Logger,
+	}
 	return
 }
 
-func configureBucket(region string, bucket string, svc *s3.S3) error {
+// tlsHTTPClient returns a client for S3 and other HTTP services, trusting certificates in caCertFile (if set)
+// besides the system ones
+func tlsHTTPClient(caCertFile string, insecureSkipVerify bool) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if caCertFile != "" {
+		pem, err := os.ReadFile(caCertFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caCertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
+
+func configureBucket(region string, bucket string, svc *s3.S3, logger *log.Logger) error {
 	input := &s3.CreateBucketInput{
 		Bucket: aws.String(bucket),
 		CreateBucketConfiguration: &s3.CreateBucketConfiguration{
@@ -74,7 +201,7 @@ func configureBucket(region string, bucket string, svc *s3.S3) error {
 			return err
 		}
 	}
-	log.Printf("Bucket %s created\n", bucket)
+	logger.Printf("Bucket %s created\n", bucket)
 	return nil
 }
 
@@ -85,6 +212,8 @@ func getCurrentPrefix(region string, bucket string, svc *s3.S3) (result string,
 			switch aerr.Code() {
 			case "NoSuchWebsiteConfiguration":
 				return "", nil
+			case "NotImplemented":
+				return getPrefixMarker(bucket, prefixMarker, svc)
 			}
 		}
 		return
@@ -129,11 +258,44 @@ func configureWebsite(region string, bucket string, prefix string, svc *s3.S3) (
 	}
 	_, err = svc.PutBucketWebsite(input)
 	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotImplemented" {
+			return putPrefixMarker(bucket, prefixMarker, prefix, svc)
+		}
 		return
 	}
 	return
 }
 
+// prefixMarker is the object recording the current prefix of repos sharing a bucket, or of the repo
+// in S3-compatible services that do not implement website configuration, such as MinIO
+const prefixMarker = ".minima-prefix"
+
+func getPrefixMarker(bucket string, key string, svc *s3.S3) (string, error) {
+	output, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return "", nil
+		}
+		return "", err
+	}
+	defer output.Body.Close()
+
+	prefix, err := io.ReadAll(output.Body)
+	return string(prefix), err
+}
+
+func putPrefixMarker(bucket string, key string, prefix string, svc *s3.S3) error {
+	_, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   strings.NewReader(prefix),
+	})
+	return err
+}
+
 func (s *S3Storage) newPrefix() string {
 	if s.prefix == "a/" {
 		return "b/"
@@ -152,14 +314,15 @@ func (s *S3Storage) NewReader(filename string, location Location) (reader io.Rea
 	}
 	input := &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
-		Key:    aws.String(prefix + filename),
+		Key:    aws.String(s.root + prefix + filename),
 	}
 
 	info, err := s.svc.GetObject(input)
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
 			switch aerr.Code() {
-			case "NotFound":
+			// GetObject returns NoSuchKey, HEAD requests NotFound
+			case s3.ErrCodeNoSuchKey, "NotFound":
 				err = ErrFileNotFound
 			}
 		}
@@ -172,17 +335,17 @@ func (s *S3Storage) NewReader(filename string, location Location) (reader io.Rea
 // StoringMapper returns a mapper that will store read data to a temporary location specified by filename
 func (s *S3Storage) StoringMapper(filename string, checksum string, hash crypto.Hash) (mapper util.ReaderMapper) {
 	return func(reader io.ReadCloser) (result io.ReadCloser, err error) {
-		uploader := s3manager.NewUploaderWithClient(s.svc)
-
+		s.markStored(filename)
 		pipeReader, pipeWriter := io.Pipe()
+		metadata := s.objectMetadata(checksum, hash, s.modTimes[filename])
+		delete(s.modTimes, filename)
 
 		errs := make(chan error)
 		go func() {
-			_, err := uploader.Upload(&s3manager.UploadInput{
-				Bucket: aws.String(s.bucket),
-				Key:    aws.String(s.newPrefix() + filename),
-				Body:   pipeReader,
-			})
+			// verified while uploading, so that incomplete uploads of corrupt files are never completed
+			err := s.upload(s.root+s.newPrefix()+filename, util.NewChecksummingReader(pipeReader, checksum, hash), metadata)
+			// unblock writes if the upload stopped early
+			pipeReader.CloseWithError(err)
 			errs <- err
 		}()
 
@@ -206,58 +369,173 @@ func (w *waitingCloser) Close() error {
 	return err
 }
 
-// Recycle will copy a file from the permanent to the temporary location
+// Recycle will copy a file from the permanent to the temporary location, unless an interrupted sync
+// already did
 func (s *S3Storage) Recycle(filename string) (err error) {
+	s.markStored(filename)
+	permanent, err := s.objects(s.prefix)
+	if err != nil {
+		return
+	}
+	temporary, err := s.objects(s.newPrefix())
+	if err != nil {
+		return
+	}
+	if source, ok := permanent[filename]; ok {
+		if copied, ok := temporary[filename]; ok && sameObject(source, copied) {
+			return
+		}
+	}
+
 	input := &s3.CopyObjectInput{
 		Bucket:     aws.String(s.bucket),
-		CopySource: aws.String(s.bucket + "/" + s.prefix + filename),
-		Key:        aws.String(s.newPrefix() + filename),
+		CopySource: aws.String(s.bucket + "/" + s.root + s.prefix + filename),
+		Key:        aws.String(s.root + s.newPrefix() + filename),
+		// copies are not encrypted nor have the ACL of their source unless asked again
+		ServerSideEncryption: optionalString(s.encryption),
+		SSEKMSKeyId:          optionalString(s.kmsKeyID),
+		StorageClass:         s.storageClassOf(filename),
+		ACL:                  optionalString(s.acl),
 	}
 
 	_, err = s.svc.CopyObject(input)
 	return
 }
 
+// markStored records that filename is part of the sync in progress
+func (s *S3Storage) markStored(filename string) {
+	if s.stored == nil {
+		s.stored = map[string]bool{}
+	}
+	s.stored[filename] = true
+}
+
 // Commit moves any temporary file accumulated so far to the permanent location
 func (s *S3Storage) Commit() (err error) {
 	newPrefix := s.newPrefix()
-	err = configureWebsite(s.region, s.bucket, newPrefix, s.svc)
+
+	// files left in the temporary location by interrupted syncs must not be published
+	temporary, err := s.objects(newPrefix)
+	if err != nil {
+		return
+	}
+	stale := []string{}
+	for filename := range temporary {
+		if !s.stored[filename] {
+			stale = append(stale, filename)
+		}
+	}
+	err = s.deleteObjects(newPrefix, stale)
 	if err != nil {
 		return
 	}
 
-	batcher := s3manager.NewBatchDeleteWithClient(s.svc)
-	objectsToDelete := true
-	for objectsToDelete {
-		input := &s3.ListObjectsV2Input{
-			Bucket: aws.String(s.bucket),
-			Prefix: aws.String(s.prefix),
+	if s.generateIndex {
+		err = s.writeIndexes(newPrefix)
+		if err != nil {
+			return
 		}
+	}
 
-		objects, err := s.svc.ListObjectsV2(input)
+	if s.root == "" {
+		err = configureWebsite(s.region, s.bucket, newPrefix, s.svc)
+	} else {
+		err = putPrefixMarker(s.bucket, s.root+prefixMarker, newPrefix, s.svc)
+	}
+	if err != nil {
+		return
+	}
+
+	// parts left by failed uploads will never be completed at this point
+	for _, prefix := range []string{newPrefix, s.prefix} {
+		err = s.abortUploads(s.root + prefix)
+		if err != nil {
+			return
+		}
+	}
+
+	if s.prefix != "" {
+		permanent, err := s.objects(s.prefix)
+		if err != nil {
+			return err
+		}
+		old := []string{}
+		for filename := range permanent {
+			old = append(old, filename)
+		}
+		err = s.deleteObjects(s.prefix, old)
 		if err != nil {
 			return err
 		}
+	}
 
-		if len(objects.Contents) > 0 {
-			toDelete := []s3manager.BatchDeleteObject{}
-			for _, o := range objects.Contents {
-				toDelete = append(toDelete, s3manager.BatchDeleteObject{Object: &s3.DeleteObjectInput{
-					Key:    o.Key,
-					Bucket: aws.String(s.bucket),
-				}})
-			}
+	s.prefix = newPrefix
+	s.listings = nil
+	s.stored = nil
+	return
+}
 
-			err := batcher.Delete(nil, &s3manager.DeleteObjectsIterator{
-				Objects: toDelete,
-			})
-			if err != nil {
-				return err
-			}
-		} else {
-			objectsToDelete = false
+// storageClassOf returns the storage class for a file, nil for the bucket default
+func (s *S3Storage) storageClassOf(filename string) *string {
+	for _, rule := range s.storageClassRules {
+		if matched, _ := path.Match(rule.Pattern, path.Base(filename)); matched {
+			return aws.String(rule.Class)
 		}
 	}
+	return optionalString(s.storageClass)
+}
 
-	return
+// SetModTime records the modification time of the file stored next at filename in its minima-last-modified
+// metadata, as S3 sets Last-Modified to the upload time
+func (s *S3Storage) SetModTime(filename string, modTime time.Time) {
+	if modTime.IsZero() {
+		delete(s.modTimes, filename)
+		return
+	}
+	if s.modTimes == nil {
+		s.modTimes = map[string]time.Time{}
+	}
+	s.modTimes[filename] = modTime
+}
+
+// objectMetadata returns the user-defined metadata of a stored file with the given checksum and
+// modification time, if known
+func (s *S3Storage) objectMetadata(checksum string, hash crypto.Hash, modTime time.Time) map[string]*string {
+	metadata := map[string]*string{}
+	if s.Repo != "" {
+		metadata["minima-repo"] = aws.String(s.Repo)
+	}
+	if s.Upstream != "" {
+		metadata["minima-upstream"] = aws.String(s.Upstream)
+	}
+	if checksum != "" {
+		metadata["minima-checksum"] = aws.String(hash.String() + ":" + checksum)
+	}
+	if !modTime.IsZero() {
+		metadata["minima-last-modified"] = aws.String(modTime.UTC().Format(http.TimeFormat))
+	}
+	return metadata
+}
+
+// objectTagging returns the tag set of stored files, URL-encoded as expected in upload requests
+func (s *S3Storage) objectTagging() *string {
+	if s.disableTagging {
+		return nil
+	}
+	tags := url.Values{}
+	for key, value := range s.tags {
+		tags.Set(key, value)
+	}
+	if s.Repo != "" {
+		tags.Set("minima-repo", s.Repo)
+	}
+	return optionalString(tags.Encode())
+}
+
+// optionalString returns a pointer to value, or nil if value is empty so that it is omitted from requests
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return aws.String(value)
 }
@@ -0,0 +1,579 @@
+package get
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/uyuni-project/minima/util"
+)
+
+// fakeS3 implements the subset of the S3 API used by S3Storage, with path-style bucket addressing
+type fakeS3 struct {
+	sync.Mutex
+	objects map[string][]byte
+	uploads map[string]*fakeUpload
+	// headers of the last request creating each object or upload
+	headers map[string]http.Header
+	// number of UploadPart, ListObjectsV2 and CopyObject requests received
+	uploadedParts int
+	listings      int
+	copies        int
+	// if set, UploadPart requests for this part number fail
+	failPart int
+}
+
+type fakeUpload struct {
+	key   string
+	parts map[int][]byte
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]byte{}, uploads: map[string]*fakeUpload{}, headers: map[string]http.Header{}}
+}
+
+func etag(content []byte) string {
+	sum := md5.Sum(content)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	// /bucket/key
+	key := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[1:]
+	query := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+
+	switch {
+	case query.Has("website"):
+		// as in MinIO
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprint(w, "<Error><Code>NotImplemented</Code></Error>")
+	case r.Method == http.MethodPut && len(key) == 0:
+		// bucket creation
+	case r.Method == http.MethodGet && query.Get("list-type") == "2":
+		f.listings++
+		fmt.Fprint(w, "<ListBucketResult>")
+		for key, content := range f.objects {
+			if strings.HasPrefix(key, query.Get("prefix")) {
+				fmt.Fprintf(w, "<Contents><Key>%s</Key><ETag>%s</ETag><Size>%d</Size></Contents>", key, etag(content), len(content))
+			}
+		}
+		fmt.Fprint(w, "</ListBucketResult>")
+	case r.Method == http.MethodPost && query.Has("delete"):
+		var deleted struct {
+			Objects []struct{ Key string } `xml:"Object"`
+		}
+		xml.Unmarshal(body, &deleted)
+		for _, object := range deleted.Objects {
+			delete(f.objects, object.Key)
+		}
+		fmt.Fprint(w, "<DeleteResult></DeleteResult>")
+	case r.Method == http.MethodGet && query.Has("uploads"):
+		fmt.Fprint(w, "<ListMultipartUploadsResult>")
+		for id, upload := range f.uploads {
+			if strings.HasPrefix(upload.key, query.Get("prefix")) {
+				fmt.Fprintf(w, "<Upload><Key>%s</Key><UploadId>%s</UploadId><Initiated>2024-01-01T00:00:00Z</Initiated></Upload>", upload.key, id)
+			}
+		}
+		fmt.Fprint(w, "</ListMultipartUploadsResult>")
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		id := strconv.Itoa(len(f.uploads) + 1)
+		f.uploads[id] = &fakeUpload{key: key[0], parts: map[int][]byte{}}
+		f.headers[key[0]] = r.Header
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", key[0], id)
+	case r.Method == http.MethodGet && query.Has("uploadId"):
+		upload := f.uploads[query.Get("uploadId")]
+		fmt.Fprint(w, "<ListPartsResult>")
+		for number, content := range upload.parts {
+			fmt.Fprintf(w, "<Part><PartNumber>%d</PartNumber><ETag>%s</ETag><Size>%d</Size></Part>", number, etag(content), len(content))
+		}
+		fmt.Fprint(w, "</ListPartsResult>")
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		if number == f.failPart {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "<Error><Code>BadDigest</Code></Error>")
+			return
+		}
+		f.uploads[query.Get("uploadId")].parts[number] = body
+		f.uploadedParts++
+		w.Header().Set("ETag", etag(body))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		var completed struct {
+			Parts []struct{ PartNumber int } `xml:"Part"`
+		}
+		xml.Unmarshal(body, &completed)
+		upload := f.uploads[query.Get("uploadId")]
+		var content []byte
+		for _, part := range completed.Parts {
+			content = append(content, upload.parts[part.PartNumber]...)
+		}
+		f.objects[upload.key] = content
+		delete(f.uploads, query.Get("uploadId"))
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Key>%s</Key></CompleteMultipartUploadResult>", upload.key)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		source := strings.SplitN(r.Header.Get("X-Amz-Copy-Source"), "/", 2)[1]
+		f.copies++
+		f.objects[key[0]] = f.objects[source]
+		f.headers[key[0]] = r.Header
+		fmt.Fprintf(w, "<CopyObjectResult><ETag>%s</ETag></CopyObjectResult>", etag(f.objects[source]))
+	case r.Method == http.MethodDelete && len(key) == 1:
+		delete(f.objects, key[0])
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		f.objects[key[0]] = body
+		f.headers[key[0]] = r.Header
+		w.Header().Set("ETag", etag(body))
+	case r.Method == http.MethodGet && len(key) == 1:
+		content, ok := f.objects[key[0]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>NoSuchKey</Code></Error>")
+			return
+		}
+		w.Write(content)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// newFakeS3Storage returns an S3Storage backed by a fakeS3 server, which must be closed after use
+func newFakeS3Storage(f *fakeS3) (*S3Storage, *httptest.Server) {
+	server := httptest.NewServer(f)
+	config := aws.NewConfig().
+		WithRegion("us-east-1").
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")).
+		WithEndpoint(server.URL).
+		WithS3ForcePathStyle(true)
+	svc := s3.New(session.Must(session.NewSession()), config)
+	return &S3Storage{region: "us-east-1", bucket: "minima", prefix: "a/", svc: svc}, server
+}
+
+func TestS3StorageUpload(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), int(DefaultPartSize)*5/2/16)
+
+	tests := []struct {
+		name        string
+		content     []byte
+		concurrency int
+		uploaded    map[int][]byte
+		wantUploads int
+	}{
+		{"Small file", content[:1024], 0, nil, 0},
+		{"Multipart", content, 0, nil, 3},
+		{"Multipart sequential", content, 1, nil, 3},
+		{"Resumed", content, 0, map[int][]byte{1: content[:DefaultPartSize], 2: []byte("stale")}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeS3()
+			storage, server := newFakeS3Storage(f)
+			defer server.Close()
+			storage.concurrency = tt.concurrency
+			if tt.uploaded != nil {
+				f.uploads["previous"] = &fakeUpload{key: "b/file", parts: tt.uploaded}
+				f.objects[uploadMarkerPrefix+"previous"] = []byte(storage.uploadFingerprint("b/file", storage.objectMetadata("", 0, time.Time{})))
+			}
+
+			reader, err := storage.StoringMapper("file", "", 0)(io.NopCloser(bytes.NewReader(tt.content)))
+			assert.NoError(t, err)
+			_, err = io.Copy(io.Discard, reader)
+			assert.NoError(t, err)
+			assert.NoError(t, reader.Close())
+
+			assert.Equal(t, tt.wantUploads, f.uploadedParts)
+			assert.Empty(t, f.uploads)
+			assert.Equal(t, []string{"b/file"}, slices.Collect(maps.Keys(f.objects)))
+			assert.True(t, bytes.Equal(tt.content, f.objects["b/file"]))
+		})
+	}
+}
+
+func TestS3StorageUploadNotResumedForOtherVersions(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), int(DefaultPartSize)*3/2/16)
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+	f := newFakeS3()
+	storage, server := newFakeS3Storage(f)
+	defer server.Close()
+	// an upload of an older version of the file, with the same first part, and one without marker
+	f.uploads["older"] = &fakeUpload{key: "b/file", parts: map[int][]byte{1: content[:DefaultPartSize]}}
+	f.objects[uploadMarkerPrefix+"older"] = []byte(storage.uploadFingerprint("b/file", storage.objectMetadata("0123", crypto.SHA256, time.Time{})))
+	f.uploads["unknown"] = &fakeUpload{key: "b/file", parts: map[int][]byte{1: content[:DefaultPartSize]}}
+
+	reader, err := storage.StoringMapper("file", checksum, crypto.SHA256)(io.NopCloser(bytes.NewReader(content)))
+	assert.NoError(t, err)
+	_, err = io.Copy(io.Discard, reader)
+	assert.NoError(t, err)
+	assert.NoError(t, reader.Close())
+
+	// all parts are uploaded again, in a new upload with the current checksum
+	assert.Equal(t, 2, f.uploadedParts)
+	assert.Empty(t, f.uploads)
+	assert.Equal(t, []string{"b/file"}, slices.Collect(maps.Keys(f.objects)))
+	assert.Equal(t, "SHA-256:"+checksum, f.headers["b/file"].Get("X-Amz-Meta-Minima-Checksum"))
+}
+
+func TestS3StorageNewReaderNotFound(t *testing.T) {
+	f := newFakeS3()
+	f.objects["a/repodata/repomd.xml"] = []byte("repomd")
+	storage, server := newFakeS3Storage(f)
+	defer server.Close()
+
+	reader, err := storage.NewReader("repodata/repomd.xml", Permanent)
+	assert.NoError(t, err)
+	content, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	reader.Close()
+	assert.Equal(t, "repomd", string(content))
+
+	_, err = storage.NewReader("repodata/updateinfo.xml.gz", Permanent)
+	assert.Equal(t, ErrFileNotFound, err)
+	_, err = storage.NewReader("repodata/repomd.xml", Temporary)
+	assert.Equal(t, ErrFileNotFound, err)
+}
+
+func TestS3StorageAbortUploads(t *testing.T) {
+	f := newFakeS3()
+	f.uploads["1"] = &fakeUpload{key: "a/file", parts: map[int][]byte{}}
+	f.uploads["2"] = &fakeUpload{key: "b/file", parts: map[int][]byte{}}
+	storage, server := newFakeS3Storage(f)
+	defer server.Close()
+
+	assert.NoError(t, storage.abortUploads("b/"))
+	assert.Len(t, f.uploads, 1)
+	assert.Contains(t, f.uploads, "1")
+}
+
+func TestS3HTTPClient(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	caCertFile := filepath.Join(t.TempDir(), "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.NoError(t, os.WriteFile(caCertFile, certificate, 0644))
+
+	tests := []struct {
+		name               string
+		caCertFile         string
+		insecureSkipVerify bool
+		wantErr            bool
+	}{
+		{"System CAs", "", false, true},
+		{"CA file", caCertFile, false, false},
+		{"Insecure", "", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := tlsHTTPClient(tt.caCertFile, tt.insecureSkipVerify)
+			assert.NoError(t, err)
+			response, err := client.Get(server.URL)
+			assert.EqualValues(t, tt.wantErr, err != nil)
+			if err == nil {
+				response.Body.Close()
+			}
+		})
+	}
+
+	_, err := tlsHTTPClient(filepath.Join("testdata", "repo", "repodata", "repomd.xml"), false)
+	assert.Error(t, err)
+}
+
+func TestS3PrefixMarker(t *testing.T) {
+	storage, server := newFakeS3Storage(newFakeS3())
+	defer server.Close()
+
+	prefix, err := getPrefixMarker(storage.bucket, prefixMarker, storage.svc)
+	assert.NoError(t, err)
+	assert.Equal(t, "", prefix)
+
+	assert.NoError(t, putPrefixMarker(storage.bucket, prefixMarker, "b/", storage.svc))
+	prefix, err = getPrefixMarker(storage.bucket, prefixMarker, storage.svc)
+	assert.NoError(t, err)
+	assert.Equal(t, "b/", prefix)
+}
+
+func TestNewS3StorageForcePathStyle(t *testing.T) {
+	f := newFakeS3()
+	f.objects[prefixMarker] = []byte("a/")
+	server := httptest.NewServer(f)
+	defer server.Close()
+
+	storage, err := NewS3Storage(StorageConfig{
+		AccessKeyID:     "id",
+		SecretAccessKey: "secret",
+		Endpoint:        server.URL,
+		Bucket:          "minima",
+		ForcePathStyle:  true,
+	}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "us-east-1", storage.region)
+	assert.Equal(t, "a/", storage.prefix)
+	assert.Equal(t, "b/", storage.newPrefix())
+}
+
+func TestS3StorageServerSideEncryption(t *testing.T) {
+	f := newFakeS3()
+	f.objects["a/recycled"] = []byte("recycled")
+	storage, server := newFakeS3Storage(f)
+	defer server.Close()
+	storage.encryption = s3.ServerSideEncryptionAwsKms
+	storage.kmsKeyID = "key"
+
+	assert.NoError(t, storage.upload("b/small", bytes.NewReader([]byte("small")), nil))
+	assert.NoError(t, storage.upload("b/big", bytes.NewReader(make([]byte, DefaultPartSize+1)), nil))
+	assert.NoError(t, storage.Recycle("recycled"))
+
+	for _, key := range []string{"b/small", "b/big", "b/recycled"} {
+		assert.Equal(t, s3.ServerSideEncryptionAwsKms, f.headers[key].Get("X-Amz-Server-Side-Encryption"), key)
+		assert.Equal(t, "key", f.headers[key].Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"), key)
+	}
+	assert.Equal(t, []byte("recycled"), f.objects["b/recycled"])
+}
+
+func TestS3StorageStorageClass(t *testing.T) {
+	f := newFakeS3()
+	storage, server := newFakeS3Storage(f)
+	defer server.Close()
+	storage.storageClass = s3.StorageClassIntelligentTiering
+	storage.storageClassRules = []StorageClassRule{
+		{Pattern: "*.iso", Class: s3.StorageClassStandardIa},
+		{Pattern: "*-debuginfo-*.rpm", Class: s3.StorageClassGlacierIr},
+	}
+
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{"iso/SLE-15-SP5-Full-x86_64-GM-Media1.iso", s3.StorageClassStandardIa},
+		{"x86_64/kernel-default-debuginfo-5.14.21-150500.53.2.x86_64.rpm", s3.StorageClassGlacierIr},
+		{"x86_64/kernel-default-5.14.21-150500.53.2.x86_64.rpm", s3.StorageClassIntelligentTiering},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			assert.NoError(t, storage.upload("b/"+tt.filename, bytes.NewReader([]byte("content")), nil))
+			assert.Equal(t, tt.want, f.headers["b/"+tt.filename].Get("X-Amz-Storage-Class"))
+		})
+	}
+}
+
+func TestNewS3StorageDefaultCredentials(t *testing.T) {
+	server := httptest.NewServer(newFakeS3())
+	defer server.Close()
+
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "environment-id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "environment-secret")
+
+	storage, err := NewS3Storage(StorageConfig{Bucket: "minima", Endpoint: server.URL, ForcePathStyle: true}, "")
+	assert.NoError(t, err)
+	value, err := storage.svc.Config.Credentials.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "environment-id", value.AccessKeyID)
+
+	storage, err = NewS3Storage(StorageConfig{AccessKeyID: "id", SecretAccessKey: "secret", Bucket: "minima", Endpoint: server.URL, ForcePathStyle: true}, "")
+	assert.NoError(t, err)
+	value, err = storage.svc.Config.Credentials.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "id", value.AccessKeyID)
+	assert.Equal(t, "secret", value.SecretAccessKey)
+}
+
+func TestS3StorageUploadFailure(t *testing.T) {
+	f := newFakeS3()
+	f.failPart = 2
+	storage, server := newFakeS3Storage(f)
+	defer server.Close()
+
+	err := storage.upload("b/file", bytes.NewReader(make([]byte, DefaultPartSize*4)), nil)
+	assert.Error(t, err)
+	assert.NotContains(t, f.objects, "b/file")
+	// the incomplete upload is left to be resumed
+	assert.Len(t, f.uploads, 1)
+}
+
+func TestS3StorageChecksumMismatch(t *testing.T) {
+	for _, content := range [][]byte{[]byte("small"), make([]byte, DefaultPartSize+1)} {
+		f := newFakeS3()
+		storage, server := newFakeS3Storage(f)
+
+		reader, err := storage.StoringMapper("file", "0123", crypto.SHA256)(io.NopCloser(bytes.NewReader(content)))
+		assert.NoError(t, err)
+		io.Copy(io.Discard, reader)
+		err = reader.Close()
+		assert.IsType(t, &util.ChecksumError{}, err)
+		assert.NotContains(t, f.objects, "b/file")
+		server.Close()
+	}
+}
+
+func TestS3StorageMetadataAndTags(t *testing.T) {
+	f := newFakeS3()
+	storage, server := newFakeS3Storage(f)
+	defer server.Close()
+	storage.tags = map[string]string{"owner": "mirror team"}
+	storage.Repo = "SLE-Product-SLES15-SP5-Pool"
+	storage.Upstream = "https://updates.suse.com/SUSE/Products/SLE-Product-SLES/15-SP5/x86_64/product/"
+
+	for _, content := range [][]byte{[]byte("small"), make([]byte, DefaultPartSize+1)} {
+		sum := sha256.Sum256(content)
+		checksum := hex.EncodeToString(sum[:])
+		reader, err := storage.StoringMapper("file", checksum, crypto.SHA256)(io.NopCloser(bytes.NewReader(content)))
+		assert.NoError(t, err)
+		_, err = io.Copy(io.Discard, reader)
+		assert.NoError(t, err)
+		assert.NoError(t, reader.Close())
+
+		headers := f.headers["b/file"]
+		assert.Equal(t, storage.Repo, headers.Get("X-Amz-Meta-Minima-Repo"))
+		assert.Equal(t, storage.Upstream, headers.Get("X-Amz-Meta-Minima-Upstream"))
+		assert.Equal(t, "SHA-256:"+checksum, headers.Get("X-Amz-Meta-Minima-Checksum"))
+		assert.Equal(t, "minima-repo=SLE-Product-SLES15-SP5-Pool&owner=mirror+team", headers.Get("X-Amz-Tagging"))
+	}
+}
+
+func TestS3StorageSharedBucket(t *testing.T) {
+	f := newFakeS3()
+	f.objects["mirror/SLES/15/.minima-prefix"] = []byte("a/")
+	f.objects["mirror/SLES/15/a/old"] = []byte("old")
+	f.objects["mirror/SLES/15/a/recycled"] = []byte("recycled")
+	f.objects["mirror/SLES/12/a/other"] = []byte("other")
+	server := httptest.NewServer(f)
+	defer server.Close()
+
+	storage, err := NewS3Storage(StorageConfig{
+		AccessKeyID:     "id",
+		SecretAccessKey: "secret",
+		Bucket:          "minima",
+		Prefix:          "mirror",
+		Endpoint:        server.URL,
+		ForcePathStyle:  true,
+	}, "/SLES/15/")
+	assert.NoError(t, err)
+	assert.Equal(t, "mirror/SLES/15/", storage.root)
+
+	assert.NoError(t, storeBytes(storage, "new", []byte("new")))
+	assert.NoError(t, storage.Recycle("recycled"))
+	assert.NoError(t, storage.Commit())
+
+	keys := []string{}
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	assert.ElementsMatch(t, []string{
+		"mirror/SLES/15/.minima-prefix",
+		"mirror/SLES/15/b/new",
+		"mirror/SLES/15/b/recycled",
+		"mirror/SLES/12/a/other",
+	}, keys)
+	assert.Equal(t, []byte("b/"), f.objects["mirror/SLES/15/.minima-prefix"])
+}
+
+func TestS3StorageACL(t *testing.T) {
+	f := newFakeS3()
+	f.objects["a/recycled"] = []byte("recycled")
+	storage, server := newFakeS3Storage(f)
+	defer server.Close()
+
+	assert.NoError(t, storage.upload("b/private", bytes.NewReader([]byte("private")), nil))
+	assert.NotContains(t, f.headers["b/private"], "X-Amz-Acl")
+
+	storage.acl = s3.ObjectCannedACLPublicRead
+	assert.NoError(t, storage.upload("b/small", bytes.NewReader([]byte("small")), nil))
+	assert.NoError(t, storage.upload("b/big", bytes.NewReader(make([]byte, DefaultPartSize+1)), nil))
+	assert.NoError(t, storage.Recycle("recycled"))
+	for _, key := range []string{"b/small", "b/big", "b/recycled"} {
+		assert.Equal(t, s3.ObjectCannedACLPublicRead, f.headers[key].Get("X-Amz-Acl"), key)
+	}
+}
+
+func TestS3StorageWriteIndexes(t *testing.T) {
+	f := newFakeS3()
+	f.objects["b/repodata/repomd.xml"] = []byte("repomd")
+	f.objects["b/x86_64/libstdc++6-13.2.1.x86_64.rpm"] = []byte("rpm")
+	f.objects["b/x86_64/index.html"] = []byte("stale")
+	f.objects["a/noarch/old.rpm"] = []byte("old")
+	storage, server := newFakeS3Storage(f)
+	defer server.Close()
+
+	assert.NoError(t, storage.writeIndexes("b/"))
+
+	root := string(f.objects["b/index.html"])
+	assert.Contains(t, root, `<a href="repodata/index.html">repodata/</a>`)
+	assert.Contains(t, root, `<a href="x86_64/index.html">x86_64/</a>`)
+	assert.NotContains(t, root, "../")
+	assert.NotContains(t, root, "noarch")
+
+	x86_64 := string(f.objects["b/x86_64/index.html"])
+	assert.Contains(t, x86_64, "<title>Index of /x86_64/</title>")
+	assert.Contains(t, x86_64, `<a href="../index.html">../</a>`)
+	assert.Contains(t, x86_64, `<a href="libstdc&#43;&#43;6-13.2.1.x86_64.rpm">libstdc&#43;&#43;6-13.2.1.x86_64.rpm</a> (3 bytes)`)
+	assert.NotContains(t, x86_64, "stale")
+	assert.Equal(t, "text/html; charset=utf-8", f.headers["b/x86_64/index.html"].Get("Content-Type"))
+	assert.NotContains(t, f.objects, "a/noarch/index.html")
+}
+
+func TestS3StorageIncrementalSync(t *testing.T) {
+	f := newFakeS3()
+	for _, name := range []string{"unchanged", "changed", "missing"} {
+		f.objects["a/"+name] = []byte(name)
+	}
+	// left by an interrupted sync
+	f.objects["b/unchanged"] = []byte("unchanged")
+	f.objects["b/changed"] = []byte("outdated")
+	f.objects["b/stale"] = []byte("stale")
+	storage, server := newFakeS3Storage(f)
+	defer server.Close()
+
+	for _, name := range []string{"unchanged", "changed", "missing"} {
+		assert.NoError(t, storage.Recycle(name))
+	}
+	assert.NoError(t, storeBytes(storage, "new", []byte("new")))
+	// one listing per prefix
+	assert.Equal(t, 2, f.listings)
+	assert.Equal(t, 2, f.copies)
+
+	assert.NoError(t, storage.Commit())
+	assert.Equal(t, 2, f.listings)
+	assert.Equal(t, map[string][]byte{
+		prefixMarker:  []byte("b/"),
+		"b/unchanged": []byte("unchanged"),
+		"b/changed":   []byte("changed"),
+		"b/missing":   []byte("missing"),
+		"b/new":       []byte("new"),
+	}, f.objects)
+	assert.Equal(t, "b/", storage.prefix)
+}
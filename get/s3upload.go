@@ -0,0 +1,298 @@
+package get
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// DefaultPartSize is the size in bytes of the parts files are uploaded in by default
+const DefaultPartSize = s3manager.DefaultUploadPartSize
+
+// DefaultUploadConcurrency is the number of parts of a file uploaded in parallel by default
+const DefaultUploadConcurrency = s3manager.DefaultUploadConcurrency
+
+// upload stores body at key. Files bigger than a part are uploaded in parts, concurrently, and parts
+// already uploaded by previous failed attempts for the same file version are reused instead of being
+// sent again
+func (s *S3Storage) upload(key string, body io.Reader, metadata map[string]*string) error {
+	partSize := s.partSize
+	if partSize == 0 {
+		partSize = DefaultPartSize
+	}
+	if partSize < s3manager.MinUploadPartSize {
+		return fmt.Errorf("S3 part size %d is below the minimum of %d bytes", partSize, s3manager.MinUploadPartSize)
+	}
+	concurrency := s.concurrency
+	if concurrency == 0 {
+		concurrency = DefaultUploadConcurrency
+	}
+
+	// one buffer is being filled while the others are uploading, at most concurrency at a time.
+	// Buffers are allocated when first needed, so that small files only take one
+	buffers := make(chan []byte, concurrency+1)
+	for i := 0; i <= concurrency; i++ {
+		buffers <- nil
+	}
+	nextBuffer := func() []byte {
+		buf := <-buffers
+		if buf == nil {
+			buf = make([]byte, partSize)
+		}
+		return buf
+	}
+
+	buf := nextBuffer()
+	n, err := io.ReadFull(body, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// small file, a single request will do
+		_, err = s.svc.PutObject(&s3.PutObjectInput{
+			Bucket:               aws.String(s.bucket),
+			Key:                  aws.String(key),
+			Body:                 bytes.NewReader(buf[:n]),
+			ServerSideEncryption: optionalString(s.encryption),
+			SSEKMSKeyId:          optionalString(s.kmsKeyID),
+			StorageClass:         s.storageClassOf(key),
+			Metadata:             metadata,
+			Tagging:              s.objectTagging(),
+			ACL:                  optionalString(s.acl),
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	uploadID, uploaded, err := s.resumableUpload(key, metadata)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var uploadErr error
+	completed := []*s3.CompletedPart{}
+	for number := int64(1); n > 0; number++ {
+		if number > s3manager.MaxUploadParts {
+			err = fmt.Errorf("%s needs more than %d parts, please increase the S3 part size", key, s3manager.MaxUploadParts)
+			break
+		}
+
+		part := &s3.CompletedPart{PartNumber: aws.Int64(number)}
+		// parts of previous attempts are matched by their MD5 ETag, so they are uploaded again in FIPS mode
+		if !fipsMode(s.fips) {
+			sum := md5.Sum(buf[:n])
+			part.ETag = aws.String(`"` + hex.EncodeToString(sum[:]) + `"`)
+		}
+		completed = append(completed, part)
+		if previous, ok := uploaded[number]; !ok || aws.Int64Value(previous.Size) != int64(n) || aws.StringValue(previous.ETag) != aws.StringValue(part.ETag) {
+			wg.Add(1)
+			go func(buf []byte, content []byte) {
+				defer wg.Done()
+				output, err := s.svc.UploadPart(&s3.UploadPartInput{
+					Bucket:     aws.String(s.bucket),
+					Key:        aws.String(key),
+					UploadId:   aws.String(uploadID),
+					PartNumber: part.PartNumber,
+					Body:       bytes.NewReader(content),
+				})
+				mutex.Lock()
+				if err != nil && uploadErr == nil {
+					uploadErr = err
+				}
+				if err == nil {
+					part.ETag = output.ETag
+				}
+				mutex.Unlock()
+				buffers <- buf
+			}(buf, buf[:n])
+			buf = nextBuffer()
+		}
+
+		mutex.Lock()
+		failed := uploadErr != nil
+		mutex.Unlock()
+		if failed {
+			break
+		}
+
+		n, err = io.ReadFull(body, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			break
+		}
+		err = nil
+	}
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	if uploadErr != nil {
+		return uploadErr
+	}
+
+	_, err = s.svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return err
+	}
+	return s.deleteUploadMarker(uploadID)
+}
+
+// uploadMarkerPrefix is the key prefix of the objects recording, for each incomplete multipart upload,
+// the fingerprint of the file version it was initiated for, as S3 does not list the metadata of uploads
+const uploadMarkerPrefix = ".minima-uploads/"
+
+// uploadMarkerKey returns the key of the marker of a multipart upload, read and written like prefix markers
+func (s *S3Storage) uploadMarkerKey(uploadID string) string {
+	return s.root + uploadMarkerPrefix + uploadID
+}
+
+// uploadFingerprint returns what identifies the file version stored at key with metadata, including its
+// checksum, and the settings it is stored with
+func (s *S3Storage) uploadFingerprint(key string, metadata map[string]*string) string {
+	values := url.Values{}
+	for name, value := range metadata {
+		values.Set("metadata-"+name, aws.StringValue(value))
+	}
+	values.Set("storage-class", aws.StringValue(s.storageClassOf(key)))
+	values.Set("tagging", aws.StringValue(s.objectTagging()))
+	values.Set("encryption", s.encryption)
+	values.Set("kms-key-id", s.kmsKeyID)
+	values.Set("acl", s.acl)
+	return values.Encode()
+}
+
+// resumableUpload returns the ID of the most recent incomplete multipart upload initiated for the same
+// file version as key with metadata and its parts by number, or starts a new one. Incomplete uploads of
+// other file versions are aborted, as completing them would keep the metadata of the previous version
+func (s *S3Storage) resumableUpload(key string, metadata map[string]*string) (uploadID string, parts map[int64]*s3.Part, err error) {
+	var uploads []*s3.MultipartUpload
+	err = s.svc.ListMultipartUploadsPages(&s3.ListMultipartUploadsInput{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(key),
+	}, func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+		for _, upload := range page.Uploads {
+			if aws.StringValue(upload.Key) == key {
+				uploads = append(uploads, upload)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return
+	}
+	// most recent first
+	slices.SortStableFunc(uploads, func(a, b *s3.MultipartUpload) int {
+		return aws.TimeValue(b.Initiated).Compare(aws.TimeValue(a.Initiated))
+	})
+
+	fingerprint := s.uploadFingerprint(key, metadata)
+	for _, upload := range uploads {
+		if uploadID == "" {
+			var marker string
+			marker, err = getPrefixMarker(s.bucket, s.uploadMarkerKey(aws.StringValue(upload.UploadId)), s.svc)
+			if err != nil {
+				return
+			}
+			if marker == fingerprint {
+				uploadID = aws.StringValue(upload.UploadId)
+				continue
+			}
+		}
+		err = s.abortUpload(upload)
+		if err != nil {
+			return
+		}
+	}
+
+	parts = map[int64]*s3.Part{}
+	if uploadID == "" {
+		output, err := s.svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+			Bucket:               aws.String(s.bucket),
+			Key:                  aws.String(key),
+			ServerSideEncryption: optionalString(s.encryption),
+			SSEKMSKeyId:          optionalString(s.kmsKeyID),
+			StorageClass:         s.storageClassOf(key),
+			Metadata:             metadata,
+			Tagging:              s.objectTagging(),
+			ACL:                  optionalString(s.acl),
+		})
+		if err != nil {
+			return "", nil, err
+		}
+		uploadID = aws.StringValue(output.UploadId)
+		return uploadID, parts, putPrefixMarker(s.bucket, s.uploadMarkerKey(uploadID), fingerprint, s.svc)
+	}
+
+	loggerOrDefault(s.logger).Printf("Resuming upload of %s\n", key)
+	err = s.svc.ListPartsPages(&s3.ListPartsInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	}, func(page *s3.ListPartsOutput, lastPage bool) bool {
+		for _, part := range page.Parts {
+			parts[aws.Int64Value(part.PartNumber)] = part
+		}
+		return true
+	})
+	return
+}
+
+// abortUploads drops all incomplete multipart uploads below prefix, so that parts left by failed
+// attempts are not billed forever
+func (s *S3Storage) abortUploads(prefix string) error {
+	var uploads []*s3.MultipartUpload
+	err := s.svc.ListMultipartUploadsPages(&s3.ListMultipartUploadsInput{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+		uploads = append(uploads, page.Uploads...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, upload := range uploads {
+		err = s.abortUpload(upload)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// abortUpload drops an incomplete multipart upload and its marker
+func (s *S3Storage) abortUpload(upload *s3.MultipartUpload) error {
+	_, err := s.svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      upload.Key,
+		UploadId: upload.UploadId,
+	})
+	if err != nil {
+		return err
+	}
+	return s.deleteUploadMarker(aws.StringValue(upload.UploadId))
+}
+
+// deleteUploadMarker deletes the fingerprint recorded for a multipart upload, if any
+func (s *S3Storage) deleteUploadMarker(uploadID string) error {
+	_, err := s.svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.uploadMarkerKey(uploadID)),
+	})
+	return err
+}
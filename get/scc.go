@@ -5,16 +5,96 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // SCC defines the configuration to be used for downloading packages from SUSE Customer Center
 type SCC struct {
-	Username     string
-	Password     string
-	Repositories []SCCReposConfig
+	Username string
+	Password string
+	// file with username= and password= lines instead, like /etc/zypp/credentials.d/SCCcredentials.
+	// It is read at every sync, so that rotated credentials need no configuration change
+	CredentialsFile string `yaml:"credentials_file"`
+	Repositories    []SCCReposConfig
+	SCCFilter       `yaml:",inline"`
+	// file caching the listing of repos between syncs, not cached if empty
+	CacheFile string `yaml:"cache_file"`
+	// age after which the cached listing is refreshed, DefaultSCCCacheTTL if zero
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	// path prepended to the storage paths of the repos
+	PathPrefix string `yaml:"path_prefix"`
+	// organizations with their own credentials and settings, used instead of the ones above
+	Organizations []SCC
+}
+
+// AllOrganizations returns the organizations to mirror repos of: the configured Organizations, or s itself
+func (s SCC) AllOrganizations() []SCC {
+	if len(s.Organizations) > 0 {
+		return s.Organizations
+	}
+	if s.Username == "" && s.CredentialsFile == "" {
+		return nil
+	}
+	return []SCC{s}
+}
+
+// SCCCredentialsError signals that SCC refused the credentials, as opposed to network or server errors
+type SCCCredentialsError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *SCCCredentialsError) Error() string {
+	return fmt.Sprintf("SCC credentials are invalid or expired, got status code %d from %s", e.StatusCode, e.URL)
+}
+
+// Credentials returns the username and password, from CredentialsFile if set
+func (s SCC) Credentials() (username string, password string, err error) {
+	if s.CredentialsFile == "" {
+		return s.Username, s.Password, nil
+	}
+	data, err := os.ReadFile(s.CredentialsFile)
+	if err != nil {
+		return "", "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch strings.TrimSpace(key) {
+		case "username":
+			username = strings.TrimSpace(value)
+		case "password":
+			password = strings.TrimSpace(value)
+		}
+	}
+	if username == "" || password == "" {
+		return "", "", fmt.Errorf("no username and password in %s", s.CredentialsFile)
+	}
+	return username, password, nil
+}
+
+// SCCFilter selects SCC repos by their distro target and name, in addition to those listed by name.
+// Repos are selected if they match all the filters that are set, each with any of its globs
+type SCCFilter struct {
+	// globs of products with their version, like sle-15, from the distro target
+	MatchProducts []string `yaml:"match_products"`
+	// globs of architectures, from the distro target
+	MatchArchs []string `yaml:"match_archs"`
+	// globs of repo names, like SLE-*15-SP5-*
+	MatchNames []string `yaml:"match_names"`
+	// like `rmt-cli products enable`, matching repos of free extensions such as PackageHub and of modules
+	// SCC does not recommend are only selected if these are set
+	FreeExtensions bool `yaml:"free_extensions"`
+	AllModules     bool `yaml:"all_modules"`
 }
 
 // SCCRepoConfig defines the configuration of SCC repos sharing the same architectures
@@ -26,7 +106,62 @@ type SCCReposConfig struct {
 // HTTPRepoConfig defines the configuration of an HTTP repo
 type HTTPRepoConfig struct {
 	URL   string
+	Name  string
 	Archs []string
+	// false parks the repo like a disabled zypper repo: it is not synced, and its mirrored content is kept
+	Enabled *bool
+	// labels to select the repo with, eg. in sync --tags
+	Tags               []string
+	SkipFilelists      bool        `yaml:"skip_filelists"`
+	SkipOther          bool        `yaml:"skip_other"`
+	RegenerateMetadata bool        `yaml:"regenerate_metadata"`
+	ExtraFiles         []ExtraFile `yaml:"extra_files"`
+	SplitArchs         bool        `yaml:"split_archs"`
+	PathTemplate       string      `yaml:"path_template"`
+	StorageClass       string      `yaml:"storage_class"`
+	// path prepended to the storage path, eg. for each SCC organization
+	PathPrefix string `yaml:"path_prefix"`
+	// TLS client certificate and key, and CA certificate of the server if not trusted by the system
+	ClientCertFile string `yaml:"client_cert_file"`
+	ClientKeyFile  string `yaml:"client_key_file"`
+	CACertFile     string `yaml:"ca_cert_file"`
+	// client credentials to get OAuth2 access tokens with, for repos behind OAuth2 gateways
+	OAuth2    *OAuth2Config `yaml:"oauth2"`
+	Variables map[string]string
+	// names of the targets to write the repo to, default for the storage section
+	Targets   []string
+	SyncHooks `yaml:",inline"`
+	// accept MD5 and SHA-1 checksums despite min_checksum, for legacy vendor repos
+	AllowWeakChecksums bool `yaml:"allow_weak_checksums"`
+	// armored GPG public keys each RPM must be signed with
+	RPMKeys []string `yaml:"rpm_keys"`
+	// <revision> of repomd.xml, or SHA-256 checksum of repomd.xml or Release, the repo is pinned to
+	Revision string
+	Snapshot string
+	// date or RFC 3339 time to mirror the repo as of, by the issue dates of its advisories
+	AsOf string `yaml:"as_of"`
+	// only mirror packages of advisories issued since the last sync
+	PatchStream bool `yaml:"patch_stream"`
+	// shell patterns of licenses whose packages are not mirrored
+	ExcludeLicenses []string `yaml:"exclude_licenses"`
+	// maximum size of the packages of the repo, like 50G, and what to do when it is exceeded
+	MaxSize     string `yaml:"max_size"`
+	QuotaPolicy string `yaml:"quota_policy"`
+	// download large packages with zsync where upstream publishes control files
+	Zsync bool
+	// repair corrupted copies of large packages with the piece checksums of upstream metalinks or torrents
+	RepairPieces bool `yaml:"repair_pieces"`
+	// store packages in subdirectories named after their checksum, see Syncer.ShardPackages
+	ShardPackages bool `yaml:"shard_packages"`
+	// compression, gz or zst, to store metadata with regardless of upstream's, see Syncer.RecompressMetadata
+	RecompressMetadata string `yaml:"recompress_metadata"`
+	// returns a new URL of a discovered repo when its token expires, not configurable
+	RefreshURL func(repoURL string) (string, error) `yaml:"-"`
+}
+
+// Disabled returns true if the repo is set enabled: false
+func (c HTTPRepoConfig) Disabled() bool {
+	return c.Enabled != nil && !*c.Enabled
 }
 
 // Repo represents the JSON entry for a repository as retuned by SCC API
@@ -35,14 +170,97 @@ type Repo struct {
 	Name         string
 	Description  string
 	DistroTarget string `json:"distro_target"`
+	Enabled      bool
+}
+
+// Product represents the JSON entry for a product as returned by SCC API, with its repositories
+type Product struct {
+	Identifier   string
+	Version      string
+	Arch         string
+	FriendlyName string `json:"friendly_name"`
+	// base, extension or module
+	ProductType  string `json:"product_type"`
+	Free         bool
+	Recommended  bool
+	Repositories []Repo
+	Extensions   []Product
 }
 
 // maps a repo name to the available archs for it
 type sccMap map[string][]string
 
-// SCCToHTTPConfigs returns HTTPS repos configurations (URL and archs) for repos in SCC
-func SCCToHTTPConfigs(baseURL string, username string, password string, sccConfigs []SCCReposConfig, quiet bool) ([]HTTPRepoConfig, error) {
-	token := base64.URLEncoding.EncodeToString([]byte(username + ":" + password))
+// SCCToHTTPConfigs returns HTTPS repos configurations (URL and archs) for repos in SCC listed in
+// sccConfigs or selected by filter. The listing of repos comes from cache while it is fresh. Progress is
+// logged to logger, the standard logger if nil
+func SCCToHTTPConfigs(baseURL string, username string, password string, sccConfigs []SCCReposConfig, filter SCCFilter, cache SCCCache, quiet bool, logger *log.Logger) ([]HTTPRepoConfig, error) {
+	err := filter.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	logger = loggerOrDefault(logger)
+	logger.Println("Checking available SCC repositories ...")
+	repos, err := cache.repos(baseURL, username, password, logger)
+	if err != nil {
+		return nil, err
+	}
+	excluded := map[string]bool{}
+	if filter.isSet() && (!filter.FreeExtensions || !filter.AllModules) {
+		products, err := SCCProducts(baseURL, username, password, logger)
+		if err != nil {
+			return nil, err
+		}
+		excluded = filter.excluded(products)
+	}
+	httpConfigs := selectHTTPConfigs(repos, sccConfigs, filter, excluded, quiet, logger)
+	refresher := &sccURLRefresher{baseURL: baseURL, username: username, password: password, logger: logger}
+	for i := range httpConfigs {
+		httpConfigs[i].RefreshURL = refresher.refreshURL
+	}
+	return httpConfigs, nil
+}
+
+// sccRefreshInterval is the minimum time between listings of repos to refresh their tokens
+const sccRefreshInterval = time.Minute
+
+// sccURLRefresher lists SCC repos again to get new tokens for their URLs, shared by all repos of an
+// organization so that SCC is not queried by each of them
+type sccURLRefresher struct {
+	baseURL  string
+	username string
+	password string
+	logger   *log.Logger
+	mutex    sync.Mutex
+	repos    []Repo
+	listed   time.Time
+}
+
+// refreshURL returns the current URL, with its token, of the SCC repo at repoURL
+func (f *sccURLRefresher) refreshURL(repoURL string) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if time.Since(f.listed) > sccRefreshInterval {
+		repos, err := sccRepos(f.baseURL, f.username, f.password, f.logger)
+		if err != nil {
+			return "", err
+		}
+		f.repos = repos
+		f.listed = time.Now()
+	}
+
+	withoutToken, _, _ := strings.Cut(repoURL, "?")
+	for _, repo := range f.repos {
+		if current, _, _ := strings.Cut(repo.URL, "?"); current == withoutToken {
+			return repo.URL, nil
+		}
+	}
+	return "", fmt.Errorf("%s is no longer available in SCC", withoutToken)
+}
+
+// selectHTTPConfigs returns HTTPS repos configurations for repos listed in sccConfigs or selected by filter,
+// unless their URL is excluded from filters
+func selectHTTPConfigs(repos []Repo, sccConfigs []SCCReposConfig, filter SCCFilter, excluded map[string]bool, quiet bool, logger *log.Logger) []HTTPRepoConfig {
 	httpConfigs := []HTTPRepoConfig{}
 
 	// build a map of name - available archs entries to avoid repeated iterations
@@ -54,79 +272,228 @@ func SCCToHTTPConfigs(baseURL string, username string, password string, sccConfi
 		}
 	}
 
-	var page []byte
-	var err error
-	next := baseURL + "/connect/organizations/repositories"
-
-	fmt.Println("Checking available SCC repositories ...")
-	for {
-		page, next, err = downloadPaged(next, token)
-		if err != nil {
-			return nil, err
+	for _, repo := range repos {
+		if !quiet {
+			logger.Printf("  %s: %s\n", repo.Name, repo.Description)
 		}
 
-		var repos []Repo
-		err = json.Unmarshal(page, &repos)
-		if err != nil {
-			return nil, err
-		}
-
-		for _, repo := range repos {
-			if !quiet {
-				fmt.Printf("  %s: %s\n", repo.Name, repo.Description)
-			}
-
-			config, ok := getHTTPConfig(repo.Name, repo.Description, repo.URL, sccEntries)
-			if ok {
-				httpConfigs = append(httpConfigs, config)
-			}
+		config, ok := getHTTPConfig(repo, sccEntries)
+		if !ok && !excluded[repo.URL] {
+			config, ok = filter.httpConfig(repo)
 		}
-
-		if next == "" {
-			break
+		if ok {
+			httpConfigs = append(httpConfigs, config)
 		}
 	}
+	return httpConfigs
+}
 
-	return httpConfigs, nil
+// sccRepos returns the repos available to the organization of username in SCC
+func sccRepos(baseURL string, username string, password string, logger *log.Logger) ([]Repo, error) {
+	token := base64.URLEncoding.EncodeToString([]byte(username + ":" + password))
+	repos := []Repo{}
+
+	err := downloadAllPages(baseURL+"/connect/organizations/repositories", token, logger, func(page []byte) error {
+		var pageRepos []Repo
+		err := json.Unmarshal(page, &pageRepos)
+		repos = append(repos, pageRepos...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return repos, nil
 }
 
 // getHTTPConfig attempts to match the given repo name and description to one of the given
-// sccMap entries and build a HTTRepoConfig for it.
+// sccMap entries and build a HTTRepoConfig for it, with path template variables from its distro target.
 //
 // Returns a HTTPRepoConfig and a bool indicating whether the match was successfull or not.
-func getHTTPConfig(name, description, url string, sccEntries sccMap) (HTTPRepoConfig, bool) {
+func getHTTPConfig(repo Repo, sccEntries sccMap) (HTTPRepoConfig, bool) {
 	httpConfig := HTTPRepoConfig{
 		Archs: []string{},
 	}
 
-	repoArchs, ok := sccEntries[name]
+	repoArchs, ok := sccEntries[repo.Name]
 	if ok {
 		for _, arch := range repoArchs {
-			if strings.Contains(description, arch) {
+			if strings.Contains(repo.Description, arch) {
 				httpConfig.Archs = append(httpConfig.Archs, arch)
 			}
 		}
 		if len(httpConfig.Archs) > 0 {
-			httpConfig.URL = url
+			httpConfig.URL = repo.URL
+			httpConfig.Name = repo.Name
+			httpConfig.Variables = distroTargetVariables(repo.DistroTarget)
 			return httpConfig, true
 		}
 	}
 	return httpConfig, false
 }
 
-func downloadPaged(url string, token string) (page []byte, next string, err error) {
-	req, err := http.NewRequest("GET", url, nil)
+// SCCProducts returns the products available to the organization of username in SCC. Retries are logged
+// to logger, the standard logger if nil
+func SCCProducts(baseURL string, username string, password string, logger *log.Logger) ([]Product, error) {
+	token := base64.URLEncoding.EncodeToString([]byte(username + ":" + password))
+	products := []Product{}
+
+	err := downloadAllPages(baseURL+"/connect/organizations/products", token, logger, func(page []byte) error {
+		var pageProducts []Product
+		err := json.Unmarshal(page, &pageProducts)
+		products = append(products, pageProducts...)
+		return err
+	})
 	if err != nil {
-		return
+		return nil, err
 	}
+	return products, nil
+}
 
-	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", token))
-	req.Header.Add("Accept", "application/vnd.scc.suse.com.v4+json")
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return
+// isSet returns true if any filter is set
+func (f SCCFilter) isSet() bool {
+	return len(f.MatchProducts) > 0 || len(f.MatchArchs) > 0 || len(f.MatchNames) > 0
+}
+
+// validate checks the syntax of all globs
+func (f SCCFilter) validate() error {
+	for _, patterns := range [][]string{f.MatchProducts, f.MatchArchs, f.MatchNames} {
+		for _, pattern := range patterns {
+			_, err := path.Match(pattern, "")
+			if err != nil {
+				return fmt.Errorf("invalid SCC filter %s: %v", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// matchAny returns true if value matches any of patterns, or if there are none
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return len(patterns) == 0
+}
+
+// excluded returns the URLs of repos that only belong to free extensions or to modules not recommended
+// by SCC, among products and their extensions, unless the filter selects them
+func (f SCCFilter) excluded(products []Product) map[string]bool {
+	excluded := map[string]bool{}
+	included := map[string]bool{}
+	var walk func(products []Product)
+	walk = func(products []Product) {
+		for _, product := range products {
+			exclude := (product.ProductType == "extension" && product.Free && !f.FreeExtensions) ||
+				(product.ProductType == "module" && !product.Recommended && !f.AllModules)
+			for _, repo := range product.Repositories {
+				if exclude {
+					excluded[repo.URL] = true
+				} else {
+					included[repo.URL] = true
+				}
+			}
+			walk(product.Extensions)
+		}
 	}
+	walk(products)
 
+	// repos shared with a selected product, like modules of several base products, are kept
+	for repoURL := range included {
+		delete(excluded, repoURL)
+	}
+	return excluded
+}
+
+// httpConfig builds a HTTPRepoConfig for repo if it is selected by the filter, for the arch of its
+// distro target. Repos without a distro target cannot be matched by product or arch
+//
+// Returns a HTTPRepoConfig and a bool indicating whether the repo is selected or not.
+func (f SCCFilter) httpConfig(repo Repo) (HTTPRepoConfig, bool) {
+	if !f.isSet() || !matchAny(f.MatchNames, repo.Name) {
+		return HTTPRepoConfig{}, false
+	}
+	parts := strings.Split(repo.DistroTarget, "-")
+	if len(parts) < 3 {
+		return HTTPRepoConfig{}, false
+	}
+	product := strings.Join(parts[:len(parts)-1], "-")
+	arch := parts[len(parts)-1]
+	if !matchAny(f.MatchProducts, product) || !matchAny(f.MatchArchs, arch) {
+		return HTTPRepoConfig{}, false
+	}
+	return HTTPRepoConfig{
+		URL:       repo.URL,
+		Name:      repo.Name,
+		Archs:     []string{arch},
+		Variables: distroTargetVariables(repo.DistroTarget),
+	}, true
+}
+
+// sccMaxAttempts is how many times a request is sent while SCC answers it is overloaded
+const sccMaxAttempts = 6
+
+// sccMaxDelay caps waits for rate limits
+const sccMaxDelay = 5 * time.Minute
+
+// sccSleep waits between requests to SCC
+var sccSleep = time.Sleep
+
+// downloadAllPages calls add with each page of a paginated SCC API endpoint, following Link headers.
+// Retries are logged to logger
+func downloadAllPages(first string, token string, logger *log.Logger, add func(page []byte) error) error {
+	logger = loggerOrDefault(logger)
+	seen := map[string]bool{}
+	for next := first; next != ""; {
+		if seen[next] {
+			return fmt.Errorf("SCC pagination loops back to %s", next)
+		}
+		seen[next] = true
+
+		page, following, err := downloadPaged(next, token, logger)
+		if err != nil {
+			return err
+		}
+		err = add(page)
+		if err != nil {
+			return err
+		}
+		next = following
+	}
+	return nil
+}
+
+// downloadPaged returns a page of an SCC API endpoint and the URL of the next one, if any. Requests
+// refused for rate limits are retried after the delay given by SCC, or with exponential backoff
+func downloadPaged(url string, token string, logger *log.Logger) (page []byte, next string, err error) {
+	var resp *http.Response
+	for attempt := 1; ; attempt++ {
+		var req *http.Request
+		req, err = http.NewRequest("GET", url, nil)
+		if err != nil {
+			return
+		}
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", token))
+		req.Header.Add("Accept", "application/vnd.scc.suse.com.v4+json")
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			return
+		}
+		if (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) || attempt == sccMaxAttempts {
+			break
+		}
+		resp.Body.Close()
+		delay := retryDelay(resp.Header, attempt)
+		logger.Printf("SCC answered %d, retrying in %s...\n", resp.StatusCode, delay)
+		sccSleep(delay)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		err = &SCCCredentialsError{url, resp.StatusCode}
+		return
+	}
 	if resp.StatusCode != 200 {
 		err = &UnexpectedStatusCodeError{url, resp.StatusCode}
 		return
@@ -137,11 +504,65 @@ func downloadPaged(url string, token string) (page []byte, next string, err erro
 		return
 	}
 
-	re := regexp.MustCompile("<([^>]+)>; rel=\"next\"")
-	matches := re.FindStringSubmatch(resp.Header["Link"][0])
-	if matches != nil {
-		next = matches[1]
+	next, err = nextLink(url, resp.Header.Values("Link"))
+	if err != nil {
+		return
 	}
 
+	// the quota is exhausted, wait for its reset rather than be refused the next page
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" && next != "" {
+		if reset, parseErr := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); parseErr == nil {
+			delay := time.Until(time.Unix(reset, 0))
+			if delay > sccMaxDelay {
+				delay = sccMaxDelay
+			}
+			if delay > 0 {
+				sccSleep(delay)
+			}
+		}
+	}
 	return
 }
+
+// retryDelay returns the delay before a new attempt, from the Retry-After header if any
+func retryDelay(header http.Header, attempt int) time.Duration {
+	delay := time.Second << (attempt - 1)
+	retryAfter := header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(retryAfter); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(retryAfter); err == nil {
+		delay = time.Until(date)
+	}
+	if delay > sccMaxDelay {
+		delay = sccMaxDelay
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return delay
+}
+
+// linkRegexp matches a link of a Link header, with its parameters
+var linkRegexp = regexp.MustCompile(`<([^>]*)>([^,<]*)`)
+
+// nextLink returns the URL of the link with relation next in Link headers, resolved against base
+func nextLink(base string, links []string) (string, error) {
+	for _, match := range linkRegexp.FindAllStringSubmatch(strings.Join(links, ","), -1) {
+		for _, param := range strings.Split(match[2], ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if !strings.EqualFold(name, "rel") || !slices.Contains(strings.Fields(strings.Trim(value, `"`)), "next") {
+				continue
+			}
+			baseURL, err := url.Parse(base)
+			if err != nil {
+				return "", err
+			}
+			nextURL, err := baseURL.Parse(match[1])
+			if err != nil {
+				return "", err
+			}
+			return nextURL.String(), nil
+		}
+	}
+	return "", nil
+}
@@ -2,9 +2,17 @@ package get
 
 import (
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -95,7 +103,7 @@ func TestSCCToHTTPConfigs(t *testing.T) {
 					Names: tt.names,
 					Archs: tt.archs,
 				},
-			}, false)
+			}, SCCFilter{}, SCCCache{}, false, nil)
 			assert.EqualValues(t, tt.wantErr, (err != nil))
 			assert.Equal(t, len(tt.want), len(httpConfigs))
 
@@ -109,3 +117,259 @@ func TestSCCToHTTPConfigs(t *testing.T) {
 		})
 	}
 }
+
+func TestSCCFilter(t *testing.T) {
+	pool := Repo{URL: "http://whatever/SLES15-SP5-Pool", Name: "SLE-Product-SLES15-SP5-Pool", DistroTarget: "sle-15-x86_64"}
+	s390x := Repo{URL: "http://whatever/SLES15-SP5-Pool-s390x", Name: "SLE-Product-SLES15-SP5-Pool", DistroTarget: "sle-15-s390x"}
+	sp4 := Repo{URL: "http://whatever/SLES15-SP4-Pool", Name: "SLE-Product-SLES15-SP4-Pool", DistroTarget: "sle-15-x86_64"}
+	sle12 := Repo{URL: "http://whatever/SLES12-SP5-Pool", Name: "SLES12-SP5-Pool", DistroTarget: "sle-12-x86_64"}
+	noTarget := Repo{URL: "http://whatever/SLES15-SP5-Extra", Name: "SLE-Product-SLES15-SP5-Extra"}
+
+	tests := []struct {
+		name   string
+		filter SCCFilter
+		want   []Repo
+	}{
+		{"No filters", SCCFilter{}, []Repo{}},
+		{"Product", SCCFilter{MatchProducts: []string{"sle-15"}}, []Repo{pool, s390x, sp4}},
+		{"Product and arch", SCCFilter{MatchProducts: []string{"sle-15"}, MatchArchs: []string{"x86_64"}}, []Repo{pool, sp4}},
+		{"Product, arch and name", SCCFilter{MatchProducts: []string{"sle-15"}, MatchArchs: []string{"x86_64"}, MatchNames: []string{"*SLES15-SP5-*"}}, []Repo{pool}},
+		{"Multiple globs", SCCFilter{MatchProducts: []string{"sle-1[25]"}, MatchArchs: []string{"x86_64", "s390x"}}, []Repo{pool, s390x, sp4, sle12}},
+		{"Name only", SCCFilter{MatchNames: []string{"SLE-Product-SLES15-SP5-*"}}, []Repo{pool, s390x}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []Repo{}
+			for _, repo := range []Repo{pool, s390x, sp4, sle12, noTarget} {
+				config, ok := tt.filter.httpConfig(repo)
+				if ok {
+					assert.Equal(t, repo.URL, config.URL)
+					assert.Len(t, config.Archs, 1)
+					assert.True(t, strings.HasSuffix(repo.DistroTarget, "-"+config.Archs[0]))
+					got = append(got, repo)
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := SCCToHTTPConfigs("http://localhost:8080", "user", "pass", nil, SCCFilter{MatchNames: []string{"SLE-["}}, SCCCache{}, true, nil)
+	assert.ErrorContains(t, err, "invalid SCC filter")
+}
+
+func TestSCCProducts(t *testing.T) {
+	http.HandleFunc("/connect/organizations/products", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		if user != "user" || pass != "pass" {
+			w.WriteHeader(401)
+			return
+		}
+		w.Header().Set("Link", "<http://localhost:8080/connect/organizations/products2>; rel=\"next\"")
+		fmt.Fprintf(w, `[{"identifier": "SLES", "version": "15.5", "arch": "x86_64", "friendly_name": "SUSE Linux Enterprise Server 15 SP5 x86_64",
+			"repositories": [{"url": "http://whatever/SLES15-SP5-Pool", "name": "SLE-Product-SLES15-SP5-Pool", "distro_target": "sle-15-x86_64", "enabled": true}]}]`)
+	})
+	http.HandleFunc("/connect/organizations/products2", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `[{"identifier": "sle-module-basesystem", "version": "15.5", "arch": "aarch64", "friendly_name": "Basesystem Module 15 SP5 aarch64",
+			"repositories": [{"url": "http://whatever/Basesystem-Debuginfo", "name": "SLE-Module-Basesystem15-SP5-Debuginfo-Pool", "distro_target": "sle-15-aarch64", "enabled": false}]}]`)
+	})
+
+	products, err := SCCProducts("http://localhost:8080", "user", "pass", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []Product{
+		{
+			Identifier: "SLES", Version: "15.5", Arch: "x86_64", FriendlyName: "SUSE Linux Enterprise Server 15 SP5 x86_64",
+			Repositories: []Repo{{URL: "http://whatever/SLES15-SP5-Pool", Name: "SLE-Product-SLES15-SP5-Pool", DistroTarget: "sle-15-x86_64", Enabled: true}},
+		},
+		{
+			Identifier: "sle-module-basesystem", Version: "15.5", Arch: "aarch64", FriendlyName: "Basesystem Module 15 SP5 aarch64",
+			Repositories: []Repo{{URL: "http://whatever/Basesystem-Debuginfo", Name: "SLE-Module-Basesystem15-SP5-Debuginfo-Pool", DistroTarget: "sle-15-aarch64"}},
+		},
+	}, products)
+
+	_, err = SCCProducts("http://localhost:8080", "user", "thiswillfail", nil)
+	assert.Error(t, err)
+}
+
+func TestSCCCredentials(t *testing.T) {
+	username, password, err := SCC{Username: "user", Password: "pass"}.Credentials()
+	assert.NoError(t, err)
+	assert.Equal(t, "user", username)
+	assert.Equal(t, "pass", password)
+
+	file := filepath.Join(t.TempDir(), "SCCcredentials")
+	assert.NoError(t, os.WriteFile(file, []byte("username=SCC_rotated\npassword=secret\n"), 0600))
+	username, password, err = SCC{Username: "user", Password: "pass", CredentialsFile: file}.Credentials()
+	assert.NoError(t, err)
+	assert.Equal(t, "SCC_rotated", username)
+	assert.Equal(t, "secret", password)
+
+	assert.NoError(t, os.WriteFile(file, []byte("username=SCC_rotated\n"), 0600))
+	_, _, err = SCC{CredentialsFile: file}.Credentials()
+	assert.ErrorContains(t, err, "no username and password")
+
+	// refused credentials are told apart from other failures
+	_, err = SCCToHTTPConfigs("http://localhost:8080", "user", "expired", nil, SCCFilter{}, SCCCache{}, true, nil)
+	var credentialsErr *SCCCredentialsError
+	assert.ErrorAs(t, err, &credentialsErr)
+	assert.Equal(t, http.StatusUnauthorized, credentialsErr.StatusCode)
+	_, err = SCCToHTTPConfigs("http://localhost:8080/missing", "user", "pass", nil, SCCFilter{}, SCCCache{}, true, nil)
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &credentialsErr))
+}
+
+func TestSCCAllOrganizations(t *testing.T) {
+	assert.Empty(t, SCC{}.AllOrganizations())
+
+	scc := SCC{Username: "user", Password: "pass"}
+	assert.Equal(t, []SCC{scc}, scc.AllOrganizations())
+
+	organizations := []SCC{{Username: "customer-a", PathPrefix: "a"}, {CredentialsFile: "b.credentials", PathPrefix: "b"}}
+	assert.Equal(t, organizations, SCC{Organizations: organizations}.AllOrganizations())
+}
+
+func TestSCCPagination(t *testing.T) {
+	delays := []time.Duration{}
+	sccSleep = func(delay time.Duration) { delays = append(delays, delay) }
+	defer func() { sccSleep = time.Sleep }()
+
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.RequestURI()]++
+		switch r.URL.RequestURI() {
+		case "/connect/organizations/repositories":
+			// several Link headers, with relative and unquoted relations
+			w.Header().Add("Link", `<http://first.example.com/?page=1>; rel="first"`)
+			w.Header().Add("Link", `</connect/organizations/repositories?page=2>; rel=next, <http://last.example.com/?page=3>; rel="last"`)
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+			fmt.Fprint(w, `[{"name": "first"}]`)
+		case "/connect/organizations/repositories?page=2":
+			if requests[r.URL.RequestURI()] == 1 {
+				w.Header().Set("Retry-After", "3")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			if requests[r.URL.RequestURI()] == 2 {
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.Header().Set("Link", `<`+"http://"+r.Host+`/connect/organizations/repositories?page=3>; rel="next"`)
+			fmt.Fprint(w, `[{"name": "second"}]`)
+		case "/connect/organizations/repositories?page=3":
+			fmt.Fprint(w, `[{"name": "third"}]`)
+		}
+	}))
+	defer server.Close()
+
+	repos, err := sccRepos(server.URL, "user", "pass", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []Repo{{Name: "first"}, {Name: "second"}, {Name: "third"}}, repos)
+	// quota reset capped, Retry-After, then backoff of the second attempt
+	assert.Equal(t, []time.Duration{sccMaxDelay, 3 * time.Second, 2 * time.Second}, delays)
+
+	// requests are given up after sccMaxAttempts
+	requests = map[string]int{}
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.RequestURI()]++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	_, err = sccRepos(server.URL, "user", "pass", nil)
+	assert.Error(t, err)
+	assert.Equal(t, sccMaxAttempts, requests["/connect/organizations/repositories"])
+
+	// pagination loops are detected
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", `</connect/organizations/repositories>; rel="next"`)
+		fmt.Fprint(w, `[]`)
+	})
+	_, err = sccRepos(server.URL, "user", "pass", nil)
+	assert.ErrorContains(t, err, "loops back")
+}
+
+func TestSCCFilterExtensions(t *testing.T) {
+	repo := func(name string) Repo {
+		return Repo{URL: "http://whatever/" + name, Name: name, DistroTarget: "sle-15-x86_64"}
+	}
+	products := []Product{{
+		Identifier: "SLES", ProductType: "base", Repositories: []Repo{repo("SLES15-SP5-Pool")},
+		Extensions: []Product{
+			{Identifier: "sle-module-basesystem", ProductType: "module", Free: true, Recommended: true, Repositories: []Repo{repo("Basesystem15-SP5-Pool")},
+				Extensions: []Product{
+					{Identifier: "PackageHub", ProductType: "extension", Free: true, Repositories: []Repo{repo("PackageHub15-SP5-Pool")}},
+					{Identifier: "sle-module-legacy", ProductType: "module", Free: true, Repositories: []Repo{repo("Legacy15-SP5-Pool"), repo("Shared15-SP5-Pool")}},
+				}},
+			{Identifier: "sle-ha", ProductType: "extension", Repositories: []Repo{repo("HA15-SP5-Pool"), repo("Shared15-SP5-Pool")}},
+		},
+	}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/connect/organizations/repositories", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{"url": "http://whatever/SLES15-SP5-Pool", "name": "SLES15-SP5-Pool", "distro_target": "sle-15-x86_64"},
+			{"url": "http://whatever/Basesystem15-SP5-Pool", "name": "Basesystem15-SP5-Pool", "distro_target": "sle-15-x86_64"},
+			{"url": "http://whatever/PackageHub15-SP5-Pool", "name": "PackageHub15-SP5-Pool", "description": "PackageHub15-SP5-Pool for sle-15-x86_64", "distro_target": "sle-15-x86_64"},
+			{"url": "http://whatever/Legacy15-SP5-Pool", "name": "Legacy15-SP5-Pool", "distro_target": "sle-15-x86_64"},
+			{"url": "http://whatever/HA15-SP5-Pool", "name": "HA15-SP5-Pool", "distro_target": "sle-15-x86_64"},
+			{"url": "http://whatever/Shared15-SP5-Pool", "name": "Shared15-SP5-Pool", "distro_target": "sle-15-x86_64"}
+		]`)
+	})
+	mux.HandleFunc("/connect/organizations/products", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := json.Marshal(products)
+		w.Write(data)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	names := func(configs []HTTPRepoConfig) []string {
+		result := []string{}
+		for _, config := range configs {
+			result = append(result, config.Name)
+		}
+		return result
+	}
+	tests := []struct {
+		name   string
+		filter SCCFilter
+		want   []string
+	}{
+		{"Default", SCCFilter{}, []string{"SLES15-SP5-Pool", "Basesystem15-SP5-Pool", "HA15-SP5-Pool", "Shared15-SP5-Pool"}},
+		{"Free extensions", SCCFilter{FreeExtensions: true}, []string{"SLES15-SP5-Pool", "Basesystem15-SP5-Pool", "PackageHub15-SP5-Pool", "HA15-SP5-Pool", "Shared15-SP5-Pool"}},
+		{"All modules", SCCFilter{AllModules: true}, []string{"SLES15-SP5-Pool", "Basesystem15-SP5-Pool", "Legacy15-SP5-Pool", "HA15-SP5-Pool", "Shared15-SP5-Pool"}},
+		{"Everything", SCCFilter{FreeExtensions: true, AllModules: true}, []string{"SLES15-SP5-Pool", "Basesystem15-SP5-Pool", "PackageHub15-SP5-Pool", "Legacy15-SP5-Pool", "HA15-SP5-Pool", "Shared15-SP5-Pool"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.filter.MatchProducts = []string{"sle-15"}
+			configs, err := SCCToHTTPConfigs(server.URL, "user", "pass", nil, tt.filter, SCCCache{}, true, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, names(configs))
+		})
+	}
+
+	// repos listed by name are always selected
+	configs, err := SCCToHTTPConfigs(server.URL, "user", "pass", []SCCReposConfig{{Names: []string{"PackageHub15-SP5-Pool"}, Archs: []string{"x86_64"}}},
+		SCCFilter{MatchNames: []string{"Legacy*"}}, SCCCache{}, true, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"PackageHub15-SP5-Pool"}, names(configs))
+}
+
+func TestSCCURLRefresher(t *testing.T) {
+	token := "old"
+	listings := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		listings++
+		fmt.Fprintf(w, `[{"url": "https://updates.suse.com/SLES15-SP5-Pool/?%s", "name": "SLES15-SP5-Pool"}]`, token)
+	}))
+	defer server.Close()
+
+	refresher := &sccURLRefresher{baseURL: server.URL, username: "user", password: "pass"}
+	token = "new"
+	refreshed, err := refresher.refreshURL("https://updates.suse.com/SLES15-SP5-Pool/?old")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://updates.suse.com/SLES15-SP5-Pool/?new", refreshed)
+
+	// repos share the listing
+	_, err = refresher.refreshURL("https://updates.suse.com/SLES15-SP5-Updates/?old")
+	assert.ErrorContains(t, err, "no longer available")
+	assert.Equal(t, 1, listings)
+}
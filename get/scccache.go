@@ -0,0 +1,111 @@
+package get
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultSCCCacheTTL is how long a cached listing of SCC repos is used without asking SCC again
+const DefaultSCCCacheTTL = time.Hour
+
+// SCCCache keeps the listing of SCC repos in File, so that frequent syncs do not query SCC every time.
+// A stale listing is still used if SCC cannot be reached
+type SCCCache struct {
+	File string
+	TTL  time.Duration
+	// ignore a fresh listing, querying SCC anyway
+	Refresh bool
+}
+
+// sccCacheContent is the JSON content of a cache file
+type sccCacheContent struct {
+	// owner of the listing, as repos depend on the subscriptions of the organization
+	Username string
+	Repos    []Repo
+}
+
+// repos returns the repos available to the organization of username, from the cache if it is fresh.
+// Cache failures are logged to logger
+func (c SCCCache) repos(baseURL string, username string, password string, logger *log.Logger) ([]Repo, error) {
+	logger = loggerOrDefault(logger)
+	if c.File == "" {
+		return sccRepos(baseURL, username, password, logger)
+	}
+	ttl := c.TTL
+	if ttl == 0 {
+		ttl = DefaultSCCCacheTTL
+	}
+
+	cached, age, cacheErr := c.read(username)
+	if cacheErr == nil && age < ttl && !c.Refresh {
+		return cached, nil
+	}
+	repos, err := sccRepos(baseURL, username, password, logger)
+	if err != nil {
+		// outdated listings are fine during outages, but not with revoked credentials
+		var credentialsErr *SCCCredentialsError
+		if cacheErr != nil || errors.As(err, &credentialsErr) {
+			return nil, err
+		}
+		logger.Printf("Cannot list SCC repositories, using listing cached %s ago: %v\n", age.Round(time.Second), err)
+		return cached, nil
+	}
+	err = c.write(username, repos)
+	if err != nil {
+		logger.Printf("Cannot cache SCC repositories: %v\n", err)
+	}
+	return repos, nil
+}
+
+// Cached returns the repos of the organization of username cached by earlier syncs, however old, without
+// querying SCC
+func (c SCCCache) Cached(username string) ([]Repo, error) {
+	if c.File == "" {
+		return nil, os.ErrNotExist
+	}
+	repos, _, err := c.read(username)
+	return repos, err
+}
+
+// read returns the cached repos of username and their age
+func (c SCCCache) read(username string) ([]Repo, time.Duration, error) {
+	info, err := os.Stat(c.File)
+	if err != nil {
+		return nil, 0, err
+	}
+	data, err := os.ReadFile(c.File)
+	if err != nil {
+		return nil, 0, err
+	}
+	var content sccCacheContent
+	err = json.Unmarshal(data, &content)
+	if err != nil {
+		return nil, 0, err
+	}
+	if content.Username != username {
+		return nil, 0, os.ErrNotExist
+	}
+	return content.Repos, time.Since(info.ModTime()), nil
+}
+
+// write replaces the cache with repos of username. It contains no credentials
+func (c SCCCache) write(username string, repos []Repo) error {
+	data, err := json.Marshal(sccCacheContent{username, repos})
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(c.File), 0755)
+	if err != nil {
+		return err
+	}
+	temporary := c.File + ".tmp"
+	err = os.WriteFile(temporary, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporary, c.File)
+}
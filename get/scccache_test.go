@@ -0,0 +1,88 @@
+package get
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSCCCache(t *testing.T) {
+	sccSleep = func(time.Duration) {}
+	defer func() { sccSleep = time.Sleep }()
+	requests := 0
+	available := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if !available {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if user, _, _ := r.BasicAuth(); user == "revoked" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprintf(w, `[{"url": "http://whatever/SLES15-SP5-Pool", "name": "SLE-Product-SLES15-SP5-Pool", "distro_target": "sle-15-x86_64"}]`)
+	}))
+	defer server.Close()
+	want := []Repo{{URL: "http://whatever/SLES15-SP5-Pool", Name: "SLE-Product-SLES15-SP5-Pool", DistroTarget: "sle-15-x86_64"}}
+
+	cache := SCCCache{File: filepath.Join(t.TempDir(), "cache", "scc.json")}
+	repos, err := cache.repos(server.URL, "user", "pass", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, want, repos)
+	assert.Equal(t, 1, requests)
+	assert.FileExists(t, cache.File)
+
+	// fresh listings are not requested again, unless refreshed
+	repos, err = cache.repos(server.URL, "user", "pass", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, want, repos)
+	assert.Equal(t, 1, requests)
+	cache.Refresh = true
+	_, err = cache.repos(server.URL, "user", "pass", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, requests)
+	cache.Refresh = false
+
+	// stale listings are requested again, but still used if SCC stays unavailable after retries
+	old := time.Now().Add(-2 * DefaultSCCCacheTTL)
+	assert.NoError(t, os.Chtimes(cache.File, old, old))
+	available = false
+	repos, err = cache.repos(server.URL, "user", "pass", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, want, repos)
+	assert.Equal(t, 2+sccMaxAttempts, requests)
+
+	// but not if the credentials were revoked
+	available = true
+	revoked := SCCCache{File: filepath.Join(t.TempDir(), "revoked.json")}
+	_, err = revoked.repos(server.URL, "revoked", "pass", nil)
+	assert.Error(t, err)
+	assert.NoError(t, revoked.write("revoked", want))
+	assert.NoError(t, os.Chtimes(revoked.File, old, old))
+	_, err = revoked.repos(server.URL, "revoked", "pass", nil)
+	var credentialsErr *SCCCredentialsError
+	assert.ErrorAs(t, err, &credentialsErr)
+	available = false
+
+	// listings of other users are not used
+	_, err = cache.repos(server.URL, "other", "pass", nil)
+	assert.Error(t, err)
+	assert.Equal(t, 4+2*sccMaxAttempts, requests)
+
+	// cached listings are read without requests, however old
+	repos, err = cache.Cached("user")
+	assert.NoError(t, err)
+	assert.Equal(t, want, repos)
+	_, err = cache.Cached("other")
+	assert.Error(t, err)
+	_, err = SCCCache{}.Cached("user")
+	assert.Error(t, err)
+	assert.Equal(t, 4+2*sccMaxAttempts, requests)
+}
@@ -0,0 +1,103 @@
+package get
+
+import (
+	"crypto"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/uyuni-project/minima/util"
+)
+
+// Divergence is a stored file not matching the SHA256SUMS of its repo
+type Divergence struct {
+	// File is the path of the file in the mirror
+	File string `json:"file"`
+	// Expected is the checksum in SHA256SUMS, Actual the one of the file, empty if it is missing
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// ScrubResult is the outcome of Scrub
+type ScrubResult struct {
+	// Verified is the number of files checked
+	Verified int
+	// Divergences lists the files not matching their checksums
+	Divergences []Divergence
+	// Position is the path of the last file checked, where the next Scrub starts
+	Position string
+}
+
+// Scrub verifies up to maxFiles files of the mirror in directory against the SHA256SUMS of their repos,
+// see ChecksumsStorage, to detect silent corruption of the storage. Files are checked in order of their
+// paths after position, continuing from the first one at the end, so that repeated scrubs cover the whole
+// mirror over time
+func Scrub(directory string, position string, maxFiles int) (ScrubResult, error) {
+	result := ScrubResult{Position: position}
+	checksums := Manifest{}
+	err := filepath.WalkDir(directory, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if strings.HasSuffix(name, "-in-progress") || strings.HasSuffix(name, "-old") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() != checksumsFile {
+			return nil
+		}
+		repo, err := filepath.Rel(directory, filepath.Dir(name))
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		manifest, err := ReadManifest(f)
+		if err != nil {
+			return err
+		}
+		for filename, checksum := range manifest {
+			checksums[filepath.ToSlash(filepath.Join(repo, filepath.FromSlash(filename)))] = checksum
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	files := []string{}
+	for filename := range checksums {
+		files = append(files, filename)
+	}
+	sort.Strings(files)
+	start := sort.SearchStrings(files, position)
+	if start < len(files) && files[start] == position {
+		start++
+	}
+	for i := 0; i < len(files) && i < maxFiles; i++ {
+		filename := files[(start+i)%len(files)]
+		result.Verified++
+		result.Position = filename
+
+		actual := ""
+		f, err := os.Open(filepath.Join(directory, filepath.FromSlash(filename)))
+		if err == nil {
+			actual, err = util.Checksum(f, crypto.SHA256)
+			f.Close()
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return result, err
+		}
+		if actual != checksums[filename] {
+			result.Divergences = append(result.Divergences, Divergence{File: filename, Expected: checksums[filename], Actual: actual})
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,43 @@
+package get
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScrub(t *testing.T) {
+	dir := t.TempDir()
+	for _, repo := range []string{"one", "two"} {
+		storage, err := NewStorage(StorageConfig{Type: "file", Path: dir, SHA256Sums: true}, StorageRepo{Path: repo})
+		assert.NoError(t, err)
+		assert.NoError(t, storeBytes(storage, "repodata/repomd.xml", []byte("repomd")))
+		assert.NoError(t, storeBytes(storage, "Packages/a.rpm", []byte("a")))
+		assert.NoError(t, storage.Commit())
+	}
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "two", "Packages", "a.rpm"), []byte("corrupted"), 0644))
+	assert.NoError(t, os.Remove(filepath.Join(dir, "one", "repodata", "repomd.xml")))
+
+	result, err := Scrub(dir, "", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.Verified)
+	assert.Equal(t, "two/Packages/a.rpm", result.Position)
+	assert.Equal(t, []Divergence{
+		{File: "one/repodata/repomd.xml", Expected: "d0df476353bf7bd128a22e414a59d0baebd1fb57d11d6a197ad839227e02dad0"},
+		{File: "two/Packages/a.rpm", Expected: "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb", Actual: "3dbb3963d11aa418de8b61f846c3dbd5af43b40d252842adb823f90936fe6920"},
+	}, result.Divergences)
+
+	// the next scrub starts after the position, wrapping around
+	result, err = Scrub(dir, result.Position, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.Verified)
+	assert.Equal(t, "one/repodata/repomd.xml", result.Position)
+	assert.Len(t, result.Divergences, 1)
+
+	result, err = Scrub(dir, "", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, result.Verified)
+	assert.Len(t, result.Divergences, 2)
+}
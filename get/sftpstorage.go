@@ -0,0 +1,367 @@
+package get
+
+import (
+	"bufio"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"github.com/uyuni-project/minima/util"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPStorage allows to store data in a directory of a remote host, over SFTP. Like FileStorage,
+// files are stored in a -in-progress directory which replaces the permanent one at Commit
+type SFTPStorage struct {
+	// dial connects to the host at first use. Connections are closed after Commit, so that repos
+	// waiting for their sync do not keep SSH sessions open
+	dial       func() (*sftp.Client, io.Closer, error)
+	clientLock sync.Mutex
+	client     *sftp.Client
+	conn       io.Closer
+	directory  string
+
+	// directories known to exist
+	dirsLock sync.Mutex
+	dirs     map[string]bool
+}
+
+// NewSFTPStorage returns a new Storage in repoPath below the remote directory of an SFTP host, as
+// specified by the sftp-specific fields of config
+func NewSFTPStorage(config StorageConfig, repoPath string) (Storage, error) {
+	signer, err := sshSigner(config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	knownHostsFile := config.KnownHostsFile
+	if knownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	host := config.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "22")
+	}
+	user := config.Username
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+	dial := func() (*sftp.Client, io.Closer, error) {
+		auth, agentConn, err := sshAuth(signer)
+		if err != nil {
+			return nil, nil, err
+		}
+		conn, err := ssh.Dial("tcp", host, &ssh.ClientConfig{User: user, Auth: []ssh.AuthMethod{auth}, HostKeyCallback: hostKeyCallback})
+		if agentConn != nil {
+			agentConn.Close()
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot connect to %s: %v", host, err)
+		}
+		client, err := sftp.NewClient(conn, sftp.UseConcurrentWrites(true))
+		if err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("cannot start SFTP on %s: %v", host, err)
+		}
+		return client, conn, nil
+	}
+	return newSFTPStorage(dial, path.Join(config.Path, repoPath)), nil
+}
+
+// sshSigner returns the private key in keyFile, nil if unset and the keys of the SSH agent are to be used
+func sshSigner(keyFile string) (ssh.Signer, error) {
+	if keyFile == "" {
+		if os.Getenv("SSH_AUTH_SOCK") == "" {
+			return nil, fmt.Errorf("no key_file configured and no SSH agent available")
+		}
+		return nil, nil
+	}
+
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %v", keyFile, err)
+	}
+	return signer, nil
+}
+
+// sshAuth authenticates with signer, or the keys of the SSH agent if nil. The connection to the agent
+// returned in that case is only needed until authenticated
+func sshAuth(signer ssh.Signer) (ssh.AuthMethod, io.Closer, error) {
+	if signer != nil {
+		return ssh.PublicKeys(signer), nil, nil
+	}
+	conn, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("no key_file configured and no SSH agent available: %v", err)
+	}
+	return ssh.PublicKeysCallback(agent.NewClient(conn).Signers), conn, nil
+}
+
+func newSFTPStorage(dial func() (*sftp.Client, io.Closer, error), directory string) *SFTPStorage {
+	return &SFTPStorage{dial: dial, directory: strings.TrimSuffix(directory, "/"), dirs: map[string]bool{}}
+}
+
+// connect connects to the host, if not already connected
+func (s *SFTPStorage) connect() error {
+	s.clientLock.Lock()
+	defer s.clientLock.Unlock()
+	if s.client != nil {
+		return nil
+	}
+	client, conn, err := s.dial()
+	if err != nil {
+		return err
+	}
+	s.client = client
+	s.conn = conn
+	return nil
+}
+
+// disconnect closes the connection to the host, if any
+func (s *SFTPStorage) disconnect() error {
+	s.clientLock.Lock()
+	defer s.clientLock.Unlock()
+	if s.client == nil {
+		return nil
+	}
+	err := s.client.Close()
+	if connErr := s.conn.Close(); err == nil {
+		err = connErr
+	}
+	s.client = nil
+	s.conn = nil
+	return err
+}
+
+// NewReader returns a Reader for a file in a location, returns ErrFileNotFound
+// if the requested path was not found at all
+func (s *SFTPStorage) NewReader(filename string, location Location) (io.ReadCloser, error) {
+	err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	directory := s.directory
+	if location == Temporary {
+		directory += "-in-progress"
+	}
+	file, err := s.client.Open(path.Join(directory, filename))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// StoringMapper returns a mapper that will store read data to a temporary location specified by filename
+func (s *SFTPStorage) StoringMapper(filename string, checksum string, hash crypto.Hash) util.ReaderMapper {
+	return func(reader io.ReadCloser) (result io.ReadCloser, err error) {
+		err = s.connect()
+		if err != nil {
+			return
+		}
+		file, err := s.create(path.Join(s.directory+"-in-progress", filename))
+		if err != nil {
+			return
+		}
+		result = util.NewTeeReadCloser(reader, util.NewChecksummingWriter(file, checksum, hash))
+		return
+	}
+}
+
+// create opens a file for writing, creating any missing directories
+func (s *SFTPStorage) create(fullPath string) (io.WriteCloser, error) {
+	err := s.mkdirAll(path.Dir(fullPath))
+	if err != nil {
+		return nil, err
+	}
+	file, err := s.client.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create %s: %v", fullPath, err)
+	}
+	return &sftpFileWriter{Writer: bufio.NewWriterSize(file, sftpWriteBufferSize), file: file}, nil
+}
+
+// sftpWriteBufferSize is the size of buffered writes, sent as concurrent requests of at most 32 KiB
+const sftpWriteBufferSize = 512 * 1024
+
+// sftpFileWriter buffers writes to a remote file, so that several write requests are in flight
+type sftpFileWriter struct {
+	*bufio.Writer
+	file *sftp.File
+}
+
+func (w *sftpFileWriter) Close() error {
+	err := w.Flush()
+	closeErr := w.file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Recycle will copy a file from the permanent to the temporary location, as a hard link if the server
+// supports them
+func (s *SFTPStorage) Recycle(filename string) error {
+	oldPath := path.Join(s.directory, filename)
+	newPath := path.Join(s.directory+"-in-progress", filename)
+	err := s.connect()
+	if err != nil {
+		return err
+	}
+	err = s.mkdirAll(path.Dir(newPath))
+	if err != nil {
+		return err
+	}
+
+	// a leftover of an interrupted sync would make linking fail
+	err = s.client.Remove(newPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if _, ok := s.client.HasExtension(sftpHardlink); ok {
+		return s.client.Link(oldPath, newPath)
+	}
+
+	reader, err := s.NewReader(filename, Permanent)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	writer, err := s.create(newPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, reader)
+	if err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// Commit replaces the permanent directory with the temporary one. Renames are atomic on the
+// remote host, so clients see the old or new repo, never a mix. The connection is closed afterwards
+func (s *SFTPStorage) Commit() (err error) {
+	oldDir := s.directory + "-old"
+	tmpDir := s.directory + "-in-progress"
+
+	err = s.connect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if disconnectErr := s.disconnect(); err == nil {
+			err = disconnectErr
+		}
+	}()
+
+	err = s.removeAll(oldDir)
+	if err != nil {
+		return err
+	}
+	err = s.rename(s.directory, oldDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	err = s.rename(tmpDir, s.directory)
+	if err != nil {
+		return err
+	}
+
+	s.dirsLock.Lock()
+	s.dirs = map[string]bool{}
+	s.dirsLock.Unlock()
+	return s.removeAll(oldDir)
+}
+
+// extensions implemented by OpenSSH for renames overwriting targets and hard links
+const (
+	sftpPosixRename = "posix-rename@openssh.com"
+	sftpHardlink    = "hardlink@openssh.com"
+)
+
+// rename renames a file or directory, replacing any existing target if the server supports it
+func (s *SFTPStorage) rename(oldPath string, newPath string) error {
+	if _, ok := s.client.HasExtension(sftpPosixRename); ok {
+		return s.client.PosixRename(oldPath, newPath)
+	}
+	return s.client.Rename(oldPath, newPath)
+}
+
+// mkdirAll creates a directory and any missing parent
+func (s *SFTPStorage) mkdirAll(dir string) error {
+	s.dirsLock.Lock()
+	known := s.dirs[dir]
+	s.dirsLock.Unlock()
+	if known || dir == "/" || dir == "." {
+		return nil
+	}
+
+	// directories created concurrently by other mappers are not errors
+	err := s.client.MkdirAll(dir)
+	if err != nil {
+		return fmt.Errorf("cannot create %s: %v", dir, err)
+	}
+
+	s.dirsLock.Lock()
+	s.dirs[dir] = true
+	s.dirsLock.Unlock()
+	return nil
+}
+
+// removeAll removes a file or directory with its contents, if it exists
+func (s *SFTPStorage) removeAll(name string) error {
+	// unlike sftp.Client.RemoveAll, symbolic links to directories are not followed
+	info, err := s.client.Lstat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return s.client.Remove(name)
+	}
+
+	entries, err := s.client.ReadDir(name)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		child := path.Join(name, entry.Name())
+		if entry.IsDir() {
+			err = s.removeAll(child)
+		} else {
+			err = s.client.Remove(child)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return s.client.RemoveDirectory(name)
+}
@@ -0,0 +1,154 @@
+package get
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+)
+
+// pipeReadWriter is one end of a pipe-based connection
+type pipeReadWriter struct {
+	*io.PipeReader
+	*io.PipeWriter
+}
+
+func (p pipeReadWriter) Close() error {
+	p.PipeReader.Close()
+	return p.PipeWriter.Close()
+}
+
+// newFakeSFTPStorage returns an SFTPStorage for directory /mirror/repo of root, connecting to SFTP servers
+// with extensions
+func newFakeSFTPStorage(t *testing.T, root string, extensions ...string) *SFTPStorage {
+	var clients []*sftp.Client
+	t.Cleanup(func() {
+		for _, client := range clients {
+			client.Close()
+		}
+	})
+	dial := func() (*sftp.Client, io.Closer, error) {
+		// the server advertises extensions when the client connects, they are restored afterwards
+		assert.NoError(t, sftp.SetSFTPExtensions(extensions...))
+		defer sftp.SetSFTPExtensions(sftpHardlink, sftpPosixRename, "statvfs@openssh.com")
+
+		requestReader, requestWriter := io.Pipe()
+		responseReader, responseWriter := io.Pipe()
+		conn := pipeReadWriter{requestReader, responseWriter}
+		server, err := sftp.NewServer(conn)
+		if err != nil {
+			return nil, nil, err
+		}
+		go func() {
+			server.Serve()
+			server.Close()
+		}()
+		client, err := sftp.NewClientPipe(responseReader, requestWriter, sftp.UseConcurrentWrites(true))
+		if err != nil {
+			return nil, nil, err
+		}
+		clients = append(clients, client)
+		return client, conn, nil
+	}
+	return newSFTPStorage(dial, path.Join(filepath.ToSlash(root), "mirror", "repo"))
+}
+
+func TestSFTPStorage(t *testing.T) {
+	tests := []struct {
+		name       string
+		extensions []string
+	}{
+		{"OpenSSH", []string{sftpPosixRename, sftpHardlink}},
+		{"no extensions", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			assert.NoError(t, os.MkdirAll(filepath.Join(root, "mirror", "repo", "Packages"), 0755))
+			assert.NoError(t, os.WriteFile(filepath.Join(root, "mirror", "repo", "Packages", "recycled.rpm"), []byte("recycled"), 0644))
+			assert.NoError(t, os.WriteFile(filepath.Join(root, "mirror", "repo", "old.rpm"), []byte("old"), 0644))
+
+			storage := newFakeSFTPStorage(t, root, tt.extensions...)
+			big := make([]byte, sftpWriteBufferSize*3+1)
+			for i := range big {
+				big[i] = byte(i)
+			}
+			assert.NoError(t, storeBytes(storage, "repodata/repomd.xml", []byte("repomd")))
+			assert.NoError(t, storeBytes(storage, "repodata/big.xml", big))
+			assert.NoError(t, storage.Recycle("Packages/recycled.rpm"))
+
+			_, err := storage.NewReader("repodata/repomd.xml", Permanent)
+			assert.Equal(t, ErrFileNotFound, err)
+			reader, err := storage.NewReader("repodata/big.xml", Temporary)
+			assert.NoError(t, err)
+			content, err := io.ReadAll(reader)
+			assert.NoError(t, err)
+			assert.Equal(t, big, content)
+			assert.NoError(t, reader.Close())
+
+			assert.NoError(t, storage.Commit())
+			files := map[string]string{}
+			filepath.WalkDir(filepath.Join(root, "mirror"), func(name string, d fs.DirEntry, err error) error {
+				if !d.IsDir() {
+					content, _ := os.ReadFile(name)
+					relative, _ := filepath.Rel(root, name)
+					files[filepath.ToSlash(relative)] = string(content[:min(len(content), 8)])
+				}
+				return nil
+			})
+			assert.Equal(t, map[string]string{
+				"mirror/repo/repodata/repomd.xml":   "repomd",
+				"mirror/repo/repodata/big.xml":      string(big[:8]),
+				"mirror/repo/Packages/recycled.rpm": "recycled",
+			}, files)
+
+			// a second sync replaces the previous one
+			assert.NoError(t, storage.Recycle("Packages/recycled.rpm"))
+			assert.NoError(t, storage.Commit())
+			_, err = os.Stat(filepath.Join(root, "mirror", "repo", "repodata"))
+			assert.True(t, os.IsNotExist(err))
+			_, err = os.Stat(filepath.Join(root, "mirror", "repo", "Packages", "recycled.rpm"))
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestSFTPStorageConnections(t *testing.T) {
+	root := t.TempDir()
+	storage := newFakeSFTPStorage(t, root)
+	dials := 0
+	dial := storage.dial
+	storage.dial = func() (*sftp.Client, io.Closer, error) {
+		dials++
+		return dial()
+	}
+
+	// connections are opened at first use, and closed after Commit
+	assert.Equal(t, 0, dials)
+	assert.NoError(t, storeBytes(storage, "repodata/repomd.xml", []byte("repomd")))
+	assert.NoError(t, storeBytes(storage, "repodata/primary.xml", []byte("primary")))
+	assert.Equal(t, 1, dials)
+	assert.NoError(t, storage.Commit())
+	assert.Nil(t, storage.client)
+
+	// the next sync connects again
+	reader, err := storage.NewReader("repodata/repomd.xml", Permanent)
+	assert.NoError(t, err)
+	reader.Close()
+	assert.NoError(t, storage.Recycle("repodata/repomd.xml"))
+	assert.Equal(t, 2, dials)
+	assert.NoError(t, storage.Commit())
+	assert.Nil(t, storage.client)
+
+	storage.dial = func() (*sftp.Client, io.Closer, error) {
+		return nil, nil, errors.New("connection refused")
+	}
+	_, err = storage.NewReader("repodata/repomd.xml", Permanent)
+	assert.EqualError(t, err, "connection refused")
+}
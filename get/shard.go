@@ -0,0 +1,69 @@
+package get
+
+import (
+	"encoding/xml"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// locationRegexp matches the empty <location> tag of a <package> tag in primary
+var locationRegexp = regexp.MustCompile(`<location\b[^>]*/>`)
+
+// shards reports whether packages of repoType are stored sharded, see ShardPackages
+func (r *Syncer) shards(repoType RepoType) bool {
+	return r.ShardPackages && repoType.MetadataPath == repomdPath
+}
+
+// shardedLocation returns the location of a package in a subdirectory of its upstream directory named after
+// the first two hex digits of its checksum
+func shardedLocation(pack XMLPackage) string {
+	checksum := strings.ToLower(pack.Checksum.Checksum)
+	if len(checksum) < 2 {
+		return pack.Location.Href
+	}
+	dir, name := path.Split(pack.Location.Href)
+	return dir + checksum[:2] + "/" + name
+}
+
+// upstreamLocation returns the location of the package upstream, which differs from the stored one if sharded
+func (p XMLPackage) upstreamLocation() string {
+	if p.upstream != "" {
+		return p.upstream
+	}
+	return p.Location.Href
+}
+
+// relocate returns a function rewriting the <location> tag of <package> tags in primary whose pkgid is in
+// locations, then applying keep
+func relocate(locations map[string]string, keep func(*metadataElement) bool) func(*metadataElement) bool {
+	return func(element *metadataElement) bool {
+		if !keep(element) {
+			return false
+		}
+		if location, ok := locations[element.pkgid()]; ok {
+			var tag strings.Builder
+			tag.WriteString(`<location href="`)
+			xml.EscapeText(&tag, []byte(location))
+			tag.WriteString(`"/>`)
+			replaced := false
+			element.Content = locationRegexp.ReplaceAllFunc(element.Content, func(match []byte) []byte {
+				if replaced {
+					return match
+				}
+				replaced = true
+				return []byte(tag.String())
+			})
+		}
+		return true
+	}
+}
+
+// escapeLocation escapes the file name of a repo-relative location for requests
+func escapeLocation(location string) string {
+	// we need to escape package names because some CDN, proxies (...) are not perfectly RFC 3986 compliant
+	// in such cases characters like '+' (which are common in c++ pkgs) will assume a different meaning
+	name := path.Base(location)
+	return strings.TrimSuffix(location, name) + url.QueryEscape(name)
+}
@@ -0,0 +1,53 @@
+package get
+
+import (
+	"bytes"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedLocation(t *testing.T) {
+	pack := XMLPackage{Location: XMLLocation{Href: "x86_64/foo-1.0-1.x86_64.rpm"}, Checksum: XMLChecksum{Type: "sha256", Checksum: "3FAB"}}
+	assert.Equal(t, "x86_64/3f/foo-1.0-1.x86_64.rpm", shardedLocation(pack))
+	pack.Checksum.Checksum = ""
+	assert.Equal(t, "x86_64/foo-1.0-1.x86_64.rpm", shardedLocation(pack))
+}
+
+func TestStoreRepoShardPackages(t *testing.T) {
+	directory := filepath.Join(t.TempDir(), "repo")
+	repoURL, err := url.Parse("http://localhost:8080/repo")
+	assert.NoError(t, err)
+	storage := NewFileStorage(directory)
+	syncer := NewSyncer(*repoURL, map[string]bool{"x86_64": true}, storage, true)
+	syncer.ShardPackages = true
+	assert.NoError(t, syncer.StoreRepo())
+	// packages are recycled at their sharded locations
+	assert.NoError(t, syncer.StoreRepo())
+
+	b, err := os.ReadFile(filepath.Join(directory, "repodata", "repomd.xml"))
+	assert.NoError(t, err)
+	repomd, err := repoTypes["rpm"].DecodeMetadata(bytes.NewReader(b))
+	assert.NoError(t, err)
+	for _, data := range repomd.Data {
+		if data.Type != "primary" {
+			continue
+		}
+		reader, err := storage.NewReader(data.Location.Href, Permanent)
+		assert.NoError(t, err)
+		primary, err := repoTypes["rpm"].DecodePackages(reader, strings.Trim(filepath.Ext(data.Location.Href), "."))
+		reader.Close()
+		assert.NoError(t, err)
+		assert.Len(t, primary.Packages, 12)
+		for _, pack := range primary.Packages {
+			assert.Equal(t, pack.Checksum.Checksum[:2], path.Base(path.Dir(pack.Location.Href)))
+			_, err := os.Stat(filepath.Join(directory, filepath.FromSlash(pack.Location.Href)))
+			assert.NoError(t, err)
+		}
+	}
+}
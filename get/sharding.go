@@ -0,0 +1,264 @@
+package get
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// defaultSettleTime is the SettleTime of shardings without one
+const defaultSettleTime = 10 * time.Second
+
+// shardLabel is the label of the member Lease objects of shardings, with the name of the sharding
+const shardLabel = "minima.uyuni-project.org/sharding"
+
+// Sharding configures instances of minima sharing a configuration to partition its repos between them.
+// Instances register as members in a coordination backend, and repos are assigned to members by
+// rendezvous hashing, so that only the repos of instances joining or leaving move to others
+type Sharding struct {
+	// coordination backend, LockKubernetes for Lease objects or LockFile for files in a shared directory
+	Backend string
+	// name of the sharding, prefix of the Lease objects of members, minima by default
+	Name string
+	// namespace of the Lease objects, the one of the pod by default
+	Namespace string
+	// shared directory of the member files
+	Directory string
+	// member name of the instance, the hostname and process ID by default
+	Identity string
+	// time after which members that are not renewed, eg. of crashed instances, no longer get repos
+	LeaseDuration time.Duration `yaml:"lease_duration"`
+	// time members wait for others to register before partitioning repos
+	SettleTime time.Duration `yaml:"settle_time"`
+}
+
+// ValidateSharding checks the settings of a sharding section
+func ValidateSharding(sharding Sharding) error {
+	switch sharding.Backend {
+	case "", LockKubernetes:
+	case LockFile:
+		if sharding.Directory == "" {
+			return fmt.Errorf("file backend requires directory")
+		}
+	default:
+		return fmt.Errorf("unsupported backend %s", sharding.Backend)
+	}
+	if sharding.LeaseDuration < 0 || sharding.LeaseDuration > 0 && sharding.LeaseDuration < time.Second {
+		return fmt.Errorf("lease_duration must be at least 1s")
+	}
+	if sharding.SettleTime < 0 {
+		return fmt.Errorf("settle_time must not be negative")
+	}
+	return nil
+}
+
+// shardMembers registers an instance in the coordination backend of a sharding. acquire registers or
+// renews its membership, release ends it
+type shardMembers interface {
+	leaderLock
+	// members returns the names of the registered members whose membership did not expire
+	members(ctx context.Context) ([]string, error)
+}
+
+// Shard is the part of the repos of a sharding assigned to an instance
+type Shard struct {
+	identity string
+	// members when the shard was joined, sorted
+	members []string
+	backend shardMembers
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// JoinShard registers the instance as a member of sharding, waits for the settle time and returns its
+// shard among the registered members. The membership is renewed until Leave
+func JoinShard(ctx context.Context, sharding Sharding, logger *log.Logger) (*Shard, error) {
+	// the defaults of leader elections apply to shardings too
+	election, err := LeaderElection{Name: sharding.Name, Identity: sharding.Identity, LeaseDuration: sharding.LeaseDuration}.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	settleTime := sharding.SettleTime
+	if settleTime == 0 {
+		settleTime = defaultSettleTime
+	}
+	// member leases are named after hashes of identities, which may not be valid names
+	hash := fnv.New64a()
+	hash.Write([]byte(election.Identity))
+	name := election.Name
+	memberName := fmt.Sprintf("%s-%016x", name, hash.Sum64())
+
+	var backend shardMembers
+	if sharding.Backend == LockFile {
+		lease := &fileLease{path: filepath.Join(sharding.Directory, memberName+".json"), identity: election.Identity, duration: election.LeaseDuration}
+		backend = &fileMembers{fileLease: lease, directory: sharding.Directory}
+	} else {
+		election.Name, election.Namespace = memberName, sharding.Namespace
+		lease, err := newKubernetesLease(election)
+		if err != nil {
+			return nil, err
+		}
+		lease.labels = map[string]string{shardLabel: name}
+		backend = &kubernetesMembers{kubernetesLease: lease}
+	}
+
+	shard, err := joinShard(ctx, backend, election, settleTime)
+	if err != nil {
+		return nil, err
+	}
+	logger.Printf("Syncing the shard of %s, one of %d instances", shard.identity, len(shard.members))
+	return shard, nil
+}
+
+// joinShard registers the instance with backend, and renews its membership every third of the lease
+// duration from then on
+func joinShard(ctx context.Context, backend shardMembers, election LeaderElection, settleTime time.Duration) (*Shard, error) {
+	held, err := backend.acquire(ctx)
+	if err == nil && !held {
+		err = fmt.Errorf("member %s is registered by another instance", election.Identity)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot join sharding: %v", err)
+	}
+	shard := &Shard{identity: election.Identity, backend: backend, stop: make(chan struct{}), stopped: make(chan struct{})}
+	go func() {
+		defer close(shard.stopped)
+		ticker := time.NewTicker(election.LeaseDuration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-shard.stop:
+				return
+			case <-ticker.C:
+				// failed renewals only matter to instances joining later, members are not listed again
+				backend.acquire(context.Background())
+			}
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		shard.Leave(context.Background())
+		return nil, ctx.Err()
+	case <-time.After(settleTime):
+	}
+	members, err := backend.members(ctx)
+	if err != nil {
+		shard.Leave(context.Background())
+		return nil, fmt.Errorf("cannot list sharding members: %v", err)
+	}
+	if !slices.Contains(members, shard.identity) {
+		members = append(members, shard.identity)
+	}
+	slices.Sort(members)
+	shard.members = slices.Compact(members)
+	return shard, nil
+}
+
+// Identity returns the member name of the instance
+func (s *Shard) Identity() string {
+	return s.identity
+}
+
+// Owner returns the member syncing the repo with key, for which all members get the same result
+func (s *Shard) Owner(key string) string {
+	owner := ""
+	highest := uint64(0)
+	for _, member := range s.members {
+		// weights must be evenly distributed for all bits, unlike FNV ones for keys differing at the end
+		sum := sha256.Sum256([]byte(member + "\x00" + key))
+		if weight := binary.BigEndian.Uint64(sum[:8]); owner == "" || weight > highest {
+			owner, highest = member, weight
+		}
+	}
+	return owner
+}
+
+// Leave ends the membership of the instance
+func (s *Shard) Leave(ctx context.Context) error {
+	close(s.stop)
+	<-s.stopped
+	return s.backend.release(ctx)
+}
+
+// fileMembers registers members with fileLeases in a shared directory
+type fileMembers struct {
+	*fileLease
+	directory string
+}
+
+func (m *fileMembers) members(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(m.directory)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	members := []string{}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(m.directory, entry.Name()))
+		if errors.Is(err, os.ErrNotExist) {
+			// left meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		record := leaseRecord{}
+		if json.Unmarshal(data, &record) != nil {
+			// being created
+			continue
+		}
+		if !record.expired(now) {
+			members = append(members, record.Holder)
+			continue
+		}
+		// files of crashed instances are removed, as identities change with each run
+		os.Remove(filepath.Join(m.directory, entry.Name()))
+	}
+	return members, nil
+}
+
+// kubernetesMembers registers members with kubernetesLeases labeled with the name of the sharding
+type kubernetesMembers struct {
+	*kubernetesLease
+}
+
+func (m *kubernetesMembers) members(ctx context.Context) ([]string, error) {
+	list := kubernetesLeaseList{}
+	_, err := m.do(ctx, http.MethodGet, "?"+url.Values{"labelSelector": {shardLabel + "=" + m.labels[shardLabel]}}.Encode(), nil, &list)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	members := []string{}
+	for _, lease := range list.Items {
+		if !lease.expired(now) {
+			members = append(members, lease.Spec.HolderIdentity)
+			continue
+		}
+		// as are Lease objects, only if they are not renewed meanwhile
+		m.do(ctx, http.MethodDelete, "/"+lease.Metadata.Name, map[string]any{"preconditions": map[string]string{"resourceVersion": lease.Metadata.ResourceVersion}}, nil)
+	}
+	return members, nil
+}
+
+// release deletes the Lease object of the member
+func (m *kubernetesMembers) release(ctx context.Context) error {
+	_, err := m.do(ctx, http.MethodDelete, "/"+m.name, nil, nil)
+	return err
+}
@@ -0,0 +1,106 @@
+package get
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSharding(t *testing.T) {
+	assert.NoError(t, ValidateSharding(Sharding{}))
+	assert.NoError(t, ValidateSharding(Sharding{Backend: LockFile, Directory: "/srv/mirror/.members"}))
+	assert.ErrorContains(t, ValidateSharding(Sharding{Backend: "etcd"}), "unsupported backend etcd")
+	assert.ErrorContains(t, ValidateSharding(Sharding{Backend: LockFile}), "file backend requires directory")
+	assert.ErrorContains(t, ValidateSharding(Sharding{SettleTime: -time.Second}), "settle_time")
+}
+
+func TestShardOwner(t *testing.T) {
+	three := &Shard{members: []string{"a", "b", "c"}}
+	two := &Shard{members: []string{"a", "b"}}
+	owned := map[string]int{}
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("http://test/repo-%d/", i)
+		owner := three.Owner(key)
+		owned[owner]++
+		// only the repos of the member leaving move
+		if owner != "c" {
+			assert.Equal(t, owner, two.Owner(key))
+		}
+	}
+	assert.Len(t, owned, 3)
+	for _, count := range owned {
+		assert.Greater(t, count, 60)
+	}
+}
+
+func TestJoinShardFile(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	directory := filepath.Join(t.TempDir(), "members")
+	// a member of a crashed instance
+	assert.NoError(t, os.MkdirAll(directory, 0755))
+	data, err := json.Marshal(leaseRecord{Holder: "crashed", RenewTime: time.Now().Add(-time.Hour), LeaseDurationSeconds: 60})
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(directory, "minima-crashed.json"), data, 0644))
+
+	shards := make([]*Shard, 2)
+	wait := sync.WaitGroup{}
+	for i, identity := range []string{"b", "a"} {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			shard, err := JoinShard(context.Background(), Sharding{Backend: LockFile, Directory: directory, Identity: identity, SettleTime: 200 * time.Millisecond}, logger)
+			assert.NoError(t, err)
+			shards[i] = shard
+		}()
+	}
+	wait.Wait()
+	assert.Equal(t, []string{"a", "b"}, shards[0].members)
+	assert.Equal(t, shards[0].members, shards[1].members)
+	assert.Equal(t, "b", shards[0].Identity())
+	assert.NoFileExists(t, filepath.Join(directory, "minima-crashed.json"))
+
+	for _, shard := range shards {
+		assert.NoError(t, shard.Leave(context.Background()))
+	}
+	entries, err := os.ReadDir(directory)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestKubernetesMembers(t *testing.T) {
+	api, server, tokenFile := newFakeLeaseAPI(t)
+	newMembers := func(identity string) *kubernetesMembers {
+		return &kubernetesMembers{&kubernetesLease{client: server.Client(), apiURL: server.URL, tokenFile: tokenFile, namespace: "mirrors", name: "minima-" + identity, identity: identity, duration: time.Minute, labels: map[string]string{shardLabel: "minima"}}}
+	}
+	election := LeaderElection{Identity: "a", LeaseDuration: time.Minute}
+	// a member of another sharding, and one of a crashed instance
+	other := newMembers("other")
+	other.labels = map[string]string{shardLabel: "other"}
+	_, err := other.acquire(context.Background())
+	assert.NoError(t, err)
+	crashed := newMembers("crashed")
+	_, err = crashed.acquire(context.Background())
+	assert.NoError(t, err)
+	api.leases["minima-crashed"].Spec.RenewTime = time.Now().Add(-time.Hour).UTC().Format(kubernetesMicroTime)
+
+	b := newMembers("b")
+	_, err = b.acquire(context.Background())
+	assert.NoError(t, err)
+	shard, err := joinShard(context.Background(), newMembers("a"), election, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, shard.members)
+	assert.NotContains(t, api.leases, "minima-crashed")
+
+	assert.NoError(t, shard.Leave(context.Background()))
+	assert.NotContains(t, api.leases, "minima-a")
+	assert.Contains(t, api.leases, "minima-b")
+}
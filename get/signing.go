@@ -0,0 +1,92 @@
+package get
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+// SigningConfig defines the local GPG key used to sign metadata rewritten by minima
+type SigningConfig struct {
+	KeyFile    string `yaml:"key_file"`
+	Passphrase string
+}
+
+// ReadSigningKey reads an armored GPG private key from keyFile, decrypting it with passphrase if needed
+func ReadSigningKey(keyFile string, passphrase string) (*openpgp.Entity, error) {
+	f, err := os.Open(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keyring, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read signing key %s: %v", keyFile, err)
+	}
+	if len(keyring) != 1 {
+		return nil, fmt.Errorf("signing key file %s must contain exactly one key, found %d", keyFile, len(keyring))
+	}
+
+	key := keyring[0]
+	if key.PrivateKey == nil {
+		return nil, errors.New("signing key file " + keyFile + " does not contain a private key")
+	}
+	if key.PrivateKey.Encrypted {
+		if err = key.DecryptPrivateKeys([]byte(passphrase)); err != nil {
+			return nil, fmt.Errorf("cannot decrypt signing key %s: %v", keyFile, err)
+		}
+	}
+	return key, nil
+}
+
+// ReadPackageKeys reads the armored GPG public keys packages are expected to be signed with
+func ReadPackageKeys(keyFiles []string) (openpgp.EntityList, error) {
+	keys := openpgp.EntityList{}
+	for _, keyFile := range keyFiles {
+		f, err := os.Open(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		keyring, err := openpgp.ReadArmoredKeyRing(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot read package key %s: %v", keyFile, err)
+		}
+		keys = append(keys, keyring...)
+	}
+	return keys, nil
+}
+
+// signMetadata returns the detached signature and public key files for metadata rewritten by minima
+func signMetadata(key *openpgp.Entity, metadata []byte, repoType RepoType) (signatureFiles map[string][]byte, err error) {
+	var signature bytes.Buffer
+	err = openpgp.ArmoredDetachSign(&signature, key, bytes.NewReader(metadata), nil)
+	if err != nil {
+		return
+	}
+
+	var publicKey bytes.Buffer
+	writer, err := armor.Encode(&publicKey, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return
+	}
+	err = key.Serialize(writer)
+	if err != nil {
+		return
+	}
+	err = writer.Close()
+	if err != nil {
+		return
+	}
+
+	signatureFiles = map[string][]byte{
+		repoType.MetadataPath + repoType.MetadataSignatureExt: signature.Bytes(),
+		repoType.MetadataPath + ".key":                        publicKey.Bytes(),
+	}
+	return
+}
@@ -0,0 +1,115 @@
+package get
+
+import (
+	"bytes"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreRepoSignMetadata(t *testing.T) {
+	entity, err := openpgp.NewEntity("minima", "test", "minima@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "signing-key.asc")
+	var armored bytes.Buffer
+	writer, err := armor.Encode(&armored, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = entity.SerializePrivate(writer, nil); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+	if err = os.WriteFile(keyFile, armored.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ReadSigningKey(keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	directory := filepath.Join(os.TempDir(), "syncer_test")
+	err = os.RemoveAll(directory)
+	if err != nil {
+		t.Error(err)
+	}
+
+	url, err := url.Parse("http://localhost:8080/repo")
+	if err != nil {
+		t.Error(err)
+	}
+	syncer := NewSyncer(*url, map[string]bool{"x86_64": true}, NewFileStorage(directory), true)
+	syncer.RegenerateMetadata = true
+	syncer.SigningKey = key
+
+	err = syncer.StoreRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repomd, err := os.ReadFile(filepath.Join(directory, "repodata", "repomd.xml"))
+	assert.NoError(t, err)
+	signature, err := os.ReadFile(filepath.Join(directory, "repodata", "repomd.xml.asc"))
+	assert.NoError(t, err)
+	publicKey, err := os.ReadFile(filepath.Join(directory, "repodata", "repomd.xml.key"))
+	assert.NoError(t, err)
+
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(publicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, keyring[0].PrivateKey)
+	_, err = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(repomd), bytes.NewReader(signature), nil)
+	assert.NoError(t, err)
+}
+
+func TestReadSigningKeyWithoutPrivateKey(t *testing.T) {
+	entity, err := openpgp.NewEntity("minima", "test", "minima@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "public-key.asc")
+	var armored bytes.Buffer
+	writer, err := armor.Encode(&armored, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = entity.Serialize(writer); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+	if err = os.WriteFile(keyFile, armored.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ReadSigningKey(keyFile, "")
+	assert.Error(t, err)
+}
+
+func TestReadPackageKeys(t *testing.T) {
+	entity, err := openpgp.NewEntity("minima", "test", "minima@example.com", nil)
+	assert.NoError(t, err)
+	keyFile := filepath.Join(t.TempDir(), "RPM-GPG-KEY-minima")
+	var armored bytes.Buffer
+	writer, err := armor.Encode(&armored, openpgp.PublicKeyType, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, entity.Serialize(writer))
+	writer.Close()
+	assert.NoError(t, os.WriteFile(keyFile, armored.Bytes(), 0644))
+
+	keys, err := ReadPackageKeys([]string{keyFile})
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+	assert.Equal(t, entity.PrimaryKey.KeyId, keys[0].PrimaryKey.KeyId)
+
+	_, err = ReadPackageKeys([]string{keyFile + ".missing"})
+	assert.Error(t, err)
+}
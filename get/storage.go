@@ -4,6 +4,7 @@ import (
 	"crypto"
 	"errors"
 	"io"
+	"log"
 
 	"github.com/uyuni-project/minima/util"
 )
@@ -20,20 +21,104 @@ const (
 
 type StorageConfig struct {
 	Type string
+	// default template of repo paths, see ExpandPathTemplate
+	PathTemplate string `yaml:"path_template"`
+	// arrangement of repos matching another tool, like LayoutUyuni, if set
+	Layout string
+	// whether every repo gets a SHA256SUMS file listing the checksums of all its files
+	SHA256Sums bool `yaml:"sha256sums"`
+	// base URLs the storage is published at, listed in metalink and mirrorlist files of every repo
+	Mirrors []string
+	// local file every file added, replaced or deleted is logged to, see AuditStorage
+	AuditLog string `yaml:"audit_log"`
+	// FIPS mode of the syncs storing to it, not configurable per storage, see Syncer.FIPS
+	FIPS bool `yaml:"-"`
+	// logger of the syncs storing to it, for progress of uploads and publishing, the standard logger if nil
+	Logger *log.Logger `yaml:"-"`
 	// file-specific
 	Path string
+	// size of the buffers files are written and read through, like 1M, and fsync policy, see FileStorageOptions
+	BufferSize string `yaml:"buffer_size"`
+	Fsync      string
+	// ownership, permissions and SELinux label of files and directories, see FileAttributes
+	Owner          string
+	Group          string
+	Mode           string
+	SELinuxContext string `yaml:"selinux_context"`
+	// whether the source URL, checksum and time of stored files are recorded in extended attributes
+	ProvenanceXattrs bool `yaml:"provenance_xattrs"`
 	// s3-specific
+	// static credentials, if unset the default AWS credential chain is used with Profile
 	AccessKeyID     string `yaml:"access_key_id"`
 	SecretAccessKey string `yaml:"secret_access_key"`
+	Profile         string
 	Region          string
 	Bucket          string
-	JsonPath        string `yaml:"jsonpath"`
-	ProjectID       string `yaml:"projectid"`
+	// key prefix of all repos in Bucket
+	Prefix string
+	// S3-compatible service to use instead of AWS, with its TLS settings
+	Endpoint             string
+	CACertFile           string `yaml:"ca_cert_file"`
+	InsecureSkipVerify   bool   `yaml:"insecure_skip_verify"`
+	ForcePathStyle       bool   `yaml:"force_path_style"`
+	PartSizeMB           int64  `yaml:"part_size_mb"`
+	UploadConcurrency    int    `yaml:"upload_concurrency"`
+	ServerSideEncryption string `yaml:"server_side_encryption"`
+	SSEKMSKeyID          string `yaml:"sse_kms_key_id"`
+	// default storage class of stored files, and per file name pattern overrides
+	StorageClass      string             `yaml:"storage_class"`
+	StorageClassRules []StorageClassRule `yaml:"storage_class_rules"`
+	// tags of stored files, besides minima-repo with the repo name
+	Tags map[string]string
+	// no tags at all, for S3-compatible services not supporting them
+	DisableTagging bool `yaml:"disable_tagging"`
+	// canned ACL of stored files, eg. public-read
+	ACL           string
+	GenerateIndex bool `yaml:"generate_index"`
+	// azblob-specific, Endpoint and Prefix are also used
+	Account                 string
+	Container               string
+	SASToken                string `yaml:"sas_token"`
+	ManagedIdentityClientID string `yaml:"managed_identity_client_id"`
+	// swift-specific, Container, Region and Prefix are also used
+	AuthURL       string `yaml:"auth_url"`
+	Username      string
+	Password      string
+	UserDomain    string `yaml:"user_domain"`
+	Project       string
+	ProjectDomain string `yaml:"project_domain"`
+	// sftp-specific, Username and Path (on the remote host) are also used
+	Host           string
+	KeyFile        string `yaml:"key_file"`
+	KnownHostsFile string `yaml:"known_hosts_file"`
+	// rsync-specific, destinations pushed to after every sync. Path is the local staging directory,
+	// Username, KeyFile and KnownHostsFile are also used
+	RsyncTargets []string `yaml:"rsync_targets"`
+	// http_put-specific bearer token, eg. an Artifactory access token, instead of Username and Password.
+	// Endpoint is the URL of the target repository, TLS settings also apply
+	Token string
+	// artifactory or nexus to publish packages only to a hosted yum repository at Endpoint, leaving
+	// metadata to the artifact manager
+	ArtifactManager string `yaml:"artifact_manager"`
+	// settings of storage types registered by programs embedding minima
+	Options   map[string]string
+	JsonPath  string `yaml:"jsonpath"`
+	ProjectID string `yaml:"projectid"`
+}
+
+// StorageClassRule selects an S3 storage class for files with a name matching Pattern (see path.Match)
+type StorageClassRule struct {
+	Pattern string
+	Class   string
 }
 
 // Storage allows to store data in the form of files. Files are accumulated in
 // a "temporary" location until Commit is called at that point any file in the
-// temporary location is moved in the "permanent" location
+// temporary location is moved in the "permanent" location.
+//
+// Files are stored one at a time, and Commit is only called once all mapped readers are closed.
+// Implementations should make Commit replace the permanent location as atomically as the backend
+// allows, so that clients never see a partially synced repo. New types are added with RegisterStorage
 type Storage interface {
 	// StoringMapper returns a mapper that will store read data to a temporary location specified by filename
 	StoringMapper(filename string, checksum string, hash crypto.Hash) util.ReaderMapper
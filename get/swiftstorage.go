@@ -0,0 +1,264 @@
+package get
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// swiftStore is an objectStore in an OpenStack Swift container, authenticated with Keystone v3.
+// Objects are uploaded in a single request, so files are limited to 5 GiB
+type swiftStore struct {
+	config    StorageConfig
+	container string
+	token     swiftToken
+	client    *http.Client
+}
+
+// swiftToken is a cached Keystone token, with the object storage endpoint from its catalog
+type swiftToken struct {
+	sync.Mutex
+	value    string
+	endpoint string
+	expires  time.Time
+}
+
+// NewSwiftStorage returns a new Storage backed by an OpenStack Swift container, as specified by
+// the swift-specific fields of config. Files are stored below repoPath, itself below the configured prefix
+func NewSwiftStorage(config StorageConfig, repoPath string) (Storage, error) {
+	store := &swiftStore{
+		config:    config,
+		container: config.Container,
+		client:    http.DefaultClient,
+	}
+	storage, err := newObjectStorage(store, path.Join(config.Prefix, repoPath))
+	if err != nil {
+		return nil, err
+	}
+	return storage, nil
+}
+
+// keystoneAuthRequest maps the body of a Keystone v3 password authentication request
+type keystoneAuthRequest struct {
+	Auth struct {
+		Identity struct {
+			Methods  []string `json:"methods"`
+			Password struct {
+				User keystoneUser `json:"user"`
+			} `json:"password"`
+		} `json:"identity"`
+		Scope struct {
+			Project keystoneProject `json:"project"`
+		} `json:"scope"`
+	} `json:"auth"`
+}
+
+type keystoneUser struct {
+	Name     string         `json:"name"`
+	Domain   keystoneDomain `json:"domain"`
+	Password string         `json:"password"`
+}
+
+type keystoneProject struct {
+	Name   string         `json:"name"`
+	Domain keystoneDomain `json:"domain"`
+}
+
+type keystoneDomain struct {
+	Name string `json:"name"`
+}
+
+// keystoneAuthResponse maps the parts of a Keystone v3 token used to find the object storage endpoint
+type keystoneAuthResponse struct {
+	Token struct {
+		ExpiresAt time.Time `json:"expires_at"`
+		Catalog   []struct {
+			Type      string `json:"type"`
+			Endpoints []struct {
+				Interface string `json:"interface"`
+				Region    string `json:"region"`
+				URL       string `json:"url"`
+			} `json:"endpoints"`
+		} `json:"catalog"`
+	} `json:"token"`
+}
+
+// domainOrDefault returns name, or the Keystone default domain if unset
+func domainOrDefault(name string) keystoneDomain {
+	if name == "" {
+		return keystoneDomain{"Default"}
+	}
+	return keystoneDomain{name}
+}
+
+// authenticate returns a Keystone token and the public object storage endpoint, authenticating again
+// when the token is about to expire
+func (s *swiftStore) authenticate() (token string, endpoint string, err error) {
+	s.token.Lock()
+	defer s.token.Unlock()
+	if s.token.value != "" && time.Now().Add(5*time.Minute).Before(s.token.expires) {
+		return s.token.value, s.token.endpoint, nil
+	}
+
+	var auth keystoneAuthRequest
+	auth.Auth.Identity.Methods = []string{"password"}
+	auth.Auth.Identity.Password.User = keystoneUser{s.config.Username, domainOrDefault(s.config.UserDomain), s.config.Password}
+	auth.Auth.Scope.Project = keystoneProject{s.config.Project, domainOrDefault(s.config.ProjectDomain)}
+	body, err := json.Marshal(auth)
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL := strings.TrimSuffix(s.config.AuthURL, "/") + "/auth/tokens"
+	resp, err := s.client.Post(authURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", "", &UnexpectedStatusCodeError{authURL, resp.StatusCode}
+	}
+
+	var result keystoneAuthResponse
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return "", "", err
+	}
+	for _, service := range result.Token.Catalog {
+		if service.Type != "object-store" {
+			continue
+		}
+		for _, e := range service.Endpoints {
+			if e.Interface == "public" && (s.config.Region == "" || e.Region == s.config.Region) {
+				s.token.value = resp.Header.Get("X-Subject-Token")
+				s.token.endpoint = strings.TrimSuffix(e.URL, "/")
+				s.token.expires = result.Token.ExpiresAt
+				return s.token.value, s.token.endpoint, nil
+			}
+		}
+	}
+	return "", "", fmt.Errorf("no public object-store endpoint in the Keystone catalog for region %q", s.config.Region)
+}
+
+// do sends a request for an object (or the container if key is empty), returning the response if it
+// has the expected status code
+func (s *swiftStore) do(method string, key string, query url.Values, body io.Reader, headers map[string]string, expected int) (*http.Response, error) {
+	token, endpoint, err := s.authenticate()
+	if err != nil {
+		return nil, err
+	}
+	u := endpoint + "/" + url.PathEscape(s.container)
+	if key != "" {
+		u += "/" + swiftEscape(key)
+	}
+	if query != nil {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Auth-Token", token)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != expected {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, ErrFileNotFound
+		}
+		return nil, &UnexpectedStatusCodeError{method + " " + req.URL.Path, resp.StatusCode}
+	}
+	return resp, nil
+}
+
+// swiftEscape escapes each segment of an object name
+func swiftEscape(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func (s *swiftStore) get(key string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, key, nil, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *swiftStore) put(key string, body io.Reader) error {
+	resp, err := s.do(http.MethodPut, key, nil, body, nil, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// copy copies an object server-side
+func (s *swiftStore) copy(source string, destination string) error {
+	headers := map[string]string{"X-Copy-From": url.PathEscape(s.container) + "/" + swiftEscape(source)}
+	resp, err := s.do(http.MethodPut, destination, nil, nil, headers, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// list returns the names of all objects below prefix, a page at a time
+func (s *swiftStore) list(prefix string) ([]string, error) {
+	names := []string{}
+	marker := ""
+	for {
+		resp, err := s.do(http.MethodGet, "", url.Values{"format": {"json"}, "prefix": {prefix}, "marker": {marker}}, nil, nil, http.StatusOK)
+		// older Swift versions answer empty listings with no content
+		if e, ok := err.(*UnexpectedStatusCodeError); ok && e.StatusCode == http.StatusNoContent {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var page []struct {
+			Name string `json:"name"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(page) == 0 {
+			return names, nil
+		}
+		for _, object := range page {
+			names = append(names, object.Name)
+		}
+		marker = page[len(page)-1].Name
+	}
+}
+
+func (s *swiftStore) delete(key string) error {
+	resp, err := s.do(http.MethodDelete, key, nil, nil, nil, http.StatusNoContent)
+	if err == ErrFileNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
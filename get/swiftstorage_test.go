@@ -0,0 +1,162 @@
+package get
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSwift implements Keystone v3 password authentication and the subset of the Swift API used by
+// swiftStore, for container "minima"
+type fakeSwift struct {
+	sync.Mutex
+	objects map[string][]byte
+	// URL of the server, to be returned in the catalog
+	url string
+	// number of authentications
+	tokens int
+}
+
+func (f *fakeSwift) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	if r.URL.Path == "/v3/auth/tokens" {
+		var auth keystoneAuthRequest
+		json.NewDecoder(r.Body).Decode(&auth)
+		user := auth.Auth.Identity.Password.User
+		if user.Name != "minima" || user.Password != "secret" || user.Domain.Name != "Default" || auth.Auth.Scope.Project.Name != "mirrors" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		f.tokens++
+		w.Header().Set("X-Subject-Token", "token")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token": {"expires_at": "2100-01-01T00:00:00Z", "catalog": [
+			{"type": "identity", "endpoints": [{"interface": "public", "region": "RegionOne", "url": "%[1]s/v3"}]},
+			{"type": "object-store", "endpoints": [
+				{"interface": "internal", "region": "RegionOne", "url": "http://invalid/"},
+				{"interface": "public", "region": "RegionTwo", "url": "http://invalid/"},
+				{"interface": "public", "region": "RegionOne", "url": "%[1]s/v1/AUTH_mirrors"}
+			]}
+		]}}`, f.url)
+		return
+	}
+
+	if r.Header.Get("X-Auth-Token") != "token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	key, _ := strings.CutPrefix(r.URL.Path, "/v1/AUTH_mirrors/minima/")
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/AUTH_mirrors/minima":
+		names := []string{}
+		for name := range f.objects {
+			if strings.HasPrefix(name, query.Get("prefix")) && name > query.Get("marker") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		// pages of one object, to exercise paging
+		page := []map[string]string{}
+		if len(names) > 0 {
+			page = append(page, map[string]string{"name": names[0]})
+		}
+		json.NewEncoder(w).Encode(page)
+	case r.Method == http.MethodGet:
+		content, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(content)
+	case r.Method == http.MethodPut && r.Header.Get("X-Copy-From") != "":
+		source, _ := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Copy-From"), "minima/"))
+		content, ok := f.objects[source]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		f.objects[key] = content
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut:
+		f.objects[key], _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodDelete:
+		if _, ok := f.objects[key]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestSwiftStorage(t *testing.T) {
+	f := &fakeSwift{objects: map[string][]byte{
+		"mirror/repo/.minima-prefix":    []byte("a/"),
+		"mirror/repo/a/recycled":        []byte("recycled"),
+		"mirror/repo/a/old":             []byte("old"),
+		"mirror/repo/b/stale":           []byte("stale"),
+		"mirror/other/.minima-prefix":   []byte("a/"),
+		"mirror/other/a/file with 100%": []byte("other"),
+	}}
+	server := httptest.NewServer(f)
+	defer server.Close()
+	f.url = server.URL
+
+	storage, err := NewSwiftStorage(StorageConfig{
+		AuthURL:   server.URL + "/v3/",
+		Username:  "minima",
+		Password:  "secret",
+		Project:   "mirrors",
+		Region:    "RegionOne",
+		Container: "minima",
+		Prefix:    "mirror",
+	}, "/repo/")
+	assert.NoError(t, err)
+	assert.Equal(t, "a/", storage.(*objectStorage).prefix)
+
+	assert.NoError(t, storeBytes(storage, "repodata/repomd.xml", []byte("repomd")))
+	assert.NoError(t, storage.Recycle("recycled"))
+	_, err = storage.NewReader("repodata/repomd.xml", Permanent)
+	assert.Equal(t, ErrFileNotFound, err)
+
+	assert.NoError(t, storage.Commit())
+	assert.Equal(t, map[string][]byte{
+		"mirror/repo/.minima-prefix":        []byte("b/"),
+		"mirror/repo/b/repodata/repomd.xml": []byte("repomd"),
+		"mirror/repo/b/recycled":            []byte("recycled"),
+		"mirror/other/.minima-prefix":       []byte("a/"),
+		"mirror/other/a/file with 100%":     []byte("other"),
+	}, f.objects)
+	assert.Equal(t, 1, f.tokens)
+
+	reader, err := storage.NewReader("repodata/repomd.xml", Permanent)
+	assert.NoError(t, err)
+	content, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("repomd"), content)
+	reader.Close()
+
+	other, err := NewSwiftStorage(StorageConfig{AuthURL: server.URL + "/v3", Username: "minima", Password: "secret", Project: "mirrors", Region: "RegionOne", Container: "minima"}, "mirror/other")
+	assert.NoError(t, err)
+	assert.NoError(t, other.Recycle("file with 100%"))
+	assert.Equal(t, []byte("other"), f.objects["mirror/other/b/file with 100%"])
+
+	_, err = NewSwiftStorage(StorageConfig{AuthURL: server.URL + "/v3", Username: "minima", Password: "secret", Project: "mirrors", Region: "RegionThree", Container: "minima"}, "")
+	assert.Error(t, err)
+}
@@ -3,16 +3,21 @@ package get
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"net/url"
+	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/klauspost/compress/zstd"
@@ -30,7 +35,8 @@ type XMLLocation struct {
 
 // XMLRepomd maps a <repomd> tag in repodata/repomd.xml
 type XMLRepomd struct {
-	Data []XMLData `xml:"data"`
+	Revision string    `xml:"revision"`
+	Data     []XMLData `xml:"data"`
 }
 
 // XMLData maps a <data> tag in repodata/repomd.xml
@@ -38,6 +44,7 @@ type XMLData struct {
 	Type     string      `xml:"type,attr"`
 	Location XMLLocation `xml:"location"`
 	Checksum XMLChecksum `xml:"checksum"`
+	Size     int64       `xml:"size"`
 }
 
 // repodata/<ID>-primary.xml.<compression>
@@ -49,9 +56,33 @@ type XMLMetaData struct {
 
 // XMLPackage maps a <package> tag in repodata/<ID>-primary.xml.<compression>
 type XMLPackage struct {
+	Name     string      `xml:"name"`
 	Arch     string      `xml:"arch"`
+	Version  XMLVersion  `xml:"version"`
 	Location XMLLocation `xml:"location"`
 	Checksum XMLChecksum `xml:"checksum"`
+	License  string      `xml:"format>license"`
+	Size     XMLSize     `xml:"size"`
+	Time     XMLTime     `xml:"time"`
+	// location upstream, if the package is stored at another one, see ShardPackages
+	upstream string
+}
+
+// XMLSize maps a <size> tag in repodata/<ID>-primary.xml.<compression>
+type XMLSize struct {
+	Package int64 `xml:"package,attr"`
+}
+
+// XMLTime maps a <time> tag in repodata/<ID>-primary.xml.<compression>
+type XMLTime struct {
+	Build int64 `xml:"build,attr"`
+}
+
+// XMLVersion maps a <version> tag in repodata/<ID>-primary.xml.<compression>
+type XMLVersion struct {
+	Epoch   string `xml:"epoch,attr"`
+	Version string `xml:"ver,attr"`
+	Release string `xml:"rel,attr"`
 }
 
 // XMLChecksum maps a <checksum> tag in repodata/<ID>-primary.xml.<compression>
@@ -61,12 +92,30 @@ type XMLChecksum struct {
 }
 
 var hashMap = map[string]crypto.Hash{
+	"md5":    crypto.MD5,
 	"sha":    crypto.SHA1,
 	"sha1":   crypto.SHA1,
 	"sha256": crypto.SHA256,
 	"sha512": crypto.SHA512,
 }
 
+// checksumStrength ranks the checksum types of hashMap, see Syncer.MinChecksum
+var checksumStrength = map[string]int{
+	"md5":    1,
+	"sha":    2,
+	"sha1":   2,
+	"sha256": 3,
+	"sha512": 4,
+}
+
+// ValidateMinChecksum checks that minChecksum, if set, is a known checksum type
+func ValidateMinChecksum(minChecksum string) error {
+	if minChecksum != "" && checksumStrength[minChecksum] == 0 {
+		return fmt.Errorf("unsupported checksum type %s", minChecksum)
+	}
+	return nil
+}
+
 const repomdPath = "repodata/repomd.xml"
 const releasePath = "Release"
 
@@ -107,7 +156,6 @@ var (
 			Noarch:               "all",
 		},
 	}
-	SkipLegacy bool
 )
 
 // Syncer syncs repos from an HTTP source to a Storage
@@ -117,6 +165,103 @@ type Syncer struct {
 	archs   map[string]bool
 	storage Storage
 	quiet   bool
+	// SkipFilelists disables mirroring of filelists metadata, which is then dropped from repomd.xml
+	SkipFilelists bool
+	// SkipOther disables mirroring of other (changelog) metadata, which is then dropped from repomd.xml
+	SkipOther bool
+	// SkipLegacy disables mirroring of i586 and i686 packages
+	SkipLegacy bool
+	// OnlyPackages, if set, limits syncs to the packages it contains. Metadata of rpm repos is regenerated
+	// to only list them
+	OnlyPackages *PackageList
+	// OnlyCVEs, if set, limits syncs of rpm repos to the advisories fixing any of these CVEs, and to their
+	// packages. Repos without updateinfo are not mirrored
+	OnlyCVEs map[string]bool
+	// RegenerateMetadata rewrites repodata to only list the packages actually mirrored, when filters exclude any
+	RegenerateMetadata bool
+	// SigningKey, if set, is used to sign rewritten metadata
+	SigningKey *openpgp.Entity
+	// ExtraFiles are local files published alongside mirrored content
+	ExtraFiles []ExtraFile
+	// Client downloads from the repo, http.DefaultClient if nil
+	Client *http.Client
+	// RefreshURL, if set, returns a new URL for the repo when downloads are refused, as tokens in URLs of
+	// SCC repos can expire during long syncs
+	RefreshURL func(repoURL string) (string, error)
+	// Logger receives progress messages, the standard logger if nil
+	Logger *log.Logger
+	// Hooks run around StoreRepo with HookVariables in their environment
+	Hooks         SyncHooks
+	HookVariables map[string]string
+	// Events, if set, is called with the progress of StoreRepo
+	Events func(Event)
+	// MinChecksum, if set, is the weakest checksum type accepted in metadata, eg. sha256. Repos listing
+	// files with weaker or no checksums are refused
+	MinChecksum string
+	// FIPS restricts hashing and verification to FIPS 140-3 approved algorithms: metadata listing files
+	// with MD5 or SHA-1 checksums only, or signed with those, is refused. It is always on when the Go FIPS
+	// 140-3 module is enabled, eg. with GODEBUG=fips140=on
+	FIPS bool
+	// PackageKeys, if set, must have signed each RPM, whose embedded signature is verified on download
+	PackageKeys openpgp.EntityList
+	// QuarantineDir, if set, is a local directory downloads failing verification are moved to, with a
+	// QuarantineReport
+	QuarantineDir string
+	// Revision, if set, pins the repo to the repomd.xml with this <revision>, and Snapshot to the metadata
+	// file, repomd.xml or Release, with this SHA-256 checksum. Syncs of any other metadata fail with a
+	// PinError, keeping the mirror as it is
+	Revision string
+	Snapshot string
+	// AsOf, if set, mirrors the repo as it was at that time, approximately: advisories issued later are dropped
+	// from updateinfo, and packages only listed in those advisories are not mirrored, metadata then being
+	// regenerated as with RegenerateMetadata
+	AsOf time.Time
+	// PatchStream, if set, only mirrors the packages of advisories issued since the last sync, replacing the
+	// previous ones. Metadata is regenerated to list only them, so that the repo is an incremental patch
+	// channel. Without newer advisories, the repo is kept as it is
+	PatchStream bool
+	// ExcludeLicenses, if set, are shell patterns of licenses, like SUSE-NonFree or *Proprietary*, whose
+	// packages are not mirrored. Metadata is regenerated to not list them
+	ExcludeLicenses []string
+	// licenses of packages excluded by ExcludeLicenses in the last attempt, by location
+	licenseExcluded map[string]string
+	// MaxSize, if set, is the maximum size in bytes of the packages of the repo. QuotaPolicy decides what
+	// happens to repos exceeding it, by default QuotaFail
+	MaxSize     int64
+	QuotaPolicy string
+	// ChecksumCache, if set, spares hashing again files in the temporary location that are unchanged since
+	// an interrupted sync downloaded or verified them
+	ChecksumCache *ChecksumCache
+	// Dedup, if set, is shared by the Syncers of a run so that packages listed by several repos are only
+	// downloaded once
+	Dedup *Dedup
+	// Zsync, if set, downloads large packages whose upstream publishes a zsync control file next to them only
+	// in part, copying the blocks that did not change from the previous version of the package
+	Zsync bool
+	// RepairPieces, if set, downloads large packages of which a corrupted or partial copy is stored only in
+	// part, where upstream publishes a metalink or torrent with checksums of their pieces next to them
+	RepairPieces bool
+	// locations of the packages of the last sync by name and arch, read when first needed by Zsync
+	seeds map[string]string
+	// ShardPackages, if set, stores the packages of rpm repos in subdirectories of their upstream directories
+	// named after the first two hex digits of their checksum, like x86_64/3f/foo-1.0-1.x86_64.rpm, so that
+	// directories of repos with hundreds of thousands of packages stay small. primary is rewritten to match
+	ShardPackages bool
+	// RecompressMetadata, if set, is the compression, gz or zst, metadata files compressed with the other one
+	// are stored with. repomd.xml is rewritten to list them, and they are downloaded again at every sync
+	RecompressMetadata string
+	// advisories selected by AsOf, PatchStream and OnlyCVEs in the last attempt
+	advisories *advisorySelection
+	// number of files downloaded by the last attempt
+	changed int
+}
+
+// ExtraFile defines a local file to be published in a mirrored repo
+type ExtraFile struct {
+	// Source is the path of the local file
+	Source string
+	// Target is the repo-relative path to publish the file at, defaults to the Source base name
+	Target string
 }
 
 // Decision encodes what to do with a file
@@ -133,23 +278,69 @@ const (
 
 // NewSyncer creates a new Syncer
 func NewSyncer(url url.URL, archs map[string]bool, storage Storage, quiet bool) *Syncer {
-	return &Syncer{url, archs, storage, quiet}
+	return &Syncer{URL: url, archs: archs, storage: storage, quiet: quiet}
 }
 
 // StoreRepo stores an HTTP repo in a Storage, automatically retrying in case of recoverable errors
 func (r *Syncer) StoreRepo() (err error) {
-	checksumMap := r.readChecksumMap()
+	return r.StoreRepoContext(context.Background())
+}
+
+// StoreRepoContext is StoreRepo, stopping downloads and retries once ctx is done. The new repo is not
+// committed then
+func (r *Syncer) StoreRepoContext(ctx context.Context) (err error) {
+	r.send(Event{Type: RepoStarted, Repo: eventRepo(r.URL)})
+	err = storeWithHooks(ctx, r.Hooks, r.HookVariables, r.logger(), func() (int, error) {
+		checksumMap := r.readChecksumMap()
+		err := retryTemporaryErrors(ctx, r.logger(), func() error {
+			err := r.storeRepo(ctx, checksumMap)
+			if verificationError(err) {
+				r.send(Event{Type: VerificationFailed, Repo: eventRepo(r.URL), Err: err})
+			}
+			return err
+		})
+		return r.changed, err
+	})
+	r.send(Event{Type: RepoFinished, Repo: eventRepo(r.URL), Err: err})
+	return err
+}
+
+// send calls Events, if set
+func (r *Syncer) send(event Event) {
+	if r.Events != nil {
+		r.Events(event)
+	}
+}
+
+// logger returns the Logger of the syncer, or the standard logger
+func (r *Syncer) logger() *log.Logger {
+	return loggerOrDefault(r.Logger)
+}
+
+// loggerOrDefault returns logger, or the standard logger if nil
+func loggerOrDefault(logger *log.Logger) *log.Logger {
+	if logger == nil {
+		return log.Default()
+	}
+	return logger
+}
+
+// retryTemporaryErrors calls store until it succeeds or returns an error which is not presumably temporary
+func retryTemporaryErrors(ctx context.Context, logger *log.Logger, store func() error) (err error) {
 	for i := 0; i < 20; i++ {
-		err = r.storeRepo(checksumMap)
+		err = store()
 		if err == nil {
 			return
 		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
 		uerr, unexpectedStatusCode := err.(*UnexpectedStatusCodeError)
 		if unexpectedStatusCode {
 			sc := uerr.StatusCode
 			if sc == 401 || sc == 403 || sc == 404 || sc == 410 || sc == 502 || sc == 503 || sc == 504 {
-				log.Printf("Got %v, presumably temporarily, retrying...\n", sc)
+				logger.Printf("Got %v, presumably temporarily, retrying...\n", sc)
 			} else {
 				return err
 			}
@@ -157,97 +348,351 @@ func (r *Syncer) StoreRepo() (err error) {
 
 		_, checksumError := err.(*util.ChecksumError)
 		if checksumError {
-			log.Println(err.Error())
-			log.Println("Checksum did not match, presumably the repo was published while syncing, retrying...")
+			logger.Println(err.Error())
+			logger.Println("Checksum did not match, presumably the repo was published while syncing, retrying...")
 			continue
 		}
 
 		_, signatureError := err.(*SignatureError)
 		if signatureError {
-			log.Println(err.Error())
-			log.Println("Signature not valid, presumably the repo was published while syncing, retrying...")
+			logger.Println(err.Error())
+			logger.Println("Signature not valid, presumably the repo was published while syncing, retrying...")
 		} else {
 			return err
 		}
 	}
 
-	log.Println("Too many temporary errors, aborting...")
+	logger.Println("Too many temporary errors, aborting...")
 	return err
 }
 
 // StoreRepo stores an HTTP repo in a Storage
-func (r *Syncer) storeRepo(checksumMap map[string]XMLChecksum) (err error) {
-	packagesToDownload, packagesToRecycle, err := r.processMetadata(checksumMap)
+func (r *Syncer) storeRepo(ctx context.Context, checksumMap map[string]XMLChecksum) (err error) {
+	r.changed = 0
+	r.seeds = nil
+	defer func() {
+		saveErr := r.ChecksumCache.Save()
+		if err == nil {
+			err = saveErr
+		}
+	}()
+	r.licenseExcluded = nil
+	packagesToDownload, packagesToRecycle, err := r.processMetadata(ctx, checksumMap)
+	if err != nil {
+		return
+	}
+	r.reportExcludedLicenses()
+	err = r.checkPackageChecksums(append(packagesToDownload, packagesToRecycle...))
+	if err != nil {
+		return
+	}
+	err = r.checkPackageCollisions(append(packagesToDownload, packagesToRecycle...))
 	if err != nil {
 		return
 	}
 
 	downloadCount := len(packagesToDownload)
-	log.Printf("Downloading %v packages...\n", downloadCount)
+	downloadSize := int64(0)
+	for _, pack := range packagesToDownload {
+		downloadSize += pack.Size.Package
+	}
+	r.send(Event{Type: PackagesPlanned, Repo: eventRepo(r.URL), Count: downloadCount, Size: downloadSize})
+	r.logger().Printf("Downloading %v packages...\n", downloadCount)
 	for i, pack := range packagesToDownload {
-		// we need to escape package names because some CDN, proxies (...) are not perfectly RFC 3986 compliant
-		// in such cases characters like '+' (which are common in c++ pkgs) will assume a different meaning
-		name := path.Base(pack.Location.Href)
-		escapedName := url.QueryEscape(name)
-		relativeURL := strings.TrimSuffix(pack.Location.Href, name) + escapedName
-
-		description := fmt.Sprintf("(%v/%v) %v", i+1, downloadCount, name)
-		err = r.downloadStoreApply(relativeURL, pack.Checksum.Checksum, description, hashMap[pack.Checksum.Type], util.Nop)
+		err = r.downloadPackage(ctx, pack, i, downloadCount)
 		if err != nil {
 			return err
 		}
 	}
 
 	recycleCount := len(packagesToRecycle)
-	log.Printf("Recycling %v packages...\n", recycleCount)
+	r.logger().Printf("Recycling %v packages...\n", recycleCount)
 	for _, pack := range packagesToRecycle {
+		if err = ctx.Err(); err != nil {
+			return
+		}
 		err = r.storage.Recycle(pack.Location.Href)
 		if err != nil {
 			return
 		}
 	}
 
-	log.Println("Committing changes...")
+	err = r.storeExtraFiles()
+	if err != nil {
+		return
+	}
+
+	r.logger().Println("Committing changes...")
 	err = r.storage.Commit()
 	if err != nil {
 		return
 	}
-	return
+	return r.ChecksumCache.Clear()
 }
 
-// downloadStoreApply downloads a repo-relative path into a file, while applying a ReaderConsumer
-func (r *Syncer) downloadStoreApply(relativePath string, checksum string, description string, hash crypto.Hash, f util.ReaderConsumer) error {
-	if !r.quiet {
-		log.Printf("Downloading %v...", description)
+// downloadPackage downloads the i-th of count packages into the temporary location
+func (r *Syncer) downloadPackage(ctx context.Context, pack XMLPackage, i int, count int) error {
+	name := path.Base(pack.Location.Href)
+	relativeURL := escapeLocation(pack.upstreamLocation())
+	storageURL := escapeLocation(pack.Location.Href)
+
+	description := fmt.Sprintf("(%v/%v) %v", i+1, count, name)
+	verify := util.Nop
+	if r.verifiesSignature(pack.Location.Href) {
+		verify = func(reader io.ReadCloser) error {
+			return verifyRPMSignature(reader, pack.Location.Href, r.PackageKeys, r.FIPS)
+		}
+	}
+	stored, err := r.copyDownloaded(pack, storageURL, description, verify)
+	if err != nil {
+		return err
+	}
+	if stored {
+		r.ChecksumCache.Add(pack.Location.Href, pack.Checksum)
+		return nil
+	}
+	if r.RepairPieces && pack.Size.Package >= pieceRepairMinSize {
+		if seed, ok := r.repairSeed(pack); ok {
+			stored, err := r.piecesStoreApply(ctx, pack, relativeURL, storageURL, seed, description, verify)
+			if err != nil {
+				return err
+			}
+			if stored {
+				r.ChecksumCache.Add(pack.Location.Href, pack.Checksum)
+				r.Dedup.add(pack.Checksum, r.storage, pack.Location.Href)
+				return nil
+			}
+		}
+	}
+	// zsync locates blocks by MD4 checksums, so it is not used in FIPS mode
+	if r.Zsync && pack.Size.Package >= zsyncMinSize && !fipsMode(r.FIPS) {
+		if seed, ok := r.zsyncSeed(pack); ok {
+			stored, err := r.zsyncStoreApply(ctx, pack, relativeURL, storageURL, seed, description, verify)
+			if err != nil {
+				return err
+			}
+			if stored {
+				r.ChecksumCache.Add(pack.Location.Href, pack.Checksum)
+				r.Dedup.add(pack.Checksum, r.storage, pack.Location.Href)
+				return nil
+			}
+		}
 	}
+	body, err := r.download(ctx, relativeURL, description)
+	if err != nil {
+		return err
+	}
+	err = r.storeApply(body, storageURL, pack.Checksum.Checksum, hashMap[pack.Checksum.Type], verify)
+	if err != nil {
+		return err
+	}
+	r.ChecksumCache.Add(pack.Location.Href, pack.Checksum)
+	r.Dedup.add(pack.Checksum, r.storage, pack.Location.Href)
+	return nil
+}
 
-	repoURL := r.URL
-	repoURL.Path = path.Join(repoURL.Path, relativePath)
-	finalURL := fmt.Sprintf("%s://%s%s?%s", repoURL.Scheme, repoURL.Host, repoURL.Path, repoURL.Query().Encode())
+// verifiesSignature returns true if the signature of the package at location is checked against PackageKeys
+func (r *Syncer) verifiesSignature(location string) bool {
+	return r.PackageKeys != nil && path.Ext(location) == ".rpm"
+}
 
-	body, err := ReadURL(finalURL)
+// storeExtraFiles copies ExtraFiles to the temporary location
+func (r *Syncer) storeExtraFiles() error {
+	for _, extraFile := range r.ExtraFiles {
+		target := extraFile.Target
+		if target == "" {
+			target = filepath.Base(extraFile.Source)
+		}
+		target = path.Clean(filepath.ToSlash(target))
+		if path.IsAbs(target) || target == ".." || strings.HasPrefix(target, "../") {
+			return fmt.Errorf("extra file target %s is outside of the repo", extraFile.Target)
+		}
+
+		if !r.quiet {
+			r.logger().Printf("Publishing %v...", target)
+		}
+		f, err := os.Open(extraFile.Source)
+		if err != nil {
+			return err
+		}
+		err = util.Compose(r.storage.StoringMapper(target, "", 0), util.Nop)(f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadStoreApply downloads a repo-relative path into a file, while applying a ReaderConsumer
+func (r *Syncer) downloadStoreApply(ctx context.Context, relativePath string, checksum string, description string, hash crypto.Hash, f util.ReaderConsumer) error {
+	body, err := r.download(ctx, relativePath, description)
 	if err != nil {
 		return err
 	}
+	return r.storeApply(body, relativePath, checksum, hash, f)
+}
+
+// storeApply stores body as a repo-relative path, while applying a ReaderConsumer
+func (r *Syncer) storeApply(body io.ReadCloser, relativePath string, checksum string, hash crypto.Hash, f util.ReaderConsumer) error {
 	// unescape to preserve original pkg name
 	storagePath, err := url.QueryUnescape(relativePath)
+	if err != nil {
+		body.Close()
+		return err
+	}
+	setModTime(r.storage, storagePath, lastModified(body))
+	counted := &countingReader{ReadCloser: body}
+	body, quarantined, err := r.quarantining(counted, storagePath, checksum)
+	if err != nil {
+		return err
+	}
+	err = util.Compose(r.storage.StoringMapper(storagePath, checksum, hash), f)(body)
+	quarantined.finish(err)
 	if err != nil {
 		return err
 	}
-	return util.Compose(r.storage.StoringMapper(storagePath, checksum, hash), f)(body)
+	r.changed++
+	r.send(Event{Type: FileDownloaded, Repo: eventRepo(r.URL), File: storagePath, Size: counted.count})
+	return nil
+}
+
+// download returns a Reader for a repo-relative path
+func (r *Syncer) download(ctx context.Context, relativePath string, description string) (io.ReadCloser, error) {
+	if !r.quiet {
+		r.logger().Printf("Downloading %v...", description)
+	}
+
+	body, err := r.readRelative(ctx, relativePath)
+	uerr, unexpectedStatusCode := err.(*UnexpectedStatusCodeError)
+	if r.RefreshURL == nil || !unexpectedStatusCode || (uerr.StatusCode != 401 && uerr.StatusCode != 403) {
+		return body, err
+	}
+
+	refreshed, refreshErr := r.RefreshURL(r.URL.String())
+	if refreshErr != nil {
+		r.logger().Printf("Cannot refresh the URL of %s: %v\n", r.URL.Redacted(), refreshErr)
+		return nil, err
+	}
+	refreshedURL, refreshErr := url.Parse(refreshed)
+	if refreshErr != nil || refreshedURL.String() == r.URL.String() {
+		return nil, err
+	}
+	r.logger().Printf("Access to %s was refused, retrying with a refreshed token...\n", description)
+	r.URL = *refreshedURL
+	return r.readRelative(ctx, relativePath)
+}
+
+// readRelative returns a Reader for a repo-relative path, without logging
+func (r *Syncer) readRelative(ctx context.Context, relativePath string) (io.ReadCloser, error) {
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return readURL(ctx, client, r.repoFileURL(relativePath))
+}
+
+// repoFileURL returns the URL of a repo-relative path
+func (r *Syncer) repoFileURL(relativePath string) string {
+	repoURL := r.URL
+	repoURL.Path = path.Join(repoURL.Path, relativePath)
+	return fmt.Sprintf("%s://%s%s?%s", repoURL.Scheme, repoURL.Host, repoURL.Path, repoURL.Query().Encode())
+}
+
+// downloadAll reads a repo-relative path fully in memory
+func (r *Syncer) downloadAll(ctx context.Context, relativePath string) ([]byte, error) {
+	body, err := r.download(ctx, relativePath, path.Base(relativePath))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	// kept if the content is stored unchanged
+	setModTime(r.storage, relativePath, lastModified(body))
+	return io.ReadAll(body)
+}
+
+// downloadVerified returns a Reader for a repo-relative path, checking on Close that the checksum matches
+func (r *Syncer) downloadVerified(ctx context.Context, relativePath string, checksum XMLChecksum) (io.ReadCloser, error) {
+	err := r.checkChecksum(relativePath, checksum)
+	if err != nil {
+		return nil, err
+	}
+	body, err := r.download(ctx, relativePath, path.Base(relativePath))
+	if err != nil {
+		return nil, err
+	}
+	body, quarantined, err := r.quarantining(body, relativePath, checksum.Checksum)
+	if err != nil {
+		return nil, err
+	}
+	checker := util.NewChecksummingWriter(util.NewNopWriteCloser(io.Discard), checksum.Checksum, hashMap[checksum.Type])
+	if quarantined == nil {
+		return util.NewTeeReadCloser(body, checker), nil
+	}
+	return &quarantiningReader{util.NewTeeReadCloser(body, checker), quarantined}, nil
+}
+
+// checkChecksum returns an error if checksum, of the file at location, is weaker than MinChecksum or is
+// not approved in FIPS mode
+func (r *Syncer) checkChecksum(location string, checksum XMLChecksum) error {
+	if fipsMode(r.FIPS) && !fipsChecksums[checksum.Type] {
+		if checksum.Type == "" {
+			return &ChecksumPolicyError{fmt.Sprintf("%s has no checksum, which FIPS mode requires", location)}
+		}
+		return &ChecksumPolicyError{fmt.Sprintf("%s only has a %s checksum, which is not FIPS approved", location, checksum.Type)}
+	}
+	if r.MinChecksum == "" || checksumStrength[checksum.Type] >= checksumStrength[r.MinChecksum] {
+		return nil
+	}
+	if checksum.Type == "" {
+		return &ChecksumPolicyError{fmt.Sprintf("%s has no checksum, %s is required", location, r.MinChecksum)}
+	}
+	return &ChecksumPolicyError{fmt.Sprintf("%s only has a %s checksum, %s is required", location, checksum.Type, r.MinChecksum)}
+}
+
+// checkPackageChecksums returns an error if any of packages has a checksum weaker than MinChecksum
+func (r *Syncer) checkPackageChecksums(packages []XMLPackage) error {
+	for _, pack := range packages {
+		err := r.checkChecksum(pack.Location.Href, pack.Checksum)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadVerifiedAll reads a repo-relative path fully in memory, checking that the checksum matches
+func (r *Syncer) downloadVerifiedAll(ctx context.Context, relativePath string, checksum XMLChecksum) ([]byte, error) {
+	reader, err := r.downloadVerified(ctx, relativePath, checksum)
+	if err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(reader)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return b, reader.Close()
+}
+
+// storeBytes stores content in a file in the temporary location of a Storage
+func storeBytes(storage Storage, filename string, content []byte) error {
+	return util.Compose(storage.StoringMapper(filename, "", 0), util.Nop)(util.NewNopReadCloser(bytes.NewReader(content)))
 }
 
 // processMetadata stores the repo metadata and returns a list of package file
 // paths to download
-func (r *Syncer) processMetadata(checksumMap map[string]XMLChecksum) (packagesToDownload []XMLPackage, packagesToRecycle []XMLPackage, err error) {
-	doProcessMetadata := func(reader io.ReadCloser, repoType RepoType) (err error) {
-		b, err := io.ReadAll(reader)
+func (r *Syncer) processMetadata(ctx context.Context, checksumMap map[string]XMLChecksum) (packagesToDownload []XMLPackage, packagesToRecycle []XMLPackage, err error) {
+	doProcessMetadata := func(repoType RepoType) (err error) {
+		b, err := r.downloadAll(ctx, repoType.MetadataPath)
 		if err != nil {
 			return
 		}
 
-		err = r.checkRepomdSignature(bytes.NewReader(b), repoType)
+		signatureFiles, err := r.checkRepomdSignature(ctx, bytes.NewReader(b), repoType)
 		if err != nil {
+			if verificationError(err) {
+				r.quarantineBytes(repoType.MetadataPath, b, err)
+			}
 			return
 		}
 
@@ -255,30 +700,130 @@ func (r *Syncer) processMetadata(checksumMap map[string]XMLChecksum) (packagesTo
 		if err != nil {
 			return
 		}
+		err = r.checkPin(repoType.MetadataPath, b, repomd)
+		if err != nil {
+			return
+		}
+
+		skipped := func(string) bool { return false }
+		if repoType.MetadataPath == repomdPath {
+			skipped = r.skippedMetadata()
+		}
 
 		data := repomd.Data
+		rewrite := false
+		recompressed := map[string]repomdData{}
+
+		// when selecting advisories, updateinfo is processed first to know what packages to mirror
+		r.advisories = nil
+		if r.selectsAdvisories() && repoType.MetadataPath == repomdPath {
+			for _, entry := range data {
+				if entry.Type == "updateinfo" {
+					r.advisories, err = r.filterUpdateinfo(ctx, entry)
+					if err != nil {
+						return
+					}
+				}
+			}
+		}
+		if r.selectsAdvisories() && r.advisories == nil {
+			r.advisories = r.newAdvisorySelection()
+			if r.advisories.exclusive {
+				r.logger().Println("No updateinfo, no packages are mirrored")
+			} else {
+				r.logger().Println("No updateinfo, mirroring all packages")
+			}
+		}
+		if uncovered := r.UncoveredCVEs(); len(uncovered) > 0 {
+			r.logger().Printf("%d of %d CVEs are not fixed by this repo: %s\n", len(uncovered), len(r.OnlyCVEs), strings.Join(uncovered, ", "))
+		}
+
+		// when regenerating metadata, primary is processed first to know what packages other files must keep
+		var regenerated *regeneration
+		regenerate := (r.RegenerateMetadata || r.ShardPackages || r.OnlyPackages != nil || len(r.ExcludeLicenses) > 0 || r.evicting() || r.advisories.dropsPackages()) && repoType.MetadataPath == repomdPath
+		if regenerate {
+			for _, entry := range data {
+				if entry.Type == repoType.PackagesType {
+					regenerated, packagesToDownload, packagesToRecycle, err = r.regeneratePrimary(ctx, entry, checksumMap, repoType)
+					if err != nil {
+						return
+					}
+				}
+			}
+			rewrite = regenerated != nil || r.advisories.affects("updateinfo")
+		}
+
 		for _, entry := range data {
 			if !r.quiet {
-				log.Println(entry.Location.Href)
+				r.logger().Println(entry.Location.Href)
+			}
+
+			if skipped(entry.Type) {
+				if !r.quiet {
+					r.logger().Println("...skipping")
+				}
+				rewrite = true
+				continue
+			}
+
+			if regenerate && entry.Type == repoType.PackagesType {
+				// already processed
+				continue
+			}
+
+			if r.advisories != nil && (entry.Type == "updateinfo" || r.advisories.affects(entry.Type)) {
+				// already processed, or a variant of rewritten updateinfo to drop
+				continue
+			}
+
+			if regenerated != nil && regenerated.affects(entry.Type) {
+				if !r.quiet {
+					r.logger().Println("...regenerating")
+				}
+				err = regenerated.regenerate(ctx, r, entry)
+				if err != nil {
+					return
+				}
+				rewrite = true
+				continue
 			}
 
 			metadataLocation := entry.Location.Href
 			metadataChecksum := entry.Checksum
+			err = r.checkChecksum(metadataLocation, metadataChecksum)
+			if err != nil {
+				return
+			}
+
+			if r.recompresses(entry) {
+				if !r.quiet {
+					r.logger().Println("...recompressing")
+				}
+				recompressed[entry.Type], err = r.recompress(ctx, entry)
+				if err != nil {
+					return
+				}
+				if entry.Type == repoType.PackagesType {
+					packagesToDownload, packagesToRecycle, err = r.processPrimary(recompressed[entry.Type].Location.Href, checksumMap, repoType)
+				}
+				rewrite = true
+				continue
+			}
 
 			decision := r.decide(metadataLocation, metadataChecksum, checksumMap)
 			switch decision {
 			case Download:
 				if !r.quiet {
-					log.Println("...downloading")
+					r.logger().Println("...downloading")
 				}
 
-				err = r.downloadStoreApply(metadataLocation, metadataChecksum.Checksum, path.Base(metadataLocation), hashMap[metadataChecksum.Type], util.Nop)
+				err = r.downloadStoreApply(ctx, metadataLocation, metadataChecksum.Checksum, path.Base(metadataLocation), hashMap[metadataChecksum.Type], util.Nop)
 				if err != nil {
 					return
 				}
 			case Recycle:
 				if !r.quiet {
-					log.Println("...recycling")
+					r.logger().Println("...recycling")
 				}
 
 				r.storage.Recycle(metadataLocation)
@@ -288,60 +833,135 @@ func (r *Syncer) processMetadata(checksumMap map[string]XMLChecksum) (packagesTo
 				packagesToDownload, packagesToRecycle, err = r.processPrimary(metadataLocation, checksumMap, repoType)
 			}
 		}
+
+		// updateinfo is rewritten when advisories are dropped or recompressed
+		if r.advisories.affects("updateinfo") {
+			rewrite = true
+		}
+		if rewrite {
+			document, err := parseRepomd(b)
+			if err != nil {
+				return err
+			}
+			document.removeData(skipped)
+			if regenerated != nil {
+				regenerated.update(&document)
+			}
+			if r.advisories.affects("updateinfo") {
+				r.advisories.update(&document)
+			}
+			document.replaceData(recompressed)
+			b, err = document.marshal()
+			if err != nil {
+				return err
+			}
+			// the upstream signature does not match the rewritten file anymore
+			setModTime(r.storage, repoType.MetadataPath, time.Time{})
+			for filename := range signatureFiles {
+				setModTime(r.storage, filename, time.Time{})
+			}
+			signatureFiles = nil
+			if r.SigningKey != nil {
+				signatureFiles, err = signMetadata(r.SigningKey, b, repoType)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		err = storeBytes(r.storage, repoType.MetadataPath, b)
+		if err != nil {
+			return
+		}
+		for filename, content := range signatureFiles {
+			err = storeBytes(r.storage, filename, content)
+			if err != nil {
+				return
+			}
+		}
 		return
 	}
 
-	err = r.downloadStoreApply(repomdPath, "", path.Base(repomdPath), 0, func(reader io.ReadCloser) (err error) {
-		err = doProcessMetadata(reader, repoTypes["rpm"])
+	err = doProcessMetadata(repoTypes["rpm"])
+	switch err.(type) {
+	case *ChecksumPolicyError, *PinError, *QuotaError:
 		return
-	})
+	}
 	if err != nil {
-		log.Println(err.Error())
-		log.Println("Fallback to next repo type")
+		r.logger().Println(err.Error())
+		r.logger().Println("Fallback to next repo type")
 		// attempt to download Debian's Release file
-		err = r.downloadStoreApply(releasePath, "", path.Base(releasePath), 0, func(reader io.ReadCloser) (err error) {
-			err = doProcessMetadata(reader, repoTypes["deb"])
-			return
-		})
+		err = doProcessMetadata(repoTypes["deb"])
 		return
 	}
 
 	return
 }
 
-func (r *Syncer) checkRepomdSignature(repomdReader io.Reader, repoType RepoType) (err error) {
+// checkPin checks the metadata file at location, with content b, against Revision and Snapshot
+func (r *Syncer) checkPin(location string, b []byte, repomd XMLRepomd) error {
+	revision := strings.TrimSpace(repomd.Revision)
+	if r.Revision != "" && revision != r.Revision {
+		if revision == "" {
+			return &PinError{fmt.Sprintf("%s has no revision, the repo is pinned to revision %s", location, r.Revision)}
+		}
+		return &PinError{fmt.Sprintf("%s has revision %s, the repo is pinned to revision %s", location, revision, r.Revision)}
+	}
+	if r.Snapshot != "" {
+		checksum, err := util.Checksum(io.NopCloser(bytes.NewReader(b)), crypto.SHA256)
+		if err != nil {
+			return err
+		}
+		if checksum != r.Snapshot {
+			return &PinError{fmt.Sprintf("%s has checksum %s, the repo is pinned to snapshot %s", location, checksum, r.Snapshot)}
+		}
+	}
+	return nil
+}
+
+// checkRepomdSignature verifies the metadata signature, if upstream provides one, and
+// returns the signature and key files to be stored alongside the metadata
+func (r *Syncer) checkRepomdSignature(ctx context.Context, repomdReader io.Reader, repoType RepoType) (signatureFiles map[string][]byte, err error) {
 	ascPath := repoType.MetadataPath + repoType.MetadataSignatureExt
 	keyPath := repoType.MetadataPath + ".key"
 
-	err = r.downloadStoreApply(ascPath, "", path.Base(ascPath), 0, func(signatureReader io.ReadCloser) (err error) {
-		err = r.downloadStoreApply(keyPath, "", path.Base(keyPath), 0, func(keyReader io.ReadCloser) (err error) {
-			keyring, err := openpgp.ReadArmoredKeyRing(keyReader)
-			if err != nil {
-				return &SignatureError{keyPath + " file does not contain a valid signature"}
-			}
-			_, err = openpgp.CheckArmoredDetachedSignature(keyring, repomdReader, signatureReader, nil)
-			if err != nil {
-				return &SignatureError{ascPath + " signature check failed, signature is not valid"}
-			}
-			return
-		})
+	signature, err := r.downloadAll(ctx, ascPath)
+	if err != nil {
+		err = ignoreStatusCode(r.logger(), err, 403, 404)
+		return
+	}
+	signatureFiles = map[string][]byte{ascPath: signature}
+
+	key, err := r.downloadAll(ctx, keyPath)
+	if err != nil {
+		err = ignoreStatusCode(r.logger(), err, 404)
+		return
+	}
+	signatureFiles[keyPath] = key
+
+	if fipsMode(r.FIPS) {
+		err = checkFIPSSignature(ascPath, signature)
 		if err != nil {
-			err = ignoreStatusCode(err, 404)
+			return nil, err
 		}
-		return
-	})
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(key))
+	if err != nil {
+		return nil, &SignatureError{keyPath + " file does not contain a valid signature"}
+	}
+	_, err = openpgp.CheckArmoredDetachedSignature(keyring, repomdReader, bytes.NewReader(signature), nil)
 	if err != nil {
-		err = ignoreStatusCode(err, 403, 404)
+		return nil, &SignatureError{ascPath + " signature check failed, signature is not valid"}
 	}
 	return
 }
 
-func ignoreStatusCode(err error, codes ...int) error {
+func ignoreStatusCode(logger *log.Logger, err error, codes ...int) error {
 	uerr, unexpectedStatusCode := err.(*UnexpectedStatusCodeError)
 	if unexpectedStatusCode {
 		for _, code := range codes {
 			if uerr.StatusCode == code {
-				log.Printf("Got %d, ignoring...\n", code)
+				logger.Printf("Got %d, ignoring...\n", code)
 				return nil
 			}
 		}
@@ -358,38 +978,56 @@ func (e *SignatureError) Error() string {
 	return fmt.Sprintf("Signature error: %s", e.reason)
 }
 
+// ChecksumPolicyError is returned if metadata lists a file with a checksum weaker than MinChecksum
+type ChecksumPolicyError struct {
+	reason string
+}
+
+func (e *ChecksumPolicyError) Error() string {
+	return e.reason
+}
+
+// PinError is returned if upstream metadata does not match the Revision or Snapshot a repo is pinned to
+type PinError struct {
+	reason string
+}
+
+func (e *PinError) Error() string {
+	return e.reason
+}
+
 // Uncompress and read primary XML
 func readMetaData(reader io.Reader, compType string) (XMLMetaData, error) {
 	var primary XMLMetaData
 
+	uncompressed, err := decompress(reader, compType)
+	if err != nil {
+		return primary, err
+	}
+	defer uncompressed.Close()
+
+	decoder := xml.NewDecoder(uncompressed)
+	if err = decoder.Decode(&primary); err != nil {
+		return primary, err
+	}
+
+	return primary, nil
+}
+
+// decompress returns a Reader for uncompressed data given a Reader for data compressed with compType
+func decompress(reader io.Reader, compType string) (io.ReadCloser, error) {
 	switch compType {
 	case "gz":
-		reader, err := gzip.NewReader(reader)
-		if err != nil {
-			return primary, err
-		}
-		defer reader.Close()
-
-		decoder := xml.NewDecoder(reader)
-		if err = decoder.Decode(&primary); err != nil {
-			return primary, err
-		}
+		return gzip.NewReader(reader)
 	case "zst":
-		reader, err := zstd.NewReader(reader)
+		decoder, err := zstd.NewReader(reader)
 		if err != nil {
-			return primary, err
-		}
-		defer reader.Close()
-
-		decoder := xml.NewDecoder(reader)
-		if err = decoder.Decode(&primary); err != nil {
-			return primary, err
+			return nil, err
 		}
+		return decoder.IOReadCloser(), nil
 	default:
-		return primary, errors.New("unsupported compression type")
+		return nil, errors.New("unsupported compression type")
 	}
-
-	return primary, nil
 }
 
 func (r *Syncer) readChecksumMap() (checksumMap map[string]XMLChecksum) {
@@ -401,12 +1039,12 @@ func (r *Syncer) readChecksumMap() (checksumMap map[string]XMLChecksum) {
 		if err == ErrFileNotFound {
 			repomdReader, err = r.storage.NewReader(releasePath, Permanent)
 			if err != nil {
-				log.Println("First-time sync started")
+				r.logger().Println("First-time sync started")
 				return
 			}
 			repoType = repoTypes["deb"]
 		} else {
-			log.Println("Error while reading previously-downloaded metadata. Starting sync from scratch")
+			r.logger().Println("Error while reading previously-downloaded metadata. Starting sync from scratch")
 			return
 		}
 	}
@@ -414,7 +1052,7 @@ func (r *Syncer) readChecksumMap() (checksumMap map[string]XMLChecksum) {
 
 	repomd, err := repoType.DecodeMetadata(repomdReader)
 	if err != nil {
-		log.Println("Error while parsing previously-downloaded metadata. Starting sync from scratch")
+		r.logger().Println("Error while parsing previously-downloaded metadata. Starting sync from scratch")
 		return
 	}
 
@@ -448,6 +1086,7 @@ func (r *Syncer) processPrimary(path string, checksumMap map[string]XMLChecksum,
 	if err != nil {
 		return
 	}
+	defer reader.Close()
 
 	compType := strings.Trim(filepath.Ext(path), ".")
 	primary, err := repoType.DecodePackages(reader, compType)
@@ -455,31 +1094,77 @@ func (r *Syncer) processPrimary(path string, checksumMap map[string]XMLChecksum,
 		return
 	}
 
-	allArchs := len(r.archs) == 0
+	packagesToDownload, packagesToRecycle, _, err = r.selectPackages(primary, checksumMap, repoType)
+	return
+}
+
+// selectPackages filters the packages in primary XML metadata and returns the ones to download,
+// the ones to recycle and all the ones to be mirrored, within MaxSize
+func (r *Syncer) selectPackages(primary XMLMetaData, checksumMap map[string]XMLChecksum, repoType RepoType) (packagesToDownload []XMLPackage, packagesToRecycle []XMLPackage, selected []XMLPackage, err error) {
 	for _, pack := range primary.Packages {
-		legacyPackage := (pack.Arch == "i586" || pack.Arch == "i686")
+		if r.wanted(pack, repoType) {
+			selected = append(selected, pack)
+		}
+	}
+	selected, err = r.applyQuota(selected)
+	if err != nil {
+		return
+	}
+	for _, pack := range selected {
+		if r.shards(repoType) {
+			pack.upstream = pack.Location.Href
+			pack.Location.Href = shardedLocation(pack)
+		}
+		decision := r.decide(pack.Location.Href, pack.Checksum, checksumMap)
+		switch decision {
+		case Download:
+			packagesToDownload = append(packagesToDownload, pack)
+		case Recycle:
+			packagesToRecycle = append(packagesToRecycle, pack)
+		}
+	}
+	return
+}
 
-		if SkipLegacy && legacyPackage {
-			if !r.quiet {
-				fmt.Println("Skipping legacy package:", pack.Location.Href)
-			}
-			continue
+// wanted reports whether a package passes the filters configured for this Syncer
+func (r *Syncer) wanted(pack XMLPackage, repoType RepoType) bool {
+	if r.OnlyPackages != nil && !r.OnlyPackages.Contains(pack) {
+		return false
+	}
+
+	if r.advisories.excludes(pack) {
+		return false
+	}
+
+	legacyPackage := (pack.Arch == "i586" || pack.Arch == "i686")
+
+	if r.SkipLegacy && legacyPackage {
+		if !r.quiet {
+			r.logger().Println("Skipping legacy package:", pack.Location.Href)
 		}
+		return false
+	}
 
-		if allArchs || pack.Arch == repoType.Noarch || r.archs[pack.Arch] || (r.archs["x86_64"] && legacyPackage) {
-			decision := r.decide(pack.Location.Href, pack.Checksum, checksumMap)
-			switch decision {
-			case Download:
-				packagesToDownload = append(packagesToDownload, pack)
-			case Recycle:
-				packagesToRecycle = append(packagesToRecycle, pack)
-			}
+	allArchs := len(r.archs) == 0
+	if !allArchs && pack.Arch != repoType.Noarch && !r.archs[pack.Arch] && !(r.archs["x86_64"] && legacyPackage) {
+		return false
+	}
+
+	if license := r.excludedLicense(pack); license != "" {
+		if r.licenseExcluded == nil {
+			r.licenseExcluded = map[string]string{}
 		}
+		r.licenseExcluded[pack.Location.Href] = license
+		return false
 	}
-	return
+	return true
 }
 
 func (r *Syncer) decide(location string, checksum XMLChecksum, checksumMap map[string]XMLChecksum) Decision {
+	if r.checkChecksum(location, checksum) != nil {
+		// refused before downloading, without hashing the file with a refused algorithm
+		return Download
+	}
 	previousChecksum, foundInChecksumMap := checksumMap[location]
 
 	if foundInChecksumMap {
@@ -491,22 +1176,40 @@ func (r *Syncer) decide(location string, checksum XMLChecksum, checksumMap map[s
 	}
 
 	if !foundInChecksumMap || previousChecksum.Type != checksum.Type || previousChecksum.Checksum != checksum.Checksum {
+		if r.ChecksumCache.Matches(location, checksum) {
+			return Skip
+		}
 		reader, err := r.storage.NewReader(location, Temporary)
 		if err != nil {
 			return Download
 		}
 		defer reader.Close()
 
-		log.Printf("Reading %s checksum\n", checksum.Type)
+		r.logger().Printf("Reading %s checksum\n", checksum.Type)
 		readChecksum, err := util.Checksum(reader, hashMap[checksum.Type])
 		if err != nil || readChecksum != checksum.Checksum {
 			return Download
 		}
+		// a package rejected by a previous attempt is kept in the temporary location
+		if r.verifiesSignature(location) && r.verifyStoredSignature(location) != nil {
+			return Download
+		}
+		r.ChecksumCache.Add(location, checksum)
 		return Skip
 	}
 	return Recycle
 }
 
+// verifyStoredSignature checks the signature of a package in the temporary location
+func (r *Syncer) verifyStoredSignature(location string) error {
+	reader, err := r.storage.NewReader(location, Temporary)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return verifyRPMSignature(reader, location, r.PackageKeys, r.FIPS)
+}
+
 // Functions to handle Debian formatted repositories
 func decodeRelease(reader io.Reader) (repomd XMLRepomd, err error) {
 	entries, err := util.ProcessPropertiesFile(reader)
@@ -530,10 +1233,12 @@ func decodeRelease(reader io.Reader) (repomd XMLRepomd, err error) {
 			err = fmt.Errorf("badly formatted file entry: '%s'", fileEntry)
 			return
 		}
+		size, _ := strconv.ParseInt(infos[1], 10, 64)
 		fileData := XMLData{
 			Type:     infos[2],
 			Location: XMLLocation{Href: infos[2]},
 			Checksum: XMLChecksum{Type: "sha256", Checksum: infos[0]},
+			Size:     size,
 		}
 		data = append(data, fileData)
 	}
@@ -549,8 +1254,12 @@ func decodePackages(reader io.Reader, _ string) (metadata XMLMetaData, err error
 
 	packages := make([]XMLPackage, 0)
 	for _, packageEntry := range packagesEntries {
+		size, _ := strconv.ParseInt(packageEntry["Size"], 10, 64)
 		packages = append(packages, XMLPackage{
+			Name:     packageEntry["Package"],
 			Arch:     packageEntry["Architecture"],
+			Version:  XMLVersion{Version: packageEntry["Version"]},
+			Size:     XMLSize{Package: size},
 			Location: XMLLocation{Href: packageEntry["Filename"]},
 			Checksum: XMLChecksum{Type: "sha256", Checksum: packageEntry["SHA256"]},
 		})
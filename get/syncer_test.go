@@ -1,17 +1,20 @@
 package get
 
 import (
+	"context"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uyuni-project/minima/util"
 )
 
 func TestStoreRepo(t *testing.T) {
-	// Respond to http://localhost:8080/repo serving the content of the testdata/repo directory
-	http.Handle("/", http.FileServer(http.Dir("testdata")))
-
 	directory := filepath.Join(os.TempDir(), "syncer_test")
 	err := os.RemoveAll(directory)
 	if err != nil {
@@ -178,3 +181,291 @@ func TestStoreDebRepo(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestStoreRepoSkipMetadata(t *testing.T) {
+	directory := filepath.Join(os.TempDir(), "syncer_test")
+	err := os.RemoveAll(directory)
+	if err != nil {
+		t.Error(err)
+	}
+
+	storage := NewFileStorage(directory)
+	url, err := url.Parse("http://localhost:8080/repo")
+	if err != nil {
+		t.Error(err)
+	}
+	syncer := NewSyncer(*url, map[string]bool{"x86_64": true}, storage, false)
+	syncer.SkipFilelists = true
+	syncer.SkipOther = true
+
+	err = syncer.StoreRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	skippedFiles := []string{
+		filepath.Join("repodata", "06288a8a3ec708ceabe3197087e9aa93cbf4d5b81a391857c0cbec9a676fdba2-filelists.xml.gz"),
+		filepath.Join("repodata", "f08a89e1946493d15313244a30a2962e7a43fc07205b9f7fca1ebeec3c6d2d2e-other.xml.gz"),
+	}
+	for _, file := range skippedFiles {
+		if _, serr := os.Stat(filepath.Join(directory, file)); !os.IsNotExist(serr) {
+			t.Error("skipped file", file, "was synced")
+		}
+	}
+
+	repomd, err := os.ReadFile(filepath.Join(directory, "repodata", "repomd.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	document, err := parseRepomd(repomd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := []string{}
+	for _, data := range document.Data {
+		types = append(types, data.Type)
+	}
+	assert.ElementsMatch(t, []string{"primary", "updateinfo"}, types)
+	assert.Equal(t, "1436435242", document.Revision)
+
+	// second sync
+	err = syncer.StoreRepo()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStoreRepoRegenerateMetadata(t *testing.T) {
+	directory := filepath.Join(os.TempDir(), "syncer_test")
+	err := os.RemoveAll(directory)
+	if err != nil {
+		t.Error(err)
+	}
+
+	storage := NewFileStorage(directory)
+	url, err := url.Parse("http://localhost:8080/repo")
+	if err != nil {
+		t.Error(err)
+	}
+	syncer := NewSyncer(*url, map[string]bool{"x86_64": true}, storage, false)
+	syncer.RegenerateMetadata = true
+
+	err = syncer.StoreRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repomd, err := os.ReadFile(filepath.Join(directory, "repodata", "repomd.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	document, err := parseRepomd(repomd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, document.Data, 4)
+
+	for _, data := range document.Data {
+		reader, err := storage.NewReader(data.Location.Href, Permanent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checksum, err := util.Checksum(reader, hashMap[data.Checksum.Type])
+		reader.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, data.Checksum.Checksum, checksum, data.Type)
+
+		if data.Type == "updateinfo" {
+			continue
+		}
+		reader, err = storage.NewReader(data.Location.Href, Permanent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		metadata, err := readMetaData(reader, "gz")
+		reader.Close()
+		assert.NoError(t, err)
+		// 5 x86_64, 2 noarch, 5 i586 packages
+		assert.Len(t, metadata.Packages, 12, data.Type)
+		for _, pack := range metadata.Packages {
+			assert.NotEqual(t, "src", pack.Arch)
+		}
+	}
+
+	// second sync
+	err = syncer.StoreRepo()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStoreRepoExtraFiles(t *testing.T) {
+	directory := filepath.Join(os.TempDir(), "syncer_test")
+	err := os.RemoveAll(directory)
+	if err != nil {
+		t.Error(err)
+	}
+
+	source := filepath.Join(t.TempDir(), "corporate.repo")
+	err = os.WriteFile(source, []byte("[corporate]\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url, err := url.Parse("http://localhost:8080/repo")
+	if err != nil {
+		t.Error(err)
+	}
+	syncer := NewSyncer(*url, map[string]bool{"x86_64": true}, NewFileStorage(directory), true)
+	syncer.ExtraFiles = []ExtraFile{
+		{Source: source},
+		{Source: source, Target: "keys/other.repo"},
+	}
+
+	err = syncer.StoreRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"corporate.repo", filepath.Join("keys", "other.repo")} {
+		content, err := os.ReadFile(filepath.Join(directory, file))
+		assert.NoError(t, err)
+		assert.Equal(t, "[corporate]\n", string(content))
+	}
+
+	syncer.ExtraFiles = []ExtraFile{{Source: source, Target: "../outside.repo"}}
+	err = syncer.StoreRepo()
+	assert.Error(t, err)
+}
+
+func TestStoreRepoRefreshURL(t *testing.T) {
+	// the token expires after a few downloads
+	token := "old"
+	downloads := 0
+	fileServer := http.FileServer(http.Dir("testdata"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		if downloads == 4 {
+			token = "new"
+		}
+		if !r.URL.Query().Has(token) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	repoURL, err := url.Parse(server.URL + "/repo?old")
+	assert.NoError(t, err)
+	syncer := NewSyncer(*repoURL, map[string]bool{"x86_64": true}, NewFileStorage(t.TempDir()), true)
+	refreshes := 0
+	syncer.RefreshURL = func(current string) (string, error) {
+		refreshes++
+		assert.Equal(t, server.URL+"/repo?old", current)
+		return server.URL + "/repo?" + token, nil
+	}
+	assert.NoError(t, syncer.StoreRepo())
+	assert.Equal(t, 1, refreshes)
+	assert.Equal(t, server.URL+"/repo?new", syncer.URL.String())
+
+	// refused downloads fail if the URL does not change
+	token = "newer"
+	downloads = 0
+	syncer.RefreshURL = func(current string) (string, error) {
+		return current, nil
+	}
+	err = syncer.storeRepo(context.Background(), map[string]XMLChecksum{})
+	var uerr *UnexpectedStatusCodeError
+	assert.ErrorAs(t, err, &uerr)
+	assert.Equal(t, http.StatusForbidden, uerr.StatusCode)
+}
+
+func TestStoreRepoContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fileServer := http.FileServer(http.Dir("testdata"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".rpm") {
+			// the sync is cancelled in the middle of a package download
+			w.Header().Set("Content-Length", "1000000")
+			w.Write([]byte("partial"))
+			w.(http.Flusher).Flush()
+			cancel()
+			<-r.Context().Done()
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	directory := t.TempDir()
+	repoURL, err := url.Parse(server.URL + "/repo")
+	assert.NoError(t, err)
+	syncer := NewSyncer(*repoURL, map[string]bool{"x86_64": true}, NewFileStorage(directory), true)
+	err = syncer.StoreRepoContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// nothing was committed
+	_, err = os.Stat(filepath.Join(directory, "repodata", "repomd.xml"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStoreRepoMinChecksum(t *testing.T) {
+	repoURL, err := url.Parse("http://localhost:8080/repo")
+	assert.NoError(t, err)
+
+	// testdata only has SHA-256 checksums
+	directory := t.TempDir()
+	syncer := NewSyncer(*repoURL, map[string]bool{"x86_64": true}, NewFileStorage(directory), true)
+	syncer.MinChecksum = "sha256"
+	assert.NoError(t, syncer.StoreRepo())
+
+	directory = t.TempDir()
+	syncer = NewSyncer(*repoURL, map[string]bool{"x86_64": true}, NewFileStorage(directory), true)
+	syncer.MinChecksum = "sha512"
+	err = syncer.StoreRepo()
+	assert.ErrorContains(t, err, "only has a sha256 checksum, sha512 is required")
+	_, err = os.Stat(filepath.Join(directory, "repodata", "repomd.xml"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStoreRepoPinned(t *testing.T) {
+	repoURL, err := url.Parse("http://localhost:8080/repo")
+	assert.NoError(t, err)
+
+	directory := t.TempDir()
+	syncer := NewSyncer(*repoURL, map[string]bool{"x86_64": true}, NewFileStorage(directory), true)
+	syncer.Revision = "1436435242"
+	syncer.Snapshot = "45f93954dcd8bc9e0e6a9b5990cb44240fe09dcf9a52e5acd47d1cad78314d56"
+	assert.NoError(t, syncer.StoreRepo())
+
+	// newer upstream metadata is refused, without falling back to a Debian repo
+	syncer.Revision = "1436435241"
+	syncer.Snapshot = ""
+	err = syncer.StoreRepo()
+	assert.EqualError(t, err, "repodata/repomd.xml has revision 1436435242, the repo is pinned to revision 1436435241")
+	assert.IsType(t, &PinError{}, err)
+
+	syncer.Revision = ""
+	syncer.Snapshot = "0000000000000000000000000000000000000000000000000000000000000000"
+	err = syncer.StoreRepo()
+	assert.EqualError(t, err, "repodata/repomd.xml has checksum 45f93954dcd8bc9e0e6a9b5990cb44240fe09dcf9a52e5acd47d1cad78314d56, the repo is pinned to snapshot "+syncer.Snapshot)
+	_, err = os.Stat(filepath.Join(directory, "repodata", "repomd.xml"))
+	assert.NoError(t, err)
+}
+
+func TestCheckChecksum(t *testing.T) {
+	syncer := &Syncer{MinChecksum: "sha256"}
+	assert.NoError(t, syncer.checkChecksum("a.rpm", XMLChecksum{Type: "sha256"}))
+	assert.NoError(t, syncer.checkChecksum("a.rpm", XMLChecksum{Type: "sha512"}))
+	assert.EqualError(t, syncer.checkChecksum("a.rpm", XMLChecksum{Type: "sha"}), "a.rpm only has a sha checksum, sha256 is required")
+	assert.EqualError(t, syncer.checkChecksum("a.rpm", XMLChecksum{Type: "md5"}), "a.rpm only has a md5 checksum, sha256 is required")
+	assert.EqualError(t, syncer.checkChecksum("a.rpm", XMLChecksum{}), "a.rpm has no checksum, sha256 is required")
+
+	// without a minimum, legacy checksums are accepted
+	syncer.MinChecksum = ""
+	assert.NoError(t, syncer.checkChecksum("a.rpm", XMLChecksum{Type: "md5"}))
+
+	assert.NoError(t, ValidateMinChecksum("sha512"))
+	assert.EqualError(t, ValidateMinChecksum("crc32"), "unsupported checksum type crc32")
+}
@@ -0,0 +1,273 @@
+package get
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Piece lengths of torrents, powers of two so that pieces align with blocks
+const (
+	minPieceLength = 256 << 10
+	maxPieceLength = 16 << 20
+	// targetPieces is the number of pieces a torrent is aimed to be split in, to keep it small
+	targetPieces = 2000
+)
+
+// Torrent is a BitTorrent metainfo file of the files of a mirrored repo, as of when it is created
+type Torrent struct {
+	// name of the directory of the files, that clients create
+	Name        string
+	PieceLength int64
+	// SHA-1 checksums of pieces, files are concatenated in order into pieces
+	Pieces [][sha1.Size]byte
+	Files  []TorrentFile
+	// tracker URLs, tried in order by clients
+	Announce []string
+	// URLs over HTTP of the directory containing Name, eg. of minima serve, clients download from besides peers
+	WebSeeds []string
+	// local directory of the files, for seeding
+	directory string
+}
+
+// TorrentFile is a file of a Torrent
+type TorrentFile struct {
+	// path relative to the directory of the torrent, with slashes
+	Path   string
+	Length int64
+}
+
+// NewTorrent returns a Torrent of the files in directory, in pieces of pieceLength bytes or, if 0, of a
+// length depending on the total size
+func NewTorrent(directory string, pieceLength int64) (*Torrent, error) {
+	torrent := &Torrent{Name: filepath.Base(directory), directory: directory}
+	total := int64(0)
+	err := filepath.WalkDir(directory, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(directory, name)
+		if err != nil {
+			return err
+		}
+		torrent.Files = append(torrent.Files, TorrentFile{Path: filepath.ToSlash(relative), Length: info.Size()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("no content in %s", directory)
+	}
+	sort.Slice(torrent.Files, func(i, j int) bool { return torrent.Files[i].Path < torrent.Files[j].Path })
+
+	torrent.PieceLength = pieceLength
+	if pieceLength == 0 {
+		torrent.PieceLength = minPieceLength
+		for torrent.PieceLength < maxPieceLength && total/torrent.PieceLength > targetPieces {
+			torrent.PieceLength *= 2
+		}
+	}
+	piece := make([]byte, torrent.PieceLength)
+	for offset := int64(0); offset < total; offset += torrent.PieceLength {
+		n, err := torrent.ReadAt(piece, offset)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		torrent.Pieces = append(torrent.Pieces, sha1.Sum(piece[:n]))
+	}
+	return torrent, nil
+}
+
+// Length returns the total size of the files of the torrent
+func (t *Torrent) Length() int64 {
+	total := int64(0)
+	for _, file := range t.Files {
+		total += file.Length
+	}
+	return total
+}
+
+// ReadAt reads the content of the files of the torrent, concatenated, at offset. Files must not have
+// changed since the torrent was created
+func (t *Torrent) ReadAt(p []byte, offset int64) (int, error) {
+	read := 0
+	start := int64(0)
+	for _, file := range t.Files {
+		end := start + file.Length
+		if read < len(p) && offset+int64(read) < end && offset+int64(read) >= start {
+			f, err := os.Open(filepath.Join(t.directory, filepath.FromSlash(file.Path)))
+			if err != nil {
+				return read, err
+			}
+			length := min(int64(len(p)-read), end-offset-int64(read))
+			n, err := f.ReadAt(p[read:read+int(length)], offset+int64(read)-start)
+			f.Close()
+			read += n
+			if err != nil {
+				return read, fmt.Errorf("%s changed since the torrent was created: %v", file.Path, err)
+			}
+		}
+		start = end
+	}
+	if read < len(p) {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+// info returns the info dictionary of the torrent
+func (t *Torrent) info() map[string]any {
+	files := []any{}
+	for _, file := range t.Files {
+		path := []any{}
+		for _, element := range strings.Split(file.Path, "/") {
+			path = append(path, element)
+		}
+		files = append(files, map[string]any{"length": file.Length, "path": path})
+	}
+	pieces := make([]byte, 0, len(t.Pieces)*sha1.Size)
+	for _, piece := range t.Pieces {
+		pieces = append(pieces, piece[:]...)
+	}
+	return map[string]any{"name": t.Name, "piece length": t.PieceLength, "pieces": pieces, "files": files}
+}
+
+// InfoHash returns the SHA-1 checksum of the info dictionary, identifying the torrent to trackers and peers
+func (t *Torrent) InfoHash() [sha1.Size]byte {
+	return sha1.Sum(bencode(t.info()))
+}
+
+// Encode returns the content of the .torrent file, created at creationTime
+func (t *Torrent) Encode(creationTime time.Time) []byte {
+	metainfo := map[string]any{"info": t.info(), "created by": "minima", "creation date": creationTime.Unix()}
+	if len(t.Announce) > 0 {
+		metainfo["announce"] = t.Announce[0]
+		// each tracker in a tier of its own, so that clients try them in order
+		tiers := []any{}
+		for _, announce := range t.Announce {
+			tiers = append(tiers, []any{announce})
+		}
+		metainfo["announce-list"] = tiers
+	}
+	if len(t.WebSeeds) > 0 {
+		seeds := []any{}
+		for _, seed := range t.WebSeeds {
+			seeds = append(seeds, seed)
+		}
+		metainfo["url-list"] = seeds
+	}
+	return bencode(metainfo)
+}
+
+// bencode returns the bencoding of value, a string, []byte, int, int64, []any or map[string]any
+func bencode(value any) []byte {
+	buffer := &bytes.Buffer{}
+	bencodeTo(buffer, value)
+	return buffer.Bytes()
+}
+
+func bencodeTo(buffer *bytes.Buffer, value any) {
+	switch value := value.(type) {
+	case string:
+		buffer.WriteString(strconv.Itoa(len(value)) + ":" + value)
+	case []byte:
+		buffer.WriteString(strconv.Itoa(len(value)) + ":")
+		buffer.Write(value)
+	case int:
+		buffer.WriteString("i" + strconv.Itoa(value) + "e")
+	case int64:
+		buffer.WriteString("i" + strconv.FormatInt(value, 10) + "e")
+	case []any:
+		buffer.WriteByte('l')
+		for _, element := range value {
+			bencodeTo(buffer, element)
+		}
+		buffer.WriteByte('e')
+	case map[string]any:
+		// keys are sorted as raw strings
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buffer.WriteByte('d')
+		for _, key := range keys {
+			bencodeTo(buffer, key)
+			bencodeTo(buffer, value[key])
+		}
+		buffer.WriteByte('e')
+	default:
+		panic(fmt.Sprintf("cannot bencode %T", value))
+	}
+}
+
+// bdecode returns the value bencoded at the start of data, strings as string, integers as int64, lists as
+// []any and dictionaries as map[string]any, and the rest of data
+func bdecode(data []byte) (any, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("unexpected end of bencoded data")
+	}
+	switch data[0] {
+	case 'i':
+		end := bytes.IndexByte(data, 'e')
+		if end < 0 {
+			return nil, nil, fmt.Errorf("unterminated bencoded integer")
+		}
+		n, err := strconv.ParseInt(string(data[1:end]), 10, 64)
+		return n, data[end+1:], err
+	case 'l', 'd':
+		list := []any{}
+		rest := data[1:]
+		for len(rest) > 0 && rest[0] != 'e' {
+			var element any
+			var err error
+			element, rest, err = bdecode(rest)
+			if err != nil {
+				return nil, nil, err
+			}
+			list = append(list, element)
+		}
+		if len(rest) == 0 {
+			return nil, nil, fmt.Errorf("unterminated bencoded list")
+		}
+		if data[0] == 'l' {
+			return list, rest[1:], nil
+		}
+		if len(list)%2 != 0 {
+			return nil, nil, fmt.Errorf("bencoded dictionary without value")
+		}
+		dictionary := map[string]any{}
+		for i := 0; i < len(list); i += 2 {
+			key, ok := list[i].(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("bencoded dictionary key is not a string")
+			}
+			dictionary[key] = list[i+1]
+		}
+		return dictionary, rest[1:], nil
+	default:
+		colon := bytes.IndexByte(data, ':')
+		if colon < 0 {
+			return nil, nil, fmt.Errorf("invalid bencoded data")
+		}
+		length, err := strconv.Atoi(string(data[:colon]))
+		if err != nil || length < 0 || colon+1+length > len(data) {
+			return nil, nil, fmt.Errorf("invalid bencoded string")
+		}
+		return string(data[colon+1 : colon+1+length]), data[colon+1+length:], nil
+	}
+}
@@ -0,0 +1,184 @@
+package get
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTorrentRepo writes a repo of a few files of 700 bytes whose content is their index
+func writeTorrentRepo(t *testing.T) string {
+	directory := filepath.Join(t.TempDir(), "SLE-Product-SLES15-SP5-Pool")
+	assert.NoError(t, os.MkdirAll(filepath.Join(directory, "repodata"), 0755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(directory, "x86_64"), 0755))
+	for i, name := range []string{"repodata/repomd.xml", "x86_64/a.rpm", "x86_64/b.rpm"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(directory, name), bytes.Repeat([]byte{byte('0' + i)}, 700), 0644))
+	}
+	return directory
+}
+
+func TestNewTorrent(t *testing.T) {
+	directory := writeTorrentRepo(t)
+	torrent, err := NewTorrent(directory, 1024)
+	assert.NoError(t, err)
+	assert.Equal(t, "SLE-Product-SLES15-SP5-Pool", torrent.Name)
+	assert.Equal(t, []TorrentFile{{"repodata/repomd.xml", 700}, {"x86_64/a.rpm", 700}, {"x86_64/b.rpm", 700}}, torrent.Files)
+	assert.Equal(t, int64(2100), torrent.Length())
+	// pieces span files, the last one is shorter
+	assert.Len(t, torrent.Pieces, 3)
+	assert.Equal(t, sha1.Sum(append(bytes.Repeat([]byte("0"), 700), bytes.Repeat([]byte("1"), 324)...)), torrent.Pieces[0])
+	assert.Equal(t, sha1.Sum(bytes.Repeat([]byte("2"), 52)), torrent.Pieces[2])
+
+	block := make([]byte, 10)
+	_, err = torrent.ReadAt(block, 1395)
+	assert.NoError(t, err)
+	assert.Equal(t, "1111122222", string(block))
+	_, err = torrent.ReadAt(block, 2095)
+	assert.ErrorIs(t, err, io.EOF)
+
+	torrent.Announce = []string{"http://tracker.example.com/announce", "http://backup.example.com/announce"}
+	torrent.WebSeeds = []string{"http://minima.example.com/"}
+	value, rest, err := bdecode(torrent.Encode(time.Unix(1700000000, 0)))
+	assert.NoError(t, err)
+	assert.Empty(t, rest)
+	metainfo := value.(map[string]any)
+	assert.Equal(t, "http://tracker.example.com/announce", metainfo["announce"])
+	assert.Equal(t, []any{[]any{"http://tracker.example.com/announce"}, []any{"http://backup.example.com/announce"}}, metainfo["announce-list"])
+	assert.Equal(t, []any{"http://minima.example.com/"}, metainfo["url-list"])
+	assert.Equal(t, int64(1700000000), metainfo["creation date"])
+	info := metainfo["info"].(map[string]any)
+	assert.Equal(t, int64(1024), info["piece length"])
+	assert.Len(t, info["pieces"], 3*sha1.Size)
+	assert.Equal(t, map[string]any{"length": int64(700), "path": []any{"x86_64", "a.rpm"}}, info["files"].([]any)[1])
+	// the info hash is of the info dictionary as encoded
+	assert.Equal(t, sha1.Sum(bencode(info)), torrent.InfoHash())
+
+	// piece lengths depend on the size by default
+	torrent, err = NewTorrent(directory, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(minPieceLength), torrent.PieceLength)
+	assert.Len(t, torrent.Pieces, 1)
+
+	_, err = NewTorrent(t.TempDir(), 0)
+	assert.ErrorContains(t, err, "no content")
+}
+
+func TestBdecode(t *testing.T) {
+	value, rest, err := bdecode([]byte("d8:intervali1800e5:peers0:eextra"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{"interval": int64(1800), "peers": ""}, value)
+	assert.Equal(t, "extra", string(rest))
+
+	for _, invalid := range []string{"", "i12", "l1:a", "d1:ae", "di1e1:ae", "5:abc", "x"} {
+		_, _, err = bdecode([]byte(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestTorrentSeeder(t *testing.T) {
+	torrent, err := NewTorrent(writeTorrentRepo(t), 1024)
+	assert.NoError(t, err)
+	seeder := NewTorrentSeeder([]*Torrent{torrent}, log.New(io.Discard, "", 0))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() { served <- seeder.Serve(ctx, listener) }()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	infoHash := torrent.InfoHash()
+	handshake := append([]byte(torrentProtocol), make([]byte, 8)...)
+	handshake = append(append(handshake, infoHash[:]...), []byte("-TE0001-000000000000")...)
+	_, err = conn.Write(handshake)
+	assert.NoError(t, err)
+	reply := make([]byte, len(handshake))
+	_, err = io.ReadFull(conn, reply)
+	assert.NoError(t, err)
+	assert.Equal(t, handshake[:len(torrentProtocol)+8+sha1.Size], reply[:len(torrentProtocol)+8+sha1.Size])
+	assert.Equal(t, "-MI0001-", string(reply[len(reply)-20:len(reply)-12]))
+
+	readMessage := func() []byte {
+		var length uint32
+		assert.NoError(t, binary.Read(conn, binary.BigEndian, &length))
+		message := make([]byte, length)
+		_, err := io.ReadFull(conn, message)
+		assert.NoError(t, err)
+		return message
+	}
+	// three pieces available
+	assert.Equal(t, []byte{torrentBitfield, 0xe0}, readMessage())
+	assert.NoError(t, writeTorrentMessage(conn, torrentInterested, nil))
+	assert.Equal(t, []byte{torrentUnchoke}, readMessage())
+
+	// the end of the second file, in the second piece
+	request := make([]byte, 12)
+	binary.BigEndian.PutUint32(request, 1)
+	binary.BigEndian.PutUint32(request[4:], 371)
+	binary.BigEndian.PutUint32(request[8:], 10)
+	assert.NoError(t, writeTorrentMessage(conn, torrentRequest, request))
+	assert.Equal(t, append(append([]byte{torrentPiece}, request[:8]...), "1111122222"...), readMessage())
+
+	// past the end of the last piece
+	binary.BigEndian.PutUint32(request, 2)
+	binary.BigEndian.PutUint32(request[4:], 50)
+	assert.NoError(t, writeTorrentMessage(conn, torrentRequest, request))
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+
+	cancel()
+	assert.NoError(t, <-served)
+}
+
+func TestTorrentSeederAnnounce(t *testing.T) {
+	torrent, err := NewTorrent(writeTorrentRepo(t), 0)
+	assert.NoError(t, err)
+	queries := make(chan map[string][]string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.Query()
+		if r.URL.Query().Get("passkey") == "" {
+			w.Write([]byte("d14:failure reason12:unregisterede"))
+			return
+		}
+		w.Write([]byte("d8:intervali1800e5:peers0:e"))
+	}))
+	defer server.Close()
+	seeder := NewTorrentSeeder([]*Torrent{torrent}, log.New(io.Discard, "", 0))
+	infoHash := torrent.InfoHash()
+
+	interval, err := seeder.announce(context.Background(), server.URL+"/announce?passkey=secret", infoHash, 6881, "started")
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Minute, interval)
+	query := <-queries
+	assert.Equal(t, []string{string(infoHash[:])}, query["info_hash"])
+	assert.Equal(t, []string{"6881"}, query["port"])
+	assert.Equal(t, []string{"0"}, query["left"])
+	assert.Equal(t, []string{"started"}, query["event"])
+	assert.Equal(t, []string{"secret"}, query["passkey"])
+
+	_, err = seeder.announce(context.Background(), server.URL+"/announce", infoHash, 6881, "")
+	assert.ErrorContains(t, err, "unregistered")
+	assert.NotContains(t, <-queries, "event")
+
+	// announces are sent for each tracker of each torrent, until canceled
+	torrent.Announce = []string{server.URL + "/announce?passkey=secret"}
+	ctx, cancel := context.WithCancel(context.Background())
+	seeder.Announce(ctx, 6881)
+	query = <-queries
+	assert.True(t, strings.HasPrefix(query["peer_id"][0], "-MI0001-"))
+	cancel()
+}
@@ -0,0 +1,252 @@
+package get
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// torrentProtocol starts the handshake of the BitTorrent peer wire protocol
+const torrentProtocol = "\x13BitTorrent protocol"
+
+// Messages of the peer wire protocol handled by seeders
+const (
+	torrentUnchoke    = 1
+	torrentInterested = 2
+	torrentBitfield   = 5
+	torrentRequest    = 6
+	torrentPiece      = 7
+)
+
+const (
+	// maxTorrentBlock is the largest block peers may request, clients request 16 KiB
+	maxTorrentBlock = 128 << 10
+	// torrentIdle is the time after which connections of silent peers are closed, clients send
+	// keep-alives every two minutes
+	torrentIdle = 3 * time.Minute
+	// defaultAnnounceInterval is the interval of announces to trackers not setting one
+	defaultAnnounceInterval = 30 * time.Minute
+)
+
+// TorrentSeeder uploads the files of torrents to peers, and announces them to their trackers. It never
+// downloads: peers are unchoked as soon as they are interested
+type TorrentSeeder struct {
+	torrents map[[sha1.Size]byte]*Torrent
+	peerID   [20]byte
+	logger   *log.Logger
+	client   *http.Client
+}
+
+// NewTorrentSeeder returns a seeder of torrents, created with NewTorrent
+func NewTorrentSeeder(torrents []*Torrent, logger *log.Logger) *TorrentSeeder {
+	seeder := &TorrentSeeder{torrents: map[[sha1.Size]byte]*Torrent{}, logger: logger, client: http.DefaultClient}
+	for _, torrent := range torrents {
+		seeder.torrents[torrent.InfoHash()] = torrent
+	}
+	// Azureus-style peer ID, with a random suffix
+	copy(seeder.peerID[:], "-MI0001-")
+	rand.Read(seeder.peerID[8:])
+	return seeder
+}
+
+// Serve accepts connections of peers on listener until ctx is done
+func (s *TorrentSeeder) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			err := s.serveConn(conn)
+			if err != nil && !errors.Is(err, io.EOF) {
+				s.logger.Printf("Torrent peer %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// serveConn answers the requests of a peer for the pieces of a torrent
+func (s *TorrentSeeder) serveConn(conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(torrentIdle))
+	handshake := make([]byte, len(torrentProtocol)+8+sha1.Size+20)
+	_, err := io.ReadFull(conn, handshake)
+	if err != nil {
+		return err
+	}
+	if string(handshake[:len(torrentProtocol)]) != torrentProtocol {
+		return fmt.Errorf("not a BitTorrent peer")
+	}
+	var infoHash [sha1.Size]byte
+	copy(infoHash[:], handshake[len(torrentProtocol)+8:])
+	torrent, found := s.torrents[infoHash]
+	if !found {
+		return fmt.Errorf("unknown torrent %x", infoHash)
+	}
+	reply := append([]byte(torrentProtocol), make([]byte, 8)...)
+	reply = append(append(reply, infoHash[:]...), s.peerID[:]...)
+	_, err = conn.Write(reply)
+	if err != nil {
+		return err
+	}
+
+	// all pieces are available
+	bitfield := make([]byte, (len(torrent.Pieces)+7)/8)
+	for i := range torrent.Pieces {
+		bitfield[i/8] |= 0x80 >> (i % 8)
+	}
+	err = writeTorrentMessage(conn, torrentBitfield, bitfield)
+	if err != nil {
+		return err
+	}
+	total := torrent.Length()
+	for {
+		conn.SetDeadline(time.Now().Add(torrentIdle))
+		var length uint32
+		err = binary.Read(conn, binary.BigEndian, &length)
+		if err != nil {
+			return err
+		}
+		if length == 0 {
+			// keep-alive
+			continue
+		}
+		if length > 1+12+maxTorrentBlock {
+			return fmt.Errorf("message too long")
+		}
+		message := make([]byte, length)
+		_, err = io.ReadFull(conn, message)
+		if err != nil {
+			return err
+		}
+		switch message[0] {
+		case torrentInterested:
+			err = writeTorrentMessage(conn, torrentUnchoke, nil)
+		case torrentRequest:
+			if len(message) != 13 {
+				return fmt.Errorf("invalid request")
+			}
+			index := binary.BigEndian.Uint32(message[1:])
+			begin := binary.BigEndian.Uint32(message[5:])
+			size := binary.BigEndian.Uint32(message[9:])
+			offset := int64(index)*torrent.PieceLength + int64(begin)
+			if int(index) >= len(torrent.Pieces) || int64(begin)+int64(size) > torrent.PieceLength || size > maxTorrentBlock || offset+int64(size) > total {
+				return fmt.Errorf("invalid request of piece %d", index)
+			}
+			block := make([]byte, 8+size)
+			copy(block, message[1:9])
+			_, err = torrent.ReadAt(block[8:], offset)
+			if err != nil {
+				return err
+			}
+			err = writeTorrentMessage(conn, torrentPiece, block)
+		}
+		// other messages, eg. have or cancel, do not matter to seeders
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writeTorrentMessage writes a message of the peer wire protocol with id and payload
+func writeTorrentMessage(writer io.Writer, id byte, payload []byte) error {
+	message := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(message, uint32(1+len(payload)))
+	message[4] = id
+	_, err := writer.Write(append(message, payload...))
+	return err
+}
+
+// Announce tells the trackers of all torrents that the seeder accepts peers on port, again at the
+// intervals they request, until ctx is done. Trackers failing are retried at the default interval
+func (s *TorrentSeeder) Announce(ctx context.Context, port int) {
+	for infoHash, torrent := range s.torrents {
+		for _, announce := range torrent.Announce {
+			go func() {
+				event := "started"
+				for {
+					interval, err := s.announce(ctx, announce, infoHash, port, event)
+					if err != nil {
+						if ctx.Err() != nil {
+							return
+						}
+						s.logger.Printf("Cannot announce %s to %s: %v", torrent.Name, announce, err)
+					} else {
+						event = ""
+					}
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(interval):
+					}
+				}
+			}()
+		}
+	}
+}
+
+// announce sends an announce request to a tracker and returns the interval of the next one
+func (s *TorrentSeeder) announce(ctx context.Context, announce string, infoHash [sha1.Size]byte, port int, event string) (time.Duration, error) {
+	announceURL, err := url.Parse(announce)
+	if err != nil {
+		return defaultAnnounceInterval, err
+	}
+	query := announceURL.Query()
+	query.Set("info_hash", string(infoHash[:]))
+	query.Set("peer_id", string(s.peerID[:]))
+	query.Set("port", strconv.Itoa(port))
+	query.Set("uploaded", "0")
+	query.Set("downloaded", "0")
+	query.Set("left", "0")
+	query.Set("compact", "1")
+	if event != "" {
+		query.Set("event", event)
+	}
+	announceURL.RawQuery = query.Encode()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, announceURL.String(), nil)
+	if err != nil {
+		return defaultAnnounceInterval, err
+	}
+	response, err := s.client.Do(request)
+	if err != nil {
+		return defaultAnnounceInterval, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return defaultAnnounceInterval, fmt.Errorf("%s", response.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return defaultAnnounceInterval, err
+	}
+	value, _, err := bdecode(bytes.TrimSpace(body))
+	dictionary, ok := value.(map[string]any)
+	if err != nil || !ok {
+		return defaultAnnounceInterval, fmt.Errorf("invalid tracker response")
+	}
+	if reason, ok := dictionary["failure reason"].(string); ok {
+		return defaultAnnounceInterval, fmt.Errorf("%s", reason)
+	}
+	if interval, ok := dictionary["interval"].(int64); ok && interval > 0 {
+		return time.Duration(interval) * time.Second, nil
+	}
+	return defaultAnnounceInterval, nil
+}
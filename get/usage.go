@@ -0,0 +1,73 @@
+package get
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// RepoUsage is the storage taken by a mirrored repo, according to its metadata
+type RepoUsage struct {
+	// Metadata is the size of repomd.xml or Release and of the files they list
+	Metadata int64
+	// Packages is the number of mirrored packages, PackagesSize their size
+	Packages     int
+	PackagesSize int64
+}
+
+// Total returns the size of metadata and packages
+func (u RepoUsage) Total() int64 {
+	return u.Metadata + u.PackagesSize
+}
+
+// Usage returns the storage taken by the repo as of its last sync, computed from its metadata rather than
+// by listing files, which also works for object storages. Repos never synced take no storage
+func (r *Syncer) Usage() (usage RepoUsage, err error) {
+	repoType := repoTypes["rpm"]
+	reader, err := r.storage.NewReader(repoType.MetadataPath, Permanent)
+	if err == ErrFileNotFound {
+		repoType = repoTypes["deb"]
+		reader, err = r.storage.NewReader(repoType.MetadataPath, Permanent)
+	}
+	if err == ErrFileNotFound {
+		return usage, nil
+	}
+	if err != nil {
+		return
+	}
+	b, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return
+	}
+	repomd, err := repoType.DecodeMetadata(bytes.NewReader(b))
+	if err != nil {
+		return
+	}
+
+	usage.Metadata = int64(len(b))
+	for _, entry := range repomd.Data {
+		usage.Metadata += entry.Size
+		if entry.Type != repoType.PackagesType {
+			continue
+		}
+		reader, err = r.storage.NewReader(entry.Location.Href, Permanent)
+		if err != nil {
+			return
+		}
+		var packages XMLMetaData
+		packages, err = repoType.DecodePackages(reader, strings.Trim(filepath.Ext(entry.Location.Href), "."))
+		reader.Close()
+		if err != nil {
+			return
+		}
+		for _, pack := range packages.Packages {
+			if r.wanted(pack, repoType) {
+				usage.Packages++
+				usage.PackagesSize += pack.Size.Package
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,25 @@
+package get
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsage(t *testing.T) {
+	repoURL, err := url.Parse("http://localhost:8080/repo")
+	assert.NoError(t, err)
+	syncer := NewSyncer(*repoURL, map[string]bool{"x86_64": true}, NewFileStorage(t.TempDir()), true)
+
+	usage, err := syncer.Usage()
+	assert.NoError(t, err)
+	assert.Equal(t, RepoUsage{}, usage)
+
+	assert.NoError(t, syncer.StoreRepo())
+	usage, err = syncer.Usage()
+	assert.NoError(t, err)
+	// 5 x86_64, 2 noarch, 5 i586 packages, repomd.xml and the 4 files it lists
+	assert.Equal(t, RepoUsage{Metadata: 8342, Packages: 12, PackagesSize: 111976}, usage)
+	assert.Equal(t, int64(120318), usage.Total())
+}
@@ -0,0 +1,176 @@
+package get
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+)
+
+// Uyuni defines the configuration to discover repos of the software channels of an Uyuni or SUSE Manager
+// server, so that the mirror follows the channels added to and removed from it
+type Uyuni struct {
+	// base URL of the server, eg. https://uyuni.example.com
+	URL      string
+	Username string
+	Password string
+	// CA certificate of the server, if not trusted by the system
+	CACertFile string `yaml:"ca_cert_file"`
+	// globs of labels of the channels to mirror, all channels if empty
+	Channels []string
+	// path prepended to the storage paths of the repos
+	PathPrefix string `yaml:"path_prefix"`
+}
+
+// uyuniArchs maps channel architecture labels to package architectures, where they differ
+var uyuniArchs = map[string][]string{
+	"ia32": {"i586", "i686"},
+}
+
+// uyuniClient calls the HTTP API of an Uyuni server, keeping the session cookie
+type uyuniClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+// newUyuniClient returns a client logged in to the server of config
+func newUyuniClient(config Uyuni) (*uyuniClient, error) {
+	client, err := tlsHTTPClient(config.CACertFile, false)
+	if err != nil {
+		return nil, err
+	}
+	client.Jar, err = cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &uyuniClient{strings.TrimSuffix(config.URL, "/") + "/rhn/manager/api/", client}
+	err = c.call(http.MethodPost, "auth/login", nil, map[string]string{"login": config.Username, "password": config.Password}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot log in to %s: %v", config.URL, err)
+	}
+	return c, nil
+}
+
+// call sends a request to endpoint with query parameters and an optional JSON body, decoding the
+// result field of the response into result if not nil
+func (c *uyuniClient) call(method string, endpoint string, query url.Values, body interface{}, result interface{}) error {
+	u := c.baseURL + endpoint
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var content []byte
+	if body != nil {
+		var err error
+		content, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &UnexpectedStatusCodeError{method + " " + req.URL.Path, resp.StatusCode}
+	}
+
+	var response struct {
+		Success bool
+		Message string
+		Result  json.RawMessage
+	}
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return err
+	}
+	if !response.Success {
+		return fmt.Errorf("%s %s failed: %s", method, endpoint, response.Message)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(response.Result, result)
+}
+
+// UyuniToHTTPConfigs returns HTTPS repos configurations for the repos of the software channels of the
+// server of config matching its channel globs, for the architectures of the channels. Progress is logged
+// to logger, the standard logger if nil
+func UyuniToHTTPConfigs(config Uyuni, quiet bool, logger *log.Logger) ([]HTTPRepoConfig, error) {
+	channelsFilter := SCCFilter{MatchNames: config.Channels}
+	err := channelsFilter.validate()
+	if err != nil {
+		return nil, err
+	}
+	client, err := newUyuniClient(config)
+	if err != nil {
+		return nil, err
+	}
+	defer client.call(http.MethodPost, "auth/logout", nil, nil, nil)
+
+	logger = loggerOrDefault(logger)
+	logger.Println("Checking Uyuni software channels ...")
+	var channels []struct {
+		Label string
+	}
+	err = client.call(http.MethodGet, "channel/listSoftwareChannels", nil, nil, &channels)
+	if err != nil {
+		return nil, err
+	}
+
+	httpConfigs := []HTTPRepoConfig{}
+	for _, channel := range channels {
+		if !matchAny(config.Channels, channel.Label) {
+			continue
+		}
+		query := url.Values{"channelLabel": {channel.Label}}
+		var details struct {
+			ArchLabel string `json:"arch_label"`
+		}
+		err = client.call(http.MethodGet, "channel/software/getDetails", query, nil, &details)
+		if err != nil {
+			return nil, err
+		}
+		var repos []struct {
+			Label     string
+			SourceURL string `json:"sourceUrl"`
+			Type      string
+		}
+		err = client.call(http.MethodGet, "channel/software/listChannelRepos", query, nil, &repos)
+		if err != nil {
+			return nil, err
+		}
+
+		arch := strings.TrimSuffix(strings.TrimPrefix(details.ArchLabel, "channel-"), "-deb")
+		archs, ok := uyuniArchs[arch]
+		if !ok {
+			archs = []string{arch}
+		}
+		for _, repo := range repos {
+			if repo.Type != "yum" && repo.Type != "deb" {
+				continue
+			}
+			if !quiet {
+				logger.Printf("  %s: %s\n", channel.Label, repo.Label)
+			}
+			httpConfigs = append(httpConfigs, HTTPRepoConfig{
+				URL:       repo.SourceURL,
+				Name:      repo.Label,
+				Archs:     archs,
+				Variables: map[string]string{"channel": channel.Label},
+			})
+		}
+	}
+	return httpConfigs, nil
+}
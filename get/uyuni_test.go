@@ -0,0 +1,103 @@
+package get
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeUyuni serves the channels API of an Uyuni server
+func fakeUyuni(t *testing.T) *httptest.Server {
+	respond := func(w http.ResponseWriter, result interface{}) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": result})
+	}
+	details := map[string]string{
+		"sle-product-sles15-sp5-pool-x86_64":    "channel-x86_64",
+		"sle-product-sles15-sp5-updates-x86_64": "channel-x86_64",
+		"ubuntu-2204-amd64-main":                "channel-amd64-deb",
+		"dev-sles15-sp5-x86_64":                 "channel-x86_64",
+	}
+	repos := map[string][]map[string]string{
+		"sle-product-sles15-sp5-pool-x86_64":    {{"label": "SLE-Product-SLES15-SP5-Pool for x86_64", "sourceUrl": "https://updates.suse.com/SUSE/Products/SLE-Product-SLES/15-SP5/x86_64/product/?token", "type": "yum"}},
+		"sle-product-sles15-sp5-updates-x86_64": {{"label": "SLE-Product-SLES15-SP5-Updates for x86_64", "sourceUrl": "https://updates.suse.com/SUSE/Updates/SLE-Product-SLES/15-SP5/x86_64/update/?token", "type": "yum"}},
+		"ubuntu-2204-amd64-main":                {{"label": "ubuntu-2204-amd64-main", "sourceUrl": "http://archive.ubuntu.com/ubuntu/dists/jammy/main/binary-amd64/", "type": "deb"}},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rhn/manager/api/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		var credentials map[string]string
+		json.NewDecoder(r.Body).Decode(&credentials)
+		if r.Method != http.MethodPost || credentials["login"] != "admin" || credentials["password"] != "secret" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": "Either the password or username is incorrect."})
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "pxt-session-cookie", Value: "session", Path: "/"})
+		respond(w, 1)
+	})
+	mux.HandleFunc("/rhn/manager/api/auth/logout", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, 1)
+	})
+	authenticated := func(handler http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if cookie, err := r.Cookie("pxt-session-cookie"); err != nil || cookie.Value != "session" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			handler(w, r)
+		}
+	}
+	mux.HandleFunc("/rhn/manager/api/channel/listSoftwareChannels", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		respond(w, []map[string]string{
+			{"label": "sle-product-sles15-sp5-pool-x86_64"},
+			{"label": "sle-product-sles15-sp5-updates-x86_64", "parent_label": "sle-product-sles15-sp5-pool-x86_64"},
+			{"label": "ubuntu-2204-amd64-main"},
+			{"label": "dev-sles15-sp5-x86_64"},
+		})
+	}))
+	mux.HandleFunc("/rhn/manager/api/channel/software/getDetails", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		respond(w, map[string]string{"arch_label": details[r.URL.Query().Get("channelLabel")]})
+	}))
+	mux.HandleFunc("/rhn/manager/api/channel/software/listChannelRepos", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		respond(w, repos[r.URL.Query().Get("channelLabel")])
+	}))
+	return httptest.NewServer(mux)
+}
+
+func TestUyuniToHTTPConfigs(t *testing.T) {
+	server := fakeUyuni(t)
+	defer server.Close()
+
+	configs, err := UyuniToHTTPConfigs(Uyuni{URL: server.URL + "/", Username: "admin", Password: "secret"}, true, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []HTTPRepoConfig{
+		{
+			URL:       "https://updates.suse.com/SUSE/Products/SLE-Product-SLES/15-SP5/x86_64/product/?token",
+			Name:      "SLE-Product-SLES15-SP5-Pool for x86_64",
+			Archs:     []string{"x86_64"},
+			Variables: map[string]string{"channel": "sle-product-sles15-sp5-pool-x86_64"},
+		},
+		{
+			URL:       "https://updates.suse.com/SUSE/Updates/SLE-Product-SLES/15-SP5/x86_64/update/?token",
+			Name:      "SLE-Product-SLES15-SP5-Updates for x86_64",
+			Archs:     []string{"x86_64"},
+			Variables: map[string]string{"channel": "sle-product-sles15-sp5-updates-x86_64"},
+		},
+		{
+			URL:       "http://archive.ubuntu.com/ubuntu/dists/jammy/main/binary-amd64/",
+			Name:      "ubuntu-2204-amd64-main",
+			Archs:     []string{"amd64"},
+			Variables: map[string]string{"channel": "ubuntu-2204-amd64-main"},
+		},
+	}, configs)
+
+	configs, err = UyuniToHTTPConfigs(Uyuni{URL: server.URL, Username: "admin", Password: "secret", Channels: []string{"sle-*-updates-*"}}, true, nil)
+	assert.NoError(t, err)
+	assert.Len(t, configs, 1)
+	assert.Equal(t, "SLE-Product-SLES15-SP5-Updates for x86_64", configs[0].Name)
+
+	_, err = UyuniToHTTPConfigs(Uyuni{URL: server.URL, Username: "admin", Password: "wrong"}, true, nil)
+	assert.ErrorContains(t, err, "password or username is incorrect")
+}
@@ -0,0 +1,60 @@
+package get
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// LayoutUyuni arranges a file storage like the local mirrors Uyuni and SUSE Manager sync from, set with
+// server.susemanager.fromdir or as file:// URLs of custom channel repos: repos are stored at the path of
+// their URL, and the SCC data mgr-sync reads is stored next to them
+const LayoutUyuni = "uyuni"
+
+// uyuniSCCData maps the files of SCC data in a local mirror to the SCC API endpoints they come from
+var uyuniSCCData = map[string]string{
+	"organizations_products_unscoped.json": "/connect/organizations/products/unscoped",
+	"organizations_repositories.json":      "/connect/organizations/repositories",
+	"organizations_subscriptions.json":     "/connect/organizations/subscriptions",
+	"organizations_orders.json":            "/connect/organizations/orders",
+	"product_tree.json":                    "/suma/product_tree.json",
+}
+
+// StoreUyuniSCCData downloads the SCC data of the organization of username and stores it in directory, as
+// expected by mgr-sync in local mirrors. Retries are logged to logger, the standard logger if nil
+func StoreUyuniSCCData(directory string, baseURL string, username string, password string, logger *log.Logger) error {
+	token := base64.URLEncoding.EncodeToString([]byte(username + ":" + password))
+	err := os.MkdirAll(directory, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	for file, endpoint := range uyuniSCCData {
+		entries := []json.RawMessage{}
+		err = downloadAllPages(baseURL+endpoint, token, logger, func(page []byte) error {
+			var pageEntries []json.RawMessage
+			err := json.Unmarshal(page, &pageEntries)
+			entries = append(entries, pageEntries...)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(entries)
+		if err != nil {
+			return err
+		}
+		// written aside and renamed, so that Uyuni never reads a partial file
+		temporary := filepath.Join(directory, file+".tmp")
+		err = os.WriteFile(temporary, data, 0644)
+		if err != nil {
+			return err
+		}
+		err = rename(temporary, filepath.Join(directory, file))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package get
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreUyuniSCCData(t *testing.T) {
+	expectedAuth := "Basic " + base64.URLEncoding.EncodeToString([]byte("user:pass"))
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != expectedAuth {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/connect/organizations/repositories":
+			w.Header().Set("Link", fmt.Sprintf("<%s/connect/organizations/repositories2>; rel=\"next\"", server.URL))
+			fmt.Fprint(w, `[{"id":1}]`)
+		case "/connect/organizations/repositories2":
+			fmt.Fprint(w, `[{"id":2}]`)
+		default:
+			fmt.Fprint(w, `[]`)
+		}
+	}))
+	defer server.Close()
+
+	directory := filepath.Join(t.TempDir(), "mirror")
+	assert.NoError(t, StoreUyuniSCCData(directory, server.URL, "user", "pass", nil))
+	for file := range uyuniSCCData {
+		_, err := os.Stat(filepath.Join(directory, file))
+		assert.NoError(t, err, file)
+	}
+	// pages are joined
+	repositories, err := os.ReadFile(filepath.Join(directory, "organizations_repositories.json"))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"id":1},{"id":2}]`, string(repositories))
+
+	assert.Error(t, StoreUyuniSCCData(directory, server.URL, "user", "wrong", nil))
+}
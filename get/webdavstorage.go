@@ -0,0 +1,216 @@
+package get
+
+import (
+	"crypto"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/uyuni-project/minima/util"
+)
+
+// WebDAVStorage allows to store data in a WebDAV collection, eg. a Nextcloud or Apache mod_dav share.
+// Like FileStorage, files are stored in a -in-progress collection which replaces the permanent one at Commit
+type WebDAVStorage struct {
+	// URL of the share, collections are never created above it
+	share     url.URL
+	directory string
+	username  string
+	password  string
+	client    *http.Client
+
+	// collections known to exist
+	collectionsLock sync.Mutex
+	collections     map[string]bool
+}
+
+// NewWebDAVStorage returns a new Storage in repoPath below the WebDAV share at the configured endpoint
+func NewWebDAVStorage(config StorageConfig, repoPath string) (Storage, error) {
+	share, err := url.Parse(strings.TrimSuffix(config.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	client, err := tlsHTTPClient(config.CACertFile, config.InsecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+	return &WebDAVStorage{
+		share:       *share,
+		directory:   path.Join("/", share.Path, repoPath),
+		username:    config.Username,
+		password:    config.Password,
+		client:      client,
+		collections: map[string]bool{},
+	}, nil
+}
+
+// url returns the URL of a path on the server
+func (s *WebDAVStorage) url(p string) string {
+	u := s.share
+	u.Path = p
+	return u.String()
+}
+
+// do sends a request for a path on the server, returning the response if it has one of the expected
+// status codes. Streamed bodies are sent with chunked transfer encoding
+func (s *WebDAVStorage) do(method string, p string, body io.Reader, headers map[string]string, expected ...int) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.url(p), body)
+	if err != nil {
+		return nil, err
+	}
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrFileNotFound
+	}
+	return nil, &UnexpectedStatusCodeError{method + " " + p, resp.StatusCode}
+}
+
+// NewReader returns a Reader for a file in a location, returns ErrFileNotFound
+// if the requested path was not found at all
+func (s *WebDAVStorage) NewReader(filename string, location Location) (io.ReadCloser, error) {
+	directory := s.directory
+	if location == Temporary {
+		directory += "-in-progress"
+	}
+	resp, err := s.do(http.MethodGet, path.Join(directory, filename), nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// StoringMapper returns a mapper that will store read data to a temporary location specified by filename
+func (s *WebDAVStorage) StoringMapper(filename string, checksum string, hash crypto.Hash) util.ReaderMapper {
+	return func(reader io.ReadCloser) (result io.ReadCloser, err error) {
+		fullPath := path.Join(s.directory+"-in-progress", filename)
+		err = s.mkcolAll(path.Dir(fullPath))
+		if err != nil {
+			return
+		}
+		pipeReader, pipeWriter := io.Pipe()
+
+		errs := make(chan error)
+		go func() {
+			resp, err := s.do(http.MethodPut, fullPath, pipeReader, nil, http.StatusCreated, http.StatusNoContent, http.StatusOK)
+			if err == nil {
+				resp.Body.Close()
+			}
+			// unblock writes if the upload stopped early
+			pipeReader.CloseWithError(err)
+			errs <- err
+		}()
+
+		result = util.NewTeeReadCloser(reader, util.NewChecksummingWriter(&waitingCloser{pipeWriter, errs, filename}, checksum, hash))
+		return
+	}
+}
+
+// Recycle will copy a file from the permanent to the temporary location, server-side
+func (s *WebDAVStorage) Recycle(filename string) error {
+	newPath := path.Join(s.directory+"-in-progress", filename)
+	err := s.mkcolAll(path.Dir(newPath))
+	if err != nil {
+		return err
+	}
+	return s.move("COPY", path.Join(s.directory, filename), newPath)
+}
+
+// move copies or moves a resource, replacing any existing one
+func (s *WebDAVStorage) move(method string, source string, destination string) error {
+	headers := map[string]string{"Destination": s.url(destination), "Overwrite": "T"}
+	resp, err := s.do(method, source, nil, headers, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Commit replaces the permanent collection with the temporary one
+func (s *WebDAVStorage) Commit() error {
+	oldDir := s.directory + "-old"
+	tmpDir := s.directory + "-in-progress"
+
+	err := s.delete(oldDir)
+	if err != nil {
+		return err
+	}
+	err = s.move("MOVE", s.directory, oldDir)
+	if err != nil && err != ErrFileNotFound {
+		return err
+	}
+	err = s.move("MOVE", tmpDir, s.directory)
+	if err != nil {
+		return err
+	}
+
+	s.collectionsLock.Lock()
+	s.collections = map[string]bool{}
+	s.collectionsLock.Unlock()
+	return s.delete(oldDir)
+}
+
+// delete deletes a resource, with all its members if it is a collection
+func (s *WebDAVStorage) delete(p string) error {
+	resp, err := s.do(http.MethodDelete, p, nil, nil, http.StatusNoContent, http.StatusOK)
+	if err == ErrFileNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// mkcolAll creates a collection and any missing parent below the share
+func (s *WebDAVStorage) mkcolAll(collection string) error {
+	s.collectionsLock.Lock()
+	known := s.collections[collection]
+	s.collectionsLock.Unlock()
+	share := path.Clean("/" + s.share.Path)
+	if known || collection == share || !strings.HasPrefix(collection, strings.TrimSuffix(share, "/")+"/") {
+		return nil
+	}
+
+	resp, err := s.do("MKCOL", collection+"/", nil, nil, http.StatusCreated, http.StatusMethodNotAllowed, http.StatusConflict)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	// conflicts mean the parent is missing, while existing collections are not allowed to be created
+	if resp.StatusCode == http.StatusConflict {
+		err = s.mkcolAll(path.Dir(collection))
+		if err != nil {
+			return err
+		}
+		resp, err = s.do("MKCOL", collection+"/", nil, nil, http.StatusCreated, http.StatusMethodNotAllowed)
+		if err != nil {
+			return fmt.Errorf("cannot create collection %s: %v", collection, err)
+		}
+		resp.Body.Close()
+	}
+
+	s.collectionsLock.Lock()
+	s.collections[collection] = true
+	s.collectionsLock.Unlock()
+	return nil
+}
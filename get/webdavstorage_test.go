@@ -0,0 +1,131 @@
+package get
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeWebDAV implements the subset of WebDAV used by WebDAVStorage in a local directory, for user minima
+type fakeWebDAV struct {
+	root string
+	// transfer encodings of PUT requests
+	encodings []string
+}
+
+func (f *fakeWebDAV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, password, _ := r.BasicAuth(); user != "minima" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	name := filepath.Join(f.root, filepath.FromSlash(strings.TrimSuffix(r.URL.Path, "/")))
+	_, err := os.Stat(name)
+	exists := err == nil
+	_, err = os.Stat(filepath.Dir(name))
+	parentExists := err == nil
+
+	switch r.Method {
+	case http.MethodGet:
+		http.ServeFile(w, r, name)
+	case http.MethodPut:
+		f.encodings = append(f.encodings, strings.Join(r.TransferEncoding, ","))
+		if !parentExists {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		content, _ := io.ReadAll(r.Body)
+		os.WriteFile(name, content, 0644)
+		w.WriteHeader(http.StatusCreated)
+	case "MKCOL":
+		if exists {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !parentExists {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		os.Mkdir(name, 0755)
+		w.WriteHeader(http.StatusCreated)
+	case "COPY", "MOVE":
+		destination, _ := url.Parse(r.Header.Get("Destination"))
+		target := filepath.Join(f.root, filepath.FromSlash(destination.Path))
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if _, err := os.Stat(target); err == nil && r.Header.Get("Overwrite") != "T" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		if r.Method == "MOVE" {
+			os.RemoveAll(target)
+			os.Rename(name, target)
+		} else {
+			content, _ := os.ReadFile(name)
+			os.WriteFile(target, content, 0644)
+		}
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		os.RemoveAll(name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestWebDAVStorage(t *testing.T) {
+	f := &fakeWebDAV{root: t.TempDir()}
+	assert.NoError(t, os.MkdirAll(filepath.Join(f.root, "dav", "mirror", "repo", "Packages"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(f.root, "dav", "mirror", "repo", "Packages", "recycled.rpm"), []byte("recycled"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(f.root, "dav", "mirror", "repo", "old.rpm"), []byte("old"), 0644))
+	server := httptest.NewServer(f)
+	defer server.Close()
+
+	storage, err := NewWebDAVStorage(StorageConfig{Endpoint: server.URL + "/dav/mirror/", Username: "minima", Password: "secret"}, "repo")
+	assert.NoError(t, err)
+
+	assert.NoError(t, storeBytes(storage, "repodata/repomd.xml", []byte("repomd")))
+	assert.NoError(t, storage.Recycle("Packages/recycled.rpm"))
+	_, err = storage.NewReader("repodata/repomd.xml", Permanent)
+	assert.Equal(t, ErrFileNotFound, err)
+	assert.Equal(t, []string{"chunked"}, f.encodings)
+
+	assert.NoError(t, storage.Commit())
+	files := map[string]string{}
+	filepath.WalkDir(filepath.Join(f.root, "dav"), func(name string, d fs.DirEntry, err error) error {
+		if !d.IsDir() {
+			content, _ := os.ReadFile(name)
+			relative, _ := filepath.Rel(f.root, name)
+			files[filepath.ToSlash(relative)] = string(content)
+		}
+		return nil
+	})
+	assert.Equal(t, map[string]string{
+		"dav/mirror/repo/repodata/repomd.xml":   "repomd",
+		"dav/mirror/repo/Packages/recycled.rpm": "recycled",
+	}, files)
+
+	reader, err := storage.NewReader("repodata/repomd.xml", Permanent)
+	assert.NoError(t, err)
+	content, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "repomd", string(content))
+	reader.Close()
+
+	unauthorized, err := NewWebDAVStorage(StorageConfig{Endpoint: server.URL + "/dav/mirror"}, "other")
+	assert.NoError(t, err)
+	assert.Error(t, storeBytes(unauthorized, "file", []byte("file")))
+}
@@ -0,0 +1,32 @@
+package get
+
+import (
+	"os"
+	"syscall"
+)
+
+// setXattr sets an extended attribute of a file or directory
+func setXattr(name string, attribute string, value []byte) error {
+	err := syscall.Setxattr(name, attribute, value, 0)
+	if err != nil {
+		return &os.PathError{Op: "setxattr", Path: name, Err: err}
+	}
+	return nil
+}
+
+// getXattr returns an extended attribute of a file or directory, nil if it is not set
+func getXattr(name string, attribute string) ([]byte, error) {
+	size, err := syscall.Getxattr(name, attribute, nil)
+	if err == syscall.ENODATA {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "getxattr", Path: name, Err: err}
+	}
+	value := make([]byte, size)
+	size, err = syscall.Getxattr(name, attribute, value)
+	if err != nil {
+		return nil, &os.PathError{Op: "getxattr", Path: name, Err: err}
+	}
+	return value[:size], nil
+}
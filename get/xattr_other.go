@@ -0,0 +1,18 @@
+//go:build !linux
+
+package get
+
+import "errors"
+
+// errXattrUnsupported is returned on platforms where extended attributes are not supported
+var errXattrUnsupported = errors.New("extended attributes are only supported on Linux")
+
+// setXattr sets an extended attribute of a file or directory
+func setXattr(name string, attribute string, value []byte) error {
+	return errXattrUnsupported
+}
+
+// getXattr returns an extended attribute of a file or directory, nil if it is not set
+func getXattr(name string, attribute string) ([]byte, error) {
+	return nil, errXattrUnsupported
+}
@@ -0,0 +1,372 @@
+package get
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/md4"
+
+	"github.com/uyuni-project/minima/util"
+)
+
+// zsyncMinSize is the size from which packages are downloaded with zsync, if enabled
+const zsyncMinSize = 8 << 20
+
+// zsyncControl maps a zsync control file, published by servers like MirrorBrain next to large files with
+// a .zsync extension. It lists checksums of the blocks of the file, so that blocks found in an older
+// version can be copied from it instead of being downloaded
+type zsyncControl struct {
+	blockSize int
+	length    int64
+	// bytes of the stored rolling checksums and MD4 checksums of blocks
+	rsumBytes     int
+	checksumBytes int
+	// rolling checksums, masked to rsumBytes, and MD4 checksums of blocks
+	rsums     []uint32
+	checksums [][]byte
+}
+
+// parseZsync reads a zsync control file
+func parseZsync(reader io.Reader) (*zsyncControl, error) {
+	buffered := bufio.NewReader(reader)
+	control := &zsyncControl{}
+	for {
+		line, err := buffered.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("invalid zsync control file: %v", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		key, value, _ := strings.Cut(line, ": ")
+		switch key {
+		case "Blocksize":
+			control.blockSize, err = strconv.Atoi(value)
+		case "Length":
+			control.length, err = strconv.ParseInt(value, 10, 64)
+		case "Hash-Lengths":
+			lengths := strings.Split(value, ",")
+			if len(lengths) != 3 {
+				return nil, fmt.Errorf("invalid zsync Hash-Lengths %s", value)
+			}
+			control.rsumBytes, err = strconv.Atoi(lengths[1])
+			if err == nil {
+				control.checksumBytes, err = strconv.Atoi(lengths[2])
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid zsync %s %s", key, value)
+		}
+	}
+	if control.blockSize <= 0 || control.length < 0 || control.rsumBytes < 1 || control.rsumBytes > 4 ||
+		control.checksumBytes < 1 || control.checksumBytes > md4.Size {
+		return nil, fmt.Errorf("unsupported zsync control file")
+	}
+
+	blocks := int((control.length + int64(control.blockSize) - 1) / int64(control.blockSize))
+	entry := make([]byte, control.rsumBytes+control.checksumBytes)
+	for i := 0; i < blocks; i++ {
+		_, err := io.ReadFull(buffered, entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid zsync control file: %v", err)
+		}
+		rsum := make([]byte, 4)
+		copy(rsum[4-control.rsumBytes:], entry[:control.rsumBytes])
+		control.rsums = append(control.rsums, binary.BigEndian.Uint32(rsum))
+		control.checksums = append(control.checksums, append([]byte{}, entry[control.rsumBytes:]...))
+	}
+	return control, nil
+}
+
+// rsumMask returns the bits of rolling checksums stored in the control file
+func (c *zsyncControl) rsumMask() uint32 {
+	return uint32(1<<(8*c.rsumBytes) - 1)
+}
+
+// match scans seed for blocks of the file, returning the offsets in seed of the ones found, by block
+func (c *zsyncControl) match(seed io.Reader) (map[int]int64, error) {
+	candidates := map[uint32][]int{}
+	for i, rsum := range c.rsums {
+		candidates[rsum] = append(candidates[rsum], i)
+	}
+
+	found := map[int]int64{}
+	window := make([]byte, c.blockSize)
+	n, err := io.ReadFull(seed, window)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// short seeds are compared zero-padded, like the last block
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var a, b uint16
+	for i, value := range window {
+		a += uint16(value)
+		b += uint16(c.blockSize-i) * uint16(value)
+	}
+
+	buffered := bufio.NewReader(seed)
+	mask := c.rsumMask()
+	// padding fed once seed is over, so that its last block can match the last one of the file
+	padding := c.blockSize - 1
+	if n < c.blockSize {
+		padding = 0
+	}
+	start := 0
+	for offset := int64(0); ; offset++ {
+		if blocks, ok := candidates[(uint32(a)<<16|uint32(b))&mask]; ok {
+			h := md4.New()
+			h.Write(window[start:])
+			h.Write(window[:start])
+			sum := h.Sum(nil)
+			for _, block := range blocks {
+				if _, done := found[block]; !done && bytes.Equal(sum[:c.checksumBytes], c.checksums[block]) {
+					found[block] = offset
+				}
+			}
+			if len(found) == len(c.rsums) {
+				break
+			}
+		}
+
+		next, err := buffered.ReadByte()
+		if err == io.EOF {
+			if padding == 0 {
+				break
+			}
+			next, err = 0, nil
+			padding--
+		}
+		if err != nil {
+			return nil, err
+		}
+		old := window[start]
+		window[start] = next
+		start = (start + 1) % c.blockSize
+		a += uint16(next) - uint16(old)
+		b += a - uint16(c.blockSize)*uint16(old)
+	}
+	return found, nil
+}
+
+// readURLRange returns a Reader for bytes from start to end, included, of url
+func readURLRange(ctx context.Context, client *http.Client, url string, start int64, end int64) (io.ReadCloser, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusPartialContent {
+		response.Body.Close()
+		return nil, &UnexpectedStatusCodeError{url, response.StatusCode}
+	}
+	return response.Body, nil
+}
+
+// zsyncSeed returns the location of the package of the last sync with the same name and arch as pack, if
+// different
+func (r *Syncer) zsyncSeed(pack XMLPackage) (string, bool) {
+	if r.seeds == nil {
+		r.seeds = map[string]string{}
+		packages, repoType, err := r.previousPackages()
+		if err != nil {
+			r.logger().Printf("Cannot read packages of the last sync, not using zsync: %v\n", err)
+		}
+		for _, previous := range packages {
+			if r.wanted(previous, repoType) {
+				r.seeds[previous.Name+"."+previous.Arch] = previous.Location.Href
+			}
+		}
+	}
+	seed, ok := r.seeds[pack.Name+"."+pack.Arch]
+	return seed, ok && seed != pack.Location.Href
+}
+
+// previousPackages returns the packages listed by the metadata of the last sync, none if there is none
+func (r *Syncer) previousPackages() ([]XMLPackage, RepoType, error) {
+	repoType := repoTypes["rpm"]
+	reader, err := r.storage.NewReader(repoType.MetadataPath, Permanent)
+	if err == ErrFileNotFound {
+		repoType = repoTypes["deb"]
+		reader, err = r.storage.NewReader(repoType.MetadataPath, Permanent)
+	}
+	if err == ErrFileNotFound {
+		return nil, repoType, nil
+	}
+	if err != nil {
+		return nil, repoType, err
+	}
+	repomd, err := repoType.DecodeMetadata(reader)
+	reader.Close()
+	if err != nil {
+		return nil, repoType, err
+	}
+	for _, entry := range repomd.Data {
+		if entry.Type != repoType.PackagesType {
+			continue
+		}
+		reader, err = r.storage.NewReader(entry.Location.Href, Permanent)
+		if err != nil {
+			return nil, repoType, err
+		}
+		defer reader.Close()
+		packages, err := repoType.DecodePackages(reader, strings.Trim(filepath.Ext(entry.Location.Href), "."))
+		return packages.Packages, repoType, err
+	}
+	return nil, repoType, nil
+}
+
+// zsyncBody returns a Reader of pack built from the blocks of seed found in its zsync control file and of
+// the others, downloaded with range requests. ok is false if zsync cannot be used, as upstream has no
+// control file or it does not match pack
+func (r *Syncer) zsyncBody(ctx context.Context, pack XMLPackage, relativeURL string, seed string) (body io.ReadCloser, ok bool, err error) {
+	controlReader, err := r.readRelative(ctx, relativeURL+".zsync")
+	if uerr, unexpectedStatusCode := err.(*UnexpectedStatusCodeError); unexpectedStatusCode && (uerr.StatusCode == http.StatusNotFound || uerr.StatusCode == http.StatusForbidden) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	control, err := parseZsync(controlReader)
+	controlReader.Close()
+	if err != nil {
+		r.logger().Printf("Ignoring zsync control file of %s: %v\n", pack.Location.Href, err)
+		return nil, false, nil
+	}
+	if control.length != pack.Size.Package {
+		return nil, false, nil
+	}
+
+	// the seed is copied to a local file, so that its blocks can be read in any order
+	seedReader, err := r.storage.NewReader(seed, Permanent)
+	if err != nil {
+		return nil, false, err
+	}
+	local, err := os.CreateTemp("", "minima-zsync-*")
+	if err != nil {
+		seedReader.Close()
+		return nil, false, err
+	}
+	found, err := control.match(io.TeeReader(seedReader, local))
+	seedReader.Close()
+	if err != nil {
+		local.Close()
+		os.Remove(local.Name())
+		return nil, false, err
+	}
+	r.logger().Printf("Reusing %d of %d blocks of %s from %s\n", len(found), len(control.rsums), path.Base(pack.Location.Href), path.Base(seed))
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	fileURL := r.repoFileURL(relativeURL)
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		pipeWriter.CloseWithError(control.assemble(pipeWriter, found, local, func(start int64, end int64) (io.ReadCloser, error) {
+			return readURLRange(ctx, client, fileURL, start, end)
+		}))
+	}()
+	return &assembledReader{pipeReader, local}, true, nil
+}
+
+// assemble writes the file, copying found blocks from seed and downloading runs of missing ones with fetch
+func (c *zsyncControl) assemble(writer io.Writer, found map[int]int64, seed io.ReaderAt, fetch func(start int64, end int64) (io.ReadCloser, error)) error {
+	return assembleBlocks(writer, c.blockSize, c.length, found, seed, fetch)
+}
+
+// assembleBlocks writes a file of length bytes in blocks of blockSize, copying found blocks from their
+// offsets in seed and downloading runs of missing ones with fetch
+func assembleBlocks(writer io.Writer, blockSize int, length int64, found map[int]int64, seed io.ReaderAt, fetch func(start int64, end int64) (io.ReadCloser, error)) error {
+	block := make([]byte, blockSize)
+	blocks := int((length + int64(blockSize) - 1) / int64(blockSize))
+	for i := 0; i < blocks; {
+		start := int64(i) * int64(blockSize)
+		if offset, ok := found[i]; ok {
+			n, err := seed.ReadAt(block, offset)
+			if err != nil && err != io.EOF {
+				return err
+			}
+			clear(block[n:])
+			_, err = writer.Write(block[:min(int64(blockSize), length-start)])
+			if err != nil {
+				return err
+			}
+			i++
+			continue
+		}
+
+		j := i + 1
+		for ; j < blocks; j++ {
+			if _, ok := found[j]; ok {
+				break
+			}
+		}
+		end := min(int64(j)*int64(blockSize), length) - 1
+		body, err := fetch(start, end)
+		if err != nil {
+			return err
+		}
+		copied, err := io.Copy(writer, body)
+		body.Close()
+		if err != nil {
+			return err
+		}
+		if copied != end-start+1 {
+			return fmt.Errorf("range %d-%d has %d bytes", start, end, copied)
+		}
+		i = j
+	}
+	return nil
+}
+
+// zsyncStoreApply stores pack built with zsync from seed, while applying a ReaderConsumer. stored is false if
+// zsync cannot be used, or if the result does not match the checksum of pack and must be downloaded fully
+func (r *Syncer) zsyncStoreApply(ctx context.Context, pack XMLPackage, relativeURL string, storageURL string, seed string, description string, f util.ReaderConsumer) (stored bool, err error) {
+	if !r.quiet {
+		r.logger().Printf("Downloading %v with zsync...", description)
+	}
+	body, ok, err := r.zsyncBody(ctx, pack, relativeURL, seed)
+	if err != nil || !ok {
+		return false, err
+	}
+	err = r.storeApply(body, storageURL, pack.Checksum.Checksum, hashMap[pack.Checksum.Type], f)
+	if _, checksumError := err.(*util.ChecksumError); checksumError {
+		r.logger().Printf("zsync of %s did not match its checksum, downloading it fully\n", path.Base(pack.Location.Href))
+		return false, nil
+	}
+	if _, rangeError := err.(*UnexpectedStatusCodeError); rangeError {
+		r.logger().Printf("zsync of %s failed, downloading it fully: %v\n", path.Base(pack.Location.Href), err)
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// assembledReader reads a file assembled from a seed, removing the local copy of the seed when closed
+type assembledReader struct {
+	*io.PipeReader
+	seed *os.File
+}
+
+func (r *assembledReader) Close() error {
+	err := r.PipeReader.Close()
+	r.seed.Close()
+	os.Remove(r.seed.Name())
+	return err
+}
@@ -0,0 +1,142 @@
+package get
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/md4"
+
+	"github.com/uyuni-project/minima/util"
+)
+
+// makeZsync returns a zsync control file of content, like zsyncmake
+func makeZsync(content []byte, blockSize int, rsumBytes int, checksumBytes int) []byte {
+	control := &bytes.Buffer{}
+	fmt.Fprintf(control, "zsync: 0.6.2\nFilename: test.rpm\nBlocksize: %d\nLength: %d\nHash-Lengths: 1,%d,%d\n\n", blockSize, len(content), rsumBytes, checksumBytes)
+	for start := 0; start < len(content); start += blockSize {
+		block := make([]byte, blockSize)
+		copy(block, content[start:])
+		var a, b uint16
+		for i, value := range block {
+			a += uint16(value)
+			b += uint16(blockSize-i) * uint16(value)
+		}
+		rsum := []byte{byte(a >> 8), byte(a), byte(b >> 8), byte(b)}
+		control.Write(rsum[4-rsumBytes:])
+		h := md4.New()
+		h.Write(block)
+		control.Write(h.Sum(nil)[:checksumBytes])
+	}
+	return control.Bytes()
+}
+
+func TestZsync(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	seed := make([]byte, 10000)
+	random.Read(seed)
+	// the new version inserts and changes bytes, and is longer
+	content := append(append([]byte{}, seed[:3000]...), []byte("inserted")...)
+	content = append(content, seed[3000:8000]...)
+	content = append(content, bytes.Repeat([]byte{7}, 1500)...)
+	content = append(content, seed[8000:]...)
+
+	control, err := parseZsync(bytes.NewReader(makeZsync(content, 512, 3, 8)))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(content)), control.length)
+	assert.Len(t, control.rsums, 23)
+
+	found, err := control.match(bytes.NewReader(seed))
+	assert.NoError(t, err)
+	// only blocks around the changes are missing, the last one matches the zero-padded end of seed
+	assert.Len(t, found, 18)
+	assert.Equal(t, int64(9756), found[22])
+
+	fetched := int64(0)
+	assembled := &bytes.Buffer{}
+	err = control.assemble(assembled, found, bytes.NewReader(seed), func(start int64, end int64) (io.ReadCloser, error) {
+		fetched += end - start + 1
+		return io.NopCloser(bytes.NewReader(content[start : end+1])), nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(content, assembled.Bytes()))
+	assert.Equal(t, int64(5*512), fetched)
+}
+
+func TestParseZsyncInvalid(t *testing.T) {
+	_, err := parseZsync(bytes.NewReader([]byte("zsync: 0.6.2\nBlocksize: 0\n\n")))
+	assert.ErrorContains(t, err, "unsupported zsync control file")
+
+	control := makeZsync(make([]byte, 2048), 1024, 4, 16)
+	_, err = parseZsync(bytes.NewReader(control[:len(control)-1]))
+	assert.ErrorContains(t, err, "invalid zsync control file")
+}
+
+func TestZsyncStoreApply(t *testing.T) {
+	random := rand.New(rand.NewSource(2))
+	seed := make([]byte, 100000)
+	random.Read(seed)
+	content := append(append([]byte{}, seed[:50000]...), []byte("changed")...)
+	content = append(content, seed[50000:]...)
+	control := makeZsync(content, 2048, 4, 16)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/repo/x86_64/test-2.rpm":
+			http.ServeContent(w, r, "test-2.rpm", time.Time{}, bytes.NewReader(content))
+		case "/repo/x86_64/test-2.rpm.zsync":
+			w.Write(control)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	directory := filepath.Join(t.TempDir(), "repo")
+	assert.NoError(t, os.MkdirAll(filepath.Join(directory, "x86_64"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(directory, "x86_64", "test-1.rpm"), seed, 0644))
+	repoURL, err := url.Parse(server.URL + "/repo")
+	assert.NoError(t, err)
+	syncer := NewSyncer(*repoURL, map[string]bool{"x86_64": true}, NewFileStorage(directory), true)
+
+	sum := sha256.Sum256(content)
+	pack := XMLPackage{
+		Location: XMLLocation{Href: "x86_64/test-2.rpm"},
+		Checksum: XMLChecksum{Type: "sha256", Checksum: hex.EncodeToString(sum[:])},
+		Size:     XMLSize{Package: int64(len(content))},
+	}
+	stored, err := syncer.zsyncStoreApply(context.Background(), pack, pack.Location.Href, pack.Location.Href, "x86_64/test-1.rpm", "test-2.rpm", util.Nop)
+	assert.NoError(t, err)
+	assert.True(t, stored)
+	// the control file and a single range
+	assert.Equal(t, 2, requests)
+	storedContent, err := os.ReadFile(filepath.Join(directory+"-in-progress", "x86_64", "test-2.rpm"))
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(content, storedContent))
+
+	// results not matching the checksum are downloaded fully
+	pack.Checksum.Checksum = "0123"
+	stored, err = syncer.zsyncStoreApply(context.Background(), pack, pack.Location.Href, pack.Location.Href, "x86_64/test-1.rpm", "test-2.rpm", util.Nop)
+	assert.NoError(t, err)
+	assert.False(t, stored)
+
+	// as are packages without control file
+	pack.Location.Href = "x86_64/other-2.rpm"
+	stored, err = syncer.zsyncStoreApply(context.Background(), pack, pack.Location.Href, pack.Location.Href, "x86_64/test-1.rpm", "other-2.rpm", util.Nop)
+	assert.NoError(t, err)
+	assert.False(t, stored)
+}
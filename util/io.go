@@ -7,6 +7,8 @@ import (
 	"hash"
 	"io"
 	"io/ioutil"
+	"runtime"
+	"sync"
 )
 
 // ReaderConsumer consumes bytes from a Reader
@@ -53,6 +55,20 @@ func (r *NopReadCloser) Read(p []byte) (n int, err error) { return r.r.Read(p) }
 // Close does nothing
 func (r *NopReadCloser) Close() error { return nil }
 
+// NopWriteCloser wraps a Writer into a WriteCloser
+type NopWriteCloser struct{ w io.Writer }
+
+// NewNopWriteCloser returns a new NopWriteCloser
+func NewNopWriteCloser(w io.Writer) *NopWriteCloser {
+	return &NopWriteCloser{w}
+}
+
+// Write delegates to the wrapped Write function
+func (w *NopWriteCloser) Write(p []byte) (n int, err error) { return w.w.Write(p) }
+
+// Close does nothing
+func (w *NopWriteCloser) Close() error { return nil }
+
 // TeeReadCloser uses a TeeReader to copy data from a reader to a writer
 type TeeReadCloser struct {
 	reader    io.ReadCloser
@@ -83,47 +99,127 @@ func (t *TeeReadCloser) Close() (err error) {
 		return
 	}
 	err = t.reader.Close()
-	if err != nil {
-		return
+	// the writer is closed regardless, as it may hold resources like temporary files
+	writerErr := t.writer.Close()
+	if err == nil {
+		err = writerErr
 	}
-	err = t.writer.Close()
 	return
 }
 
-// ChecksummingWriter is a WriteCloser that checks on close that the checksum matches
+// checksumWorkers bounds the number of chunks hashed at the same time. Slots are only held while hashing a
+// chunk, as ChecksummingWriters may write to others, eg. one per target of a MultiStorage
+var checksumWorkers = make(chan struct{}, runtime.NumCPU())
+
+// checksumChunkSize is the size of the buffers data is copied to for hashing
+const checksumChunkSize = 256 * 1024
+
+var checksumChunks = sync.Pool{New: func() any { return make([]byte, 0, checksumChunkSize) }}
+
+// ChecksummingWriter is a WriteCloser that checks on close that the checksum matches. Data is hashed by one
+// of a pool of workers while it is written, so that hashing large files does not hold up writers
 type ChecksummingWriter struct {
-	writer       io.WriteCloser
-	expectedSum  string
-	hashFunction crypto.Hash
-	hash         hash.Hash
+	writer      io.WriteCloser
+	expectedSum string
+	// chunks to hash, nil without hash function
+	chunks chan []byte
+	// receives the actual checksum once all chunks are hashed
+	sum chan string
+	// data not sent to chunks yet
+	pending []byte
 }
 
 // NewChecksummingWriter returns a new ChecksummingWriter
 func NewChecksummingWriter(writer io.WriteCloser, expectedSum string, hashFunction crypto.Hash) *ChecksummingWriter {
+	w := &ChecksummingWriter{writer: writer, expectedSum: expectedSum}
 	if hashFunction != 0 {
-		return &ChecksummingWriter{writer, expectedSum, hashFunction, hashFunction.New()}
+		w.chunks = make(chan []byte, 4)
+		w.sum = make(chan string, 1)
+		go hashChunks(hashFunction.New(), w.chunks, w.sum)
+	}
+	return w
+}
+
+// hashChunks hashes each of chunks once a worker is available, and sends the resulting checksum to sum
+func hashChunks(h hash.Hash, chunks chan []byte, sum chan string) {
+	for chunk := range chunks {
+		checksumWorkers <- struct{}{}
+		h.Write(chunk)
+		<-checksumWorkers
+		checksumChunks.Put(chunk[:0])
 	}
-	return &ChecksummingWriter{writer, expectedSum, hashFunction, nil}
+	sum <- hex.EncodeToString(h.Sum(nil))
 }
 
-// Write delegates to the writer and hash
+// Write delegates to the writer and queues a copy of p for hashing
 func (w *ChecksummingWriter) Write(p []byte) (n int, err error) {
-	if w.hashFunction != 0 {
-		w.hash.Write(p)
+	n, err = w.writer.Write(p)
+	if w.chunks != nil {
+		for data := p[:n]; len(data) > 0; {
+			if w.pending == nil {
+				w.pending = checksumChunks.Get().([]byte)
+			}
+			copied := min(len(data), cap(w.pending)-len(w.pending))
+			w.pending = append(w.pending, data[:copied]...)
+			data = data[copied:]
+			if len(w.pending) == cap(w.pending) {
+				w.chunks <- w.pending
+				w.pending = nil
+			}
+		}
 	}
-	return w.writer.Write(p)
+	return
 }
 
 // Close delegates to the writer and checks the hash sum
 func (w *ChecksummingWriter) Close() (err error) {
 	err = w.writer.Close()
+	if w.chunks == nil {
+		return
+	}
+	if w.pending != nil {
+		w.chunks <- w.pending
+		w.pending = nil
+	}
+	close(w.chunks)
+	actualSum := <-w.sum
+	w.chunks = nil
 	if err != nil {
 		return
 	}
-	if w.hashFunction != 0 {
-		actualSum := hex.EncodeToString(w.hash.Sum(nil))
-		if w.expectedSum != actualSum {
-			err = &ChecksumError{w.expectedSum, actualSum}
+	if w.expectedSum != actualSum {
+		err = &ChecksumError{w.expectedSum, actualSum}
+	}
+	return
+}
+
+// ChecksummingReader is a Reader returning a ChecksumError instead of io.EOF if the checksum of read data
+// does not match, so that consumers like uploads stop before storing corrupt content
+type ChecksummingReader struct {
+	reader      io.Reader
+	expectedSum string
+	hash        hash.Hash
+}
+
+// NewChecksummingReader returns a new ChecksummingReader
+func NewChecksummingReader(reader io.Reader, expectedSum string, hashFunction crypto.Hash) *ChecksummingReader {
+	if hashFunction != 0 {
+		return &ChecksummingReader{reader, expectedSum, hashFunction.New()}
+	}
+	return &ChecksummingReader{reader, expectedSum, nil}
+}
+
+// Read delegates to the reader and checks the hash sum at the end of data
+func (r *ChecksummingReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+	if r.hash == nil {
+		return
+	}
+	r.hash.Write(p[:n])
+	if err == io.EOF {
+		actualSum := hex.EncodeToString(r.hash.Sum(nil))
+		if r.expectedSum != actualSum {
+			err = &ChecksumError{r.expectedSum, actualSum}
 		}
 	}
 	return
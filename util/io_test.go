@@ -2,9 +2,14 @@ package util
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io"
 	"io/ioutil"
 	"testing"
+	"time"
 )
 
 func TestCompose(t *testing.T) {
@@ -34,3 +39,105 @@ func TestCompose(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestChecksummingWriter(t *testing.T) {
+	// larger than a chunk, written in pieces not aligned to chunks
+	data := bytes.Repeat([]byte("minima"), 100000)
+	expected := sha256.Sum256(data)
+
+	for _, sum := range []string{hex.EncodeToString(expected[:]), "wrong"} {
+		buffer := &bytes.Buffer{}
+		writer := NewChecksummingWriter(NewNopWriteCloser(buffer), sum, crypto.SHA256)
+		for i := 0; i < len(data); i += 100003 {
+			_, err := writer.Write(data[i:min(i+100003, len(data))])
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		err := writer.Close()
+		if !bytes.Equal(buffer.Bytes(), data) {
+			t.Error("Unexpected written data")
+		}
+		_, checksumError := err.(*ChecksumError)
+		if sum == "wrong" && !checksumError {
+			t.Error("Expected a checksum error, got ", err)
+		}
+		if sum != "wrong" && err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestNestedChecksummingWriters(t *testing.T) {
+	workers := checksumWorkers
+	checksumWorkers = make(chan struct{}, 1)
+	defer func() { checksumWorkers = workers }()
+
+	// writers writing to each other, as with several targets, share the only worker
+	data := bytes.Repeat([]byte("minima"), 1000000)
+	expected := sha256.Sum256(data)
+	sum := hex.EncodeToString(expected[:])
+	buffer := &bytes.Buffer{}
+	inner := NewChecksummingWriter(NewNopWriteCloser(buffer), sum, crypto.SHA256)
+	outer := NewChecksummingWriter(NewChecksummingWriter(inner, sum, crypto.SHA256), sum, crypto.SHA256)
+	done := make(chan error)
+	go func() {
+		_, err := outer.Write(data)
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- outer.Close()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Nested writers did not finish")
+	}
+	if !bytes.Equal(buffer.Bytes(), data) {
+		t.Error("Unexpected written data")
+	}
+}
+
+// failingCloser is a ReadCloser failing to close
+type failingCloser struct{ io.Reader }
+
+func (failingCloser) Close() error { return errors.New("close failed") }
+
+func TestTeeReadCloserClose(t *testing.T) {
+	data := []byte("minima")
+	expected := sha256.Sum256(data)
+	writer := NewChecksummingWriter(NewNopWriteCloser(&bytes.Buffer{}), hex.EncodeToString(expected[:]), crypto.SHA256)
+	tee := NewTeeReadCloser(failingCloser{bytes.NewReader(data)}, writer)
+	err := tee.Close()
+	if err == nil || err.Error() != "close failed" {
+		t.Error("Expected the error of the reader, got ", err)
+	}
+	// the writer was closed too, releasing its hashing goroutine
+	if writer.chunks != nil {
+		t.Error("Writer not closed")
+	}
+}
+
+func TestChecksummingReader(t *testing.T) {
+	data := []byte("minima")
+	expected := sha256.Sum256(data)
+
+	read, err := io.ReadAll(NewChecksummingReader(bytes.NewReader(data), hex.EncodeToString(expected[:]), crypto.SHA256))
+	if err != nil || !bytes.Equal(read, data) {
+		t.Error("Unexpected result ", read, err)
+	}
+
+	_, err = io.ReadAll(NewChecksummingReader(bytes.NewReader(data), "wrong", crypto.SHA256))
+	if _, ok := err.(*ChecksumError); !ok {
+		t.Error("Expected a checksum error, got ", err)
+	}
+
+	_, err = io.ReadAll(NewChecksummingReader(bytes.NewReader(data), "", 0))
+	if err != nil {
+		t.Error(err)
+	}
+}